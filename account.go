@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -31,6 +32,9 @@ type Account struct {
 	// A list of saved dashboards which can be loaded.
 	// Is a map of "dashboard name" -> its content
 	Dashboards map[string]*Dashboard `bson:"dashboards"`
+	// Mutex, as dashboards are modified by the websocket
+	// commands and by the API requests concurrently
+	DashboardsMutex sync.RWMutex `bson:"-"`
 
 	// Date when new features were seen the last time,
 	// to see the notification only once
@@ -39,6 +43,9 @@ type Account struct {
 	// Personal settings
 	// which override the default server-side settings
 	Options *Options `bson:"options"`
+	// Mutex, as options are changed by the websocket
+	// commands while the searches are using them
+	OptionsMutex sync.RWMutex `bson:"-"`
 
 	// A list of uploaded and processed files with indicators
 	Uploads *Uploads `bson:"uploads"`
@@ -196,6 +203,16 @@ func credentialsAreValid(username, password string) error {
 	return nil
 }
 
+/*
+ * Get a copy of the account's personal settings
+ */
+func (a *Account) options() Options {
+	a.OptionsMutex.RLock()
+	defer a.OptionsMutex.RUnlock()
+
+	return *a.Options
+}
+
 /*
  * Update struct fields in case its structure has changed.
  * For backward compatibility.
@@ -240,7 +257,7 @@ func (a *Account) adoptFields() error {
 /*
  * Handle 'uuid' websocket command to regenerate a new auth UUID
  */
-func (a *Account) regenerateUUID() {
+func (a *Account) regenerateUUID(reqID string) {
 	a.UUID = uuid.NewString()
 
 	err := a.update("uuid", a.UUID)
@@ -250,11 +267,11 @@ func (a *Account) regenerateUUID() {
 			Str("username", a.Username).
 			Msg("Can't regenerate UUID: " + err.Error())
 
-		a.send("error", err.Error(), "Can't regenerate UUID!")
+		a.reply(reqID, "error", err.Error(), "Can't regenerate UUID!")
 		return
 	}
 
-	a.send("uuid", a.UUID, "")
+	a.reply(reqID, "uuid", a.UUID, "")
 
 	log.Info().
 		Str("ip", a.Session.IP).
@@ -265,7 +282,7 @@ func (a *Account) regenerateUUID() {
 /*
  * Handle 'account-save' websocket command to save account data
  */
-func (a *Account) saveHandler(password string) {
+func (a *Account) saveHandler(reqID, password string) {
 	// Update password
 	err := a.setPassword(password)
 	if err != nil {
@@ -274,11 +291,11 @@ func (a *Account) saveHandler(password string) {
 			Str("username", a.Username).
 			Msg("Can't update password: " + err.Error())
 
-		a.send("error", err.Error(), "Can't update password!")
+		a.reply(reqID, "error", err.Error(), "Can't update password!")
 		return
 	}
 
-	a.send("ok", "", "")
+	a.reply(reqID, "ok", "", "")
 
 	log.Info().
 		Str("ip", a.Session.IP).
@@ -289,7 +306,7 @@ func (a *Account) saveHandler(password string) {
 /*
  * Handle 'account-delete' websocket command to delete an account
  */
-func (a *Account) delete() {
+func (a *Account) delete(reqID string) {
 	// Delete account from a database
 	err := db.deleteAccount(a.Username)
 	if err != nil {
@@ -298,7 +315,7 @@ func (a *Account) delete() {
 			Str("username", a.Username).
 			Msg("Can't delete account: " + err.Error())
 
-		a.send("error", err.Error(), "Can't delete account!")
+		a.reply(reqID, "error", err.Error(), "Can't delete account!")
 		return
 	}
 
@@ -310,7 +327,7 @@ func (a *Account) delete() {
 			Str("username", a.Username).
 			Msg("Can't delete user session: " + err.Error())
 
-		a.send("error", err.Error(), "Can't delete session!")
+		a.reply(reqID, "error", err.Error(), "Can't delete session!")
 		return
 	}
 
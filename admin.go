@@ -6,11 +6,16 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var (
 	// Regex to validate Hex color definition
 	reColor = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}){1,2}$`)
+
+	// Several admins can request a reload at the same time,
+	// the previous collectors must be stopped by the next one
+	reloadMx sync.Mutex
 )
 
 /*
@@ -115,9 +120,20 @@ func adminHandler(w http.ResponseWriter, r *http.Request) {
 		msg = err.Error()
 	}
 
+	tokens, err := db.getTokens("")
+	if err != nil {
+		log.Error().
+			Str("ip", ip).
+			Str("username", username).
+			Msg("Can't get API tokens: " + err.Error())
+
+		msg = err.Error()
+	}
+
 	templateData := &TemplateData{
 		Account:       account,
 		Accounts:      accounts,
+		Tokens:        tokens,
 		GraphSettings: settings,
 		Error:         msg,
 	}
@@ -134,7 +150,7 @@ func adminHandler(w http.ResponseWriter, r *http.Request) {
 /*
  * Handle 'settings' websocket command to save global graph settings
  */
-func (a *Account) settingsHandler(settings string) {
+func (a *Account) settingsHandler(reqID, settings string) {
 
 	// Drop non-admin users request
 	if !a.Admin && config.Environment == "prod" {
@@ -143,7 +159,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Not enough rights to set UI settings")
 
-		a.send("error", "Not enough rights to set UI settings.", "Can't save!")
+		a.reply(reqID, "error", "Not enough rights to set UI settings.", "Can't save!")
 		return
 	}
 
@@ -157,7 +173,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse node size value: " + err.Error())
 
-		a.send("error", "Invalid <strong>node size</strong> value given, integer expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>node size</strong> value given, integer expected.", "Can't save!")
 		return
 	}
 
@@ -168,7 +184,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse node border width value: " + err.Error())
 
-		a.send("error", "Invalid <strong>node border width</strong> value given, integer expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>node border width</strong> value given, integer expected.", "Can't save!")
 		return
 	}
 
@@ -179,7 +195,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse node background color value: " + bgcolor)
 
-		a.send("error", "Invalid <strong>node background color</strong> value given, <strong>#000</strong> or <strong>#000000</strong> format expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>node background color</strong> value given, <strong>#000</strong> or <strong>#000000</strong> format expected.", "Can't save!")
 		return
 	}
 
@@ -190,7 +206,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse node border color value: " + bordercolor)
 
-		a.send("error", "Invalid <strong>node border color</strong> value given, <strong>#000</strong> or <strong>#000000</strong> format expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>node border color</strong> value given, <strong>#000</strong> or <strong>#000000</strong> format expected.", "Can't save!")
 		return
 	}
 
@@ -201,7 +217,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse node font size value: " + err.Error())
 
-		a.send("error", "Invalid <strong>node font size</strong> value given, integer expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>node font size</strong> value given, integer expected.", "Can't save!")
 		return
 	}
 
@@ -212,7 +228,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse shadow value: " + err.Error())
 
-		a.send("error", "Invalid <strong>node shadow</strong> value given, boolean expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>node shadow</strong> value given, boolean expected.", "Can't save!")
 		return
 	}
 
@@ -223,7 +239,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse edge width value: " + err.Error())
 
-		a.send("error", "Invalid <strong>edge width</strong> value given, integer expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>edge width</strong> value given, integer expected.", "Can't save!")
 		return
 	}
 
@@ -234,7 +250,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse edge color value: " + edgecolor)
 
-		a.send("error", "Invalid <strong>edge color</strong> value given, <strong>#000</strong> or <strong>#000000</strong> format expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>edge color</strong> value given, <strong>#000</strong> or <strong>#000000</strong> format expected.", "Can't save!")
 		return
 	}
 
@@ -245,7 +261,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse edge font size value: " + err.Error())
 
-		a.send("error", "Invalid <strong>edge font size</strong> value given, integer expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>edge font size</strong> value given, integer expected.", "Can't save!")
 		return
 	}
 
@@ -256,7 +272,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse edge font color value: " + edgefontcolor)
 
-		a.send("error", "Invalid <strong>edge font color</strong> value given, <strong>#000</strong> or <strong>#000000</strong> format expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>edge font color</strong> value given, <strong>#000</strong> or <strong>#000000</strong> format expected.", "Can't save!")
 		return
 	}
 
@@ -267,7 +283,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse edge arrow value: " + err.Error())
 
-		a.send("error", "Invalid <strong>edge arrow</strong> value given, boolean expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>edge arrow</strong> value given, boolean expected.", "Can't save!")
 		return
 	}
 
@@ -278,7 +294,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse edge smooth value: " + err.Error())
 
-		a.send("error", "Invalid <strong>edge smooth</strong> value given, boolean expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>edge smooth</strong> value given, boolean expected.", "Can't save!")
 		return
 	}
 
@@ -289,7 +305,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse hover value: " + err.Error())
 
-		a.send("error", "Invalid <strong>hover</strong> value given, boolean expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>hover</strong> value given, boolean expected.", "Can't save!")
 		return
 	}
 
@@ -300,7 +316,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse multiselect value: " + err.Error())
 
-		a.send("error", "Invalid <strong>multiselect</strong> value given, boolean expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>multiselect</strong> value given, boolean expected.", "Can't save!")
 		return
 	}
 
@@ -311,7 +327,7 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't parse hideedgesondrag value: " + err.Error())
 
-		a.send("error", "Invalid <strong>hide edges on drag</strong> value given, boolean expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>hide edges on drag</strong> value given, boolean expected.", "Can't save!")
 		return
 	}
 
@@ -343,11 +359,11 @@ func (a *Account) settingsHandler(settings string) {
 			Str("username", a.Username).
 			Msg("Can't update graph UI settings: " + err.Error())
 
-		a.send("error", "Can't update graph UI settings: "+err.Error(), "Can't save!")
+		a.reply(reqID, "error", "Can't update graph UI settings: "+err.Error(), "Can't save!")
 		return
 	}
 
-	a.send("ok", "", "")
+	a.reply(reqID, "ok", "", "")
 
 	log.Info().
 		Str("ip", a.Session.IP).
@@ -364,7 +380,7 @@ func (a *Account) settingsHandler(settings string) {
  *   admin-true:     give admin rights
  *   admin-false:    remove admin rights
  */
-func (a *Account) usersHandler(data string) {
+func (a *Account) usersHandler(reqID, data string) {
 
 	// Drop non-admin users request
 	if !a.Admin && config.Environment == "prod" {
@@ -373,7 +389,7 @@ func (a *Account) usersHandler(data string) {
 			Str("username", a.Username).
 			Msg("Not enough rights to set users options")
 
-		a.send("error", "Not enough rights to set users options.", "Error!")
+		a.reply(reqID, "error", "Not enough rights to set users options.", "Error!")
 		return
 	}
 
@@ -391,7 +407,7 @@ func (a *Account) usersHandler(data string) {
 			Str("username", a.Username).
 			Msg("Can't GetAccount to get user to modify: " + err.Error())
 
-		a.send("error", "Can't validate requested user: "+err.Error(), "Error!")
+		a.reply(reqID, "error", "Can't validate requested user: "+err.Error(), "Error!")
 		return
 	}
 
@@ -405,11 +421,11 @@ func (a *Account) usersHandler(data string) {
 				Str("user-to-reset", user).
 				Msg("Can't reset password: " + err.Error())
 
-			a.send("error", "Can't reset password: "+err.Error(), "Error!")
+			a.reply(reqID, "error", "Can't reset password: "+err.Error(), "Error!")
 			return
 		}
 
-		a.send("account-reset", "", "")
+		a.reply(reqID, "account-reset", "", "")
 
 		log.Info().
 			Str("ip", a.Session.IP).
@@ -426,7 +442,7 @@ func (a *Account) usersHandler(data string) {
 				Str("user-to-delete", user).
 				Msg("Can't deleteAccount: " + err.Error())
 
-			a.send("error", "Can't delete account: "+err.Error(), "Error!")
+			a.reply(reqID, "error", "Can't delete account: "+err.Error(), "Error!")
 			return
 		}
 
@@ -438,11 +454,11 @@ func (a *Account) usersHandler(data string) {
 				Str("user-to-delete", user).
 				Msg("Can't delete user session: " + err.Error())
 
-			a.send("error", "Can't delete user session: "+err.Error(), "Error!")
+			a.reply(reqID, "error", "Can't delete user session: "+err.Error(), "Error!")
 			return
 		}
 
-		a.send("account-deleted", user, "")
+		a.reply(reqID, "account-deleted", user, "")
 
 		log.Info().
 			Str("ip", a.Session.IP).
@@ -459,7 +475,7 @@ func (a *Account) usersHandler(data string) {
 				Str("user-to-make-admin", user).
 				Msg("Can't set admin rights: " + err.Error())
 
-			a.send("error", "Can't set admin rights: "+err.Error(), "Error!")
+			a.reply(reqID, "error", "Can't set admin rights: "+err.Error(), "Error!")
 			return
 		}
 
@@ -478,7 +494,7 @@ func (a *Account) usersHandler(data string) {
 				Str("user-to-remove-admin", user).
 				Msg("Can't unset admin rights: " + err.Error())
 
-			a.send("error", "Can't unset admin rights: "+err.Error(), "Error!")
+			a.reply(reqID, "error", "Can't unset admin rights: "+err.Error(), "Error!")
 			return
 		}
 
@@ -494,7 +510,7 @@ func (a *Account) usersHandler(data string) {
 			Str("username", a.Username).
 			Msg("Unknown user mod. action requested: " + action)
 
-		a.send("error", "Unknown user mod. action requested: "+action, "Error!")
+		a.reply(reqID, "error", "Unknown user mod. action requested: "+action, "Error!")
 		return
 	}
 }
@@ -507,10 +523,13 @@ func (a *Account) usersHandler(data string) {
  *   - To recreate dropped connections
  *   - To refresh the list of fields to query for the Web GUI autocomplete
  */
-func (a *Account) reloadHandler() {
+func (a *Account) reloadHandler(reqID string) {
+	reloadMx.Lock()
+	defer reloadMx.Unlock()
+
 	err := setupCollectors()
 	if err != nil {
-		a.send("error", "Can't reload collectors: "+err.Error(), "Error!")
+		a.reply(reqID, "error", "Can't reload collectors: "+err.Error(), "Error!")
 
 		log.Info().
 			Str("ip", a.Session.IP).
@@ -519,9 +538,20 @@ func (a *Account) reloadHandler() {
 		return
 	}
 
+	err = setupSourceGroups()
+	if err != nil {
+		a.reply(reqID, "error", "Can't reload sources groups: "+err.Error(), "Error!")
+
+		log.Info().
+			Str("ip", a.Session.IP).
+			Str("username", a.Username).
+			Msg("Can't reload sources groups: " + err.Error())
+		return
+	}
+
 	err = setupProcessors()
 	if err != nil {
-		a.send("error", "Can't reload processors: "+err.Error(), "Error!")
+		a.reply(reqID, "error", "Can't reload processors: "+err.Error(), "Error!")
 
 		log.Info().
 			Str("ip", a.Session.IP).
@@ -530,7 +560,7 @@ func (a *Account) reloadHandler() {
 		return
 	}
 
-	a.send("ok", "", "")
+	a.reply(reqID, "ok", "", "")
 
 	log.Info().
 		Str("ip", a.Session.IP).
@@ -5,16 +5,13 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"regexp"
 	"runtime/debug"
+	"sync"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"golang.org/x/sync/errgroup"
-)
 
-var (
-	// Regex to detect requested data source
-	reSource = regexp.MustCompile(`(?i) *FROM +(.+?) +WHERE `)
+	"github.com/cert-lv/graphoscope/pdk"
 )
 
 /*
@@ -28,15 +25,21 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// User inputs:
-	//   - auth UUID
+	//   - auth UUID, when "Authorization: Bearer" token is not given
 	//   - output format
 	//   - query debug info, disabled by default
+	//   - NDJSON streaming, disabled by default
+	//   - query explanation, disabled by default
 	//   - SQL request
+	//   - continuation token of the next page
 	uuid := r.FormValue("uuid")
 	format := r.FormValue("format")
 	showLimited := false
 	includeDebug := false
+	streaming := r.FormValue("stream") == "true"
+	explain := r.FormValue("explain") == "true"
 	sql := r.FormValue("sql")
+	token := r.FormValue("cursor")
 
 	// Response to send back
 	response := &APIresponse{
@@ -45,26 +48,14 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authenticate user
-	account, err := db.getAccountByUUID(uuid)
+	account, apiToken, err := authenticate(r, uuid)
 	if err != nil {
-		response.Error = "Can't authenticate user by the given UUID"
+		response.Error = "Can't authenticate user by the given token or UUID"
 		response.send(w, ip, "", format, sql)
 
 		log.Error().
 			Str("ip", ip).
-			Msg("Can't authenticate user by the given UUID: " + err.Error())
-		return
-	}
-
-	// Validate SQL query
-	if sql == "" {
-		response.Error = "Query can't be empty"
-		response.send(w, ip, account.Username, format, "")
-
-		log.Error().
-			Str("ip", ip).
-			Str("username", account.Username).
-			Msg("Query can't empty")
+			Msg("Can't authenticate user by the given token or UUID: " + err.Error())
 		return
 	}
 
@@ -78,31 +69,91 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 		includeDebug = true
 	}
 
-	// Find requested data source
-	match := reSource.FindStringSubmatch(sql)
-	if len(match) != 2 {
-		response.Error = "Requested data source missing"
+	// Continue the paginated search with the original query
+	var positions map[string]string
+
+	if token != "" {
+		cursor, err := decodeCursor(token)
+		if err != nil {
+			response.Error = err.Error()
+			response.send(w, ip, account.Username, format, sql)
+
+			log.Error().
+				Str("ip", ip).
+				Str("username", account.Username).
+				Msg("Can't decode cursor: " + err.Error())
+			return
+		}
+
+		sql = cursor.SQL
+		positions = cursor.Positions
+	}
+
+	// Validate SQL query and find requested data source
+	request, err := prepareQuery(sql)
+	if err != nil {
+		response.Error = err.Error()
+		response.send(w, ip, account.Username, format, sql)
+
+		log.Error().
+			Str("ip", ip).
+			Str("username", account.Username).
+			Str("sql", sql).
+			Msg("Invalid query: " + err.Error())
+		return
+	}
+
+	source := request.Source
+	sql = request.SQL
+
+	// Token can be limited to some data sources only
+	err = apiToken.allows(source)
+	if err != nil {
+		response.Error = err.Error()
 		response.send(w, ip, account.Username, format, sql)
 
 		log.Error().
 			Str("ip", ip).
 			Str("username", account.Username).
 			Str("sql", sql).
-			Msg("Requested data source missing")
+			Msg("Forbidden data source: " + err.Error())
 		return
 	}
-	source := match[1]
 
-	// Query data sources for the new relations
-	response = querySources(source, sql, showLimited, includeDebug, account.Username)
+	// Show how data sources would be queried
+	// instead of running the search
+	if explain || request.Explain {
+		response = explainSources(source, sql, account.Username)
+		response.send(w, ip, account.Username, format, sql)
+		return
+	}
+
+	// Write each data source's results as a separate JSON line
+	// as soon as they arrive
+	var stream streamFunc
+	if streaming {
+		stream = streamTo(w, ip, account.Username, sql)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+
+	// Query data sources for the new relations.
+	// Request's context is canceled when the client disconnects
+	response = querySources(r.Context(), source, sql, positions, showLimited, includeDebug, account.Username, stream)
 
 	if len(response.Stats) != 0 {
 		if response.Error != "" {
-			response.Error += ". "
+			response.Error += "\n"
 		}
 		response.Error += "The amount of data has exceeded the limit"
 	}
 
+	// The last line contains a summary
+	// and the relations that were not streamed, like from cache
+	if streaming {
+		response.Done = true
+		format = "json"
+	}
+
 	response.send(w, ip, account.Username, format, sql)
 
 	// Allow OS to take memory back
@@ -110,9 +161,45 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 /*
- * Query all the requested data sources
+ * Create a callback to write streamed results to the API user.
+ * Receives user's IP, name and SQL query for the logging
  */
-func querySources(source, sql string, showLimited, includeDebug bool, username string) *APIresponse {
+func streamTo(w http.ResponseWriter, ip, username, sql string) streamFunc {
+	// Data sources deliver their results concurrently
+	mx := &sync.Mutex{}
+	flusher, _ := w.(http.Flusher)
+
+	return func(source string, relations []map[string]interface{}, status *SourceStatus, pending int) {
+		mx.Lock()
+		defer mx.Unlock()
+
+		partialResponse(source, relations, status).send(w, ip, username, "json", sql)
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+/*
+ * Callback to receive a single data source's results
+ * as soon as they arrive, without waiting for the others.
+ * "pending" is the number of the data source's queries still running
+ */
+type streamFunc func(source string, relations []map[string]interface{}, status *SourceStatus, pending int)
+
+/*
+ * Query all the requested data sources.
+ * Running searches are stopped when the given context is canceled.
+ *
+ * When "stream" callback is given - each data source's results are delivered
+ * through it, merged with the previously delivered ones,
+ * and the returned response contains only the not yet delivered relations.
+ *
+ * Positions of the next page are given when the client continues
+ * a paginated search, nil for the first page
+ */
+func querySources(ctx context.Context, source, sql string, positions map[string]string, showLimited, includeDebug bool, username string, stream streamFunc) *APIresponse {
 
 	// Response to send back
 	response := &APIresponse{
@@ -121,9 +208,20 @@ func querySources(source, sql string, showLimited, includeDebug bool, username s
 		Debug:     make(map[string]interface{}),
 	}
 
-	// Check cache first
-	if config.Database.CacheTTL != 0 {
-		cache, err := db.getCache(sql)
+	// Cache key keeps the relative time expressions as they are,
+	// otherwise the rolling time window never matches a cached one
+	cacheKey := sql
+
+	// Resolve relative time expressions before anything else
+	sql, err := resolveTime(sql)
+	if err != nil {
+		response.Error = "Can't resolve time expression: " + err.Error()
+		return response
+	}
+
+	// Check cache first, only the first page is cached
+	if config.Database.CacheTTL != 0 && positions == nil {
+		cache, err := db.getCache(cacheKey)
 		if err != nil {
 			response.Error = "Can't query cache: " + err.Error()
 		}
@@ -136,26 +234,147 @@ func querySources(source, sql string, showLimited, includeDebug bool, username s
 
 			response.Relations = cache.Relations
 			response.Stats = cache.Stats
+			response.Sources = cache.Sources
+			response.Cursor = cache.Cursor
+			response.summarize()
 
 			return response
 		}
 	}
 
-	// Group of concurrent queries to improve performance
-	group, _ := errgroup.WithContext(context.Background())
+	// Processors errors are not related to any data source
+	processErr := ""
+
+	// Data sources loaded when the search starts,
+	// a reload meanwhile doesn't affect it
+	sourcesMx.RLock()
+	collector, single := collectors[source]
+	_, grouped := sourceGroups[source]
+	selected := groupCollectors(source)
+	queues := schedulers
+	sourcesMx.RUnlock()
+
+	// Group of concurrent queries to improve performance.
+	// Every data source gets its own status, a failed search
+	// doesn't stop the others, so their results are still delivered
+	group := &errgroup.Group{}
+
+	// Identical nodes and edges from the different
+	// data sources and independent queries are merged
+	merger := newRelationMerger()
+
+	// Number of the not finished queries of every data source,
+	// protected by the merger's lock
+	pending := make(map[string]int)
+
+	// Merge a finished query's relations with the already received ones
+	// and deliver them, so the streamed nodes already know all their sources.
+	// Delivered while locked, as the next parts modify merged relations
+	deliver := func(name string, result []map[string]interface{}, status *SourceStatus) {
+		merger.Lock()
+		defer merger.Unlock()
+
+		result = merger.add(result)
+		pending[name]--
+
+		if stream != nil {
+			stream(name, result, status, pending[name])
+		}
+	}
+
+	// Run a single query of the data source
+	search := func(collector pdk.SourcePlugin, query *Query, paged bool) func() error {
+		return func() error {
+			name := collector.Conf().Name
+
+			// Wait for a turn when the data source's requests are limited
+			release, err := queues[name].acquire(ctx, username)
+			if err != nil {
+				status := searchFailed(ctx, name, username, sql, err)
+				response.setStatus(name, status)
+				deliver(name, nil, status)
+				return fmt.Errorf("\"%s\" search failed", name)
+			}
+			defer release()
+
+			// Do not wait for the data source longer than its timeout
+			sctx, cancel := context.WithTimeout(ctx, collector.Conf().Timeout)
+			defer cancel()
+
+			result, stat, debug, next, err := searchPage(sctx, collector, query, paged, positions[name])
+			if err != nil {
+				status := searchFailed(sctx, name, username, sql, err)
+				response.setStatus(name, status)
+				deliver(name, nil, status)
+				return fmt.Errorf("\"%s\" search failed", name)
+			}
+
+			// Data source has more data to request later
+			if next != "" {
+				response.setPosition(name, next)
+			}
+
+			// Apply filters the data source can't handle itself
+			result = query.filter(result)
+
+			status := &SourceStatus{
+				Status: statusOK,
+				Stats:  stat,
+			}
+
+			if stat != nil {
+				status.Status = statusLimited
+
+				// Show partial results only when requested
+				if !showLimited {
+					result = nil
+				}
+			}
+
+			status.Relations = len(result)
+
+			// Process received data by the processor plugins
+			result, err = process(result)
+
+			response.Lock()
+			if err != nil {
+				processErr = err.Error()
+			}
+
+			if includeDebug {
+				response.Debug[name] = debug
+			}
+
+			response.Unlock()
+
+			response.setStatus(name, status)
+			deliver(name, result, status)
+
+			return nil
+		}
+	}
 
 	/*
 	 * Use one specific collector
 	 */
 
-	if collector, ok := collectors[source]; ok {
+	if single {
 
 		// Parse textual SQL into a syntax tree object
-		queries, err := parseSQL(sql, collector.Conf().IncludeDatetime, collector.Conf().IncludeFields, collector.Conf().ReplaceFields, collector.Conf().SupportsSQL)
+		queries, err := parseSQL(sql, collector.Conf().IncludeDatetime, collector.Conf().IncludeFields, collector.Conf().ReplaceFields, collector.Conf().SupportsSQL, matchesCIDR(collector), capabilities(collector))
 		if err != nil {
-			response.Error = err.Error()
+			response.setStatus(collector.Conf().Name, &SourceStatus{
+				Status: statusError,
+				Error:  err.Error(),
+			})
 
 		} else {
+			paged := pageable(collector, queries)
+
+			merger.Lock()
+			pending[collector.Conf().Name] = len(queries)
+			merger.Unlock()
+
 			for i := range queries {
 				// Additional variable to prevent "govet" tool's warning:
 				// loopclosure: loop variable query captured by func literal
@@ -164,61 +383,47 @@ func querySources(source, sql string, showLimited, includeDebug bool, username s
 				log.Info().
 					Str("username", username).
 					Str("sql", sql).
-					Str("modified", sqlparser.String(query)).
+					Str("modified", sqlparser.String(query.Select)).
 					Msg("New request")
 
 				// Run the search
-				group.Go(func() error {
-					result, stat, debug, err := collector.Search(query)
-					if err != nil {
-						return fmt.Errorf("%s", err.Error())
-					}
-
-					if stat != nil {
-						response.Stats = stat
-					}
-
-					response.Lock()
-
-					if stat == nil || (stat != nil && showLimited) {
-						response.Relations = append(response.Relations, result...)
-					}
-
-					if includeDebug {
-						response.Debug[collector.Conf().Name] = debug
-					}
-
-					response.Unlock()
-
-					return nil
-				})
+				group.Go(search(collector, query, paged))
 			}
 		}
 
 		/*
-		 * Search through the all collectors
+		 * Search through the all global collectors
+		 * or a named group of collectors
 		 */
 
-	} else if source == "global" {
+	} else if grouped || source == "global" {
 
 		// Use this pattern instead of 'for _, collector := range collectors {'
 		// because Golang uses a pointer to the same collector
 		// in every 'group.Go(func()', but we need to call everyone
-		for key := range collectors {
-			collector := collectors[key]
+		for i := range selected {
+			collector := selected[i]
 
-			// Skip some collectors,
-			// for example very slow or without full featured query possibilities
-			if !collector.Conf().InGlobal {
+			// Next page is requested only from the data sources with more data
+			if _, ok := positions[collector.Conf().Name]; positions != nil && !ok {
 				continue
 			}
 
 			// Parse textual SQL into syntax tree object
-			queries, err := parseSQL(sql, collector.Conf().IncludeDatetime, collector.Conf().IncludeFields, collector.Conf().ReplaceFields, collector.Conf().SupportsSQL)
+			queries, err := parseSQL(sql, collector.Conf().IncludeDatetime, collector.Conf().IncludeFields, collector.Conf().ReplaceFields, collector.Conf().SupportsSQL, matchesCIDR(collector), capabilities(collector))
 			if err != nil {
-				response.Error = err.Error()
+				response.setStatus(collector.Conf().Name, &SourceStatus{
+					Status: statusError,
+					Error:  err.Error(),
+				})
 
 			} else {
+				paged := pageable(collector, queries)
+
+				merger.Lock()
+				pending[collector.Conf().Name] = len(queries)
+				merger.Unlock()
+
 				for i := range queries {
 					// Additional variable to prevent "govet" tool's warning:
 					// loopclosure: loop variable query captured by func literal
@@ -227,32 +432,12 @@ func querySources(source, sql string, showLimited, includeDebug bool, username s
 					log.Info().
 						Str("username", username).
 						Str("sql", sql).
-						Str("modified", sqlparser.String(query)).
+						Str("modified", sqlparser.String(query.Select)).
 						Str("source", collector.Conf().Name).
 						Msg("New global request")
 
 					// Run the search
-					group.Go(func() error {
-						result, stat, debug, err := collector.Search(query)
-						if err != nil {
-							return fmt.Errorf("%s - %s", collector.Conf().Name, err.Error())
-						}
-
-						if stat != nil {
-							response.Stats = stat
-						}
-
-						response.Lock()
-						response.Relations = append(response.Relations, result...)
-
-						if includeDebug {
-							response.Debug[collector.Conf().Name] = debug
-						}
-
-						response.Unlock()
-
-						return nil
-					})
+					group.Go(search(collector, query, paged))
 				}
 			}
 		}
@@ -265,10 +450,42 @@ func querySources(source, sql string, showLimited, includeDebug bool, username s
 		response.Error = "Unknown data source requested"
 	}
 
-	// Check whether any goroutines failed
-	err := group.Wait()
-	if err != nil {
-		response.Error = err.Error()
+	// Wait for all the searches to finish,
+	// their errors are stored in the data sources statuses
+	failed := group.Wait() != nil
+
+	response.Relations = merger.merged
+
+	// Client has left or stopped the search,
+	// partial results can't be cached
+	canceled := ctx.Err() != nil
+	if canceled {
+		response.Error = "Search canceled"
+	}
+
+	// Incomplete results are not cached either
+	for _, status := range response.Sources {
+		if status.Status == statusError || status.Status == statusTimeout {
+			failed = true
+		}
+	}
+
+	// Remember the data sources which have responded successfully,
+	// failures are remembered when they are logged
+	if !canceled {
+		for name, status := range response.Sources {
+			if status.Status == statusOK || status.Status == statusLimited {
+				observeHealth(name, nil)
+			}
+		}
+	}
+
+	// Token to request the next page of the results
+	if !canceled && len(response.positions) != 0 {
+		response.Cursor, err = encodeCursor(source, sql, response.positions)
+		if err != nil {
+			response.Error = "Can't create cursor: " + err.Error()
+		}
 	}
 
 	// Format warning for the Web GUI modal window,
@@ -282,6 +499,17 @@ func querySources(source, sql string, showLimited, includeDebug bool, username s
 		response.Error = fmt.Sprintf("\"%s\" error: %s", source, response.Error)
 	}
 
+	// Fill the common error and stats fields
+	// by the data sources statuses
+	response.summarize()
+
+	if processErr != "" {
+		if response.Error != "" {
+			response.Error += "\n"
+		}
+		response.Error += processErr
+	}
+
 	if len(response.Relations) != 0 || len(response.Stats) != 0 {
 		log.Debug().
 			Str("username", username).
@@ -296,19 +524,111 @@ func querySources(source, sql string, showLimited, includeDebug bool, username s
 			Msg("No relations data found")
 	}
 
-	// Cache results to make the identical future requests faster
-	if config.Database.CacheTTL != 0 {
-		db.setCache(sql, response.Relations, response.Stats)
+	// Cache results to make the identical future requests faster.
+	// Relations are already processed by the processor plugins
+	if config.Database.CacheTTL != 0 && !canceled && !failed && positions == nil {
+		db.setCache(cacheKey, response.Relations, response.Stats, response.Sources, response.Cursor)
 	}
 
-	// Process received data by the processor plugins
-	for _, processor := range processors {
-		response.Relations, err = processor.Process(response.Relations)
-		if err != nil {
-			response.Error = fmt.Sprintf("\"%s\" error: %s", processor.Conf().Name, err.Error())
-		}
+	// Relations were already delivered to the client
+	if stream != nil {
+		response.Relations = []map[string]interface{}{}
 	}
 
 	// Return the request results
 	return response
 }
+
+/*
+ * Get collectors of the "global" namespace
+ * or of the named data sources group.
+ * Must be called with the loaded data sources locked
+ */
+func groupCollectors(source string) []pdk.SourcePlugin {
+	selected := []pdk.SourcePlugin{}
+
+	if group, ok := sourceGroups[source]; ok {
+		for _, name := range group.Sources {
+			if collector, ok := collectors[name]; ok {
+				selected = append(selected, collector)
+			}
+		}
+
+		return selected
+	}
+
+	for _, collector := range collectors {
+		// Skip some collectors,
+		// for example very slow or without full featured query possibilities
+		if collector.Conf().InGlobal {
+			selected = append(selected, collector)
+		}
+	}
+
+	return selected
+}
+
+/*
+ * Process a single data source's relations by the processor plugins
+ */
+func process(relations []map[string]interface{}) ([]map[string]interface{}, error) {
+	var err, processErr error
+
+	sourcesMx.RLock()
+	chain := processors
+	sourcesMx.RUnlock()
+
+	for _, processor := range chain {
+		relations, err = processor.Process(relations)
+		if err != nil {
+			processErr = fmt.Errorf("\"%s\" error: %s", processor.Conf().Name, err.Error())
+		}
+	}
+
+	return relations, processErr
+}
+
+/*
+ * Get the status of a failed search.
+ * Receives search's context to detect a timeout
+ */
+func failedStatus(ctx context.Context, err error) *SourceStatus {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &SourceStatus{
+			Status: statusTimeout,
+			Error:  "Data source has not responded in time",
+		}
+	}
+
+	return &SourceStatus{
+		Status: statusError,
+		Error:  err.Error(),
+	}
+}
+
+/*
+ * Handle a failed search of the data source: log it
+ * and get its status.
+ *
+ * Receives the search's own context to detect a timeout and the search error
+ */
+func searchFailed(sctx context.Context, source, username, sql string, err error) *SourceStatus {
+	status := failedStatus(sctx, err)
+	logSearchError(source, username, sql, err)
+
+	return status
+}
+
+/*
+ * Log a single data source search error
+ * and remember it for the data source's health
+ */
+func logSearchError(source, username, sql string, err error) {
+	log.Error().
+		Str("username", username).
+		Str("sql", sql).
+		Str("source", source).
+		Msg("Search error: " + err.Error())
+
+	observeHealth(source, err)
+}
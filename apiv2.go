@@ -0,0 +1,1163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+const (
+	// Prefix of the versioned JSON REST API
+	apiV2Prefix = "/api/v2"
+
+	// Max size of the JSON request body
+	apiMaxBody = 10 << 20
+)
+
+/*
+ * Error returned by the versioned API,
+ * always wrapped as {"error": {...}} in the response
+ */
+type APIerror struct {
+	// HTTP status code of the response
+	Status int `json:"-"`
+
+	// Machine readable error code, like "not_found"
+	Code string `json:"code"`
+
+	// Human readable error message
+	Message string `json:"message"`
+}
+
+func (e *APIerror) Error() string {
+	return e.Message
+}
+
+/*
+ * Create a new API error with a code derived from the HTTP status
+ */
+func newAPIerror(status int, message string) *APIerror {
+	return &APIerror{
+		Status:  status,
+		Code:    strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+		Message: message,
+	}
+}
+
+/*
+ * Create an API error of the invalid user's query.
+ * Unknown data source is not found, other errors are bad requests
+ */
+func queryAPIerror(err error) *APIerror {
+	if e, ok := err.(*QueryError); ok && e.Kind == queryErrUnknown {
+		return newAPIerror(http.StatusNotFound, e.Message)
+	}
+
+	return newAPIerror(http.StatusBadRequest, err.Error())
+}
+
+/*
+ * Body of the failed API response
+ */
+type APIerrorResponse struct {
+	Error *APIerror `json:"error"`
+}
+
+/*
+ * Single route of the versioned API with its description
+ * for the generated OpenAPI document
+ */
+type apiRoute struct {
+	// HTTP method and path relative to the API prefix,
+	// may contain "{name}" path parameters
+	Method string
+	Path   string
+
+	// Short description of the operation
+	Summary string
+
+	// Optional query parameters
+	Query []string
+
+	// Empty values of the request and response bodies
+	// to describe their schema, nil when there is no JSON body
+	Request  interface{}
+	Response interface{}
+
+	// HTTP status code of the successful response, 200 by default
+	Status int
+
+	// Whether the route is available without authentication
+	Public bool
+
+	// Whether the route modifies the data,
+	// forbidden for the read-only tokens
+	Write bool
+
+	// Whether the route is served without the version too,
+	// directly under the "/api"
+	Unversioned bool
+
+	// Route's handler. Returns a value to send back as JSON
+	// or nil when the response was written by the handler itself
+	handler func(*apiRequest) (interface{}, error)
+}
+
+/*
+ * Context of a single API request
+ */
+type apiRequest struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	ip      string
+	account *Account
+
+	// API token used to authenticate, nil for the UUID
+	token *Token
+}
+
+/*
+ * Request body of a search
+ */
+type SearchRequest struct {
+	// SQL query, not needed when cursor is given
+	SQL string `json:"sql,omitempty"`
+
+	// Continuation token of the next page
+	Cursor string `json:"cursor,omitempty"`
+
+	// Show how data sources would be queried instead of running the search
+	Explain bool `json:"explain,omitempty"`
+
+	// Include queries debug info
+	Debug bool `json:"debug,omitempty"`
+
+	// Show partial results when limit exceeded
+	ShowLimited bool `json:"showLimited,omitempty"`
+
+	// Output format: "json" by default, "table", "csv", "ndjson", "graphml" or "gexf"
+	Format string `json:"format,omitempty"`
+}
+
+/*
+ * Notes of the graph element
+ */
+type Notes struct {
+	// Graph element's ID/value
+	ID string `json:"id"`
+
+	// Notes content, empty to delete them
+	Notes string `json:"notes"`
+}
+
+/*
+ * Request body of a new list of indicators to process in a background
+ */
+type UploadRequest struct {
+	// Name to describe the list, "api.txt" by default
+	Name string `json:"name,omitempty"`
+
+	// Indicators or "field='value'" filters, one query per entry
+	Indicators []string `json:"indicators"`
+
+	// Data source to query
+	Source string `json:"source"`
+
+	// Datetime range to search in
+	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime"`
+
+	// Output format of the report: "json", "table", "csv", "ndjson", "graphml" or "gexf"
+	Format string `json:"format"`
+
+	// Data source's field to check when indicators are plain values
+	Field string `json:"field,omitempty"`
+}
+
+/*
+ * User's uploaded lists of indicators
+ */
+type UploadLists struct {
+	// Files waiting to be processed
+	Queued []string `json:"queued"`
+
+	// Processed files, their reports can be downloaded
+	Processed []string `json:"processed"`
+}
+
+/*
+ * Data source's description
+ */
+type SourceInfo struct {
+	Name      string          `json:"name"`
+	Label     string          `json:"label,omitempty"`
+	Plugin    string          `json:"plugin"`
+	Fields    []string        `json:"fields"`
+	Relations []*RelationInfo `json:"relations"`
+}
+
+/*
+ * Data source's relation description
+ */
+type RelationInfo struct {
+	From *NodeInfo `json:"from"`
+	To   *NodeInfo `json:"to"`
+	Edge *EdgeInfo `json:"edge,omitempty"`
+}
+
+/*
+ * Data source's relation node description
+ */
+type NodeInfo struct {
+	ID         string   `json:"id"`
+	Group      string   `json:"group"`
+	Search     string   `json:"search"`
+	Attributes []string `json:"attributes,omitempty"`
+
+	// Patterns of the uncommon node types
+	VarTypes []*VarTypeInfo `json:"varTypes,omitempty"`
+}
+
+/*
+ * Uncommon type of the relation's node
+ */
+type VarTypeInfo struct {
+	Regex  string `json:"regex"`
+	Group  string `json:"group,omitempty"`
+	Search string `json:"search,omitempty"`
+	Label  string `json:"label,omitempty"`
+}
+
+/*
+ * Data source's relation edge description
+ */
+type EdgeInfo struct {
+	Label      string   `json:"label,omitempty"`
+	Attributes []string `json:"attributes,omitempty"`
+}
+
+/*
+ * A list of all versioned API routes
+ */
+var apiRoutes = []*apiRoute{
+	{
+		Method:   http.MethodPost,
+		Path:     "/search",
+		Summary:  "Search in the data sources",
+		Request:  &SearchRequest{},
+		Response: &APIresponse{},
+		handler:  apiSearch,
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/lookup",
+		Summary:  "Look up a list of indicators, each one separately",
+		Request:  &LookupRequest{},
+		Response: &LookupResponse{},
+		handler:  apiLookup,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/jobs",
+		Summary:     "Start a search in a background",
+		Request:     &JobRequest{},
+		Response:    &Job{},
+		Status:      http.StatusAccepted,
+		Unversioned: true,
+		handler:     apiJobCreate,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/jobs/{id}",
+		Summary:     "Get search job's progress and results",
+		Response:    &Job{},
+		Unversioned: true,
+		handler:     apiJob,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/jobs/{id}",
+		Summary:     "Cancel a search job and delete its results",
+		Status:      http.StatusNoContent,
+		Unversioned: true,
+		handler:     apiJobDelete,
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/sources",
+		Summary:  "List data sources with their fields and relations",
+		Response: []*SourceInfo{},
+		handler:  apiSources,
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/catalog",
+		Summary:  "List data sources definitions with their health, and groups",
+		Response: &Catalog{},
+		handler:  apiCatalog,
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/catalog/{name}",
+		Summary:  "Get data source's definition and health",
+		Response: &CatalogSource{},
+		handler:  apiCatalogSource,
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/maltego",
+		Summary:  "List Maltego transforms of the data sources",
+		Response: []*MaltegoTransform{},
+		handler:  apiMaltego,
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/dashboards",
+		Summary:  "List own and shared dashboards",
+		Response: []*Dashboard{},
+		handler:  apiDashboards,
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/dashboards",
+		Summary:  "Create a new dashboard",
+		Request:  &Dashboard{},
+		Response: &Dashboard{},
+		Status:   http.StatusCreated,
+		Write:    true,
+		handler:  apiDashboardCreate,
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/dashboards/{name}",
+		Summary:  "Get a dashboard",
+		Query:    []string{"shared"},
+		Response: &Dashboard{},
+		handler:  apiDashboard,
+	},
+	{
+		Method:   http.MethodPut,
+		Path:     "/dashboards/{name}",
+		Summary:  "Replace a dashboard's content",
+		Request:  &Dashboard{},
+		Response: &Dashboard{},
+		Write:    true,
+		handler:  apiDashboardUpdate,
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/dashboards/{name}",
+		Summary: "Delete a dashboard",
+		Query:   []string{"shared"},
+		Status:  http.StatusNoContent,
+		Write:   true,
+		handler: apiDashboardDelete,
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/notes/{id}",
+		Summary:  "Get notes of the graph element",
+		Response: &Notes{},
+		handler:  apiNotes,
+	},
+	{
+		Method:   http.MethodPut,
+		Path:     "/notes/{id}",
+		Summary:  "Set notes of the graph element",
+		Request:  &Notes{},
+		Response: &Notes{},
+		Write:    true,
+		handler:  apiNotesUpdate,
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/notes/{id}",
+		Summary: "Delete notes of the graph element",
+		Status:  http.StatusNoContent,
+		Write:   true,
+		handler: apiNotesDelete,
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/uploads",
+		Summary:  "List queued and processed lists of indicators",
+		Response: &UploadLists{},
+		handler:  apiUploads,
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/uploads",
+		Summary:  "Submit a list of indicators to process in a background",
+		Request:  &UploadRequest{},
+		Response: &UploadLists{},
+		Status:   http.StatusAccepted,
+		Write:    true,
+		handler:  apiUploadCreate,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/uploads/{name}",
+		Summary: "Download the report of the processed list",
+		handler: apiUploadReport,
+	},
+}
+
+/*
+ * Register the versioned API routes
+ */
+func setupAPIv2() {
+	// Generated document describes itself too
+	apiRoutes = append(apiRoutes, &apiRoute{
+		Method:   http.MethodGet,
+		Path:     "/openapi.json",
+		Summary:  "Get OpenAPI document of this API",
+		Response: map[string]interface{}{},
+		Public:   true,
+		handler:  apiOpenAPI,
+	})
+
+	for _, route := range apiRoutes {
+		http.HandleFunc(route.Method+" "+apiV2Prefix+route.Path, route.serve)
+
+		if route.Unversioned {
+			http.HandleFunc(route.Method+" /api"+route.Path, route.serve)
+		}
+	}
+
+	// Unknown routes get the same error format
+	http.HandleFunc(apiV2Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIerror(w, newAPIerror(http.StatusNotFound, "Unknown API route: "+r.Method+" "+r.URL.Path))
+	})
+}
+
+/*
+ * Authenticate the user and run the route's handler
+ */
+func (route *apiRoute) serve(w http.ResponseWriter, r *http.Request) {
+	// Get requestor IP
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		log.Error().Msg("User IP: " + r.RemoteAddr + " is not IP:port")
+	}
+
+	req := &apiRequest{w: w, r: r, ip: ip}
+
+	if !route.Public {
+		req.account, req.token, err = apiAccount(r)
+		if err != nil {
+			writeAPIerror(w, newAPIerror(http.StatusUnauthorized, "Can't authenticate user by the given token or UUID"))
+
+			log.Error().
+				Str("ip", ip).
+				Msg("Can't authenticate user by the given token or UUID: " + err.Error())
+			return
+		}
+
+		if route.Write {
+			if err := req.token.writable(); err != nil {
+				writeAPIerror(w, newAPIerror(http.StatusForbidden, err.Error()))
+
+				log.Error().
+					Str("ip", ip).
+					Str("username", req.username()).
+					Str("route", route.Method+" "+route.Path).
+					Msg("Forbidden API request: " + err.Error())
+				return
+			}
+		}
+	}
+
+	result, err := route.handler(req)
+	if err != nil {
+		apiErr := &APIerror{}
+		if !errors.As(err, &apiErr) {
+			apiErr = newAPIerror(http.StatusInternalServerError, err.Error())
+		}
+
+		writeAPIerror(w, apiErr)
+
+		log.Error().
+			Str("ip", ip).
+			Str("username", req.username()).
+			Str("route", route.Method+" "+route.Path).
+			Msg("API request failed: " + apiErr.Message)
+		return
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+
+	// Response is already written
+	if result == nil {
+		return
+	}
+
+	writeJSON(w, status, result)
+}
+
+/*
+ * Find the account by the "Authorization: Bearer" token or by the UUID
+ * given in "X-UUID" header or "uuid" query parameter.
+ * Online user's account is used to keep its Web GUI session up to date
+ */
+func apiAccount(r *http.Request) (*Account, *Token, error) {
+	uuid := r.Header.Get("X-UUID")
+	if uuid == "" {
+		uuid = r.URL.Query().Get("uuid")
+	}
+
+	account, token, err := authenticate(r, uuid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if current, ok := online[account.Username]; ok {
+		return current, token, nil
+	}
+
+	return account, token, nil
+}
+
+/*
+ * Get the name of the authenticated user, if any
+ */
+func (req *apiRequest) username() string {
+	if req.account == nil {
+		return ""
+	}
+
+	return req.account.Username
+}
+
+/*
+ * Decode JSON request body into the given value
+ */
+func (req *apiRequest) decode(v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(req.w, req.r.Body, apiMaxBody))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return newAPIerror(http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+	}
+
+	return nil
+}
+
+/*
+ * Send a JSON response with the given status code
+ */
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Error().Msg("Can't send API response: " + err.Error())
+	}
+}
+
+/*
+ * Send an error response
+ */
+func writeAPIerror(w http.ResponseWriter, err *APIerror) {
+	writeJSON(w, err.Status, &APIerrorResponse{Error: err})
+}
+
+/*
+ * Handlers of the routes
+ */
+
+func apiOpenAPI(req *apiRequest) (interface{}, error) {
+	return openAPI(), nil
+}
+
+func apiSearch(req *apiRequest) (interface{}, error) {
+	search := &SearchRequest{}
+	if err := req.decode(search); err != nil {
+		return nil, err
+	}
+
+	if !validFormat(search.Format) {
+		return nil, newAPIerror(http.StatusBadRequest, "Unknown output format: "+search.Format)
+	}
+
+	sql := search.SQL
+
+	// Continue the paginated search with the original query
+	var positions map[string]string
+
+	if search.Cursor != "" {
+		cursor, err := decodeCursor(search.Cursor)
+		if err != nil {
+			return nil, newAPIerror(http.StatusBadRequest, err.Error())
+		}
+
+		sql = cursor.SQL
+		positions = cursor.Positions
+	}
+
+	// Validate SQL query and find requested data source
+	request, err := prepareQuery(sql)
+	if err != nil {
+		return nil, queryAPIerror(err)
+	}
+
+	if err := req.token.allows(request.Source); err != nil {
+		return nil, newAPIerror(http.StatusForbidden, err.Error())
+	}
+
+	var response *APIresponse
+
+	if search.Explain || request.Explain {
+		response = explainSources(request.Source, request.SQL, req.account.Username)
+
+	} else {
+		response = querySources(req.r.Context(), request.Source, request.SQL, positions, search.ShowLimited, search.Debug, req.account.Username, nil)
+
+		if len(response.Stats) != 0 {
+			if response.Error != "" {
+				response.Error += "\n"
+			}
+			response.Error += "The amount of data has exceeded the limit"
+		}
+	}
+
+	// Other formats are written as they are
+	if search.Format != "" && search.Format != "json" {
+		response.send(req.w, req.ip, req.account.Username, search.Format, request.SQL)
+		return nil, nil
+	}
+
+	return response, nil
+}
+
+func apiLookup(req *apiRequest) (interface{}, error) {
+	request := &LookupRequest{}
+	if err := req.decode(request); err != nil {
+		return nil, err
+	}
+
+	if err := request.validate(); err != nil {
+		return nil, newAPIerror(http.StatusBadRequest, err.Error())
+	}
+
+	if err := req.token.allows(request.Source); err != nil {
+		return nil, newAPIerror(http.StatusForbidden, err.Error())
+	}
+
+	response := lookupIndicators(req.r.Context(), request, req.account.Username)
+
+	log.Info().
+		Str("ip", req.ip).
+		Str("username", req.account.Username).
+		Str("source", request.Source).
+		Int("indicators", len(request.Indicators)).
+		Int("hits", response.Hits).
+		Msg("Indicators looked up")
+
+	return response, nil
+}
+
+func apiJobCreate(req *apiRequest) (interface{}, error) {
+	request := &JobRequest{}
+	if err := req.decode(request); err != nil {
+		return nil, err
+	}
+
+	sql := request.SQL
+
+	// Continue the paginated search with the original query
+	var positions map[string]string
+
+	if request.Cursor != "" {
+		cursor, err := decodeCursor(request.Cursor)
+		if err != nil {
+			return nil, newAPIerror(http.StatusBadRequest, err.Error())
+		}
+
+		sql = cursor.SQL
+		positions = cursor.Positions
+	}
+
+	// Validate SQL query and find requested data source
+	query, err := prepareQuery(sql)
+	if err != nil {
+		return nil, queryAPIerror(err)
+	}
+
+	if err := req.token.allows(query.Source); err != nil {
+		return nil, newAPIerror(http.StatusForbidden, err.Error())
+	}
+
+	job, err := startJob(req.account.Username, query.Source, query.SQL, positions, request.ShowLimited, request.Debug)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("ip", req.ip).
+		Str("username", req.account.Username).
+		Str("job", job.ID).
+		Str("sql", job.SQL).
+		Msg("Job started")
+
+	return job, nil
+}
+
+func apiJob(req *apiRequest) (interface{}, error) {
+	job, err := db.getJob(req.r.PathValue("id"), req.account.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if job == nil {
+		return nil, newAPIerror(http.StatusNotFound, "Job doesn't exist or has expired")
+	}
+
+	return job, nil
+}
+
+func apiJobDelete(req *apiRequest) (interface{}, error) {
+	id := req.r.PathValue("id")
+
+	exists, err := cancelJob(id, req.account.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, newAPIerror(http.StatusNotFound, "Job doesn't exist or has expired")
+	}
+
+	log.Info().
+		Str("ip", req.ip).
+		Str("username", req.account.Username).
+		Str("job", id).
+		Msg("Job deleted")
+
+	return nil, nil
+}
+
+func apiSources(req *apiRequest) (interface{}, error) {
+	sourcesMx.RLock()
+	defer sourcesMx.RUnlock()
+
+	list := make([]*SourceInfo, 0, len(collectors))
+
+	for name, collector := range collectors {
+		// Restricted token sees only the allowed data sources
+		if req.token.allows(name) != nil {
+			continue
+		}
+
+		conf := collector.Conf()
+
+		info := &SourceInfo{
+			Name:      name,
+			Label:     conf.Label,
+			Plugin:    conf.Plugin,
+			Fields:    fields[name],
+			Relations: relationsInfo(conf.Relations),
+		}
+
+		if info.Fields == nil {
+			info.Fields = []string{}
+		}
+
+		list = append(list, info)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list, nil
+}
+
+func apiCatalog(req *apiRequest) (interface{}, error) {
+	return catalog(req.token), nil
+}
+
+func apiCatalogSource(req *apiRequest) (interface{}, error) {
+	name := req.r.PathValue("name")
+
+	// Not allowed data source looks like a missing one
+	if req.token.allows(name) != nil {
+		return nil, newAPIerror(http.StatusNotFound, "Data source doesn't exist")
+	}
+
+	sourcesMx.RLock()
+	source := catalogSource(name)
+	sourcesMx.RUnlock()
+
+	if source == nil {
+		return nil, newAPIerror(http.StatusNotFound, "Data source doesn't exist")
+	}
+
+	return source, nil
+}
+
+func apiMaltego(req *apiRequest) (interface{}, error) {
+	return maltegoTransforms(), nil
+}
+
+/*
+ * Describe data source's relations
+ */
+func relationsInfo(relations []*pdk.Relation) []*RelationInfo {
+	list := make([]*RelationInfo, 0, len(relations))
+
+	for _, relation := range relations {
+		r := &RelationInfo{
+			From: nodeInfo(relation.From),
+			To:   nodeInfo(relation.To),
+		}
+
+		if relation.Edge != nil {
+			r.Edge = &EdgeInfo{
+				Label:      relation.Edge.Label,
+				Attributes: relation.Edge.Attributes,
+			}
+		}
+
+		list = append(list, r)
+	}
+
+	return list
+}
+
+/*
+ * Describe relation's node, nil when it's not defined
+ */
+func nodeInfo(node *pdk.Node) *NodeInfo {
+	if node == nil {
+		return nil
+	}
+
+	info := &NodeInfo{
+		ID:         node.ID,
+		Group:      node.Group,
+		Search:     node.Search,
+		Attributes: node.Attributes,
+	}
+
+	for _, varType := range node.VarTypes {
+		info.VarTypes = append(info.VarTypes, &VarTypeInfo{
+			Regex:  varType.Regex,
+			Group:  varType.Group,
+			Search: varType.Search,
+			Label:  varType.Label,
+		})
+	}
+
+	return info
+}
+
+func apiDashboards(req *apiRequest) (interface{}, error) {
+	shared, err := db.getSharedDashboards()
+	if err != nil {
+		return nil, err
+	}
+
+	req.account.DashboardsMutex.RLock()
+	list := make([]*Dashboard, 0, len(req.account.Dashboards)+len(shared))
+
+	for _, dashboard := range req.account.Dashboards {
+		list = append(list, dashboard)
+	}
+	req.account.DashboardsMutex.RUnlock()
+
+	for _, dashboard := range shared {
+		list = append(list, dashboard)
+	}
+
+	return list, nil
+}
+
+func apiDashboardCreate(req *apiRequest) (interface{}, error) {
+	dashboard := &Dashboard{}
+	if err := req.decode(dashboard); err != nil {
+		return nil, err
+	}
+
+	err := req.account.saveDashboard(req.ip, dashboard)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep Web GUI in sync
+	notifyDashboard(req.account, dashboard)
+
+	return dashboard, nil
+}
+
+func apiDashboard(req *apiRequest) (interface{}, error) {
+	name := req.r.PathValue("name")
+
+	if req.r.URL.Query().Get("shared") == "true" {
+		dashboard, err := db.getSharedDashboard(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if dashboard != nil {
+			return dashboard, nil
+		}
+
+	} else {
+		req.account.DashboardsMutex.RLock()
+		dashboard, ok := req.account.Dashboards[name]
+		req.account.DashboardsMutex.RUnlock()
+
+		if ok {
+			return dashboard, nil
+		}
+	}
+
+	return nil, newAPIerror(http.StatusNotFound, "Dashboard doesn't exist.")
+}
+
+func apiDashboardUpdate(req *apiRequest) (interface{}, error) {
+	dashboard := &Dashboard{}
+	if err := req.decode(dashboard); err != nil {
+		return nil, err
+	}
+
+	dashboard.Name = req.r.PathValue("name")
+
+	err := req.account.updateDashboard(req.ip, dashboard)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep Web GUI in sync
+	notifyDashboard(req.account, dashboard)
+
+	return dashboard, nil
+}
+
+func apiDashboardDelete(req *apiRequest) (interface{}, error) {
+	name := req.r.PathValue("name")
+	shared := req.r.URL.Query().Get("shared") == "true"
+
+	err := req.account.deleteDashboard(req.ip, name, shared)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep Web GUI in sync
+	if shared {
+		broadcast("dashboard-deleted", name, "true")
+	} else {
+		req.account.send("dashboard-deleted", name, "false")
+	}
+
+	return nil, nil
+}
+
+/*
+ * Send a saved dashboard to the Web GUI clients
+ */
+func notifyDashboard(account *Account, dashboard *Dashboard) {
+	bytes, err := json.Marshal(dashboard)
+	if err != nil {
+		log.Error().
+			Str("username", account.Username).
+			Msg("Can't marshal websocket message: " + err.Error())
+		return
+	}
+
+	if dashboard.Shared {
+		broadcast("dashboard-saved", string(bytes), "")
+	} else {
+		account.send("dashboard-saved", string(bytes), "")
+	}
+}
+
+func apiNotes(req *apiRequest) (interface{}, error) {
+	id := req.r.PathValue("id")
+
+	notes, err := db.getNotes(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notes{ID: id, Notes: notes}, nil
+}
+
+func apiNotesUpdate(req *apiRequest) (interface{}, error) {
+	notes := &Notes{}
+	if err := req.decode(notes); err != nil {
+		return nil, err
+	}
+
+	notes.ID = req.r.PathValue("id")
+	notes.Notes = strings.TrimSpace(notes.Notes)
+
+	// Empty notes are deleted
+	if notes.Notes == "" {
+		if err := db.delNotes(notes.ID); err != nil {
+			return nil, newAPIerror(http.StatusNotFound, err.Error())
+		}
+
+	} else if err := db.setNotes(notes.ID, notes.Notes); err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("ip", req.ip).
+		Str("username", req.account.Username).
+		Str("id", notes.ID).
+		Str("notes", notes.Notes).
+		Msg("Notes set")
+
+	return notes, nil
+}
+
+func apiNotesDelete(req *apiRequest) (interface{}, error) {
+	id := req.r.PathValue("id")
+
+	err := db.delNotes(id)
+	if err != nil {
+		return nil, newAPIerror(http.StatusNotFound, err.Error())
+	}
+
+	log.Info().
+		Str("ip", req.ip).
+		Str("username", req.account.Username).
+		Str("id", id).
+		Msg("Notes deleted")
+
+	return nil, nil
+}
+
+func apiUploads(req *apiRequest) (interface{}, error) {
+	lists := &UploadLists{
+		Queued:    []string{},
+		Processed: []string{},
+	}
+
+	for name := range req.account.Uploads.In {
+		lists.Queued = append(lists.Queued, name)
+	}
+
+	lists.Processed = append(lists.Processed, req.account.Uploads.Out...)
+	sort.Strings(lists.Queued)
+
+	return lists, nil
+}
+
+func apiUploadCreate(req *apiRequest) (interface{}, error) {
+	upload := &UploadRequest{}
+	if err := req.decode(upload); err != nil {
+		return nil, err
+	}
+
+	if len(upload.Indicators) == 0 {
+		return nil, newAPIerror(http.StatusBadRequest, "Indicators list can't be empty")
+	}
+
+	// The same validation as for the Web GUI uploads,
+	// before anything is stored
+	if err := validUploads("source", upload.Source); err != nil {
+		return nil, newAPIerror(http.StatusBadRequest, "Invalid 'source' value: "+err.Error())
+	}
+	if err := validUploads("datetime", upload.StartTime); err != nil {
+		return nil, newAPIerror(http.StatusBadRequest, "Invalid 'startTime' value: "+err.Error())
+	}
+	if err := validUploads("datetime", upload.EndTime); err != nil {
+		return nil, newAPIerror(http.StatusBadRequest, "Invalid 'endTime' value: "+err.Error())
+	}
+	if err := validUploads("field", upload.Field); err != nil {
+		return nil, newAPIerror(http.StatusBadRequest, "Invalid 'field' value: "+err.Error())
+	}
+	if upload.Format == "" || !validFormat(upload.Format) {
+		return nil, newAPIerror(http.StatusBadRequest, "Invalid 'format' value: "+upload.Format+", 'json', 'table', 'csv', 'ndjson', 'graphml' or 'gexf' expected")
+	}
+
+	if err := req.token.allows(upload.Source); err != nil {
+		return nil, newAPIerror(http.StatusForbidden, err.Error())
+	}
+
+	if upload.Name == "" {
+		upload.Name = "api.txt"
+	}
+
+	// Validate file name
+	if strings.Contains(upload.Name, "..") || strings.Contains(upload.Name, "/") {
+		return nil, newAPIerror(http.StatusBadRequest, "Invalid name: "+upload.Name)
+	}
+
+	filename := time.Now().Format("20060102-150405-07") + "-" + upload.Name
+
+	err := os.WriteFile(config.Upload.Path+"/queue/"+filename, []byte(strings.Join(upload.Indicators, "\n")+"\n"), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Can't store uploaded list: " + err.Error())
+	}
+
+	params := &Upload{
+		Source:    upload.Source,
+		StartTime: upload.StartTime,
+		EndTime:   upload.EndTime,
+		Format:    upload.Format,
+		Field:     upload.Field,
+	}
+
+	err = req.account.addUpload(filename, params)
+	if err != nil {
+		return nil, fmt.Errorf("Can't add uploaded file to the user's in-queue: " + err.Error())
+	}
+
+	log.Info().
+		Str("ip", req.ip).
+		Str("username", req.account.Username).
+		Str("filename", upload.Name).
+		Msg("File uploaded")
+
+	req.account.startUpload(req.ip, filename, params)
+
+	return &UploadLists{
+		Queued:    []string{filename},
+		Processed: []string{},
+	}, nil
+}
+
+func apiUploadReport(req *apiRequest) (interface{}, error) {
+	name := req.r.PathValue("name")
+
+	for _, processed := range req.account.Uploads.Out {
+		if processed == name {
+			req.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			http.ServeFile(req.w, req.r, config.Upload.Path+"/processed/"+name)
+
+			log.Info().
+				Str("ip", req.ip).
+				Str("username", req.account.Username).
+				Str("filename", name).
+				Msg("Processed file downloaded")
+
+			return nil, nil
+		}
+	}
+
+	if _, ok := req.account.Uploads.In[name]; ok {
+		return nil, newAPIerror(http.StatusConflict, "File is not processed yet")
+	}
+
+	return nil, newAPIerror(http.StatusNotFound, "Processed file doesn't exist")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+/*
+ * Test uploaded lists parameters are validated before they are stored
+ */
+func TestUploadCreateValidation(t *testing.T) {
+	tests := []string{
+		`{"indicators":["10.10.10.10"],"startTime":"2026-10-16T00:00:00.000Z","endTime":"2026-10-16T01:00:00.000Z","format":"json"}`,
+		`{"indicators":["10.10.10.10"],"source":"global","startTime":"yesterday","endTime":"2026-10-16T01:00:00.000Z","format":"json"}`,
+		`{"indicators":["10.10.10.10"],"source":"global","startTime":"2026-10-16T00:00:00.000Z","endTime":"' OR 1=1","format":"json"}`,
+		`{"indicators":["10.10.10.10"],"source":"global","startTime":"2026-10-16T00:00:00.000Z","endTime":"2026-10-16T01:00:00.000Z","format":"xml"}`,
+		`{"indicators":["10.10.10.10"],"source":"global","startTime":"2026-10-16T00:00:00.000Z","endTime":"2026-10-16T01:00:00.000Z","format":"json","field":"ip='1' OR ip"}`,
+	}
+
+	for _, body := range tests {
+		req := &apiRequest{
+			w: httptest.NewRecorder(),
+			r: httptest.NewRequest(http.MethodPost, apiV2Prefix+"/uploads", strings.NewReader(body)),
+		}
+
+		_, err := apiUploadCreate(req)
+
+		apiErr, ok := err.(*APIerror)
+		if !ok || apiErr.Status != http.StatusBadRequest {
+			t.Errorf("Invalid upload is not refused: %s, error: %v", body, err)
+		}
+	}
+}
+
+/*
+ * Test indicators are turned into the filters
+ * which can't change the query
+ */
+func TestIndicatorFilter(t *testing.T) {
+	tests := []struct {
+		field     string
+		indicator string
+		filter    string
+	}{
+		{"", " port=8080 ", "port=8080"},
+		{"ip", "10.10.10.10", "ip='10.10.10.10'"},
+		{"port", "8080", "port=8080"},
+		{"name", "O'Brien", `name='O\'Brien'`},
+		{"name", `x' OR name='y`, `name='x\' OR name=\'y'`},
+		{"source.ip", "10.10.10.10", "source.ip='10.10.10.10'"},
+	}
+
+	for _, test := range tests {
+		filter, err := indicatorFilter(test.field, test.indicator)
+		if err != nil {
+			t.Errorf("Can't convert '%s' of '%s': %s", test.indicator, test.field, err.Error())
+			continue
+		}
+
+		if filter != test.filter {
+			t.Errorf("Invalid filter of '%s': %s, expected: %s", test.indicator, filter, test.filter)
+			continue
+		}
+
+		// Quoted value stays a single filter of the same value
+		if test.field == "" {
+			continue
+		}
+
+		ast, err := sqlparser.Parse("SELECT * FROM t WHERE " + filter)
+		if err != nil {
+			t.Errorf("Can't parse filter '%s': %s", filter, err.Error())
+			continue
+		}
+
+		comparison, ok := ast.(*sqlparser.Select).Where.Expr.(*sqlparser.ComparisonExpr)
+		if !ok || literal(comparison.Right) != test.indicator {
+			t.Errorf("Filter '%s' doesn't match the value '%s'", filter, test.indicator)
+		}
+	}
+
+	for _, field := range []string{"ip='1' OR ip", "my field", "ip;", ".ip", "1ip"} {
+		if _, err := indicatorFilter(field, "10.10.10.10"); err == nil {
+			t.Errorf("Invalid field '%s' is accepted", field)
+		}
+	}
+}
@@ -19,6 +19,16 @@ class Account {
             this.regenerateUUID();
         });
 
+        // Create a new API token
+        $('.ui.create.token.button').on('click', (e) => {
+            this.createToken();
+        });
+
+        // Revoke API token
+        $('.ui.tokens.table').on('click', '.ui.button.revoke', (e) => {
+            this.profile.websocket.send('token-revoke', e.currentTarget.dataset.id);
+        });
+
         // Save account data
         $('.ui.save.account.button').on('click', (e) => {
             this.save();
@@ -38,6 +48,54 @@ class Account {
         this.profile.websocket.send('uuid');
     }
 
+    /*
+     * Request a new API token
+     */
+    createToken() {
+        const sources = $('.ui.token-sources.dropdown').dropdown('get value');
+
+        this.profile.websocket.send('token-create', JSON.stringify({
+            name:     document.getElementById('tokenName').value,
+            days:     parseInt(document.getElementById('tokenDays').value) || 0,
+            sources:  sources ? sources.split(',') : [],
+            readOnly: document.getElementById('tokenReadOnly').checked
+        }));
+    }
+
+    /*
+     * API token is created, show its secret once
+     * and append the token to the list
+     */
+    tokenCreated(data, secret) {
+        const token = JSON.parse(data),
+              row =   document.createElement('tr'),
+              cells = [
+                  token.name,
+                  token.sources ? token.sources.join(' ') : 'All',
+                  token.readOnly ? 'Yes' : 'No',
+                  token.expires ? new Date(token.expires).toUTCString() : 'Never',
+                  'Never'
+              ];
+
+        row.id = 'token-' + token.id;
+
+        for (const value of cells) {
+            const cell = document.createElement('td');
+            cell.innerText = value;
+            row.appendChild(cell);
+        }
+
+        const cell = document.createElement('td');
+        cell.innerHTML = '<div class="ui mini orange icon button revoke" title="Revoke token"><i class="trash icon"></i></div>';
+        cell.firstChild.dataset.id = token.id;
+        row.appendChild(cell);
+
+        document.querySelector('.ui.tokens.table tbody').appendChild(row);
+        document.getElementById('tokenName').value = '';
+
+        this.profile.modal.ok('Token created!', 'Copy the secret now, it will not be shown again:<br/><br/><span class="ui orange text">' + secret + '</span>');
+    }
+
     /*
      * Save account data
      */
@@ -32,11 +32,11 @@ class Charts {
      *
      * Receives:
      *     query - user's query that returned too much results
-     *     data - statistics numbers
+     *     list - statistics numbers, one set per data source
      *     filterID - filter's unique UUID
      */
-    create(query, data, filterID) {
-        //console.log(query, data, filterID);
+    create(query, list, filterID) {
+        //console.log(query, list, filterID);
 
         // Resize charts area when working in a fullscreen mode
         if (this.application.graph.fullscreenBtn.className.indexOf('expand') === -1)
@@ -47,24 +47,34 @@ class Charts {
         // Clear previous charts
         this.clear();
 
-        // Skip modifying the query when source doesn't support SQL
-        const se = document.querySelectorAll('div.item[data-value="' + data.source + '"]')[0],
-              issql = se.getElementsByClassName('code icon').length === 0;
+        const names = [];
+        var anysql = false;
+
+        for (var i = 0; i < list.length; i++) {
+            const data = list[i];
+
+            // Skip modifying the query when source doesn't support SQL
+            const se = document.querySelectorAll('div.item[data-value="' + data.source + '"]')[0],
+                  issql = se !== undefined && se.getElementsByClassName('code icon').length === 0;
 
-        this.header.innerHTML = '<strong>' + data.source.toUpperCase() + '</strong> returns too many results. ';
-        if (issql)
+            names.push('<strong>' + data.source.toUpperCase() + '</strong>');
+            anysql = anysql || issql;
+
+            for (var field in data) {
+                if (field === 'source') continue;
+                this.generate(query, data.source, field, data[field], filterID, issql);
+            }
+        }
+
+        this.header.innerHTML = names.join(', ') + (names.length === 1 ? ' returns' : ' return') + ' too many results. ';
+        if (anysql)
             this.header.innerHTML += 'Add filters manually or use the charts (based on limited data) to reduce the amount of returned data. ';
         this.header.innerHTML += 'Close the charts to see the possible limited data';
 
         this.container.style.display = 'block';
 
-        for (var field in data) {
-            if (field === 'source') continue;
-            this.generate(query, field, data[field], filterID);
-        }
-
         // Right click context menu
-        this.setupContext(issql);
+        this.setupContext();
     }
 
     /*
@@ -72,18 +82,22 @@ class Charts {
      *
      * Receives:
      *     query - user's query that returned too much results
+     *     source - data source the stats belong to
      *     field - data source's field this single chart is related to
      *     data - statistics numbers
      *     filterID - filter's unique UUID
+     *     issql - whether data source supports SQL to modify the query
      */
-    generate(query, field, data, filterID) {
-        //console.log(query, field, data, filterID);
+    generate(query, source, field, data, filterID, issql) {
+        //console.log(query, source, field, data, filterID, issql);
 
         // Single chart container
         const chart = document.createElement('div'),
               name =  document.createElement('div');
 
-        chart.id = field;
+        chart.id = source + '-' + field;
+        chart.setAttribute('data-field', field);
+        chart.setAttribute('data-sql', issql);
         this.container.setAttribute('data-query', query);
         this.container.setAttribute('data-filter', filterID);
         this.container.appendChild(chart);
@@ -135,19 +149,20 @@ class Charts {
         this.charts.push(chart);
 
         name.className = 'chart-name';
-        name.innerText = field;
+        name.innerText = source + ': ' + field;
         chart.appendChild(name);
     }
 
     /*
      * Setup right click context menu
      */
-    setupContext(issql) {
+    setupContext() {
         d3.select('#charts').selectAll('.c3-shape')
             .on('contextmenu', (d, i) => {
+                const chart = d3.event.target.ownerSVGElement.parentNode;
 
-                if (issql) {
-                    this.expandingField =  d3.event.target.ownerSVGElement.parentNode.id;
+                if (chart.getAttribute('data-sql') === 'true') {
+                    this.expandingField =  chart.getAttribute('data-field');
                     this.expandingQuery =  d3.event.target.ownerSVGElement.parentNode.parentNode.getAttribute('data-query');
                     this.expandingFilter = d3.event.target.ownerSVGElement.parentNode.parentNode.getAttribute('data-filter');
                     this.expandingValue =  d.data.id;
@@ -63,6 +63,40 @@ class Filters {
         return id;
     }
 
+    /*
+     * Show or hide green filter's button to load the next page of the results.
+     * Receives filter's ID, continuation token from the server
+     * and the query to describe the search
+     */
+    setCursor(id, cursor, query) {
+        const div = this.container.querySelector('[data-id="' + id + '"]');
+        if (div === null)
+            return;
+
+        var moreBtn = div.querySelector('.more');
+
+        if (cursor === undefined) {
+            if (moreBtn !== null)
+                moreBtn.remove();
+            return;
+        }
+
+        if (moreBtn === null) {
+            moreBtn = document.createElement('button');
+            moreBtn.className = 'ui icon small button more';
+            moreBtn.innerHTML = '<i class="angle double down icon"></i>';
+            moreBtn.title =     'Load more';
+
+            // Place before the other management buttons
+            div.insertBefore(moreBtn, div.children[1]);
+        }
+
+        moreBtn.onclick = () => {
+            moreBtn.remove();
+            this.application.search.more(cursor, query);
+        }
+    }
+
     /*
      * Add red filter to hide necessary nodes.
      * Receives user's query
@@ -179,7 +213,7 @@ class Filters {
      */
     edit(elem) {
         const id = elem.parentNode.getAttribute('data-id'),
-              re = /FROM (.*) WHERE /g;
+              re = /FROM `?([^`]*?)`? WHERE /g;
 
         var source, query;
 
@@ -432,7 +432,8 @@ class Graph {
         }
 
         // Send nodes to the server
-        this.application.websocket.send('common', JSON.stringify(queries), '\'' + startTime + '\' AND \'' + endTime +'\'');
+        const id = this.application.websocket.send('common', JSON.stringify(queries), '\'' + startTime + '\' AND \'' + endTime +'\'');
+        this.application.search.started(id, 'common');
 
         // Disable search button and hide menus if visible
         this.application.search.searchBtn.addClass('disabled loading');
@@ -578,7 +579,7 @@ class Graph {
                 return;
             }
 
-            this.application.search.query('FROM ' + source + ' WHERE ' + node.options.search + '=\'' + node.options.attributes[node.options.group] + '\'');
+            this.application.search.query('FROM `' + source + '` WHERE ' + node.options.search + '=\'' + node.options.attributes[node.options.group] + '\'');
             console.log('Expanding by', node.options.search, '=', node.id, 'from', source);
         }
     }
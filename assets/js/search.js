@@ -20,8 +20,8 @@ class Search {
         // SQL autocomplete
         this.autocomplete = new SQLAutocomplete(this.input);
 
-        // Amount of currently running searches
-        this.jobs = 0;
+        // Currently running searches: request ID -> query
+        this.running = {};
 
         // Bind Web GUI buttons
         this.bind();
@@ -69,6 +69,10 @@ class Search {
             } else if (e.key === 'ArrowUp') {
                 this.autocomplete.setActive(list, -1);
                 e.preventDefault();
+
+            // Stop the running searches
+            } else if (e.key === 'Escape') {
+                this.cancel();
             }
         });
 
@@ -198,9 +202,16 @@ class Search {
             return;
         }
 
+        // Check whether only an explanation of the query is requested
+        var explain = '';
+        if (query.substring(0, 8).toLowerCase() === 'explain ') {
+            explain = 'EXPLAIN ';
+            query = query.substring(8);
+        }
+
         // Add data source name if necessary
         if (query.substring(0, 5).toLowerCase() !== 'from ') {
-            query = 'FROM ' + this.source.dropdown('get value') + ' WHERE ' + query;
+            query = 'FROM `' + this.source.dropdown('get value') + '` WHERE ' + query;
         } else {
             var parts = query.split(' ');
             parts[0] = 'FROM';   // 'from' to uppercase because 'query()' expects uppercase,
@@ -219,7 +230,7 @@ class Search {
             }
         }
 
-        this.query(query);
+        this.query(explain + query);
     }
 
     /*
@@ -236,8 +247,6 @@ class Search {
             }
         }
 
-        this.jobs += 1;
-
         const startTime = this.application.calendar.rangeStart.calendar('get date').toISOString().substr(0, 19) + '.000Z',
               endTime =   this.application.calendar.rangeEnd.calendar(  'get date').toISOString().substr(0, 19) + '.000Z';
 
@@ -256,7 +265,7 @@ class Search {
         }
 
         // Send resulting SQL query to the server
-        this.application.websocket.send('sql', sql);
+        this.started(this.application.websocket.send('sql', sql), query);
 
         // Disable search button and hide menus if visible
         this.searchBtn.addClass('disabled loading');
@@ -264,28 +273,93 @@ class Search {
         this.application.charts.menu.style.visibility = 'hidden';
     }
 
+    /*
+     * Ask the server to stop all the running searches.
+     * Each of them still returns a response with an error
+     */
+    cancel() {
+        if (Object.keys(this.running).length === 0)
+            return;
+
+        this.application.websocket.send('cancel');
+    }
+
+    /*
+     * Request the next page of the paginated results.
+     * Receives continuation token and the query to describe the search
+     */
+    more(cursor, query) {
+        this.searchBtn.addClass('disabled loading');
+        this.started(this.application.websocket.send('page', cursor, query), query);
+    }
+
+    /*
+     * Remember a search sent to the server.
+     * Receives request ID and a query to describe it
+     */
+    started(id, query) {
+        if (id === null)
+            return;
+
+        this.running[id] = query;
+    }
+
+    /*
+     * Forget the finished search.
+     * Search button is enabled back when all the searches have finished
+     */
+    finished(id) {
+        if (!(id in this.running))
+            return;
+
+        delete this.running[id];
+
+        if (Object.keys(this.running).length === 0)
+            this.searchBtn.removeClass('disabled loading');
+    }
+
+    /*
+     * Display a single data source's results
+     * without waiting for the other data sources.
+     * Receives user's initial query
+     */
+    processPartial(query, response) {
+        const results = JSON.parse(response),
+              query_without_parenthesis = query.replace(' WHERE (', ' WHERE ').substring(0, query.length-2);
+
+        if (results.relations === undefined || results.relations.length === 0)
+            return;
+
+        // The same filter is used by all the parts of the response
+        const id = this.application.filters.addGreen(query_without_parenthesis);
+        this.processRelations(id, results.relations);
+    }
+
     /*
      * Process server's response.
-     * Receives also user's initial query
+     * Receives also request ID and user's initial query
      */
-    processResults(query, response) {
+    processResults(id, query, response) {
         const results = JSON.parse(response),
               query_without_parenthesis = query.replace(' WHERE (', ' WHERE ').substring(0, query.length-2);
 
-        this.jobs -= 1;
+        this.finished(id);
 
-        // Wait until all running searches have finished
-        if (this.jobs === 0)
-            this.searchBtn.removeClass('disabled loading');
+        // Nothing was searched, only queries explanation is returned
+        if (results.explain !== undefined) {
+            this.processExplain(results);
+            return;
+        }
 
         // Skip if zero entries were returned
         if (Object.keys(results).length === 0) {
             this.application.filters.addGreen(query_without_parenthesis);
 
         } else {
-            // Show an error along with the other relations data
+            // Show an error along with the other relations data,
+            // one line per data source
             if (results.error !== undefined)
-                this.application.modal.error('Server has returned an error!', results.error);
+                this.application.modal.error('Server has returned an error!', results.error.replace(/\n/g, '<br/>'));
 
             // Show available results even if some data source has returned an error
             if (results.relations === undefined)
@@ -294,10 +368,14 @@ class Search {
             const id = this.application.filters.addGreen(query_without_parenthesis);
             this.processRelations(id, results.relations);
 
+            // More results can be requested
+            this.application.filters.setCursor(id, results.cursor, query);
+
             // Show stats based on limited relations data
             // to be able to improve the query
-            if (results.stats !== undefined)
-                this.application.charts.create(query, results.stats, id);
+            const stats = this.limitedStats(results);
+            if (stats.length !== 0)
+                this.application.charts.create(query, stats, id);
 
             // Show debug info in browser's console
             if (results.debug !== undefined) {
@@ -311,25 +389,80 @@ class Search {
         }
     }
 
+    /*
+     * Show how each data source would be queried.
+     * Receives standard server's response with an "explain" field
+     */
+    processExplain(results) {
+        const escape = (text) => $('<div>').text(text).html();
+        var html = '';
+
+        for (const source of Object.keys(results.explain).sort()) {
+            html += '<p><strong>' + escape(source) + '</strong></p>';
+
+            for (const explanation of results.explain[source]) {
+                html += '<pre>' + escape(explanation.query) + '</pre>';
+
+                if (explanation.filter !== undefined)
+                    html += '<p>Post-filter: <code>' + escape(explanation.filter) + '</code></p>';
+                if (explanation.native !== undefined)
+                    html += '<pre>' + escape(JSON.stringify(explanation.native, null, 2)) + '</pre>';
+                if (explanation.error !== undefined)
+                    html += '<p class="red_fg">' + escape(explanation.error) + '</p>';
+            }
+        }
+
+        if (results.error !== undefined)
+            html += '<p class="red_fg">' + escape(results.error).replace(/\n/g, '<br/>') + '</p>';
+
+        this.application.modal.ok('Query explanation', html);
+    }
+
+    /*
+     * Get one set of stats per limited data source.
+     * Responses without the data sources statuses contain a single one
+     */
+    limitedStats(results) {
+        const stats = [];
+
+        if (results.sources === undefined) {
+            if (results.stats !== undefined)
+                stats.push(results.stats);
+
+            return stats;
+        }
+
+        for (const source of Object.keys(results.sources).sort()) {
+            const status = results.sources[source];
+
+            if (status.status === 'limited' && status.stats !== undefined)
+                stats.push(status.stats);
+        }
+
+        return stats;
+    }
+
     /*
      * Process common nodes.
      *
-     * Receives standard server's response with edges/stats/error
+     * Receives request ID, standard server's response with edges/stats/error
      * and a list of unique neighbors to display on a Web GUI right panel
      */
-    processCommon(response, list) {
+    processCommon(id, response, list) {
         const results = JSON.parse(response),
               values =  JSON.parse(list);
 
-        this.searchBtn.removeClass('disabled loading');
+        this.finished(id);
 
-        // Show an error along with the other relations data
+        // Show an error along with the other relations data,
+        // one line per data source
         if (results.error !== undefined)
-            this.application.modal.error('Server has returned an error!', results.error);
+            this.application.modal.error('Server has returned an error!', results.error.replace(/\n/g, '<br/>'));
 
         // Inform that some nodes can't be processed
-        if (results.stats !== undefined)
-            this.application.modal.error('Too many entries!', '<strong>' + results.stats.source + '</strong> nodes can\'t be processed as data source contains too many entries!');
+        const stats = this.limitedStats(results);
+        if (stats.length !== 0)
+            this.application.modal.error('Too many entries!', stats.map(s => '<strong>' + s.source + '</strong>').join(', ') + ' nodes can\'t be processed as data source contains too many entries!');
 
         // Show available results even if some data source has returned an error
         if (results.relations === undefined)
@@ -380,8 +513,9 @@ class Search {
                   existingEdge = this.application.graph.network.body.edges[from.id + '-' + to.id];
 
             // Create nodes which don't exist yet
-            const nodeFrom = this.addNode(this.application.graph.network.body.nodes[from.id], from, id, entry.source),
-                  nodeTo =   this.addNode(this.application.graph.network.body.nodes[to.id],   to,   id, entry.source);
+            // Merged nodes and edges may come from several data sources
+            const nodeFrom = this.addNode(this.application.graph.network.body.nodes[from.id], from, id, from.sources || entry.source),
+                  nodeTo =   this.addNode(this.application.graph.network.body.nodes[to.id],   to,   id, to.sources || entry.source);
 
             // Set neighbors group to be able to cluster them
             nodeFrom.options.neighbors[nodeTo.options.group] = true;
@@ -390,7 +524,7 @@ class Search {
             // Define attributes, which may not come from data sources
             entry.edge = entry.edge || {};
             entry.edge.attributes = entry.edge.attributes || {};
-            entry.edge.attributes['source'] = entry.source;
+            entry.edge.attributes['source'] = entry.sources || entry.source;
 
             // Update the edge if already exists
             if (existingEdge) {
@@ -416,7 +550,7 @@ class Search {
 
                 // Additional merge to convert all values to strings
                 edge.attributes = this.merge({}, entry.edge.attributes);
-                edge.attributes.source = [entry.source];
+                edge.attributes.source = [].concat(entry.sources || entry.source);
                 edge.id =    from.id + '-' + to.id;
                 edge.from =  from.id;
                 edge.to =    to.id;
@@ -459,7 +593,7 @@ class Search {
      *     existing - node if its value already exists on a graph, 'undefined' otherwise
      *     data     - single node's parameters
      *     filterID - filter's ID this node is related to
-     *     source   - data source's name where data comes from
+     *     source   - data source's name where data comes from, or a list of names
      */
     addNode(existing, data, filterID, source) {
         data.attributes = data.attributes || {};
@@ -1,6 +1,7 @@
 /*
  * User management.
- * Administrators can reset passwords, delete users, etc.
+ * Administrators can reset passwords, delete users,
+ * revoke API tokens, etc.
  */
 class Users {
     constructor(admin) {
@@ -31,6 +32,11 @@ class Users {
             users.apply(e.target.dataset.username, 'delete');
         });
 
+        // Button to revoke user's API token
+        $('.ui.tokens.table .ui.button.revoke').on('click', (e) => {
+            users.admin.websocket.send('token-revoke', e.currentTarget.dataset.id);
+        });
+
         // Toggle admin rights
         $('.ui.checkbox.admin').checkbox({
             onChange: function() {
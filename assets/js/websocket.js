@@ -12,6 +12,10 @@ class Websocket {
         // Attempts to reestablish a Websocket connection
         this.attempts = 0;
 
+        // Last used request ID. Server's responses contain it
+        // to know which request they belong to
+        this.requestID = 0;
+
         // Create a Websocket connection
         this.init();
     }
@@ -66,14 +70,24 @@ class Websocket {
         const message = JSON.parse(e.data);
 
         switch(message.type) {
-            // Result of the search query
-            case 'results':
-                this.application.search.processResults(message.extra, message.data);
+            // Single data source's results of the search query
+            case 'partial':
+                this.application.search.processPartial(message.extra, message.data);
+                break;
+
+            // Search query finished, summary of all the data sources
+            case 'done':
+                this.application.search.processResults(message.id, message.extra, message.data);
                 break;
 
             // Response to the user's request to find selected nodes common attributes and neighbors
             case 'common':
-                this.application.search.processCommon(message.data, message.extra);
+                this.application.search.processCommon(message.id, message.data, message.extra);
+                break;
+
+            // Running searches are being stopped,
+            // each of them still sends its own response
+            case 'canceled':
                 break;
 
             // Notification from server
@@ -86,6 +100,16 @@ class Websocket {
                 document.getElementById('uuid').innerText = message.data;
                 break;
 
+            // New API token created
+            case 'token-created':
+                this.application.account.tokenCreated(message.data, message.extra);
+                break;
+
+            // API token revoked by its owner or an administrator
+            case 'token-revoked':
+                document.getElementById('token-'+message.data).remove();
+                break;
+
             // Notes for the selected graph element
             case 'notes':
                 this.application.graph.saveNotesBtn.removeClass('disabled loading');
@@ -147,6 +171,10 @@ class Websocket {
 
             // Notify about some error
             case 'error':
+                // Failed search is not running anymore
+                if (this.application.search)
+                    this.application.search.finished(message.id);
+
                 this.application.modal.error(message.extra, unescape(message.data));
                 break;
 
@@ -191,15 +219,23 @@ class Websocket {
      *     type  - message type: sql, notes-save, etc.
      *     data  - any data to send to the server
      *     extra - possible additional data
+     *
+     * Returns a unique request ID or null if connection is not established
      */
     send(type, data, extra) {
+        if (!this.ws)
+            return null;
+
+        this.requestID++;
+
         const message = {
             type:  type,
             data:  data,
-            extra: extra
+            extra: extra,
+            id:    String(this.requestID)
         }
 
-        if (this.ws)
-            this.ws.send(JSON.stringify(message));
+        this.ws.send(JSON.stringify(message));
+        return message.id;
     }
 }
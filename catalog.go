@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+const (
+	healthOK      = "ok"
+	healthFailing = "failing"
+	healthDown    = "down"
+)
+
+var (
+	// Definitions of all the loaded data sources,
+	// including the ones which failed to set up.
+	// Is a map of data source's name -> definition
+	definitions map[string]*pdk.Source
+
+	// Runtime health of the loaded data sources,
+	// is a map of data source's name -> health
+	health map[string]*SourceHealth
+
+	// Parts of the access options names which contain secrets
+	secretOptions = []string{"pass", "secret", "token", "key", "auth", "cred"}
+)
+
+/*
+ * Data source's runtime health
+ */
+type SourceHealth struct {
+	// Protects the last search fields
+	mx sync.Mutex
+
+	// One of: ok, failing, down
+	Status string `json:"status"`
+
+	// Whether the collector was set up successfully
+	Setup      bool   `json:"setup"`
+	SetupError string `json:"setupError,omitempty"`
+
+	// Timestamp of the last setup attempt
+	Checked time.Time `json:"checked"`
+
+	// Outcome of the last searches since the setup
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+/*
+ * Data source's definition with secrets stripped,
+ * known fields and runtime health
+ */
+type CatalogSource struct {
+	Name            string            `json:"name"`
+	Label           string            `json:"label,omitempty"`
+	Icon            string            `json:"icon,omitempty"`
+	Plugin          string            `json:"plugin"`
+	InGlobal        bool              `json:"inGlobal"`
+	IncludeDatetime bool              `json:"includeDatetime"`
+	SupportsSQL     bool              `json:"supportsSQL"`
+	Timeout         string            `json:"timeout,omitempty"`
+	MaxConcurrency  int               `json:"maxConcurrency,omitempty"`
+	RateLimit       float64           `json:"rateLimit,omitempty"`
+	Access          map[string]string `json:"access,omitempty"`
+	QueryFields     []string          `json:"queryFields,omitempty"`
+	IncludeFields   []string          `json:"includeFields,omitempty"`
+	StatsFields     []string          `json:"statsFields,omitempty"`
+	ReplaceFields   map[string]string `json:"replaceFields,omitempty"`
+
+	// All the queryable fields for the autocomplete
+	Fields []string `json:"fields"`
+
+	// Named groups the data source belongs to
+	Groups []string `json:"groups,omitempty"`
+
+	// Query features declared by the plugin, if any
+	Capabilities *pdk.Capabilities `json:"capabilities,omitempty"`
+
+	Relations []*RelationInfo `json:"relations"`
+	Health    *SourceHealth   `json:"health"`
+}
+
+/*
+ * Named group of the data sources
+ */
+type CatalogGroup struct {
+	Name    string   `json:"name"`
+	Label   string   `json:"label,omitempty"`
+	Icon    string   `json:"icon,omitempty"`
+	Sources []string `json:"sources"`
+}
+
+/*
+ * All the data sources and groups
+ */
+type Catalog struct {
+	Sources []*CatalogSource `json:"sources"`
+	Groups  []*CatalogGroup  `json:"groups"`
+}
+
+/*
+ * Health of the data source after its setup
+ */
+func newHealth(err error) *SourceHealth {
+	h := &SourceHealth{
+		Status:  healthOK,
+		Setup:   true,
+		Checked: time.Now().UTC(),
+	}
+
+	if err != nil {
+		h.Status = healthDown
+		h.Setup = false
+		h.SetupError = err.Error()
+	}
+
+	return h
+}
+
+/*
+ * Remember the outcome of the data source's search.
+ * Searches canceled by the client say nothing about the data source
+ */
+func observeHealth(source string, err error) {
+	sourcesMx.RLock()
+	h, ok := health[source]
+	sourcesMx.RUnlock()
+
+	if !ok || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	now := time.Now().UTC()
+
+	if err != nil {
+		h.LastFailure = &now
+		h.LastError = err.Error()
+		h.Status = healthFailing
+	} else {
+		h.LastSuccess = &now
+		h.Status = healthOK
+	}
+}
+
+/*
+ * Copy of the health, safe to be encoded
+ */
+func (h *SourceHealth) snapshot() *SourceHealth {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	return &SourceHealth{
+		Status:      h.Status,
+		Setup:       h.Setup,
+		SetupError:  h.SetupError,
+		Checked:     h.Checked,
+		LastSuccess: h.LastSuccess,
+		LastFailure: h.LastFailure,
+		LastError:   h.LastError,
+	}
+}
+
+/*
+ * Describe all the loaded data sources and groups
+ * the token is allowed to query
+ */
+func catalog(token *Token) *Catalog {
+	sourcesMx.RLock()
+	defer sourcesMx.RUnlock()
+
+	c := &Catalog{
+		Sources: make([]*CatalogSource, 0, len(definitions)),
+		Groups:  make([]*CatalogGroup, 0, len(sourceGroups)),
+	}
+
+	for name := range definitions {
+		if token.allows(name) == nil {
+			c.Sources = append(c.Sources, catalogSource(name))
+		}
+	}
+
+	for _, group := range sourceGroups {
+		if token.allows(group.Name) != nil {
+			continue
+		}
+
+		c.Groups = append(c.Groups, &CatalogGroup{
+			Name:    group.Name,
+			Label:   group.Label,
+			Icon:    group.Icon,
+			Sources: group.Sources,
+		})
+	}
+
+	sort.Slice(c.Sources, func(i, j int) bool {
+		return c.Sources[i].Name < c.Sources[j].Name
+	})
+	sort.Slice(c.Groups, func(i, j int) bool {
+		return c.Groups[i].Name < c.Groups[j].Name
+	})
+
+	return c
+}
+
+/*
+ * Describe a single data source, nil when it's unknown.
+ * Must be called with the loaded data sources locked
+ */
+func catalogSource(name string) *CatalogSource {
+	def, ok := definitions[name]
+	if !ok {
+		return nil
+	}
+
+	source := &CatalogSource{
+		Name:            def.Name,
+		Label:           def.Label,
+		Icon:            def.Icon,
+		Plugin:          def.Plugin,
+		InGlobal:        def.InGlobal,
+		IncludeDatetime: def.IncludeDatetime,
+		SupportsSQL:     def.SupportsSQL,
+		MaxConcurrency:  def.MaxConcurrency,
+		RateLimit:       def.RateLimit,
+		Access:          stripSecrets(def.Access),
+		QueryFields:     def.QueryFields,
+		IncludeFields:   def.IncludeFields,
+		StatsFields:     def.StatsFields,
+		ReplaceFields:   def.ReplaceFields,
+		Fields:          fields[name],
+		Relations:       relationsInfo(def.Relations),
+		Health:          health[name].snapshot(),
+	}
+
+	if def.Timeout != 0 {
+		source.Timeout = def.Timeout.String()
+	}
+
+	if collector, ok := collectors[name]; ok {
+		source.Capabilities = capabilities(collector)
+	}
+
+	if source.Fields == nil {
+		source.Fields = []string{}
+	}
+
+	for _, group := range sourceGroups {
+		for _, s := range group.Sources {
+			if s == name {
+				source.Groups = append(source.Groups, group.Name)
+				break
+			}
+		}
+	}
+
+	sort.Strings(source.Groups)
+
+	return source
+}
+
+/*
+ * Copy access options without the secrets,
+ * like passwords, API keys or credentials inside URLs
+ */
+func stripSecrets(access map[string]string) map[string]string {
+	stripped := make(map[string]string, len(access))
+
+	for option, value := range access {
+		if isSecretOption(option) {
+			continue
+		}
+
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			u.User = nil
+			value = u.String()
+		}
+
+		stripped[option] = value
+	}
+
+	return stripped
+}
+
+/*
+ * Check whether the access option's name looks like a secret
+ */
+func isSecretOption(option string) bool {
+	option = strings.ToLower(option)
+
+	for _, part := range secretOptions {
+		if strings.Contains(option, part) {
+			return true
+		}
+	}
+
+	return false
+}
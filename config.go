@@ -25,7 +25,9 @@ type Config struct {
 	Environment       string `yaml:"environment"`
 	Definitions       string `yaml:"definitions"`
 	Plugins           string `yaml:"plugins"`
+	PluginMessageSize int    `yaml:"pluginMessageSize"`
 	Limit             int    `yaml:"limit"`
+	MaxSplitQueries   int    `yaml:"maxSplitQueries"`
 	StabilizationTime int    `yaml:"stabilizationTime"`
 
 	Log *struct {
@@ -60,10 +62,18 @@ type Config struct {
 		Sessions   string `yaml:"sessions"`
 		Cache      string `yaml:"cache"`
 		Settings   string `yaml:"settings"`
+		Tokens     string `yaml:"tokens"`
+		Jobs       string `yaml:"jobs"`
 		Timeout    int    `yaml:"timeout"`
 		CacheTTL   int32  `yaml:"cacheTTL"`
+		JobsTTL    int32  `yaml:"jobsTTL"`
 	} `yaml:"database"`
 
+	Maltego struct {
+		Period   string            `yaml:"period"`
+		Entities map[string]string `yaml:"entities"`
+	} `yaml:"maltego"`
+
 	Sessions *struct {
 		TTL               int    `yaml:"ttl"`
 		CookieName        string `yaml:"cookieName"`
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+/*
+ * Content of the continuation token to request the next page of the results.
+ * Token is opaque for the clients, so its format can change at any time
+ */
+type PageCursor struct {
+	// Requested data source or a group of them
+	Source string `json:"source"`
+
+	// Original query with relative time expressions resolved,
+	// so all the pages cover the same time range
+	SQL string `json:"sql"`
+
+	// Next page positions of the data sources with more data:
+	// LIMIT's offset, Elasticsearch point in time, etc.
+	Positions map[string]string `json:"positions"`
+}
+
+/*
+ * Encode the next page's positions into a continuation token
+ */
+func encodeCursor(source, sql string, positions map[string]string) (string, error) {
+	b, err := json.Marshal(&PageCursor{
+		Source:    source,
+		SQL:       sql,
+		Positions: positions,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+/*
+ * Decode the continuation token received from the client
+ */
+func decodeCursor(token string) (*PageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+
+	cursor := &PageCursor{}
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+
+	if cursor.SQL == "" || len(cursor.Positions) == 0 {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+
+	return cursor, nil
+}
+
+/*
+ * Check whether the data source's results can be paginated.
+ * Queries split into several independent ones are not paginated,
+ * as well as the data sources which don't support LIMIT's offset
+ */
+func pageable(collector pdk.SourcePlugin, queries []*Query) bool {
+	if len(queries) != 1 {
+		return false
+	}
+
+	if _, ok := collector.(pdk.PagedSourcePlugin); ok {
+		return true
+	}
+
+	return collector.Conf().SupportsSQL
+}
+
+/*
+ * Execute the query, a single page of it when the data source is paginated.
+ * Returns results, statistics, debug info, next page's position & error
+ */
+func searchPage(ctx context.Context, collector pdk.SourcePlugin, query *Query, paged bool, position string) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, string, error) {
+	if !paged {
+		results, stats, debug, err := pdk.Search(ctx, collector, query.Select)
+		return results, stats, debug, "", err
+	}
+
+	return pdk.SearchPage(ctx, collector, query.Select, position)
+}
+
+/*
+ * Remember the data source's position of the next page
+ */
+func (a *APIresponse) setPosition(source, position string) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.positions == nil {
+		a.positions = make(map[string]string)
+	}
+
+	a.positions[source] = position
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+/*
+ * Data source plugin returning nothing
+ */
+type emptyCollector struct {
+	source *pdk.Source
+}
+
+func (c *emptyCollector) Conf() *pdk.Source                     { return c.source }
+func (c *emptyCollector) Setup(source *pdk.Source, _ int) error { c.source = source; return nil }
+func (c *emptyCollector) Fields() ([]string, error)             { return nil, nil }
+func (c *emptyCollector) Stop() error                           { return nil }
+
+func (c *emptyCollector) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	return []map[string]interface{}{}, nil, nil, nil
+}
+
+/*
+ * Data source plugin tracking its positions itself
+ */
+type pagedCollector struct {
+	emptyCollector
+}
+
+func (c *pagedCollector) SearchPage(ctx context.Context, stmt *sqlparser.Select, position string) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, string, error) {
+	return []map[string]interface{}{}, nil, nil, position + "+", nil
+}
+
+/*
+ * Test continuation token encoding and decoding
+ */
+func TestCursor(t *testing.T) {
+	positions := map[string]string{"mysql": "100", "elastic": "pit"}
+
+	token, err := encodeCursor("global", "FROM global WHERE ip='10.10.10.10' LIMIT 0,100", positions)
+	if err != nil {
+		t.Fatalf("Can't encode cursor: %s", err.Error())
+	}
+
+	cursor, err := decodeCursor(token)
+	if err != nil {
+		t.Fatalf("Can't decode cursor: %s", err.Error())
+	}
+
+	expected := &PageCursor{
+		Source:    "global",
+		SQL:       "FROM global WHERE ip='10.10.10.10' LIMIT 0,100",
+		Positions: positions,
+	}
+
+	if !reflect.DeepEqual(cursor, expected) {
+		t.Errorf("Invalid decoded cursor: %+v, expected: %+v", cursor, expected)
+	}
+
+	invalid := []string{
+		"",
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("not json")),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"source":"global","positions":{"mysql":"100"}}`)),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"source":"global","sql":"FROM global","positions":{}}`)),
+	}
+
+	for _, token := range invalid {
+		if _, err := decodeCursor(token); err == nil {
+			t.Errorf("Invalid cursor is accepted: %s", token)
+		}
+	}
+}
+
+/*
+ * Test which data sources can be paginated
+ */
+func TestPageable(t *testing.T) {
+	sql := &emptyCollector{source: &pdk.Source{SupportsSQL: true}}
+	other := &emptyCollector{source: &pdk.Source{}}
+	paged := &pagedCollector{emptyCollector{source: &pdk.Source{}}}
+
+	single := []*Query{{}}
+	split := []*Query{{}, {}}
+
+	tests := []struct {
+		collector pdk.SourcePlugin
+		queries   []*Query
+		expected  bool
+	}{
+		{sql, single, true},
+		{sql, split, false},
+		{other, single, false},
+		{paged, single, true},
+		{paged, split, false},
+	}
+
+	for i, test := range tests {
+		if result := pageable(test.collector, test.queries); result != test.expected {
+			t.Errorf("Invalid pageable result of case %d: %t, expected: %t", i, result, test.expected)
+		}
+	}
+}
+
+/*
+ * Test the next page's position is returned for the paged searches only
+ */
+func TestSearchPagePosition(t *testing.T) {
+	ast, err := sqlparser.Parse("SELECT * FROM t WHERE ip='10.10.10.10'")
+	if err != nil {
+		t.Fatalf("Can't parse query: %s", err.Error())
+	}
+
+	query := &Query{Select: ast.(*sqlparser.Select)}
+	collector := &pagedCollector{emptyCollector{source: &pdk.Source{}}}
+
+	_, _, _, next, err := searchPage(context.Background(), collector, query, true, "1")
+	if err != nil {
+		t.Fatalf("Can't search page: %s", err.Error())
+	}
+
+	if next != "1+" {
+		t.Errorf("Invalid next position: %s, expected: 1+", next)
+	}
+
+	_, _, _, next, err = searchPage(context.Background(), collector, query, false, "1")
+	if err != nil {
+		t.Fatalf("Can't search: %s", err.Error())
+	}
+
+	if next != "" {
+		t.Errorf("Position returned for not paged search: %s", next)
+	}
+
+	response := &APIresponse{}
+	response.setPosition("elastic", "pit")
+
+	if response.positions["elastic"] != "pit" {
+		t.Errorf("Position is not remembered: %v", response.positions)
+	}
+}
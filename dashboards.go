@@ -2,10 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 /*
@@ -33,12 +34,12 @@ type Dashboard struct {
  * Handle 'dashboard-save' websocket command to save current dashboard.
  * Receives a JSON with all "Dashboard"'s fields filled
  */
-func (a *Account) saveDashboardHandler(data string) {
+func (a *Account) saveDashboardHandler(reqID, data string) {
 
 	var dashboard *Dashboard
 	err := json.Unmarshal([]byte(data), &dashboard)
 	if err != nil {
-		a.send("error", "Can't parse dashboard data.", "Can't save dashboard!")
+		a.reply(reqID, "error", "Can't parse dashboard data.", "Can't save dashboard!")
 
 		log.Error().
 			Str("ip", a.Session.IP).
@@ -47,16 +48,9 @@ func (a *Account) saveDashboardHandler(data string) {
 		return
 	}
 
-	dashboard.Owner = a.Username
-
-	// Skip empty name or filters
-	if dashboard.Name == "" || (len(dashboard.Filters[0]) == 0 && len(dashboard.Filters[1]) == 0) {
-		a.send("error", "Dashboard name and filters can't be empty.", "Can't save dashboard!")
-
-		log.Error().
-			Str("ip", a.Session.IP).
-			Str("username", a.Username).
-			Msg("Dashboard name and filters can't be empty")
+	err = a.saveDashboard(a.Session.IP, dashboard)
+	if err != nil {
+		a.reply(reqID, "error", err.Error(), "Can't save dashboard!")
 		return
 	}
 
@@ -69,133 +63,269 @@ func (a *Account) saveDashboardHandler(data string) {
 		return
 	}
 
+	if dashboard.Shared {
+		broadcast("dashboard-saved", string(bytes), "")
+	} else {
+		a.reply(reqID, "dashboard-saved", string(bytes), "")
+	}
+}
+
+/*
+ * Save a new dashboard as a shared or own/private one.
+ * Receives user's IP for the logging and a dashboard to save
+ */
+func (a *Account) saveDashboard(ip string, dashboard *Dashboard) error {
+
+	dashboard.Owner = a.Username
+
+	// Skip empty name or filters
+	if dashboard.Name == "" || len(dashboard.Filters) != 2 || (len(dashboard.Filters[0]) == 0 && len(dashboard.Filters[1]) == 0) {
+		log.Error().
+			Str("ip", ip).
+			Str("username", a.Username).
+			Msg("Dashboard name and filters can't be empty")
+
+		return newAPIerror(http.StatusBadRequest, "Dashboard name and filters can't be empty.")
+	}
+
 	/*
 	 * Save as shared dashboard
 	 */
 	if dashboard.Shared {
 		// Check whether already exists first
-		existing := &Dashboard{}
-
-		ctx, cancel := db.newContext()
-		defer cancel()
-
-		err = db.Dashboards.FindOne(ctx, bson.M{"_id": dashboard.Name}).Decode(existing)
-		if err != nil && err != mongo.ErrNoDocuments {
+		existing, err := db.getSharedDashboard(dashboard.Name)
+		if err != nil {
 			// Replace some characters as the error may contain:
 			// got <invalid reflect.Value>
 			// which shouldn't be rendered as HTML
 			e := strings.Replace(strings.Replace(err.Error(), "<", "&lt;", -1), ">", "&gt;", -1)
 			log.Error().
-				Str("ip", a.Session.IP).
+				Str("ip", ip).
 				Str("username", a.Username).
 				Msg("Can't check whether dashboard name is already reserved: " + e)
 
-			a.send("error", "Can't check whether dashboard name is already reserved: "+e, "Can't save dashboard!")
-			return
+			return fmt.Errorf("Can't check whether dashboard name is already reserved: " + e)
 		}
 
-		if err == mongo.ErrNoDocuments {
-			// Save in a database
-			_, err = db.Dashboards.InsertOne(ctx, dashboard)
-			if err != nil {
-				log.Error().
-					Str("ip", a.Session.IP).
-					Str("username", a.Username).
-					Msg("Error while inserting shared dashboard: " + err.Error())
-
-				a.send("error", err.Error(), "Can't save dashboard!")
-				return
-			}
-
-			broadcast("dashboard-saved", string(bytes), "")
-
+		if existing != nil {
 			log.Info().
-				Str("ip", a.Session.IP).
+				Str("ip", ip).
 				Str("username", a.Username).
-				Msg("Shared dashboard is saved: " + dashboard.Name)
+				Msg("Shared dashboard name is already reserved: " + dashboard.Name)
 
-		} else {
-			a.send("error", "Shared dashboard name is already reserved.", "Can't save dashboard!")
+			return newAPIerror(http.StatusConflict, "Shared dashboard name is already reserved.")
+		}
 
-			log.Info().
-				Str("ip", a.Session.IP).
+		// Save in a database
+		ctx, cancel := db.newContext()
+		defer cancel()
+
+		_, err = db.Dashboards.InsertOne(ctx, dashboard)
+		if err != nil {
+			log.Error().
+				Str("ip", ip).
 				Str("username", a.Username).
-				Msg("Shared dashboard name is already reserved: " + dashboard.Name)
+				Msg("Error while inserting shared dashboard: " + err.Error())
+
+			return err
 		}
 
+		log.Info().
+			Str("ip", ip).
+			Str("username", a.Username).
+			Msg("Shared dashboard is saved: " + dashboard.Name)
+
 		/*
 		 * Save as own/private dashboard
 		 */
 	} else {
+		a.DashboardsMutex.Lock()
+		defer a.DashboardsMutex.Unlock()
+
 		// Check whether already exists first
 		_, exists := a.Dashboards[dashboard.Name]
 		if exists {
-			a.send("error", "Dashboard name is already reserved.", "Can't save dashboard!")
-
 			log.Info().
-				Str("ip", a.Session.IP).
+				Str("ip", ip).
 				Str("username", a.Username).
 				Msg("Dashboard name is already reserved: " + dashboard.Name)
 
-		} else {
-			a.Dashboards[dashboard.Name] = dashboard
+			return newAPIerror(http.StatusConflict, "Dashboard name is already reserved.")
+		}
+
+		a.Dashboards[dashboard.Name] = dashboard
+
+		// Save in a database
+		err := a.update("dashboards", a.Dashboards)
+		if err != nil {
+			delete(a.Dashboards, dashboard.Name)
+			return err
+		}
 
-			// Save in a database
-			err = a.update("dashboards", a.Dashboards)
-			if err != nil {
-				a.send("error", err.Error(), "Can't save dashboard!")
-				return
-			}
+		log.Info().
+			Str("ip", ip).
+			Str("username", a.Username).
+			Msg("Dashboard is saved: " + dashboard.Name)
+	}
 
-			a.send("dashboard-saved", string(bytes), "")
+	return nil
+}
 
-			log.Info().
-				Str("ip", a.Session.IP).
+/*
+ * Replace the content of an existing shared or own/private dashboard.
+ * Receives user's IP for the logging and a new dashboard's content
+ */
+func (a *Account) updateDashboard(ip string, dashboard *Dashboard) error {
+
+	// Skip empty filters
+	if len(dashboard.Filters) != 2 || (len(dashboard.Filters[0]) == 0 && len(dashboard.Filters[1]) == 0) {
+		return newAPIerror(http.StatusBadRequest, "Dashboard filters can't be empty.")
+	}
+
+	if dashboard.Shared {
+		existing, err := a.ownSharedDashboard(ip, dashboard.Name)
+		if err != nil {
+			return err
+		}
+
+		// Shared dashboard keeps its original owner
+		dashboard.Owner = existing.Owner
+
+		ctx, cancel := db.newContext()
+		defer cancel()
+
+		res, err := db.Dashboards.ReplaceOne(ctx, bson.M{"_id": dashboard.Name}, dashboard)
+		if err != nil {
+			log.Error().
+				Str("ip", ip).
+				Str("username", a.Username).
+				Msgf("Can't update shared dashboard '%s': %s", dashboard.Name, err.Error())
+			return err
+		}
+
+		if res.MatchedCount == 0 {
+			return newAPIerror(http.StatusNotFound, "Shared dashboard doesn't exist.")
+		}
+
+	} else {
+		a.DashboardsMutex.Lock()
+		defer a.DashboardsMutex.Unlock()
+
+		previous, exists := a.Dashboards[dashboard.Name]
+		if !exists {
+			return newAPIerror(http.StatusNotFound, "Dashboard doesn't exist.")
+		}
+
+		dashboard.Owner = a.Username
+		a.Dashboards[dashboard.Name] = dashboard
+
+		err := a.update("dashboards", a.Dashboards)
+		if err != nil {
+			a.Dashboards[dashboard.Name] = previous
+
+			log.Error().
+				Str("ip", ip).
 				Str("username", a.Username).
-				Msg("Dashboard is saved: " + dashboard.Name)
+				Msgf("Can't update dashboard '%s': %s", dashboard.Name, err.Error())
+			return err
 		}
 	}
+
+	log.Info().
+		Str("ip", ip).
+		Str("username", a.Username).
+		Msg("Dashboard is updated: " + dashboard.Name)
+
+	return nil
+}
+
+/*
+ * Get an existing shared dashboard the user is allowed to modify:
+ * its owner or an admin. Receives user's IP for the logging
+ */
+func (a *Account) ownSharedDashboard(ip, name string) (*Dashboard, error) {
+	existing, err := db.getSharedDashboard(name)
+	if err != nil {
+		log.Error().
+			Str("ip", ip).
+			Str("username", a.Username).
+			Msgf("Can't get shared dashboard '%s': %s", name, err.Error())
+		return nil, err
+	}
+
+	if existing == nil {
+		return nil, newAPIerror(http.StatusNotFound, "Shared dashboard doesn't exist.")
+	}
+
+	if existing.Owner != a.Username && !a.Admin {
+		log.Error().
+			Str("ip", ip).
+			Str("username", a.Username).
+			Msg("Shared dashboard of another user can't be modified: " + name)
+
+		return nil, newAPIerror(http.StatusForbidden, "Shared dashboard of another user can't be modified.")
+	}
+
+	return existing, nil
 }
 
 /*
  * Handle 'dashboard-delete' websocket command to delete selected dashboard
  * by its name. "shared" says to search in a private or shared lists
  */
-func (a *Account) delDashboardHandler(name, shared string) {
+func (a *Account) delDashboardHandler(reqID, name, shared string) {
 
-	// Skip empty name or filters
-	if name == "" {
-		a.send("error", "Dashboard name can't be empty", "")
+	err := a.deleteDashboard(a.Session.IP, name, shared == "true")
+	if err != nil {
+		a.reply(reqID, "error", err.Error(), "")
+		return
+	}
+
+	if shared == "true" {
+		broadcast("dashboard-deleted", name, "true")
+	} else {
+		a.reply(reqID, "dashboard-deleted", name, "false")
+	}
+}
+
+/*
+ * Delete shared or own/private dashboard by its name.
+ * Receives also user's IP for the logging
+ */
+func (a *Account) deleteDashboard(ip, name string, shared bool) error {
 
+	// Skip empty name
+	if name == "" {
 		log.Error().
-			Str("ip", a.Session.IP).
+			Str("ip", ip).
 			Str("username", a.Username).
 			Msg("Dashboard name can't be empty to delete")
-		return
+
+		return newAPIerror(http.StatusBadRequest, "Dashboard name can't be empty")
 	}
 
 	/*
 	 * Delete shared dashboard
 	 */
-	if shared == "true" {
+	if shared {
+		if _, err := a.ownSharedDashboard(ip, name); err != nil {
+			return err
+		}
+
 		ctx, cancel := db.newContext()
 		defer cancel()
 
 		_, err := db.Dashboards.DeleteOne(ctx, bson.M{"_id": name})
 		if err != nil {
-			a.send("error", err.Error(), "")
-
 			log.Error().
-				Str("ip", a.Session.IP).
+				Str("ip", ip).
 				Str("username", a.Username).
 				Msgf("Can't delete shared dashboard '%s': %s", name, err.Error())
-			return
+			return err
 		}
 
-		broadcast("dashboard-deleted", name, "true")
-
 		log.Info().
-			Str("ip", a.Session.IP).
+			Str("ip", ip).
 			Str("username", a.Username).
 			Msg("Shared dashboard is deleted: " + name)
 
@@ -203,23 +333,24 @@ func (a *Account) delDashboardHandler(name, shared string) {
 		 * Delete private dashboard
 		 */
 	} else {
+		a.DashboardsMutex.Lock()
 		delete(a.Dashboards, name)
 		err := a.update("dashboards", a.Dashboards)
-		if err != nil {
-			a.send("error", err.Error(), "")
+		a.DashboardsMutex.Unlock()
 
+		if err != nil {
 			log.Error().
-				Str("ip", a.Session.IP).
+				Str("ip", ip).
 				Str("username", a.Username).
 				Msgf("Can't delete dashboard '%s': %s", name, err.Error())
-			return
+			return err
 		}
 
-		a.send("dashboard-deleted", name, "false")
-
 		log.Info().
-			Str("ip", a.Session.IP).
+			Str("ip", ip).
 			Str("username", a.Username).
 			Msg("Dashboard is deleted: " + name)
 	}
+
+	return nil
 }
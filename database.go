@@ -47,6 +47,12 @@ type Database struct {
 
 	// Graph global UI settings
 	Settings *mongo.Collection
+
+	// Hashed API tokens of the users
+	Tokens *mongo.Collection
+
+	// Searches running in a background and their results
+	Jobs *mongo.Collection
 }
 
 /*
@@ -59,6 +65,12 @@ type Cache struct {
 	// Statistics info
 	Stats map[string]interface{} `bson:"stats"`
 
+	// Status of every queried data source
+	Sources map[string]*SourceStatus `bson:"sources"`
+
+	// Continuation token of the next page, if any
+	Cursor string `bson:"cursor,omitempty"`
+
 	// Record creation timestamp for the TTL
 	Ts time.Time `bson:"ts"`
 }
@@ -103,10 +115,14 @@ func setupDatabase() error {
 		Notes:      client.Database(config.Database.Name).Collection(config.Database.Notes),
 		Cache:      client.Database(config.Database.Name).Collection(config.Database.Cache),
 		Settings:   client.Database(config.Database.Name).Collection(config.Database.Settings),
+		Tokens:     client.Database(config.Database.Name).Collection(config.Database.Tokens),
+		Jobs:       client.Database(config.Database.Name).Collection(config.Database.Jobs),
 	}
 
 	db.prepare()
-	db.setCacheTTL()
+	db.setTTL(db.Cache, "cache", config.Database.CacheTTL)
+	db.setTTL(db.Jobs, "jobs", config.Database.JobsTTL)
+	db.setTokensIndexes()
 
 	log.Debug().Msg("Database successfully connected")
 
@@ -288,7 +304,17 @@ func (d *Database) deleteAccount(username string) error {
 		return fmt.Errorf("No accounts were deleted")
 	}
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Account's API tokens are not valid anymore
+	_, err = d.Tokens.DeleteMany(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("Can't delete account's tokens: " + err.Error())
+	}
+
+	return nil
 }
 
 /*
@@ -371,6 +397,25 @@ func (d *Database) getSharedDashboards() (map[string]*Dashboard, error) {
 	return dashboards, nil
 }
 
+/*
+ * Return shared dashboard by its name, nil if it doesn't exist
+ */
+func (d *Database) getSharedDashboard(name string) (*Dashboard, error) {
+	dashboard := &Dashboard{}
+
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	err := d.Dashboards.FindOne(ctx, bson.M{"_id": name}).Decode(dashboard)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return dashboard, nil
+}
+
 /*
  * Manage users notes for the graph elements
  */
@@ -497,12 +542,15 @@ func (d *Database) getCache(query string) (*Cache, error) {
 
 /*
  * Cache the data sources responses.
- * Receives user's query as a key, relations and statistics from data sources
+ * Receives user's query as a key, relations, statistics,
+ * statuses from data sources and the next page's token
  */
-func (d *Database) setCache(query string, relations []map[string]interface{}, stats map[string]interface{}) {
+func (d *Database) setCache(query string, relations []map[string]interface{}, stats map[string]interface{}, sources map[string]*SourceStatus, cursor string) {
 	cache := &Cache{
 		Relations: relations,
 		Stats:     stats,
+		Sources:   sources,
+		Cursor:    cursor,
 		Ts:        time.Now(),
 	}
 
@@ -530,31 +578,32 @@ func (d *Database) setCache(query string, relations []map[string]interface{}, st
 }
 
 /*
- * Set TTL for the cache collection's entries
+ * Set TTL for the collection's entries, like cache or jobs,
+ * based on their "ts" timestamp
  */
-func (d *Database) setCacheTTL() {
+func (d *Database) setTTL(coll *mongo.Collection, name string, ttl int32) {
 	// Drop old index first.
 	// Otherwise TTL param won't be updated
 	ctx, cancel := d.newContext()
 	defer cancel()
 
-	_, err := d.Cache.Indexes().DropAll(ctx)
+	_, err := coll.Indexes().DropAll(ctx)
 	if err != nil {
 		// Ignore namespace not found errors
 		commandErr, ok := err.(mongo.CommandError)
 		if !ok {
-			log.Error().Msg("Can't check MongoDB cache indexes drop error: " + err.Error())
+			log.Error().Msg("Can't check MongoDB " + name + " indexes drop error: " + err.Error())
 		}
 		if commandErr.Name != "NamespaceNotFound" {
-			log.Error().Msg("Failed to drop cache coll's indexes: " + err.Error())
+			log.Error().Msg("Failed to drop " + name + " coll's indexes: " + err.Error())
 		}
 
 	} else {
-		log.Debug().Msg("Cache coll's old indexes are dropped")
+		log.Debug().Msg("Old indexes of the " + name + " coll are dropped")
 	}
 
 	// Create a new index
-	if config.Database.CacheTTL != 0 {
+	if ttl != 0 {
 		opts := options.CreateIndexes().SetMaxTime(time.Duration(config.Database.Timeout) * time.Second)
 
 		index := mongo.IndexModel{
@@ -562,19 +611,259 @@ func (d *Database) setCacheTTL() {
 				"ts": 1,
 			},
 			Options: &options.IndexOptions{
-				ExpireAfterSeconds: &config.Database.CacheTTL,
+				ExpireAfterSeconds: &ttl,
 			},
 		}
 
-		_, err = d.Cache.Indexes().CreateOne(ctx, index, opts)
+		_, err = coll.Indexes().CreateOne(ctx, index, opts)
 		if err != nil {
-			log.Error().Msg("Can't create cache coll's index: " + err.Error())
+			log.Error().Msg("Can't create " + name + " coll's index: " + err.Error())
 		} else {
-			log.Debug().Msg("Cache coll's index is created")
+			log.Debug().Msg("Index of the " + name + " coll is created")
 		}
 	}
 }
 
+/*
+ * Search jobs
+ */
+
+/*
+ * Store a new search job
+ */
+func (d *Database) addJob(job *Job) error {
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	_, err := d.Jobs.InsertOne(ctx, job)
+	if err != nil {
+		return fmt.Errorf("Can't save job: " + err.Error())
+	}
+
+	return nil
+}
+
+/*
+ * Return user's search job by its ID.
+ * Returns nil when the job doesn't exist or has expired
+ */
+func (d *Database) getJob(id, username string) (*Job, error) {
+	job := &Job{}
+	filter := bson.M{"_id": id, "username": username}
+
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	err := d.Jobs.FindOne(ctx, filter).Decode(job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+/*
+ * Update search job's fields
+ */
+func (d *Database) updateJob(id string, fields map[string]interface{}) error {
+	filter := bson.M{"_id": id}
+	update := bson.M{"$set": fields}
+
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	_, err := d.Jobs.UpdateOne(ctx, filter, update)
+
+	return err
+}
+
+/*
+ * Delete search job by its ID
+ */
+func (d *Database) deleteJob(id string) error {
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	_, err := d.Jobs.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("Can't delete job: " + err.Error())
+	}
+
+	return nil
+}
+
+/*
+ * Mark all the running jobs as failed with the given error
+ */
+func (d *Database) failJobs(message string) error {
+	filter := bson.M{"status": jobRunning}
+	update := bson.M{"$set": bson.M{
+		"status":   jobFailed,
+		"error":    message,
+		"finished": time.Now().UTC(),
+	}}
+
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	_, err := d.Jobs.UpdateMany(ctx, filter, update)
+
+	return err
+}
+
+/*
+ * API tokens
+ */
+
+/*
+ * Store a new API token
+ */
+func (d *Database) addToken(token *Token) error {
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	_, err := d.Tokens.InsertOne(ctx, token)
+	if err != nil {
+		return fmt.Errorf("Can't save token: " + err.Error())
+	}
+
+	return nil
+}
+
+/*
+ * Return API token by the hash of its secret
+ */
+func (d *Database) getToken(hash string) (*Token, error) {
+	token := &Token{}
+	filter := bson.M{"hash": hash}
+
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	err := d.Tokens.FindOne(ctx, filter).Decode(token)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("Unknown token")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+/*
+ * Return API tokens of the user, sorted by the creation time.
+ * Empty username returns tokens of all users
+ */
+func (d *Database) getTokens(username string) ([]*Token, error) {
+	tokens := []*Token{}
+	filter := bson.M{}
+
+	if username != "" {
+		filter["username"] = username
+	}
+
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "username", Value: 1}, {Key: "created", Value: 1}})
+
+	cursor, err := d.Tokens.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+/*
+ * Delete API token by its ID.
+ * Non empty username allows to delete own tokens only
+ */
+func (d *Database) deleteToken(id, username string) error {
+	filter := bson.M{"_id": id}
+
+	if username != "" {
+		filter["username"] = username
+	}
+
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	res, err := d.Tokens.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("No tokens were deleted")
+	}
+
+	return nil
+}
+
+/*
+ * Set API token's last used time to now
+ */
+func (d *Database) touchToken(id string) error {
+	filter := bson.M{"_id": id}
+	update := bson.M{"$set": bson.M{
+		"lastUsed": time.Now().UTC(),
+	}}
+
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	_, err := d.Tokens.UpdateOne(ctx, filter, update)
+
+	return err
+}
+
+/*
+ * Create indexes of the tokens collection:
+ * unique hash to authenticate with and TTL of the expired tokens
+ */
+func (d *Database) setTokensIndexes() {
+	ctx, cancel := d.newContext()
+	defer cancel()
+
+	unique := true
+	expireAfter := int32(0)
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.M{"hash": 1},
+			Options: &options.IndexOptions{
+				Unique: &unique,
+			},
+		},
+		{
+			Keys: bson.M{"username": 1},
+		},
+		{
+			Keys: bson.M{"expires": 1},
+			Options: &options.IndexOptions{
+				ExpireAfterSeconds: &expireAfter,
+			},
+		},
+	}
+
+	opts := options.CreateIndexes().SetMaxTime(time.Duration(config.Database.Timeout) * time.Second)
+
+	_, err := d.Tokens.Indexes().CreateMany(ctx, indexes, opts)
+	if err != nil {
+		log.Error().Msg("Can't create tokens coll's indexes: " + err.Error())
+	} else {
+		log.Debug().Msg("Tokens coll's indexes are created")
+	}
+}
+
 /*
  * UI settings
  */
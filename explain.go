@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+var (
+	// Regex to detect "EXPLAIN FROM ... WHERE ..." queries
+	reExplain = regexp.MustCompile(`(?i)^\s*EXPLAIN\s+`)
+)
+
+/*
+ * Structure of a single data source query explanation
+ */
+type Explanation struct {
+	// Final query after the parsing, fields replacing, OR/IN splitting, etc.
+	Query string `json:"query"`
+
+	// Filters applied to the received relations in memory
+	Filter string `json:"filter,omitempty"`
+
+	// Query in the data source's native form,
+	// when the plugin is able to show it
+	Native interface{} `json:"native,omitempty"`
+
+	// Native query conversion error
+	Error string `json:"error,omitempty"`
+}
+
+/*
+ * Check whether the given query is an EXPLAIN request.
+ * Returns the query without the EXPLAIN keyword
+ */
+func explainRequested(sql string) (string, bool) {
+	if !reExplain.MatchString(sql) {
+		return sql, false
+	}
+
+	return reExplain.ReplaceAllString(sql, ""), true
+}
+
+/*
+ * Show how each of the requested data sources would be queried,
+ * without running anything
+ */
+func explainSources(source, sql, username string) *APIresponse {
+
+	// Response to send back
+	response := &APIresponse{
+		Explain: make(map[string][]*Explanation),
+	}
+
+	// Show the final absolute time range
+	sql, err := resolveTime(sql)
+	if err != nil {
+		response.Error = "Can't resolve time expression: " + err.Error()
+		return response
+	}
+
+	// Collectors to explain the query for
+	selected := []pdk.SourcePlugin{}
+
+	sourcesMx.RLock()
+	if collector, ok := collectors[source]; ok {
+		selected = append(selected, collector)
+	} else if _, ok := sourceGroups[source]; ok || source == "global" {
+		selected = groupCollectors(source)
+	} else {
+		response.Error = "Unknown data source requested"
+	}
+	sourcesMx.RUnlock()
+
+	if response.Error != "" {
+		return response
+	}
+
+	for _, collector := range selected {
+		name := collector.Conf().Name
+
+		// Parse textual SQL into a syntax tree object
+		queries, err := parseSQL(sql, collector.Conf().IncludeDatetime, collector.Conf().IncludeFields, collector.Conf().ReplaceFields, collector.Conf().SupportsSQL, matchesCIDR(collector), capabilities(collector))
+		if err != nil {
+			response.setStatus(name, &SourceStatus{
+				Status: statusError,
+				Error:  err.Error(),
+			})
+			continue
+		}
+
+		for _, query := range queries {
+			explanation := &Explanation{
+				Query: sqlparser.String(query.Select),
+			}
+
+			if query.Filter != nil {
+				explanation.Filter = sqlparser.String(query.Filter)
+			}
+
+			// Not all the plugins are able to show a native query
+			if explainer, ok := collector.(pdk.ExplainSourcePlugin); ok {
+				native, err := explainer.Explain(query.Select)
+				if err != nil {
+					explanation.Error = err.Error()
+				} else {
+					explanation.Native = native
+				}
+			}
+
+			response.Explain[name] = append(response.Explain[name], explanation)
+		}
+	}
+
+	response.summarize()
+
+	log.Info().
+		Str("username", username).
+		Str("sql", sql).
+		Msg("Query explained")
+
+	return response
+}
+
+/*
+ * Format explanations as a plain list of rows
+ * to be displayed as a table
+ */
+func (a *APIresponse) explainRows() []map[string]interface{} {
+	names := make([]string, 0, len(a.Explain))
+	for name := range a.Explain {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := []map[string]interface{}{}
+
+	for _, name := range names {
+		for _, explanation := range a.Explain[name] {
+			native := ""
+
+			if explanation.Native != nil {
+				b, err := json.Marshal(explanation.Native)
+				if err != nil {
+					native = err.Error()
+				} else {
+					native = string(b)
+				}
+			}
+
+			rows = append(rows, map[string]interface{}{
+				"source": name,
+				"query":  explanation.Query,
+				"filter": explanation.Filter,
+				"native": native,
+				"error":  explanation.Error,
+			})
+		}
+	}
+
+	return rows
+}
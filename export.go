@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Content types of the supported output formats
+	contentTypes = map[string]string{
+		"json":    "application/json",
+		"table":   "text/plain; charset=utf-8",
+		"csv":     "text/csv; charset=utf-8",
+		"ndjson":  "application/x-ndjson",
+		"graphml": "application/graphml+xml",
+		"gexf":    "application/gexf+xml",
+	}
+)
+
+/*
+ * Check whether the output format is supported.
+ * Empty format means the default JSON
+ */
+func validFormat(format string) bool {
+	if format == "" {
+		return true
+	}
+
+	_, ok := contentTypes[format]
+	return ok
+}
+
+/*
+ * Check whether the output format is for the other tools only,
+ * so human readable comments can't be mixed into it
+ */
+func rawFormat(format string) bool {
+	return format == "csv" || format == "ndjson" || graphFormat(format)
+}
+
+/*
+ * Check whether the output format describes a graph
+ * instead of the list of relations
+ */
+func graphFormat(format string) bool {
+	return format == "graphml" || format == "gexf"
+}
+
+/*
+ * Graph built from the relations,
+ * common for all the graph output formats
+ */
+type exportGraph struct {
+	Nodes []*exportElement
+	Edges []*exportElement
+
+	// Sorted names of all the nodes and edges attributes
+	NodeAttributes []string
+	EdgeAttributes []string
+}
+
+/*
+ * Single node or edge of the graph.
+ * Source and target are set for the edges only
+ */
+type exportElement struct {
+	ID         string
+	Label      string
+	Source     string
+	Target     string
+	Attributes map[string]string
+}
+
+/*
+ * Build a graph from the relations.
+ * Identical nodes are stored once, attributes of the first one are kept
+ */
+func buildGraph(relations []map[string]interface{}) *exportGraph {
+	graph := &exportGraph{}
+	nodes := make(map[string]*exportElement)
+	nodeAttributes := make(map[string]bool)
+	edgeAttributes := make(map[string]bool)
+
+	addNode := func(node map[string]interface{}) string {
+		id := fmt.Sprintf("%v:%v", node["group"], node["id"])
+		if _, ok := nodes[id]; ok {
+			return id
+		}
+
+		element := &exportElement{
+			ID:    id,
+			Label: exportValue(node["id"]),
+			Attributes: map[string]string{
+				"group": exportValue(node["group"]),
+			},
+		}
+
+		if sources, ok := node["sources"]; ok {
+			element.Attributes["sources"] = exportValue(sources)
+		}
+
+		addAttributes(element, node["attributes"])
+
+		for name := range element.Attributes {
+			nodeAttributes[name] = true
+		}
+
+		nodes[id] = element
+		graph.Nodes = append(graph.Nodes, element)
+
+		return id
+	}
+
+	for _, relation := range relations {
+		from, okFrom := relation["from"].(map[string]interface{})
+		to, okTo := relation["to"].(map[string]interface{})
+
+		// Unknown format
+		if !okFrom || !okTo {
+			continue
+		}
+
+		element := &exportElement{
+			ID:         "e" + strconv.Itoa(len(graph.Edges)),
+			Source:     addNode(from),
+			Target:     addNode(to),
+			Attributes: map[string]string{},
+		}
+
+		// Data source names and the indicator of the uploaded list
+		for _, name := range []string{"source", "sources", "indicator"} {
+			if value, ok := relation[name]; ok {
+				element.Attributes[name] = exportValue(value)
+			}
+		}
+
+		if edge, ok := relation["edge"].(map[string]interface{}); ok {
+			element.Label = exportValue(edge["label"])
+			addAttributes(element, edge["attributes"])
+		}
+
+		for name := range element.Attributes {
+			edgeAttributes[name] = true
+		}
+
+		graph.Edges = append(graph.Edges, element)
+	}
+
+	graph.NodeAttributes = sortedKeys(nodeAttributes)
+	graph.EdgeAttributes = sortedKeys(edgeAttributes)
+
+	return graph
+}
+
+/*
+ * Copy node's or edge's attributes as strings.
+ * Built-in attributes are not overwritten
+ */
+func addAttributes(element *exportElement, attributes interface{}) {
+	list, ok := attributes.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for name, value := range list {
+		if _, ok := element.Attributes[name]; !ok {
+			element.Attributes[name] = exportValue(value)
+		}
+	}
+}
+
+/*
+ * Convert attribute's value to a string.
+ * Lists are comma separated, objects become JSON
+ */
+func exportValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool, int, int32, int64, float32, float64:
+		return fmt.Sprint(v)
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		values := make([]string, rv.Len())
+		for i := range values {
+			values[i] = exportValue(rv.Index(i).Interface())
+		}
+
+		return strings.Join(values, ", ")
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(b)
+}
+
+/*
+ * Get sorted keys of the set
+ */
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+/*
+ * Format relations as a graph.
+ * Errors of the search are stored in graph's description
+ */
+func formatGraph(relations []map[string]interface{}, format, errors string) (string, error) {
+	graph := buildGraph(relations)
+
+	var doc interface{}
+	if format == "gexf" {
+		doc = graph.gexf(errors)
+	} else {
+		doc = graph.graphML(errors)
+	}
+
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("Can't format API response to " + strings.ToUpper(format) + ": " + err.Error())
+	}
+
+	return xml.Header + string(b) + "\n", nil
+}
+
+/*
+ * GraphML document structure.
+ * More details at: http://graphml.graphdrawing.org/
+ */
+type graphML struct {
+	XMLName xml.Name      `xml:"graphml"`
+	XMLNS   string        `xml:"xmlns,attr"`
+	Keys    []*graphMLKey `xml:"key"`
+	Graph   *graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string            `xml:"id,attr"`
+	EdgeDefault string            `xml:"edgedefault,attr"`
+	Data        []*graphMLData    `xml:"data"`
+	Nodes       []*graphMLElement `xml:"node"`
+	Edges       []*graphMLElement `xml:"edge"`
+}
+
+type graphMLElement struct {
+	ID     string         `xml:"id,attr"`
+	Source string         `xml:"source,attr,omitempty"`
+	Target string         `xml:"target,attr,omitempty"`
+	Data   []*graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+/*
+ * Convert graph to the GraphML document
+ */
+func (g *exportGraph) graphML(errors string) *graphML {
+	doc := &graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Graph: &graphMLGraph{
+			ID:          "G",
+			EdgeDefault: "directed",
+		},
+	}
+
+	if errors != "" {
+		doc.Keys = append(doc.Keys, &graphMLKey{ID: "g0", For: "graph", Name: "error", Type: "string"})
+		doc.Graph.Data = append(doc.Graph.Data, &graphMLData{Key: "g0", Value: errors})
+	}
+
+	// Label is the first attribute of the nodes and edges
+	keys := func(prefix, kind string, attributes []string) map[string]string {
+		ids := make(map[string]string, len(attributes)+1)
+
+		for i, name := range append([]string{"label"}, attributes...) {
+			if _, ok := ids[name]; ok {
+				continue
+			}
+
+			ids[name] = prefix + strconv.Itoa(i)
+			doc.Keys = append(doc.Keys, &graphMLKey{ID: ids[name], For: kind, Name: name, Type: "string"})
+		}
+
+		return ids
+	}
+
+	data := func(ids map[string]string, element *exportElement) []*graphMLData {
+		list := []*graphMLData{}
+
+		if element.Label != "" {
+			list = append(list, &graphMLData{Key: ids["label"], Value: element.Label})
+		}
+
+		for _, name := range sortedKeys(stringSet(element.Attributes)) {
+			if name == "label" {
+				continue
+			}
+			list = append(list, &graphMLData{Key: ids[name], Value: element.Attributes[name]})
+		}
+
+		return list
+	}
+
+	nodeKeys := keys("n", "node", g.NodeAttributes)
+	edgeKeys := keys("e", "edge", g.EdgeAttributes)
+
+	for _, node := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, &graphMLElement{
+			ID:   node.ID,
+			Data: data(nodeKeys, node),
+		})
+	}
+
+	for _, edge := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, &graphMLElement{
+			ID:     edge.ID,
+			Source: edge.Source,
+			Target: edge.Target,
+			Data:   data(edgeKeys, edge),
+		})
+	}
+
+	return doc
+}
+
+/*
+ * GEXF document structure.
+ * More details at: https://gexf.net/
+ */
+type gexf struct {
+	XMLName xml.Name   `xml:"gexf"`
+	XMLNS   string     `xml:"xmlns,attr"`
+	Version string     `xml:"version,attr"`
+	Meta    *gexfMeta  `xml:"meta"`
+	Graph   *gexfGraph `xml:"graph"`
+}
+
+type gexfMeta struct {
+	Creator     string `xml:"creator"`
+	Description string `xml:"description,omitempty"`
+}
+
+type gexfGraph struct {
+	Mode            string            `xml:"mode,attr"`
+	DefaultEdgeType string            `xml:"defaultedgetype,attr"`
+	Attributes      []*gexfAttributes `xml:"attributes"`
+	Nodes           []*gexfElement    `xml:"nodes>node"`
+	Edges           []*gexfElement    `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string           `xml:"class,attr"`
+	Attributes []*gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfElement struct {
+	ID        string          `xml:"id,attr"`
+	Source    string          `xml:"source,attr,omitempty"`
+	Target    string          `xml:"target,attr,omitempty"`
+	Label     string          `xml:"label,attr,omitempty"`
+	AttValues []*gexfAttValue `xml:"attvalues>attvalue,omitempty"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+/*
+ * Convert graph to the GEXF document
+ */
+func (g *exportGraph) gexf(errors string) *gexf {
+	doc := &gexf{
+		XMLNS:   "http://gexf.net/1.3",
+		Version: "1.3",
+		Meta: &gexfMeta{
+			Creator:     "Graphoscope " + version,
+			Description: errors,
+		},
+		Graph: &gexfGraph{
+			Mode:            "static",
+			DefaultEdgeType: "directed",
+		},
+	}
+
+	attributes := func(class string, names []string) map[string]string {
+		ids := make(map[string]string, len(names))
+		list := &gexfAttributes{Class: class}
+
+		for i, name := range names {
+			ids[name] = strconv.Itoa(i)
+			list.Attributes = append(list.Attributes, &gexfAttribute{ID: ids[name], Title: name, Type: "string"})
+		}
+
+		doc.Graph.Attributes = append(doc.Graph.Attributes, list)
+		return ids
+	}
+
+	values := func(ids map[string]string, element *exportElement) []*gexfAttValue {
+		list := []*gexfAttValue{}
+
+		for _, name := range sortedKeys(stringSet(element.Attributes)) {
+			list = append(list, &gexfAttValue{For: ids[name], Value: element.Attributes[name]})
+		}
+
+		return list
+	}
+
+	nodeIDs := attributes("node", g.NodeAttributes)
+	edgeIDs := attributes("edge", g.EdgeAttributes)
+
+	for _, node := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, &gexfElement{
+			ID:        node.ID,
+			Label:     node.Label,
+			AttValues: values(nodeIDs, node),
+		})
+	}
+
+	for _, edge := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, &gexfElement{
+			ID:        edge.ID,
+			Source:    edge.Source,
+			Target:    edge.Target,
+			Label:     edge.Label,
+			AttValues: values(edgeIDs, edge),
+		})
+	}
+
+	return doc
+}
+
+/*
+ * Get a set of the map's keys
+ */
+func stringSet(m map[string]string) map[string]bool {
+	set := make(map[string]bool, len(m))
+	for key := range m {
+		set[key] = true
+	}
+
+	return set
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// Relations to export, with a node shared by both
+var exportRelations = []map[string]interface{}{
+	{
+		"from": map[string]interface{}{
+			"id":     "10.10.10.10",
+			"group":  "ip",
+			"search": "ip",
+			"attributes": map[string]interface{}{
+				"ports": []interface{}{80, 443},
+			},
+		},
+		"to": map[string]interface{}{
+			"id":     "example.com",
+			"group":  "domain",
+			"search": "domain",
+		},
+		"edge": map[string]interface{}{
+			"label": "resolves",
+			"attributes": map[string]interface{}{
+				"seen": "<today> & \"now\"",
+			},
+		},
+		"source": "dns",
+	},
+	{
+		"from": map[string]interface{}{
+			"id":     "10.10.10.10",
+			"group":  "ip",
+			"search": "ip",
+		},
+		"to": map[string]interface{}{
+			"id":     "LV",
+			"group":  "country",
+			"search": "country",
+		},
+		"source": "geoip",
+	},
+	// Unknown format is skipped
+	{
+		"source": "broken",
+	},
+}
+
+/*
+ * Test GraphML output
+ */
+func TestFormatGraphML(t *testing.T) {
+	output, err := formatGraph(exportRelations, "graphml", "\"shodan\" error: timeout")
+	if err != nil {
+		t.Fatalf("Can't format GraphML: %s", err.Error())
+	}
+
+	if !strings.HasPrefix(output, xml.Header) {
+		t.Errorf("XML header is missing")
+	}
+
+	doc := &graphML{}
+	if err := xml.Unmarshal([]byte(output), doc); err != nil {
+		t.Fatalf("Can't parse GraphML: %s", err.Error())
+	}
+
+	if doc.Graph.EdgeDefault != "directed" || len(doc.Graph.Data) != 1 || doc.Graph.Data[0].Value != "\"shodan\" error: timeout" {
+		t.Errorf("Invalid graph: %+v", doc.Graph)
+	}
+
+	// Names of the keys by their IDs
+	keys := make(map[string]string)
+	for _, key := range doc.Keys {
+		keys[key.ID] = key.For + ":" + key.Name
+	}
+
+	data := func(element *graphMLElement) map[string]string {
+		values := make(map[string]string)
+		for _, d := range element.Data {
+			values[keys[d.Key]] = d.Value
+		}
+		return values
+	}
+
+	// Identical nodes are merged
+	if len(doc.Graph.Nodes) != 3 {
+		t.Fatalf("Invalid amount of nodes: %d, expected: 3", len(doc.Graph.Nodes))
+	}
+
+	ip := data(doc.Graph.Nodes[0])
+	if doc.Graph.Nodes[0].ID != "ip:10.10.10.10" || ip["node:label"] != "10.10.10.10" || ip["node:group"] != "ip" || ip["node:ports"] != "80, 443" {
+		t.Errorf("Invalid node: %s %v", doc.Graph.Nodes[0].ID, ip)
+	}
+
+	if len(doc.Graph.Edges) != 2 {
+		t.Fatalf("Invalid amount of edges: %d, expected: 2", len(doc.Graph.Edges))
+	}
+
+	edge := doc.Graph.Edges[0]
+	values := data(edge)
+
+	if edge.Source != "ip:10.10.10.10" || edge.Target != "domain:example.com" {
+		t.Errorf("Invalid edge direction: %s -> %s", edge.Source, edge.Target)
+	}
+
+	if values["edge:label"] != "resolves" || values["edge:source"] != "dns" || values["edge:seen"] != "<today> & \"now\"" {
+		t.Errorf("Invalid edge data: %v", values)
+	}
+
+	if data(doc.Graph.Edges[1])["edge:source"] != "geoip" {
+		t.Errorf("Invalid source of the second edge: %v", data(doc.Graph.Edges[1]))
+	}
+}
+
+/*
+ * Test GEXF output
+ */
+func TestFormatGEXF(t *testing.T) {
+	output, err := formatGraph(exportRelations, "gexf", "")
+	if err != nil {
+		t.Fatalf("Can't format GEXF: %s", err.Error())
+	}
+
+	doc := &gexf{}
+	if err := xml.Unmarshal([]byte(output), doc); err != nil {
+		t.Fatalf("Can't parse GEXF: %s", err.Error())
+	}
+
+	if doc.Version != "1.3" || doc.Meta.Description != "" || doc.Graph.DefaultEdgeType != "directed" {
+		t.Errorf("Invalid document: %+v %+v", doc.Meta, doc.Graph)
+	}
+
+	// Titles of the attributes by the class and ID
+	titles := make(map[string]string)
+	for _, list := range doc.Graph.Attributes {
+		for _, attribute := range list.Attributes {
+			titles[list.Class+attribute.ID] = attribute.Title
+		}
+	}
+
+	values := func(class string, element *gexfElement) map[string]string {
+		result := make(map[string]string)
+		for _, value := range element.AttValues {
+			result[titles[class+value.For]] = value.Value
+		}
+		return result
+	}
+
+	if len(doc.Graph.Nodes) != 3 || len(doc.Graph.Edges) != 2 {
+		t.Fatalf("Invalid amount of nodes and edges: %d, %d, expected: 3, 2", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+
+	node := doc.Graph.Nodes[1]
+	if node.ID != "domain:example.com" || node.Label != "example.com" || values("node", node)["group"] != "domain" {
+		t.Errorf("Invalid node: %+v %v", node, values("node", node))
+	}
+
+	edge := doc.Graph.Edges[0]
+	if edge.Source != "ip:10.10.10.10" || edge.Target != "domain:example.com" || edge.Label != "resolves" {
+		t.Errorf("Invalid edge: %+v", edge)
+	}
+
+	if v := values("edge", edge); v["source"] != "dns" || v["seen"] != "<today> & \"now\"" {
+		t.Errorf("Invalid edge attributes: %v", v)
+	}
+}
+
+/*
+ * Test attributes values conversion
+ */
+func TestExportValue(t *testing.T) {
+	tables := []struct {
+		value    interface{}
+		exported string
+	}{
+		{nil, ""},
+		{"text", "text"},
+		{42, "42"},
+		{1.5, "1.5"},
+		{true, "true"},
+		{[]interface{}{"a", 1}, "a, 1"},
+		{[]string{"dns", "geoip"}, "dns, geoip"},
+		{map[string]interface{}{"a": 1}, `{"a":1}`},
+	}
+
+	for _, table := range tables {
+		if exported := exportValue(table.value); exported != table.exported {
+			t.Errorf("Invalid export of %v: %s, expected: %s", table.value, exported, table.exported)
+		}
+	}
+}
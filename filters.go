@@ -19,11 +19,11 @@ type Filter struct {
 /*
  * Handle 'filters' websocket command to save all current user's filters
  */
-func (a *Account) filtersHandler(filters string) {
+func (a *Account) filtersHandler(reqID, filters string) {
 	var list []map[string]*Filter
 	err := json.Unmarshal([]byte(filters), &list)
 	if err != nil {
-		a.send("error", "Can't parse filters data.", "Can't save filters!")
+		a.reply(reqID, "error", "Can't parse filters data.", "Can't save filters!")
 
 		log.Error().
 			Str("ip", a.Session.IP).
@@ -34,7 +34,7 @@ func (a *Account) filtersHandler(filters string) {
 
 	err = a.update("filters", list)
 	if err != nil {
-		a.send("error", err.Error(), "Can't save filters!")
+		a.reply(reqID, "error", err.Error(), "Can't save filters!")
 		return
 	}
 
@@ -20,6 +20,10 @@ type TemplateData struct {
 	// will be used to generate sources dropdowns
 	Collectors map[string]pdk.SourcePlugin
 
+	// Named groups of the data sources,
+	// will be listed in sources dropdowns too
+	SourceGroups map[string]*SourceGroup
+
 	// A list of shared dashboards to be loaded
 	Shared map[string]*Dashboard
 
@@ -47,6 +51,9 @@ type TemplateData struct {
 	// A list of all registered users
 	Accounts []*Account
 
+	// API tokens of the current user, or of all users for admins
+	Tokens []*Token
+
 	// A list of new features for the current service's version.
 	// Will be displayed once for each user
 	Features []string
@@ -175,10 +182,12 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Collect dynamic data
+	sourcesMx.RLock()
 	templateData := &TemplateData{
 		Account:        account,
 		Filters:        filters,
 		Collectors:     collectors,
+		SourceGroups:   sourceGroups,
 		NonGlobalExist: nonGlobalExist,
 		Shared:         shared,
 		Groups:         groups,
@@ -186,6 +195,7 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		Fields:         fields,
 		GraphSettings:  settings,
 	}
+	sourcesMx.RUnlock()
 
 	if account.SeenFeatures != features[0] {
 		templateData.Features = features
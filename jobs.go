@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+/*
+ * Search running in a background.
+ * Stored in a database, so the results can be requested later
+ */
+type Job struct {
+	// Unique ID to request the job's status
+	ID string `bson:"_id" json:"id"`
+
+	// Owner of the job
+	Username string `bson:"username" json:"-"`
+
+	// Requested data source or a group of them and SQL query
+	Source string `bson:"source" json:"source"`
+	SQL    string `bson:"sql" json:"sql"`
+
+	// One of: running, done, failed
+	Status string `bson:"status" json:"status"`
+
+	// Progress of every queried data source,
+	// "running" while its results are not received yet
+	Sources map[string]*SourceStatus `bson:"sources" json:"sources"`
+
+	// Search results, filled when the job is done
+	Relations []map[string]interface{} `bson:"relations,omitempty" json:"relations,omitempty"`
+	Stats     map[string]interface{}   `bson:"stats,omitempty" json:"stats,omitempty"`
+	Debug     map[string]interface{}   `bson:"debug,omitempty" json:"debug,omitempty"`
+	Error     string                   `bson:"error,omitempty" json:"error,omitempty"`
+	Cursor    string                   `bson:"cursor,omitempty" json:"cursor,omitempty"`
+
+	// Creation timestamp, used for the TTL too
+	Ts time.Time `bson:"ts" json:"created"`
+
+	// Timestamp when the job was finished
+	Finished *time.Time `bson:"finished,omitempty" json:"finished,omitempty"`
+
+	// Stops the running search
+	cancel context.CancelFunc
+}
+
+var (
+	// Jobs running in this service instance by their IDs
+	runningJobs   = make(map[string]*Job)
+	runningJobsMx sync.Mutex
+)
+
+/*
+ * Request body of a new search job
+ */
+type JobRequest struct {
+	// SQL query, not needed when cursor is given
+	SQL string `json:"sql,omitempty"`
+
+	// Continuation token of the next page
+	Cursor string `json:"cursor,omitempty"`
+
+	// Include queries debug info
+	Debug bool `json:"debug,omitempty"`
+
+	// Show partial results when limit exceeded
+	ShowLimited bool `json:"showLimited,omitempty"`
+}
+
+/*
+ * Jobs which were running when the service stopped
+ * will never finish, so mark them as failed
+ */
+func setupJobs() {
+	err := db.failJobs("Search was interrupted by the service restart")
+	if err != nil {
+		log.Error().Msg("Can't mark interrupted jobs as failed: " + err.Error())
+	}
+}
+
+/*
+ * Create a new job and start the search in a background.
+ * Positions are given when the job continues a paginated search
+ */
+func startJob(username, source, sql string, positions map[string]string, showLimited, includeDebug bool) (*Job, error) {
+	job := &Job{
+		ID:       uuid.NewString(),
+		Username: username,
+		Source:   source,
+		SQL:      sql,
+		Status:   jobRunning,
+		Sources:  make(map[string]*SourceStatus),
+		Ts:       time.Now().UTC(),
+	}
+
+	// Data sources to wait for
+	sourcesMx.RLock()
+
+	if _, ok := collectors[source]; ok {
+		job.Sources[source] = &SourceStatus{Status: jobRunning}
+
+	} else {
+		for _, collector := range groupCollectors(source) {
+			name := collector.Conf().Name
+
+			// Next page is requested only from the data sources with more data
+			if _, ok := positions[name]; positions != nil && !ok {
+				continue
+			}
+
+			job.Sources[name] = &SourceStatus{Status: jobRunning}
+		}
+	}
+
+	sourcesMx.RUnlock()
+
+	err := db.addJob(job)
+	if err != nil {
+		return nil, err
+	}
+
+	// Returned job is read by the caller
+	// while the running one is modified
+	running := *job
+	running.Sources = make(map[string]*SourceStatus, len(job.Sources))
+	for name, status := range job.Sources {
+		running.Sources[name] = status
+	}
+
+	// Search doesn't depend on the client's connection
+	ctx, cancel := context.WithCancel(context.Background())
+	running.cancel = cancel
+
+	runningJobsMx.Lock()
+	runningJobs[job.ID] = &running
+	runningJobsMx.Unlock()
+
+	go running.run(ctx, positions, showLimited, includeDebug)
+
+	return job, nil
+}
+
+/*
+ * Query data sources and store the results.
+ * Progress is updated as soon as each data source responds
+ */
+func (j *Job) run(ctx context.Context, positions map[string]string, showLimited, includeDebug bool) {
+	defer func() {
+		runningJobsMx.Lock()
+		delete(runningJobs, j.ID)
+		runningJobsMx.Unlock()
+
+		j.cancel()
+	}()
+
+	// Relations are delivered through the stream
+	relations := []map[string]interface{}{}
+	mx := &sync.Mutex{}
+
+	// Independent queries of the same data source respond separately,
+	// their statuses are merged the same way as in the final response
+	statuses := make(map[string]*SourceStatus)
+
+	stream := func(source string, result []map[string]interface{}, status *SourceStatus, pending int) {
+		mx.Lock()
+		defer mx.Unlock()
+
+		relations = append(relations, result...)
+		mergeStatus(statuses, source, status)
+
+		// Data source is still running until all its queries respond
+		if pending != 0 {
+			return
+		}
+		j.Sources[source] = statuses[source]
+
+		err := db.updateJob(j.ID, map[string]interface{}{"sources": j.Sources})
+		if err != nil {
+			log.Error().
+				Str("username", j.Username).
+				Str("job", j.ID).
+				Msg("Can't update job's progress: " + err.Error())
+		}
+	}
+
+	response := querySources(ctx, j.Source, j.SQL, positions, showLimited, includeDebug, j.Username, stream)
+
+	mx.Lock()
+	defer mx.Unlock()
+
+	// Canceled job is already deleted
+	if ctx.Err() != nil {
+		log.Info().
+			Str("username", j.Username).
+			Str("job", j.ID).
+			Msg("Job canceled")
+		return
+	}
+
+	// Cached results are not streamed
+	relations = mergeRelations(append(relations, response.Relations...))
+
+	// Final statuses contain merged results of all the queries
+	for name, status := range response.Sources {
+		j.Sources[name] = status
+	}
+
+	finished := time.Now().UTC()
+
+	err := db.updateJob(j.ID, map[string]interface{}{
+		"status":    jobDone,
+		"sources":   j.Sources,
+		"relations": relations,
+		"stats":     response.Stats,
+		"debug":     response.Debug,
+		"error":     response.Error,
+		"cursor":    response.Cursor,
+		"finished":  finished,
+	})
+	if err != nil {
+		log.Error().
+			Str("username", j.Username).
+			Str("job", j.ID).
+			Msg("Can't save job's results: " + err.Error())
+
+		// Results are too large to be stored, for example
+		err = db.updateJob(j.ID, map[string]interface{}{
+			"status":   jobFailed,
+			"error":    "Can't save results: " + err.Error(),
+			"finished": finished,
+		})
+		if err != nil {
+			log.Error().
+				Str("username", j.Username).
+				Str("job", j.ID).
+				Msg("Can't mark job as failed: " + err.Error())
+		}
+		return
+	}
+
+	log.Info().
+		Str("username", j.Username).
+		Str("job", j.ID).
+		Str("sql", j.SQL).
+		Msg("Job finished")
+}
+
+/*
+ * Stop the user's job when it's still running
+ * and delete it with its results.
+ * Returns false when the job doesn't exist or has expired
+ */
+func cancelJob(id, username string) (bool, error) {
+	job, err := db.getJob(id, username)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	runningJobsMx.Lock()
+	running, ok := runningJobs[id]
+	runningJobsMx.Unlock()
+
+	if ok {
+		running.cancel()
+	}
+
+	return true, db.deleteJob(id)
+}
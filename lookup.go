@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// Max amount of the indicators in a single lookup request
+	lookupMaxIndicators = 1000
+
+	// How many indicators are looked up concurrently.
+	// Data sources own limits are applied too
+	lookupWorkers = 4
+)
+
+/*
+ * Request body of a bulk indicators lookup
+ */
+type LookupRequest struct {
+	// Indicators to check
+	Indicators []*Indicator `json:"indicators"`
+
+	// Data source or a group of them to query
+	Source string `json:"source"`
+
+	// Datetime range to search in
+	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime"`
+
+	// Default field of the indicators without their own field
+	Field string `json:"field,omitempty"`
+
+	// Include queries debug info
+	Debug bool `json:"debug,omitempty"`
+
+	// Show partial results when limit exceeded
+	ShowLimited bool `json:"showLimited,omitempty"`
+}
+
+/*
+ * Single indicator to look up
+ */
+type Indicator struct {
+	// Value to search for. Without a field
+	// it's a filter itself, like "field='value'"
+	Value string `json:"value"`
+
+	// Data source's field to check
+	Field string `json:"field,omitempty"`
+}
+
+/*
+ * Response body of a bulk indicators lookup
+ */
+type LookupResponse struct {
+	// Results in the same order as the requested indicators
+	Results []*LookupResult `json:"results"`
+
+	// Amount of the indicators found in any data source
+	Hits int `json:"hits"`
+}
+
+/*
+ * Lookup result of a single indicator
+ */
+type LookupResult struct {
+	Value string `json:"value"`
+	Field string `json:"field,omitempty"`
+
+	// Whether any data source has found the indicator,
+	// including the ones with too many results
+	Hit bool `json:"hit"`
+
+	// Search results, the same as of a regular search
+	Relations []map[string]interface{} `json:"relations"`
+	Stats     map[string]interface{}   `json:"stats,omitempty"`
+	Debug     map[string]interface{}   `json:"debug,omitempty"`
+	Sources   map[string]*SourceStatus `json:"sources,omitempty"`
+	Error     string                   `json:"error,omitempty"`
+}
+
+/*
+ * Validate the lookup request
+ */
+func (l *LookupRequest) validate() error {
+	if len(l.Indicators) == 0 {
+		return fmt.Errorf("Indicators list can't be empty")
+	}
+
+	if len(l.Indicators) > lookupMaxIndicators {
+		return fmt.Errorf("Too many indicators, max %d are allowed", lookupMaxIndicators)
+	}
+
+	if err := validUploads("source", l.Source); err != nil {
+		return fmt.Errorf("Invalid 'source' value: " + err.Error())
+	}
+	if err := validUploads("datetime", l.StartTime); err != nil {
+		return fmt.Errorf("Invalid 'startTime' value: " + err.Error())
+	}
+	if err := validUploads("datetime", l.EndTime); err != nil {
+		return fmt.Errorf("Invalid 'endTime' value: " + err.Error())
+	}
+	if err := validUploads("field", l.Field); err != nil {
+		return fmt.Errorf("Invalid 'field' value: " + err.Error())
+	}
+
+	return nil
+}
+
+/*
+ * Look up all the requested indicators, a few of them concurrently.
+ * Failed indicators get their own errors, so the others are not affected
+ */
+func lookupIndicators(ctx context.Context, request *LookupRequest, username string) *LookupResponse {
+	response := &LookupResponse{
+		Results: make([]*LookupResult, len(request.Indicators)),
+	}
+
+	group := &errgroup.Group{}
+	group.SetLimit(lookupWorkers)
+
+	for i, indicator := range request.Indicators {
+		group.Go(func() error {
+			response.Results[i] = lookupIndicator(ctx, request, indicator, username)
+			return nil
+		})
+	}
+
+	// Errors are stored in the results
+	_ = group.Wait()
+
+	for _, result := range response.Results {
+		if result.Hit {
+			response.Hits++
+		}
+	}
+
+	return response
+}
+
+/*
+ * Look up a single indicator
+ */
+func lookupIndicator(ctx context.Context, request *LookupRequest, indicator *Indicator, username string) *LookupResult {
+	result := &LookupResult{
+		Relations: []map[string]interface{}{},
+	}
+
+	if indicator == nil {
+		result.Error = "Indicator can't be empty"
+		return result
+	}
+
+	result.Value = indicator.Value
+	result.Field = indicator.Field
+
+	if result.Field == "" {
+		result.Field = request.Field
+	}
+
+	filter, err := indicatorFilter(result.Field, indicator.Value)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if filter == "" || indicator.Value == "" {
+		result.Error = "Indicator can't be empty"
+		return result
+	}
+
+	// The same validation as for the other queries
+	query, err := prepareQuery(indicatorSQL(request.Source, filter, request.StartTime, request.EndTime))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	response := querySources(ctx, query.Source, query.SQL, nil, request.ShowLimited, request.Debug, username, nil)
+
+	result.Relations = response.Relations
+	result.Stats = response.Stats
+	result.Sources = response.Sources
+	result.Error = response.Error
+
+	if request.Debug {
+		result.Debug = response.Debug
+	}
+
+	if len(result.Stats) == 0 {
+		result.Stats = nil
+	}
+
+	result.Hit = len(result.Relations) != 0 || result.Stats != nil
+
+	return result
+}
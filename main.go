@@ -71,6 +71,11 @@ func main() {
 		log.Fatal().Msg("Can't setup a database: " + err.Error())
 	}
 
+	/*
+	 * Finish background jobs interrupted by the previous run
+	 */
+	setupJobs()
+
 	/*
 	 * Setup Web GUI handlers
 	 */
@@ -95,6 +100,14 @@ func main() {
 		log.Fatal().Msg("Can't load collectors: " + err.Error())
 	}
 
+	/*
+	 * Setup named groups of the data sources
+	 */
+	err = setupSourceGroups()
+	if err != nil {
+		log.Fatal().Msg("Can't load sources groups: " + err.Error())
+	}
+
 	/*
 	 * Setup processors of the data sources received data
 	 */
@@ -107,6 +120,9 @@ func main() {
 	 * Stop collectors on service exit
 	 */
 	defer func() {
+		sourcesMx.RLock()
+		defer sourcesMx.RUnlock()
+
 		for name, collector := range collectors {
 			err := collector.Stop()
 
@@ -132,6 +148,8 @@ func main() {
 	 * Start an HTTPS server
 	 */
 	http.HandleFunc("/api", apiHandler)
+	setupAPIv2()
+	setupMaltego()
 
 	log.Info().Msgf("Graphoscope v%s. Starting the service listening on %s:%s", version, config.Server.Host, config.Server.Port)
 	server := setupTLSserver()
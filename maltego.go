@@ -0,0 +1,492 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+const (
+	// Path prefix of the Maltego TRX transforms
+	maltegoPrefix = "/maltego"
+
+	// Default time range of the transforms, relative to the current time
+	maltegoPeriod = "30d"
+
+	// Entity type of the node groups without a known Maltego type
+	maltegoDefaultEntity = "maltego.Phrase"
+
+	// Maltego entity's property to label the link to the parent entity
+	maltegoLinkLabel = "link#maltego.link.label"
+)
+
+var (
+	// Built-in mapping of the Graphoscope node groups to the Maltego entity types,
+	// can be extended by the configuration file
+	maltegoEntities = map[string]string{
+		"ip":       "maltego.IPv4Address",
+		"ipv6":     "maltego.IPv6Address",
+		"domain":   "maltego.Domain",
+		"fqdn":     "maltego.DNSName",
+		"hostname": "maltego.DNSName",
+		"email":    "maltego.EmailAddress",
+		"url":      "maltego.URL",
+		"hash":     "maltego.Hash",
+		"md5":      "maltego.Hash",
+		"sha1":     "maltego.Hash",
+		"sha256":   "maltego.Hash",
+		"asn":      "maltego.AS",
+		"network":  "maltego.Netblock",
+		"cidr":     "maltego.Netblock",
+		"phone":    "maltego.PhoneNumber",
+		"person":   "maltego.Person",
+		"company":  "maltego.Company",
+		"location": "maltego.Location",
+		"port":     "maltego.Port",
+	}
+
+	// Characters not allowed in the transform's name
+	reMaltegoName = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+	// Time range to search in, like "7d" or "12h"
+	reMaltegoPeriod = regexp.MustCompile(`^\d+[smhdwMy]$`)
+)
+
+/*
+ * Transform exposed to Maltego,
+ * searches by a single field of the data source
+ */
+type MaltegoTransform struct {
+	// Unique name to use in a Maltego transform server
+	Name string `json:"name"`
+
+	// Name to display in Maltego
+	DisplayName string `json:"displayName"`
+
+	// Data source and its field to search in
+	Source string `json:"source"`
+	Field  string `json:"field"`
+
+	// Maltego entity type the transform runs on
+	Input string `json:"input"`
+
+	// Path of the TRX endpoint
+	URL string `json:"url"`
+}
+
+/*
+ * Root element of all the Maltego TRX messages
+ */
+type maltegoMessage struct {
+	XMLName   xml.Name          `xml:"MaltegoMessage"`
+	Request   *maltegoRequest   `xml:"MaltegoTransformRequestMessage,omitempty"`
+	Response  *maltegoResponse  `xml:"MaltegoTransformResponseMessage,omitempty"`
+	Exception *maltegoException `xml:"MaltegoTransformExceptionMessage,omitempty"`
+}
+
+/*
+ * Transform request sent by Maltego
+ */
+type maltegoRequest struct {
+	Entities []*maltegoEntity `xml:"Entities>Entity"`
+
+	// Transform settings given by the user
+	Fields []*maltegoField `xml:"TransformFields>Field"`
+
+	Limits struct {
+		SoftLimit int `xml:"SoftLimit,attr"`
+		HardLimit int `xml:"HardLimit,attr"`
+	} `xml:"Limits"`
+}
+
+/*
+ * Transform results sent back to Maltego
+ */
+type maltegoResponse struct {
+	Entities []*maltegoEntity    `xml:"Entities>Entity"`
+	Messages []*maltegoUIMessage `xml:"UIMessages>UIMessage"`
+}
+
+/*
+ * Failed transform's response
+ */
+type maltegoException struct {
+	Exceptions []string `xml:"Exceptions>Exception"`
+}
+
+type maltegoEntity struct {
+	Type   string          `xml:"Type,attr"`
+	Value  string          `xml:"Value"`
+	Weight int             `xml:"Weight"`
+	Fields []*maltegoField `xml:"AdditionalFields>Field"`
+}
+
+type maltegoField struct {
+	Name         string `xml:"Name,attr"`
+	DisplayName  string `xml:"DisplayName,attr,omitempty"`
+	MatchingRule string `xml:"MatchingRule,attr,omitempty"`
+	Value        string `xml:",chardata"`
+}
+
+/*
+ * Message to display in Maltego's output window.
+ * Type is one of: Inform, PartialError, FatalError, Debug
+ */
+type maltegoUIMessage struct {
+	Type string `xml:"MessageType,attr"`
+	Text string `xml:",chardata"`
+}
+
+/*
+ * Register Maltego TRX transforms handler
+ */
+func setupMaltego() {
+	// Custom entity types of the node groups
+	for group, entity := range config.Maltego.Entities {
+		maltegoEntities[group] = entity
+	}
+
+	http.HandleFunc("POST "+maltegoPrefix+"/{source}/{field}", maltegoHandler)
+}
+
+/*
+ * Get Maltego entity type of the node's group
+ */
+func maltegoType(group string) string {
+	if entity, ok := maltegoEntities[group]; ok {
+		return entity
+	}
+
+	return maltegoDefaultEntity
+}
+
+/*
+ * List all the transforms of the data sources,
+ * one per every relation's search field
+ */
+func maltegoTransforms() []*MaltegoTransform {
+	list := []*MaltegoTransform{}
+
+	sourcesMx.RLock()
+	defer sourcesMx.RUnlock()
+
+	for name, collector := range collectors {
+		conf := collector.Conf()
+
+		label := conf.Label
+		if label == "" {
+			label = name
+		}
+
+		for field, group := range searchFields(conf.Relations) {
+			list = append(list, &MaltegoTransform{
+				Name:        "graphoscope." + reMaltegoName.ReplaceAllString(name+"_"+field, "_"),
+				DisplayName: label + ": search by " + field,
+				Source:      name,
+				Field:       field,
+				Input:       maltegoType(group),
+				URL:         maltegoPrefix + "/" + name + "/" + field,
+			})
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+/*
+ * Collect relations search fields with the node groups they belong to,
+ * is a map of field -> group
+ */
+func searchFields(relations []*pdk.Relation) map[string]string {
+	list := make(map[string]string)
+
+	add := func(field, group string) {
+		if _, ok := list[field]; !ok && field != "" {
+			list[field] = group
+		}
+	}
+
+	for _, relation := range relations {
+		for _, node := range []*pdk.Node{relation.From, relation.To} {
+			if node == nil {
+				continue
+			}
+
+			add(node.Search, node.Group)
+
+			for _, varType := range node.VarTypes {
+				add(varType.Search, varType.Group)
+			}
+		}
+	}
+
+	return list
+}
+
+/*
+ * Handle Maltego TRX transform request.
+ * Input entity's value is searched in the data source's field,
+ * related nodes are returned as the new entities
+ */
+func maltegoHandler(w http.ResponseWriter, r *http.Request) {
+	// Get requestor IP
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		log.Error().Msg("User IP: " + r.RemoteAddr + " is not IP:port")
+	}
+
+	source := r.PathValue("source")
+	field := r.PathValue("field")
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, apiMaxBody))
+	if err != nil {
+		maltegoError(w, "Can't read request: "+err.Error())
+		return
+	}
+
+	message := &maltegoMessage{}
+	if err := xml.Unmarshal(body, message); err != nil || message.Request == nil {
+		maltegoError(w, "Invalid transform request")
+		return
+	}
+
+	request := message.Request
+	settings := make(map[string]string)
+
+	for _, f := range request.Fields {
+		settings[f.Name] = f.Value
+	}
+
+	// Maltego can't set custom headers,
+	// so the API token may come as a transform setting
+	if r.Header.Get("Authorization") == "" && settings["token"] != "" {
+		r.Header.Set("Authorization", "Bearer "+settings["token"])
+	}
+
+	account, token, err := apiAccount(r)
+	if err != nil {
+		maltegoError(w, "Can't authenticate user by the given token or UUID")
+
+		log.Error().
+			Str("ip", ip).
+			Msg("Can't authenticate Maltego user by the given token or UUID: " + err.Error())
+		return
+	}
+
+	sourcesMx.RLock()
+	collector, ok := collectors[source]
+	sourcesMx.RUnlock()
+
+	if !ok {
+		maltegoError(w, "Unknown data source: "+source)
+		return
+	}
+
+	if _, ok := searchFields(collector.Conf().Relations)[field]; !ok {
+		maltegoError(w, "Data source '"+source+"' has no transform by '"+field+"'")
+		return
+	}
+
+	if err := token.allows(source); err != nil {
+		maltegoError(w, err.Error())
+		return
+	}
+
+	if len(request.Entities) == 0 || strings.TrimSpace(request.Entities[0].Value) == "" {
+		maltegoError(w, "Input entity can't be empty")
+		return
+	}
+
+	value := strings.TrimSpace(request.Entities[0].Value)
+
+	period := settings["period"]
+	if period == "" {
+		period = config.Maltego.Period
+	}
+	if period == "" {
+		period = maltegoPeriod
+	}
+
+	// Period becomes a part of the SQL query
+	if !reMaltegoPeriod.MatchString(period) {
+		maltegoError(w, "Invalid period: "+period+", a number with one of the units s, m, h, d, w, M, y expected")
+		return
+	}
+
+	filter, err := indicatorFilter(field, value)
+	if err != nil {
+		maltegoError(w, err.Error())
+		return
+	}
+
+	// The same validation as for the other queries
+	query, err := prepareQuery(indicatorSQL(source, filter, "now-"+period, "now"))
+	if err != nil {
+		maltegoError(w, err.Error())
+		return
+	}
+
+	log.Info().
+		Str("ip", ip).
+		Str("username", account.Username).
+		Str("source", source).
+		Str("field", field).
+		Str("value", value).
+		Msg("Maltego transform")
+
+	result := querySources(r.Context(), query.Source, query.SQL, nil, false, false, account.Username, nil)
+
+	response := &maltegoResponse{
+		Entities: maltegoNodes(result.Relations, value),
+		Messages: []*maltegoUIMessage{},
+	}
+
+	if result.Error != "" {
+		response.Messages = append(response.Messages, &maltegoUIMessage{Type: "PartialError", Text: result.Error})
+	}
+
+	if len(result.Stats) != 0 {
+		response.Messages = append(response.Messages, &maltegoUIMessage{
+			Type: "Inform",
+			Text: "Too many results, use Graphoscope Web GUI to see the statistics",
+		})
+	}
+
+	// Do not overwhelm the graph
+	if limit := request.Limits.SoftLimit; limit > 0 && len(response.Entities) > limit {
+		response.Messages = append(response.Messages, &maltegoUIMessage{
+			Type: "Inform",
+			Text: "Results are truncated by the transform's limit",
+		})
+
+		response.Entities = response.Entities[:limit]
+	}
+
+	maltegoSend(w, &maltegoMessage{Response: response})
+}
+
+/*
+ * Convert relations into the Maltego entities.
+ * Nodes of the searched value are skipped, as they are the input entity itself
+ */
+func maltegoNodes(relations []map[string]interface{}, value string) []*maltegoEntity {
+	entities := []*maltegoEntity{}
+	known := make(map[string]*maltegoEntity)
+	labels := make(map[*maltegoEntity][]string)
+	unique := make(map[string]bool)
+
+	add := func(node map[string]interface{}, edge interface{}) {
+		id := exportValue(node["id"])
+		if id == "" || id == value {
+			return
+		}
+
+		group := exportValue(node["group"])
+		key := group + ":" + id
+
+		entity, ok := known[key]
+		if !ok {
+			entity = &maltegoEntity{
+				Type:   maltegoType(group),
+				Value:  id,
+				Weight: 100,
+				Fields: []*maltegoField{
+					{Name: "graphoscope.group", DisplayName: "Group", MatchingRule: "loose", Value: group},
+				},
+			}
+
+			if search := exportValue(node["search"]); search != "" {
+				entity.Fields = append(entity.Fields, &maltegoField{Name: "graphoscope.search", DisplayName: "Search field", MatchingRule: "loose", Value: search})
+			}
+
+			if sources, ok := node["sources"]; ok {
+				entity.Fields = append(entity.Fields, &maltegoField{Name: "graphoscope.sources", DisplayName: "Sources", MatchingRule: "loose", Value: exportValue(sources)})
+			}
+
+			if attributes, ok := node["attributes"].(map[string]interface{}); ok {
+				names := make([]string, 0, len(attributes))
+				for name := range attributes {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+
+				for _, name := range names {
+					entity.Fields = append(entity.Fields, &maltegoField{Name: name, DisplayName: name, MatchingRule: "loose", Value: exportValue(attributes[name])})
+				}
+			}
+
+			known[key] = entity
+			entities = append(entities, entity)
+		}
+
+		// Entity can be linked by several edges
+		if e, ok := edge.(map[string]interface{}); ok {
+			label := exportValue(e["label"])
+			if label != "" && !unique[key+"\n"+label] {
+				unique[key+"\n"+label] = true
+				labels[entity] = append(labels[entity], label)
+			}
+		}
+	}
+
+	for _, relation := range relations {
+		from, okFrom := relation["from"].(map[string]interface{})
+		to, okTo := relation["to"].(map[string]interface{})
+
+		// Unknown format
+		if !okFrom || !okTo {
+			continue
+		}
+
+		add(from, relation["edge"])
+		add(to, relation["edge"])
+	}
+
+	for _, entity := range entities {
+		list, ok := labels[entity]
+		if !ok {
+			continue
+		}
+
+		entity.Fields = append(entity.Fields, &maltegoField{Name: maltegoLinkLabel, DisplayName: "Label", MatchingRule: "loose", Value: strings.Join(list, ", ")})
+	}
+
+	return entities
+}
+
+/*
+ * Send Maltego TRX message
+ */
+func maltegoSend(w http.ResponseWriter, message *maltegoMessage) {
+	b, err := xml.Marshal(message)
+	if err != nil {
+		log.Error().Msg("Can't marshal Maltego response: " + err.Error())
+		http.Error(w, "Can't marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	if _, err := w.Write(append([]byte(xml.Header), b...)); err != nil {
+		log.Error().Msg("Can't send Maltego response: " + err.Error())
+	}
+}
+
+/*
+ * Send Maltego TRX exception to display in its output window.
+ * Maltego shows the message only with a successful HTTP status
+ */
+func maltegoError(w http.ResponseWriter, msg string) {
+	maltegoSend(w, &maltegoMessage{
+		Exception: &maltegoException{Exceptions: []string{msg}},
+	})
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+ * Merge relations received from the different data sources
+ * or from the independent queries into a unique set:
+ *   - nodes with the same group and ID become one node,
+ *     different values of the same attribute are combined into a list
+ *   - duplicate edges, between the same nodes and with the same label,
+ *     are removed and their attributes are merged the same way
+ *   - "sources" of the nodes and relations list all the data sources
+ *     they came from, while "source" of the relation stays the first one.
+ *     Already merged elements, like from cache, keep their "sources"
+ *
+ * Relations order is kept
+ */
+func mergeRelations(relations []map[string]interface{}) []map[string]interface{} {
+	merger := newRelationMerger()
+	merger.add(relations)
+
+	return merger.merged
+}
+
+/*
+ * Incremental merge of the relations, which arrive in parts,
+ * like from the concurrently queried data sources.
+ * Lock it when used from several goroutines
+ */
+type relationMerger struct {
+	sync.Mutex
+
+	// Known nodes and edges by their unique keys
+	nodes map[string]map[string]interface{}
+	edges map[string]map[string]interface{}
+
+	// All the merged relations in the order of arrival
+	merged []map[string]interface{}
+}
+
+/*
+ * Create a new empty merger
+ */
+func newRelationMerger() *relationMerger {
+	return &relationMerger{
+		nodes:  make(map[string]map[string]interface{}),
+		edges:  make(map[string]map[string]interface{}),
+		merged: []map[string]interface{}{},
+	}
+}
+
+/*
+ * Merge the next part of the relations into the already known ones.
+ * Returns the merged form of the given relations, each one only once,
+ * so they can be delivered to the client immediately
+ */
+func (m *relationMerger) add(relations []map[string]interface{}) []map[string]interface{} {
+	part := make([]map[string]interface{}, 0, len(relations))
+	seen := make(map[string]bool)
+
+	for _, relation := range relations {
+		from, okFrom := relation["from"].(map[string]interface{})
+		to, okTo := relation["to"].(map[string]interface{})
+
+		// Unknown format, keep as it is
+		if !okFrom || !okTo {
+			m.merged = append(m.merged, relation)
+			part = append(part, relation)
+			continue
+		}
+
+		source, _ := relation["source"].(string)
+		fromKey := nodeKey(from)
+		toKey := nodeKey(to)
+
+		from = mergeNode(m.nodes, fromKey, from, source)
+		to = mergeNode(m.nodes, toKey, to, source)
+
+		edge, _ := relation["edge"].(map[string]interface{})
+		label, _ := edge["label"].(string)
+		key := fromKey + "\x00" + toKey + "\x00" + label
+
+		// Duplicate edge
+		if existing, ok := m.edges[key]; ok {
+			if edge != nil {
+				if e, ok := existing["edge"].(map[string]interface{}); ok {
+					mergeAttributes(e, edge)
+				} else {
+					existing["edge"] = cloneElement(edge)
+				}
+			}
+
+			existing["sources"] = appendSources(existing["sources"].([]string), elementSources(relation, source))
+
+			if !seen[key] {
+				seen[key] = true
+				part = append(part, existing)
+			}
+			continue
+		}
+
+		result := make(map[string]interface{}, len(relation)+1)
+		for k, v := range relation {
+			result[k] = v
+		}
+
+		result["from"] = from
+		result["to"] = to
+		result["sources"] = elementSources(relation, source)
+
+		if edge != nil {
+			result["edge"] = cloneElement(edge)
+		}
+
+		m.edges[key] = result
+		m.merged = append(m.merged, result)
+
+		seen[key] = true
+		part = append(part, result)
+	}
+
+	return part
+}
+
+/*
+ * Unique key of the node
+ */
+func nodeKey(node map[string]interface{}) string {
+	return fmt.Sprintf("%v\x00%v", node["group"], node["id"])
+}
+
+/*
+ * Merge the node into an already known one with the same key,
+ * or remember it as a new one.
+ * Returns the node to use in the relation
+ */
+func mergeNode(nodes map[string]map[string]interface{}, key string, node map[string]interface{}, source string) map[string]interface{} {
+	if existing, ok := nodes[key]; ok {
+		mergeAttributes(existing, node)
+		existing["sources"] = appendSources(existing["sources"].([]string), elementSources(node, source))
+
+		return existing
+	}
+
+	// Copy to avoid modifying data source's results
+	clone := cloneElement(node)
+	clone["sources"] = elementSources(node, source)
+	nodes[key] = clone
+
+	return clone
+}
+
+/*
+ * Copy the node or edge along with its attributes,
+ * so merging doesn't modify data source's results
+ */
+func cloneElement(element map[string]interface{}) map[string]interface{} {
+	clone := make(map[string]interface{}, len(element)+1)
+	for k, v := range element {
+		clone[k] = v
+	}
+
+	if attributes, ok := element["attributes"].(map[string]interface{}); ok {
+		copied := make(map[string]interface{}, len(attributes))
+		for k, v := range attributes {
+			copied[k] = v
+		}
+		clone["attributes"] = copied
+	}
+
+	return clone
+}
+
+/*
+ * Merge "attributes" of the node or edge into the target one
+ */
+func mergeAttributes(target, element map[string]interface{}) {
+	attributes, ok := element["attributes"].(map[string]interface{})
+	if !ok || len(attributes) == 0 {
+		return
+	}
+
+	existing, ok := target["attributes"].(map[string]interface{})
+	if !ok {
+		existing = make(map[string]interface{}, len(attributes))
+		target["attributes"] = existing
+	}
+
+	for k, v := range attributes {
+		if old, ok := existing[k]; ok {
+			existing[k] = mergeValues(old, v)
+		} else {
+			existing[k] = v
+		}
+	}
+}
+
+/*
+ * Combine two attribute values.
+ * Returns a single value when they are identical,
+ * otherwise a list of unique values
+ */
+func mergeValues(a, b interface{}) interface{} {
+	values := []interface{}{}
+	seen := make(map[string]bool)
+
+	for _, value := range []interface{}{a, b} {
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+
+		for _, v := range list {
+			if v == nil {
+				continue
+			}
+
+			key := fmt.Sprintf("%v", v)
+			if !seen[key] {
+				seen[key] = true
+				values = append(values, v)
+			}
+		}
+	}
+
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	return values
+}
+
+/*
+ * Add the data source's name to the list if it's not there yet
+ */
+func appendSource(sources []string, source string) []string {
+	if source == "" {
+		return sources
+	}
+
+	for _, s := range sources {
+		if s == source {
+			return sources
+		}
+	}
+
+	return append(sources, source)
+}
+
+/*
+ * Add the data sources' names to the list if they are not there yet
+ */
+func appendSources(sources, names []string) []string {
+	for _, name := range names {
+		sources = appendSource(sources, name)
+	}
+
+	return sources
+}
+
+/*
+ * Get data sources of the node or relation:
+ * the given one and the already merged ones if any
+ */
+func elementSources(element map[string]interface{}, source string) []string {
+	sources := []string{}
+
+	switch list := element["sources"].(type) {
+	case []string:
+		sources = appendSources(sources, list)
+
+	// Decoded from the database
+	case primitive.A:
+		for _, name := range list {
+			if name, ok := name.(string); ok {
+				sources = appendSource(sources, name)
+			}
+		}
+	}
+
+	return appendSource(sources, source)
+}
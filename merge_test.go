@@ -0,0 +1,215 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+ * Create a relation between the IP and the domain
+ */
+func mergeRelation(source string, ip interface{}, label string, attributes map[string]interface{}) map[string]interface{} {
+	relation := map[string]interface{}{
+		"from": map[string]interface{}{
+			"id":         ip,
+			"group":      "ip",
+			"search":     "ip",
+			"attributes": attributes,
+		},
+		"to": map[string]interface{}{
+			"id":     "example.com",
+			"group":  "domain",
+			"search": "domain",
+		},
+		"source": source,
+	}
+
+	if label != "" {
+		relation["edge"] = map[string]interface{}{
+			"label":      label,
+			"attributes": map[string]interface{}{"seen": source},
+		}
+	}
+
+	return relation
+}
+
+/*
+ * Test duplicate nodes and edges merging
+ */
+func TestMergeRelations(t *testing.T) {
+	relations := []map[string]interface{}{
+		mergeRelation("dns", "10.10.10.10", "resolves", map[string]interface{}{"asn": 64500}),
+		mergeRelation("pdns", "10.10.10.10", "resolves", map[string]interface{}{"asn": 64501}),
+		mergeRelation("pdns", "10.10.10.10", "", nil),
+		mergeRelation("geoip", "10.10.10.11", "resolves", nil),
+		{"source": "broken"},
+	}
+
+	merged := mergeRelations(relations)
+	if len(merged) != 4 {
+		t.Fatalf("Invalid amount of merged relations: %d, expected: 4", len(merged))
+	}
+
+	first := merged[0]
+	if first["source"] != "dns" {
+		t.Errorf("Invalid relation source: %v, expected: dns", first["source"])
+	}
+
+	if !reflect.DeepEqual(first["sources"], []string{"dns", "pdns"}) {
+		t.Errorf("Invalid relation sources: %v", first["sources"])
+	}
+
+	from := first["from"].(map[string]interface{})
+	if !reflect.DeepEqual(from["sources"], []string{"dns", "pdns"}) {
+		t.Errorf("Invalid node sources: %v", from["sources"])
+	}
+
+	asn := from["attributes"].(map[string]interface{})["asn"]
+	if !reflect.DeepEqual(asn, []interface{}{64500, 64501}) {
+		t.Errorf("Invalid node attributes merge: %v", asn)
+	}
+
+	seen := first["edge"].(map[string]interface{})["attributes"].(map[string]interface{})["seen"]
+	if !reflect.DeepEqual(seen, []interface{}{"dns", "pdns"}) {
+		t.Errorf("Invalid edge attributes merge: %v", seen)
+	}
+
+	// Relation without a label is a different edge of the same nodes
+	if merged[1]["from"].(map[string]interface{})["id"] != "10.10.10.10" || merged[1]["edge"] != nil {
+		t.Errorf("Edge without a label is merged: %v", merged[1])
+	}
+
+	// The same domain node is shared by all the relations
+	to := merged[2]["to"].(map[string]interface{})
+	if !reflect.DeepEqual(to["sources"], []string{"dns", "pdns", "geoip"}) {
+		t.Errorf("Invalid shared node sources: %v", to["sources"])
+	}
+
+	if merged[3]["source"] != "broken" {
+		t.Errorf("Relation of the unknown format is not kept: %v", merged[3])
+	}
+
+	// Data source's results stay untouched
+	if !reflect.DeepEqual(relations[0], mergeRelation("dns", "10.10.10.10", "resolves", map[string]interface{}{"asn": 64500})) {
+		t.Errorf("Data source's relation is modified: %v", relations[0])
+	}
+}
+
+/*
+ * Test already merged relations, like from cache, keep their sources
+ */
+func TestMergeRelationsCached(t *testing.T) {
+	cached := mergeRelation("dns", "10.10.10.10", "resolves", nil)
+	cached["sources"] = primitive.A{"dns", "pdns"}
+	cached["from"].(map[string]interface{})["sources"] = []string{"dns", "pdns"}
+
+	merged := mergeRelations([]map[string]interface{}{
+		cached,
+		mergeRelation("geoip", "10.10.10.10", "resolves", nil),
+	})
+
+	if len(merged) != 1 {
+		t.Fatalf("Invalid amount of merged relations: %d, expected: 1", len(merged))
+	}
+
+	if !reflect.DeepEqual(merged[0]["sources"], []string{"dns", "pdns", "geoip"}) {
+		t.Errorf("Invalid relation sources: %v", merged[0]["sources"])
+	}
+
+	from := merged[0]["from"].(map[string]interface{})
+	if !reflect.DeepEqual(from["sources"], []string{"dns", "pdns", "geoip"}) {
+		t.Errorf("Invalid node sources: %v", from["sources"])
+	}
+}
+
+/*
+ * Test relations merging in parts
+ */
+func TestRelationMerger(t *testing.T) {
+	merger := newRelationMerger()
+
+	part := merger.add([]map[string]interface{}{
+		mergeRelation("dns", "10.10.10.10", "resolves", nil),
+	})
+
+	if len(part) != 1 || !reflect.DeepEqual(part[0]["sources"], []string{"dns"}) {
+		t.Errorf("Invalid first part: %v", part)
+	}
+
+	part = merger.add([]map[string]interface{}{
+		mergeRelation("pdns", "10.10.10.10", "resolves", nil),
+		mergeRelation("pdns", "10.10.10.10", "resolves", nil),
+		mergeRelation("pdns", "10.10.10.11", "resolves", nil),
+	})
+
+	if len(part) != 2 {
+		t.Fatalf("Invalid amount of relations in the second part: %d, expected: 2", len(part))
+	}
+
+	// Already delivered relation is returned again with all its sources
+	if !reflect.DeepEqual(part[0]["sources"], []string{"dns", "pdns"}) {
+		t.Errorf("Invalid merged relation sources: %v", part[0]["sources"])
+	}
+
+	if len(merger.merged) != 2 {
+		t.Errorf("Invalid amount of merged relations: %d, expected: 2", len(merger.merged))
+	}
+}
+
+/*
+ * Test node's unique key
+ */
+func TestNodeKey(t *testing.T) {
+	tests := []struct {
+		a, b  map[string]interface{}
+		equal bool
+	}{
+		{
+			map[string]interface{}{"id": "10.10.10.10", "group": "ip"},
+			map[string]interface{}{"id": "10.10.10.10", "group": "ip", "search": "ip"},
+			true,
+		},
+		{
+			map[string]interface{}{"id": 80, "group": "port"},
+			map[string]interface{}{"id": "80", "group": "port"},
+			true,
+		},
+		{
+			map[string]interface{}{"id": "example.com", "group": "domain"},
+			map[string]interface{}{"id": "example.com", "group": "host"},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		if equal := nodeKey(test.a) == nodeKey(test.b); equal != test.equal {
+			t.Errorf("Invalid keys equality of %v and %v: %t, expected: %t", test.a, test.b, equal, test.equal)
+		}
+	}
+}
+
+/*
+ * Test attribute values combining
+ */
+func TestMergeValues(t *testing.T) {
+	tests := []struct {
+		a, b     interface{}
+		expected interface{}
+	}{
+		{"a", "a", "a"},
+		{"a", "b", []interface{}{"a", "b"}},
+		{80, "80", 80},
+		{[]interface{}{"a", "b"}, "b", []interface{}{"a", "b"}},
+		{[]interface{}{"a"}, []interface{}{"b", "c"}, []interface{}{"a", "b", "c"}},
+		{nil, "a", "a"},
+	}
+
+	for _, test := range tests {
+		if value := mergeValues(test.a, test.b); !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("Invalid merge of %v and %v: %v, expected: %v", test.a, test.b, value, test.expected)
+		}
+	}
+}
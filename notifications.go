@@ -65,7 +65,7 @@ func (a *Account) addNotification(typ, message string) {
  * Handle 'notifications' websocket command
  * to clean user's notifications
  */
-func (a *Account) notificationsHandler() {
+func (a *Account) notificationsHandler(reqID string) {
 
 	a.Notifications = []*Notification{}
 
@@ -76,7 +76,7 @@ func (a *Account) notificationsHandler() {
 			Str("username", a.Username).
 			Msg("Can't clean notifications: " + err.Error())
 
-		a.send("error", err.Error(), "Can't clean notifications!")
+		a.reply(reqID, "error", err.Error(), "Can't clean notifications!")
 		return
 	}
 
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Regex to find path parameters like "{name}"
+	rePathParam = regexp.MustCompile(`{([^}]+)}`)
+)
+
+/*
+ * Generate OpenAPI document of the versioned API
+ * from the routes list and Go types of their bodies
+ */
+func openAPI() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]map[string]interface{}{}
+
+	errorSchema := schemaOf(reflect.TypeOf(&APIerrorResponse{}), schemas)
+
+	for _, route := range apiRoutes {
+		operation := map[string]interface{}{
+			"summary":     route.Summary,
+			"operationId": strings.ToLower(route.Method) + operationName(route.Path),
+		}
+
+		if route.Public {
+			operation["security"] = []interface{}{}
+		}
+
+		parameters := []interface{}{}
+
+		for _, match := range rePathParam.FindAllStringSubmatch(route.Path, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]string{"type": "string"},
+			})
+		}
+
+		for _, name := range route.Query {
+			parameters = append(parameters, map[string]interface{}{
+				"name":   name,
+				"in":     "query",
+				"schema": map[string]string{"type": "string"},
+			})
+		}
+
+		if len(parameters) != 0 {
+			operation["parameters"] = parameters
+		}
+
+		if route.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": schemaOf(reflect.TypeOf(route.Request), schemas),
+					},
+				},
+			}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		success := map[string]interface{}{
+			"description": http.StatusText(status),
+		}
+
+		if route.Response != nil {
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": schemaOf(reflect.TypeOf(route.Response), schemas),
+				},
+			}
+		} else if status != http.StatusNoContent {
+			success["content"] = map[string]interface{}{
+				"text/plain": map[string]interface{}{
+					"schema": map[string]string{"type": "string"},
+				},
+			}
+		}
+
+		operation["responses"] = map[string]interface{}{
+			strconv.Itoa(status): success,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": errorSchema,
+					},
+				},
+			},
+		}
+
+		if paths[route.Path] == nil {
+			paths[route.Path] = map[string]interface{}{}
+		}
+		paths[route.Path][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "Graphoscope API",
+			"version": version,
+		},
+		"servers": []interface{}{
+			map[string]string{"url": apiV2Prefix},
+		},
+		"security": []interface{}{
+			map[string][]string{"bearer": {}},
+			map[string][]string{"uuid": {}},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]string{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+				"uuid": map[string]string{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "X-UUID",
+				},
+			},
+		},
+	}
+}
+
+/*
+ * Convert route's path into the operation name:
+ * "/dashboards/{name}" -> "DashboardsName"
+ */
+func operationName(path string) string {
+	name := ""
+
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '.'
+	}) {
+		name += strings.ToUpper(part[:1]) + part[1:]
+	}
+
+	return name
+}
+
+/*
+ * Describe Go type as a JSON schema.
+ * Named structures are stored in "schemas" and referenced
+ */
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; ok {
+			return ref
+		}
+
+		// Reserve the name first for the recursive types
+		schemas[t.Name()] = nil
+		properties := map[string]interface{}{}
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() || field.Anonymous {
+				continue
+			}
+
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			} else if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = schemaOf(field.Type, schemas)
+		}
+
+		schemas[t.Name()] = map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}
+
+		return ref
+
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaOf(t.Elem(), schemas),
+		}
+
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem(), schemas),
+		}
+
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+
+	// Any value
+	return map[string]interface{}{}
+}
@@ -4,24 +4,165 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/cert-lv/graphoscope/pdk"
 )
 
 var (
 	// Regex to remove "datetime" field from the query.
 	// Useful when data source doesn't contain such field
-	reDT = regexp.MustCompile(`(?i) +and +datetime +between +('|")\d\d\d\d-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?Z('|") +and +('|")\d\d\d\d-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?Z('|")`)
+	reDT = regexp.MustCompile(`(?i) +and +datetime +(between +('|")\d\d\d\d-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?Z('|") +and +|(>=|<=|>|<) *)('|")\d\d\d\d-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?Z('|")`)
+
+	// Regex to find relative time expressions of the "datetime" field, like:
+	//     datetime > now() - INTERVAL 7 DAY
+	//     datetime BETWEEN 'now-24h' AND 'now'
+	reTimeExpr = regexp.MustCompile(`(?i:\bdatetime\s*(?:not\s+)?(?:between|>=|<=|!=|<>|>|<|=)\s*)(` + timeExpr + `|'[^']*'|"[^"]*")(?:(?i:\s+and\s+)(` + timeExpr + `))?`)
+
+	// Regex to remove the time range and limit the Web GUI appends
+	// to the user's query, with absolute or relative time values,
+	// to get the initial query back
+	reTimeRange = regexp.MustCompile(`(?i) +and +datetime *(?:between +(?:` + timeValue + `) +and +|(?:>=|<=|>|<) *)(?:` + timeValue + `)( +limit +(\d+ *, *)?\d+)?$`)
+
+	// Regexes to resolve a single relative time expression
+	reNowFunc    = regexp.MustCompile(`(?i)now\(\)((?:\s*[+-]\s*interval\s+\d+\s+[a-z]+)*)`)
+	reNowLiteral = regexp.MustCompile(`('|")now((?:[+-]\d+[smhdwMy])*)('|")`)
+	reInterval   = regexp.MustCompile(`(?i)([+-])\s*interval\s+(\d+)\s+([a-z]+)`)
+	reOffset     = regexp.MustCompile(`([+-])(\d+)([smhdwMy])`)
+
+	// Regex to find a data source name not quoted by the backticks:
+	// FROM "name", FROM 'name' or FROM name, which may contain hyphens
+	reSourceName = regexp.MustCompile(`(?i)^(from\s+)(?:"([^"` + "`" + `]+)"|'([^'` + "`" + `]+)'|([^\s"'` + "`" + `]+))`)
 )
 
+const (
+	// Kinds of the query validation errors
+	queryErrEmpty   = "empty"
+	queryErrSyntax  = "syntax"
+	queryErrSource  = "source"
+	queryErrUnknown = "unknown"
+
+	// Relative time expression: "now()" with optional SQL intervals,
+	// or a quoted "now" with optional offsets like 'now-24h'
+	timeExpr = `(?i:now\(\)(?:\s*[+-]\s*interval\s+\d+\s+[a-z]+)*)|['"]now(?:[+-]\d+[smhdwMy])*['"]`
+
+	// Absolute or relative time value of the "datetime" field
+	timeValue = `'\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?Z'|"\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?Z"|` + timeExpr
+
+	// Format of the resolved time expressions,
+	// the same as the one used by the web GUI
+	timeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+/*
+ * Error of the user's query validation
+ */
+type QueryError struct {
+	// Kind of the error: "empty", "syntax", "source" or "unknown"
+	Kind string
+
+	// Human readable error message
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return e.Message
+}
+
+/*
+ * User's query validated and prepared for the searching
+ */
+type Request struct {
+	// Requested data source, group of data sources or "global"
+	Source string
+
+	// Query without the EXPLAIN keyword
+	SQL string
+
+	// Whether only an explanation of the query is requested
+	Explain bool
+}
+
+/*
+ * A single entry point for the user's queries from the API,
+ * websocket and uploads: detect whether an explanation is requested,
+ * parse the statement and validate the requested data source.
+ *
+ * Data source name may be quoted by the backticks, single or double quotes,
+ * if it contains spaces or other special characters.
+ * Names with hyphens, like "passive-dns", can be given without the quotes
+ */
+func prepareQuery(sql string) (*Request, error) {
+	sql, explain := explainRequested(strings.TrimSpace(sql))
+	sql = strings.TrimSpace(sql)
+
+	if sql == "" {
+		return nil, &QueryError{Kind: queryErrEmpty, Message: "Query can't be empty"}
+	}
+
+	// SQL parser expects backticks around the identifiers
+	// with the special characters
+	sql = reSourceName.ReplaceAllString(sql, "${1}`${2}${3}${4}`")
+
+	ast, err := sqlparser.Parse("SELECT * " + sql)
+	if err != nil {
+		return nil, &QueryError{Kind: queryErrSyntax, Message: "Can't parse SQL query: " + err.Error()}
+	}
+
+	query, ok := ast.(*sqlparser.Select)
+	if !ok {
+		return nil, &QueryError{Kind: queryErrSyntax, Message: "Only SELECT statement is allowed"}
+	}
+
+	if len(query.From) != 1 {
+		return nil, &QueryError{Kind: queryErrSource, Message: "Multiple FROM currently not supported"}
+	}
+
+	// Parser uses "dual" table when FROM is missing
+	table, ok := query.From[0].(*sqlparser.AliasedTableExpr)
+	if !ok {
+		return nil, &QueryError{Kind: queryErrSource, Message: "Requested data source missing"}
+	}
+
+	name, ok := table.Expr.(sqlparser.TableName)
+	if !ok || name.Name.String() == "dual" {
+		return nil, &QueryError{Kind: queryErrSource, Message: "Requested data source missing"}
+	}
+
+	source := name.Name.String()
+
+	sourcesMx.RLock()
+	_, isCollector := collectors[source]
+	_, isGroup := sourceGroups[source]
+	sourcesMx.RUnlock()
+
+	if !isCollector && !isGroup && source != "global" {
+		return nil, &QueryError{Kind: queryErrUnknown, Message: "Unknown data source requested: " + source}
+	}
+
+	if query.Where == nil {
+		return nil, &QueryError{Kind: queryErrSyntax, Message: "WHERE filters are missing"}
+	}
+
+	return &Request{
+		Source:  source,
+		SQL:     sql,
+		Explain: explain,
+	}, nil
+}
+
 /*
  * Parse SQL query for the later processing by the collectors,
  * textual SQL query into a logical object.
  *
  * Receives a query to parse, whether result should contain a "datetime" field,
- * whether data source supports SQL features
+ * whether data source supports SQL features and "cidr_match" filters,
+ * and query features declared by the plugin, nil if not declared.
+ * Returns a list of queries to send, with the optional post-filters
  */
-func parseSQL(sql string, includeDatetime bool, includeFields []string, replaceFields map[string]string, supportsSQL bool) ([]*sqlparser.Select, error) {
+func parseSQL(sql string, includeDatetime bool, includeFields []string, replaceFields map[string]string, supportsSQL, supportsCIDR bool, caps *pdk.Capabilities) ([]*Query, error) {
 
 	// Remove "datetime" field from the query if must be ignored
 	if !includeDatetime {
@@ -70,6 +211,8 @@ func parseSQL(sql string, includeDatetime bool, includeFields []string, replaceF
 	case *sqlparser.ParenExpr,
 		*sqlparser.AndExpr,
 		*sqlparser.OrExpr,
+		*sqlparser.NotExpr,
+		*sqlparser.FuncExpr,
 		*sqlparser.ComparisonExpr:
 	default:
 		return nil, fmt.Errorf("WHERE statement is not a list of filters")
@@ -125,20 +268,56 @@ func parseSQL(sql string, includeDatetime bool, includeFields []string, replaceF
 		return nil, fmt.Errorf("DISTINCT shouldn't be used, API service already returns unique nodes pairs only")
 	}
 
+	// Handle GROUP BY
+	if len(query.GroupBy) != 0 && caps != nil && !caps.GroupBy {
+		return nil, fmt.Errorf("GROUP BY is not supported by this data source")
+	}
+
+	// Validate networks of the "cidr_match" filters
+	err = validateCIDR(query.Where.Expr)
+	if err != nil {
+		return nil, err
+	}
+
 	/*
-	 * Split OR and IN queries into a list of separate queries
+	 * Split complex queries into a list of separate queries
 	 * for the data sources that don't support such queries directly
 	 */
 
-	queries := []*sqlparser.Select{}
+	queries := []*Query{}
 
 	if !supportsSQL {
-		queries, err = splitQuery(query)
+		queries, err = splitQuery(query, caps)
 		if err != nil {
 			return nil, fmt.Errorf("Can't split query: " + err.Error())
 		}
+
+	} else if !supportsCIDR || caps != nil {
+		// Move "cidr_match" filters and not supported operators into a post-filter
+		unsupported := func(expr sqlparser.Expr) bool {
+			return postFiltered(expr, supportsCIDR, caps)
+		}
+
+		where, filters, err := splitFilters(query.Where.Expr, unsupported)
+		if err != nil {
+			return nil, err
+		}
+
+		if where == nil {
+			return nil, fmt.Errorf("%s requires at least one more filter for this data source", firstFilter(query.Where.Expr, unsupported))
+		}
+
+		query.Where.Expr = where
+		q := &Query{Select: query}
+
+		if len(filters) != 0 {
+			q.Filter = conjunction(filters)
+		}
+
+		queries = append(queries, q)
+
 	} else {
-		queries = append(queries, query)
+		queries = append(queries, &Query{Select: query})
 	}
 
 	/*
@@ -146,7 +325,14 @@ func parseSQL(sql string, includeDatetime bool, includeFields []string, replaceF
 	 */
 
 	for _, node := range queries {
-		err = replaceSQL(node, replaceFields)
+		node.replace = replaceFields
+
+		// Invalid post-filter patterns are refused before the search
+		if err := node.compile(); err != nil {
+			return nil, err
+		}
+
+		err = replaceSQL(node.Select, replaceFields)
 	}
 
 	/*
@@ -157,174 +343,92 @@ func parseSQL(sql string, includeDatetime bool, includeFields []string, replaceF
 }
 
 /*
- * Split clean OR and IN queries into a list of separate queries.
+ * Replace relative time expressions of the "datetime" field
+ * with the absolute UTC timestamps, so rolling time windows
+ * can be used in saved dashboards and scheduled API requests.
  *
- * Supported query types:
- *     - ip='8.8.8.8'
- *     - ip='8.8.8.8' OR ip='1.2.3.4'
- *     - domain IN ('example.com','google.com')
+ * Supported expressions:
+ *     - now()
+ *     - now() - INTERVAL 7 DAY
+ *     - now() - INTERVAL 1 DAY + INTERVAL 2 HOUR
+ *     - 'now', 'now-24h', 'now-1d-12h', 'now+30m'
  *
- * Operators have to be "=" only, mix of different operators is not supported yet
+ * SQL intervals units: SECOND, MINUTE, HOUR, DAY, WEEK, MONTH, YEAR.
+ * Offsets units: s, m, h, d, w, M (month), y
  */
-func splitQuery(expr *sqlparser.Select) ([]*sqlparser.Select, error) {
-
-	fields := sqlparser.String(expr.SelectExprs)
-	where := expr.Where.Expr
-	lefts := []sqlparser.Expr{}
-
-	// A list of independend SELECT single-field queries to return
-	selects := []*sqlparser.Select{}
+func resolveTime(sql string) (string, error) {
+	now := time.Now().UTC().Truncate(time.Second)
 
 	var err error
 
-	//fmt.Printf("Expr: %#v\n", where)
-
-	if and, ok := where.(*sqlparser.AndExpr); ok {
-		if and.Right.(*sqlparser.RangeCond).Operator == "between" &&
-			sqlparser.String(and.Right.(*sqlparser.RangeCond).Left) == "datetime" {
+	sql = reTimeExpr.ReplaceAllStringFunc(sql, func(expr string) string {
+		expr = reNowFunc.ReplaceAllStringFunc(expr, func(match string) string {
+			t := now
 
-			// Handle:
-			//     field='...' AND datetime BETWEEN ...
-			//     field IN ('...','...') AND datetime BETWEEN ...
-			if comp, ok := and.Left.(*sqlparser.ComparisonExpr); ok {
-				lefts, err = splitOrIn(fields, comp, nil)
-				if err != nil {
-					return nil, err
+			for _, interval := range reInterval.FindAllStringSubmatch(match, -1) {
+				amount, _ := strconv.Atoi(interval[2])
+				if interval[1] == "-" {
+					amount = -amount
 				}
 
-				// Handle:
-				//     (field='...') AND datetime BETWEEN ...
-				//     (field='...' OR field='...') AND datetime BETWEEN ...
-				//     (field IN ('...','...')) AND datetime BETWEEN ...
-			} else if paren, ok := and.Left.(*sqlparser.ParenExpr); ok {
-				lefts, err = splitOrIn(fields, paren.Expr, nil)
-				if err != nil {
-					return nil, err
+				switch strings.TrimSuffix(strings.ToLower(interval[3]), "s") {
+				case "second":
+					t = t.Add(time.Duration(amount) * time.Second)
+				case "minute":
+					t = t.Add(time.Duration(amount) * time.Minute)
+				case "hour":
+					t = t.Add(time.Duration(amount) * time.Hour)
+				case "day":
+					t = t.AddDate(0, 0, amount)
+				case "week":
+					t = t.AddDate(0, 0, amount*7)
+				case "month":
+					t = t.AddDate(0, amount, 0)
+				case "year":
+					t = t.AddDate(amount, 0, 0)
+				default:
+					err = fmt.Errorf("Unknown time interval unit: %s", interval[3])
 				}
 			}
-		}
-
-		// Handle:
-		//     (field='...' OR field=...')
-		//     (field IN ('...','...'))
-		//     (field='...' AND param='...')
-	} else if paren, ok := where.(*sqlparser.ParenExpr); ok {
-		if _, ok := paren.Expr.(*sqlparser.AndExpr); ok {
-			return selects, nil
-		}
-
-		lefts, err = splitOrIn(fields, paren.Expr, nil)
-		if err != nil {
-			return nil, err
-		}
-
-		// Handle: field='...' OR field=...'
-	} else if or, ok := where.(*sqlparser.OrExpr); ok {
-		lefts, err = splitOrIn(fields, or, nil)
-		if err != nil {
-			return nil, err
-		}
-
-		// Handle:
-		//     field='...'
-		//     field IN ('...','...')
-	} else if comp, ok := where.(*sqlparser.ComparisonExpr); ok {
-		lefts, err = splitOrIn(fields, comp, nil)
-		if err != nil {
-			return nil, err
-		}
-	}
 
-	// Generate multiple independent single-field queries
-	for _, left := range lefts {
-		if and, ok := where.(*sqlparser.AndExpr); ok {
-			and.Left = left
-		} else if paren, ok := where.(*sqlparser.ParenExpr); ok {
-			paren.Expr = left
-		}
-
-		// SQL -> string -> SQL
-		// as it is hard to deep copy 'expr' to prevent rewriting it
-		ast, err := sqlparser.Parse(sqlparser.String(expr))
-		if err != nil {
-			return nil, fmt.Errorf("Can't parse splitted SQL query: %s", err.Error())
-		}
-
-		query := ast.(*sqlparser.Select)
-		selects = append(selects, query)
-	}
-
-	return selects, nil
-}
-
-/*
- * A loop to detect and split OR/IN query
- */
-func splitOrIn(fields string, expr sqlparser.Expr, list []sqlparser.Expr) ([]sqlparser.Expr, error) {
-	if list == nil {
-		list = []sqlparser.Expr{}
-	}
-
-	var err error
-
-	// Detect a clean OR query
-	// and avoid more complex queries like "... OR (... AND ...)"
-	switch left := expr.(type) {
-	case *sqlparser.OrExpr:
-		_, okOr := left.Left.(*sqlparser.OrExpr)
-		comp, okComp := left.Left.(*sqlparser.ComparisonExpr)
+			return "'" + t.Format(timeFormat) + "'"
+		})
 
-		if okOr || (okComp && comp.Operator == "=") {
-			if c, ok := left.Right.(*sqlparser.ComparisonExpr); ok {
+		return reNowLiteral.ReplaceAllStringFunc(expr, func(match string) string {
+			parts := reNowLiteral.FindStringSubmatch(match)
+			t := now
 
-				if c.Operator != "=" {
-					return list, nil
+			for _, offset := range reOffset.FindAllStringSubmatch(parts[2], -1) {
+				amount, _ := strconv.Atoi(offset[2])
+				if offset[1] == "-" {
+					amount = -amount
 				}
 
-				list = append(list, left.Right)
-
-				if c, ok := left.Left.(*sqlparser.ComparisonExpr); ok {
-					list = append(list, c)
-
-				} else if or, ok := left.Left.(*sqlparser.OrExpr); ok {
-					list, err = splitOrIn(fields, or, list)
-					if err != nil {
-						return nil, err
-					}
-
-				} else {
-					return list, nil
+				switch offset[3] {
+				case "s":
+					t = t.Add(time.Duration(amount) * time.Second)
+				case "m":
+					t = t.Add(time.Duration(amount) * time.Minute)
+				case "h":
+					t = t.Add(time.Duration(amount) * time.Hour)
+				case "d":
+					t = t.AddDate(0, 0, amount)
+				case "w":
+					t = t.AddDate(0, 0, amount*7)
+				case "M":
+					t = t.AddDate(0, amount, 0)
+				case "y":
+					t = t.AddDate(amount, 0, 0)
 				}
 			}
-		}
 
-	// Detect a clean IN query.
-	// Single 'field=value' can be represented as 1 element array
-	case *sqlparser.ComparisonExpr:
-		field := sqlparser.String(left.Left)
-
-		if left.Operator == "in" {
-			for _, value := range left.Right.(sqlparser.ValTuple) {
-				ast, err := sqlparser.Parse("SELECT " + fields + " WHERE " + field + "=" + sqlparser.String(value))
-				if err != nil {
-					return nil, err
-				}
-
-				list = append(list, ast.(*sqlparser.Select).Where.Expr)
-			}
+			return parts[1] + t.Format(timeFormat) + parts[3]
+		})
+	})
 
-		} else if left.Operator == "=" {
-			ast, err := sqlparser.Parse("SELECT " + fields + " WHERE " + field + "=" + sqlparser.String(left.Right))
-			if err != nil {
-				return nil, err
-			}
-
-			list = append(list, ast.(*sqlparser.Select).Where.Expr)
-		}
-
-	default:
-		log.Error().Msgf("Unexpected \"Left\" part of the query to split: %#v\n", left)
+	if err != nil {
+		return "", err
 	}
 
-	return list, nil
+	return sql, nil
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+/*
+ * Test resolving relative time expressions
+ */
+func TestResolveTime(t *testing.T) {
+	day := func(n int) func(time.Time) time.Time {
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, n) }
+	}
+	add := func(d time.Duration) func(time.Time) time.Time {
+		return func(t time.Time) time.Time { return t.Add(d) }
+	}
+
+	// Queries, the expected results with "%s" for every resolved time
+	// and the functions to get the expected times from "now"
+	tables := []struct {
+		sql      string
+		resolved string
+		times    []func(time.Time) time.Time
+	}{
+		{`ip='1.1.1.1' AND datetime > now() - INTERVAL 7 DAY`,
+			`ip='1.1.1.1' AND datetime > '%s'`, []func(time.Time) time.Time{day(-7)}},
+		{`ip='1.1.1.1' AND datetime < now()`,
+			`ip='1.1.1.1' AND datetime < '%s'`, []func(time.Time) time.Time{add(0)}},
+		{`ip='1.1.1.1' AND datetime >= now() - INTERVAL 1 DAY + INTERVAL 2 HOUR`,
+			`ip='1.1.1.1' AND datetime >= '%s'`, []func(time.Time) time.Time{func(t time.Time) time.Time { return t.AddDate(0, 0, -1).Add(2 * time.Hour) }}},
+		{`ip='1.1.1.1' AND datetime <= 'now-30m'`,
+			`ip='1.1.1.1' AND datetime <= '%s'`, []func(time.Time) time.Time{add(-30 * time.Minute)}},
+		{`ip='1.1.1.1' AND datetime > "now-1d-12h"`,
+			`ip='1.1.1.1' AND datetime > "%s"`, []func(time.Time) time.Time{add(-36 * time.Hour)}},
+		{`ip='1.1.1.1' AND datetime BETWEEN 'now-24h' AND 'now'`,
+			`ip='1.1.1.1' AND datetime BETWEEN '%s' AND '%s'`, []func(time.Time) time.Time{day(-1), add(0)}},
+		{`ip='1.1.1.1' AND datetime BETWEEN now() - INTERVAL 1 WEEK AND 'now+1h'`,
+			`ip='1.1.1.1' AND datetime BETWEEN '%s' AND '%s'`, []func(time.Time) time.Time{day(-7), add(time.Hour)}},
+		{`ip='1.1.1.1' AND datetime > 'now-1M'`,
+			`ip='1.1.1.1' AND datetime > '%s'`, []func(time.Time) time.Time{func(t time.Time) time.Time { return t.AddDate(0, -1, 0) }}},
+		// Absolute time and other fields are untouched
+		{`ip='1.1.1.1' AND datetime > '2023-01-01T00:00:00.000Z'`,
+			`ip='1.1.1.1' AND datetime > '2023-01-01T00:00:00.000Z'`, nil},
+		{`name='now' AND datetime BETWEEN '2023-01-01T00:00:00.000Z' AND '2023-02-01T00:00:00.000Z'`,
+			`name='now' AND datetime BETWEEN '2023-01-01T00:00:00.000Z' AND '2023-02-01T00:00:00.000Z'`, nil},
+	}
+
+	for _, table := range tables {
+		before := time.Now().UTC().Truncate(time.Second)
+
+		resolved, err := resolveTime(table.sql)
+		if err != nil {
+			t.Errorf("Can't resolve '%s': %s", table.sql, err.Error())
+			continue
+		}
+
+		after := time.Now().UTC().Truncate(time.Second)
+
+		// Second could change during the resolving
+		if resolved != expectedTime(table.resolved, table.times, before) &&
+			resolved != expectedTime(table.resolved, table.times, after) {
+
+			t.Errorf("Invalid resolving of '%s': %s, expected: %s", table.sql, resolved, expectedTime(table.resolved, table.times, before))
+		}
+	}
+
+	// Unknown interval unit
+	if _, err := resolveTime(`datetime > now() - INTERVAL 7 FORTNIGHT`); err == nil {
+		t.Errorf("Unknown interval unit must fail")
+	}
+}
+
+/*
+ * Format the expected query with the times relative to the given "now"
+ */
+func expectedTime(format string, times []func(time.Time) time.Time, now time.Time) string {
+	values := []interface{}{}
+	for _, f := range times {
+		values = append(values, f(now).Format(timeFormat))
+	}
+
+	if len(values) == 0 {
+		return format
+	}
+
+	return fmt.Sprintf(format, values...)
+}
+
+/*
+ * Test getting user's initial query back from the Web GUI query
+ */
+func TestTimeRange(t *testing.T) {
+	// Web GUI queries and the initial queries
+	tables := [][2]string{
+		{`FROM global WHERE (ip='1.1.1.1') AND datetime BETWEEN '2023-01-01T00:00:00.000Z' AND '2023-02-01T00:00:00.000Z' LIMIT 0,100`, `FROM global WHERE (ip='1.1.1.1')`},
+		{`FROM global WHERE (ip='1.1.1.1') AND datetime BETWEEN 'now-24h' AND 'now' LIMIT 0,100`, `FROM global WHERE (ip='1.1.1.1')`},
+		{`FROM global WHERE (ip='1.1.1.1') AND datetime BETWEEN now() - INTERVAL 7 DAY AND now()`, `FROM global WHERE (ip='1.1.1.1')`},
+		{`FROM global WHERE ip='1.1.1.1' AND datetime > 'now-7d'`, `FROM global WHERE ip='1.1.1.1'`},
+		{`FROM global WHERE ip='1.1.1.1' AND datetime >= now() - INTERVAL 1 HOUR LIMIT 10`, `FROM global WHERE ip='1.1.1.1'`},
+		{`FROM global WHERE ip='1.1.1.1' AND datetime < '2023-01-01T00:00:00.000Z'`, `FROM global WHERE ip='1.1.1.1'`},
+		{`FROM global WHERE ip='1.1.1.1' AND datetime <= 'now'`, `FROM global WHERE ip='1.1.1.1'`},
+		{`FROM global WHERE ip='1.1.1.1'`, `FROM global WHERE ip='1.1.1.1'`},
+	}
+
+	for _, table := range tables {
+		if query := reTimeRange.ReplaceAllString(table[0], ""); query != table[1] {
+			t.Errorf("Invalid initial query of '%s': %s, expected: %s", table[0], query, table[1])
+		}
+	}
+}
+
+/*
+ * Test validation of the user's queries and data source detection
+ */
+func TestPrepareQuery(t *testing.T) {
+	defer func(c map[string]pdk.SourcePlugin, g map[string]*SourceGroup) {
+		collectors, sourceGroups = c, g
+	}(collectors, sourceGroups)
+
+	collectors = map[string]pdk.SourcePlugin{
+		"passive-dns": nil,
+		"my source":   nil,
+	}
+	sourceGroups = map[string]*SourceGroup{
+		"internal-logs": {Name: "internal-logs"},
+	}
+
+	tests := []struct {
+		sql     string
+		source  string
+		explain bool
+	}{
+		{"FROM global WHERE ip='10.10.10.10'", "global", false},
+		{"from passive-dns where ip='10.10.10.10'", "passive-dns", false},
+		{"FROM internal-logs WHERE ip='10.10.10.10' LIMIT 0,10", "internal-logs", false},
+		{"FROM `passive-dns` WHERE ip='10.10.10.10'", "passive-dns", false},
+		{"FROM \"my source\" WHERE ip='10.10.10.10'", "my source", false},
+		{"FROM 'internal-logs' WHERE ip='10.10.10.10'", "internal-logs", false},
+		{"FROM passive-dns\nWHERE ip='10.10.10.10'\n  AND domain='example.com'", "passive-dns", false},
+		{"  EXPLAIN FROM passive-dns WHERE ip='10.10.10.10'", "passive-dns", true},
+		{"EXPLAIN\nFROM global\nWHERE ip='10.10.10.10'", "global", true},
+		{"explain\tFROM `internal-logs` WHERE ip='10.10.10.10'", "internal-logs", true},
+	}
+
+	for _, test := range tests {
+		request, err := prepareQuery(test.sql)
+		if err != nil {
+			t.Errorf("Can't prepare '%s': %s", test.sql, err.Error())
+			continue
+		}
+
+		if request.Source != test.source || request.Explain != test.explain {
+			t.Errorf("Invalid request of '%s': %s, explain: %t, expected: %s, explain: %t", test.sql, request.Source, request.Explain, test.source, test.explain)
+		}
+
+		// Prepared query is parsed again by every data source
+		if _, err := sqlparser.Parse("SELECT * " + request.SQL); err != nil {
+			t.Errorf("Can't parse prepared query '%s': %s", request.SQL, err.Error())
+		}
+	}
+
+	// Invalid queries and the expected kinds of errors
+	invalid := []struct {
+		sql  string
+		kind string
+	}{
+		{"  ", queryErrEmpty},
+		{"FROM global WHERE ip=", queryErrSyntax},
+		{"FROM global", queryErrSyntax},
+		{"WHERE ip='10.10.10.10'", queryErrSource},
+		{"FROM unknown-source WHERE ip='10.10.10.10'", queryErrUnknown},
+	}
+
+	for _, test := range invalid {
+		_, err := prepareQuery(test.sql)
+
+		e, ok := err.(*QueryError)
+		if !ok || e.Kind != test.kind {
+			t.Errorf("Invalid error of '%s': %v, expected kind: %s", test.sql, err, test.kind)
+		}
+	}
+
+	// Unknown data source is not found in the API
+	_, err := prepareQuery("FROM unknown-source WHERE ip='10.10.10.10'")
+	if status := queryAPIerror(err).Status; status != http.StatusNotFound {
+		t.Errorf("Invalid status of the unknown data source: %d, expected: %d", status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,65 @@
+package pdk
+
+import (
+	"strings"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+/*
+ * Optional interface for the data source plugins to declare
+ * which query features they can convert into the native query.
+ *
+ * Core checks data source's definition against it on start,
+ * and applies filters with the not supported operators
+ * to the received results itself, when it's possible.
+ * Plugins without the declaration get the queries the way
+ * "supportsSQL" setting says
+ */
+type CapableSourcePlugin interface {
+	SourcePlugin
+
+	// Features supported by the plugin,
+	// called after the "Setup" so can depend on the definition
+	Capabilities() *Capabilities
+}
+
+/*
+ * Query features supported by the data source plugin
+ */
+type Capabilities struct {
+	// Comparison operators, as given by the SQL parser:
+	//     "=", "!=", "<", ">", "<=", ">=", "in", "not in",
+	//     "like", "not like", "regexp", "not regexp",
+	//     "between", "not between"
+	Operators []string `json:"operators"`
+
+	// Whether filters can be joined with OR and negated with NOT,
+	// AND is always expected
+	Or bool `json:"or"`
+
+	// Whether "GROUP BY" is supported
+	GroupBy bool `json:"groupBy"`
+
+	// Whether only the selected fields are returned,
+	// as given by the "includeFields" setting
+	Projection bool `json:"projection"`
+}
+
+/*
+ * Check whether the comparison operator is supported
+ */
+func (c *Capabilities) Supports(operator string) bool {
+	operator = strings.ToLower(operator)
+	if operator == "<>" {
+		operator = sqlparser.NotEqualStr
+	}
+
+	for _, o := range c.Operators {
+		if strings.ToLower(o) == operator {
+			return true
+		}
+	}
+
+	return false
+}
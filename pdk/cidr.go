@@ -0,0 +1,102 @@
+package pdk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+const (
+	// Name of the SQL function to filter IP addresses by network:
+	//     cidr_match(ip, '10.0.0.0/8')
+	CIDRFunc = "cidr_match"
+)
+
+/*
+ * Optional interface for the data source plugins,
+ * which are able to convert "cidr_match(field, 'network')" filters
+ * into the data source's native query. For the other data sources
+ * such filters are applied by the core to the received results
+ */
+type CIDRSourcePlugin interface {
+	SourcePlugin
+
+	// Whether "cidr_match" filters are handled by the plugin
+	MatchesCIDR() bool
+}
+
+/*
+ * Check whether the given SQL expression is a "cidr_match" filter
+ */
+func IsCIDR(expr sqlparser.Expr) bool {
+	f, ok := expr.(*sqlparser.FuncExpr)
+	return ok && f.Name.EqualString(CIDRFunc)
+}
+
+/*
+ * Get the field name and network of the "cidr_match(field, 'network')" filter
+ */
+func ParseCIDR(expr *sqlparser.FuncExpr) (string, *net.IPNet, error) {
+	if len(expr.Exprs) != 2 {
+		return "", nil, fmt.Errorf("%s() expects 2 arguments: field and network", CIDRFunc)
+	}
+
+	// Field name
+	left, ok := expr.Exprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return "", nil, fmt.Errorf("%s() first argument must be a field", CIDRFunc)
+	}
+
+	col, ok := left.Expr.(*sqlparser.ColName)
+	if !ok {
+		return "", nil, fmt.Errorf("%s() first argument must be a field", CIDRFunc)
+	}
+
+	// Network
+	right, ok := expr.Exprs[1].(*sqlparser.AliasedExpr)
+	if !ok {
+		return "", nil, fmt.Errorf("%s() second argument must be a network", CIDRFunc)
+	}
+
+	val, ok := right.Expr.(*sqlparser.SQLVal)
+	if !ok || val.Type != sqlparser.StrVal {
+		return "", nil, fmt.Errorf("%s() second argument must be a network string", CIDRFunc)
+	}
+
+	_, network, err := net.ParseCIDR(string(val.Val))
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid %s() network: %s", CIDRFunc, err.Error())
+	}
+
+	return strings.Trim(sqlparser.String(col), "`"), network, nil
+}
+
+/*
+ * Get the first and the last IP address of the network
+ */
+func IPRange(network *net.IPNet) (net.IP, net.IP) {
+	first := network.IP.Mask(network.Mask)
+	last := make(net.IP, len(first))
+
+	for i := range first {
+		last[i] = first[i] | ^network.Mask[i]
+	}
+
+	return first, last
+}
+
+/*
+ * Convert IPv4 address into an integer,
+ * as some data sources store IPs this way
+ */
+func IPv4ToInt(ip net.IP) (int64, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0, fmt.Errorf("Not an IPv4 address: %s", ip.String())
+	}
+
+	return int64(binary.BigEndian.Uint32(ip4)), nil
+}
@@ -142,7 +142,7 @@ func ResultsContain(results []map[string]interface{}, entry map[string]interface
 			// Compare attributes of FROM and TO nodes and EDGE
 			if attributesAreIdentical(entry, from["attributes"], relation.From.Attributes) &&
 				attributesAreIdentical(entry, to["attributes"], relation.To.Attributes) &&
-				(relation.Edge == nil || attributesAreIdentical(entry, result["edge"], relation.Edge.Attributes)) {
+				(relation.Edge == nil || attributesAreIdentical(entry, edgeAttributes(result), relation.Edge.Attributes)) {
 
 				return true
 			}
@@ -152,6 +152,18 @@ func ResultsContain(results []map[string]interface{}, entry map[string]interface
 	return false
 }
 
+/*
+ * Get attributes of the relation's edge, nil if there are none
+ */
+func edgeAttributes(result map[string]interface{}) interface{} {
+	edge, ok := result["edge"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	return edge["attributes"]
+}
+
 /*
  * Check whether the slice contains the given string
  */
@@ -192,134 +204,31 @@ func InterfaceSliceContains(slice []interface{}, val interface{}) bool {
 }
 
 /*
- * Create relations from a data source response's single entry
+ * Create relations from a data source response's single entry.
+ * Kept for the existing plugins, "RelationBuilder" should be used instead
  */
 func CreateRelations(source *Source, entry map[string]interface{}, unique map[string]bool, counter *int, mx *sync.Mutex, results *[]map[string]interface{}) {
 	// Go through all the predefined relations and collect unique entries
 	for _, relation := range source.Relations {
-		if entry[relation.From.ID] != nil && entry[relation.To.ID] != nil &&
-			entry[relation.From.ID] != "" && entry[relation.To.ID] != "" {
+		if !hasValue(entry, relation.From.ID) || !hasValue(entry, relation.To.ID) {
+			continue
+		}
 
-			mx.Lock()
+		// Use "Sprintf(...%v..." instead of "entry[relation.From.ID].(string)"
+		// as the value can be not a string only
+		key := fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])
 
-			// Use "Printf(...%v..." instead of "entry[relation.From.ID].(string)"
-			// as the value can be not a string only
-			if _, exists := unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])]; exists {
-				if ResultsContain(*results, entry, relation) {
-					mx.Unlock()
-					continue
-				}
-			}
+		mx.Lock()
 
-			*counter++
-
-			unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])] = true
+		if unique[key] && ResultsContain(*results, entry, relation) {
 			mx.Unlock()
+			continue
+		}
 
-			/*
-			 * Check if expected relation exists in received data.
-			 * This allows returned JSON objects to have dynamic schema
-			 */
-			if _, ok := entry[relation.From.ID]; !ok {
-				continue
-			}
-
-			if _, ok := entry[relation.To.ID]; !ok {
-				continue
-			}
-
-			/*
-			 * FROM node with attributes
-			 */
-			from := map[string]interface{}{
-				"id":     entry[relation.From.ID],
-				"group":  relation.From.Group,
-				"search": relation.From.Search,
-			}
-
-			// Check FROM type & searching fields
-			if len(relation.From.VarTypes) > 0 {
-				for _, t := range relation.From.VarTypes {
-					if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[relation.From.ID])) {
-						from["group"] = t.Group
-						from["search"] = t.Search
-						from["label"] = t.Label
-
-						break
-					}
-				}
-			}
-
-			if len(relation.From.Attributes) > 0 {
-				from["attributes"] = make(map[string]interface{})
-				CopyPresentValues(entry, from["attributes"].(map[string]interface{}), relation.From.Attributes)
-			}
-
-			/*
-			 * TO node
-			 */
-			to := map[string]interface{}{
-				"id":     entry[relation.To.ID],
-				"group":  relation.To.Group,
-				"search": relation.To.Search,
-			}
-
-			// Check FROM type & searching fields
-			if len(relation.To.VarTypes) > 0 {
-				for _, t := range relation.To.VarTypes {
-					if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[relation.To.ID])) {
-						to["group"] = t.Group
-						to["search"] = t.Search
-						to["label"] = t.Label
-
-						break
-					}
-				}
-			}
-
-			if len(relation.To.Attributes) > 0 {
-				to["attributes"] = make(map[string]interface{})
-				CopyPresentValues(entry, to["attributes"].(map[string]interface{}), relation.To.Attributes)
-			}
-
-			// Resulting graph entry to return
-			result := make(map[string]interface{})
-
-			/*
-			 * Edge between FROM and TO
-			 */
-			if relation.Edge != nil && (relation.Edge.Label != "" || len(relation.Edge.Attributes) > 0) {
-				result["edge"] = make(map[string]interface{})
-
-				if to["label"] != "" && to["label"] != nil {
-					result["edge"].(map[string]interface{})["label"] = to["label"]
-				} else if from["label"] != "" && from["label"] != nil {
-					result["edge"].(map[string]interface{})["label"] = from["label"]
-				} else if relation.Edge.Label != "" {
-					result["edge"].(map[string]interface{})["label"] = relation.Edge.Label
-				}
-
-				if len(relation.Edge.Attributes) > 0 {
-					result["edge"].(map[string]interface{})["attributes"] = make(map[string]interface{})
-					CopyPresentValues(entry, result["edge"].(map[string]interface{})["attributes"].(map[string]interface{}), relation.Edge.Attributes)
-				}
-			}
-
-			/*
-			 * Put it together
-			 */
-			result["from"] = from
-			result["to"] = to
-			result["source"] = source.Name
-
-			//fmt.Println("Edge:", from, to, source)
+		*counter++
+		unique[key] = true
+		*results = append(*results, buildRelation(source, relation, entry, true, nil))
 
-			/*
-			 * Add current entry to the list to return
-			 */
-			mx.Lock()
-			*results = append(*results, result)
-			mx.Unlock()
-		}
+		mx.Unlock()
 	}
 }
@@ -1,6 +1,8 @@
 package pdk
 
 import (
+	"context"
+
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 )
 
@@ -28,6 +30,48 @@ type SourcePlugin interface {
 	Stop() error
 }
 
+/*
+ * Optional interface for the data source plugins,
+ * which are able to stop a running search when the core cancels it:
+ * client disconnects, deadline exceeds or another data source fails
+ */
+type ContextSourcePlugin interface {
+	SourcePlugin
+
+	// Execute the given query until the context is done.
+	// Returns results, statistics, debug info & error
+	SearchContext(context.Context, *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error)
+}
+
+/*
+ * Optional interface for the data source plugins,
+ * which are able to show the native query they would send
+ * to the data source: Elasticsearch DSL, MongoDB filter, SQL string, etc.
+ */
+type ExplainSourcePlugin interface {
+	SourcePlugin
+
+	// Convert the given query without running it.
+	// Returns data source's native query & error
+	Explain(*sqlparser.Select) (interface{}, error)
+}
+
+/*
+ * Optional interface for the data source plugins,
+ * which track the position of the paginated results themselves:
+ * Elasticsearch point in time with "search_after", etc.
+ * The other plugins are paginated by the LIMIT's offset
+ */
+type PagedSourcePlugin interface {
+	SourcePlugin
+
+	// Execute the given query starting from the given position,
+	// which is empty for the first page.
+	// Returns results, statistics, debug info,
+	// position of the next page or empty string when there is no more data & error
+	SearchPage(context.Context, *sqlparser.Select, string) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, string, error)
+}
+
 /*
  * Plugin interface to be implemented by the processor plugins
  */
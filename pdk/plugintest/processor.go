@@ -0,0 +1,143 @@
+package plugintest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+/*
+ * Test suite of a processor plugin:
+ *
+ *     suite := &plugintest.ProcessorSuite{
+ *         New:       func() pdk.ProcessorPlugin { return &plugin{} },
+ *         Processor: definition,
+ *         Cases: []*plugintest.ProcessorCase{
+ *             {Relations: relations, Expected: processed},
+ *         },
+ *     }
+ *
+ *     suite.Run(t)
+ */
+type ProcessorSuite struct {
+	// Create a new instance of the plugin
+	New func() pdk.ProcessorPlugin
+
+	// Create a processor definition to setup the plugin with,
+	// a new one for every case as plugins may modify its data
+	Processor func() *pdk.Processor
+
+	// Definitions the plugin must refuse on setup
+	Invalid []*pdk.Processor
+
+	// Relations to process
+	Cases []*ProcessorCase
+}
+
+/*
+ * Single processing to run
+ */
+type ProcessorCase struct {
+	// Name of the test, case index by default
+	Name string
+
+	// Relations returned by the data source plugins
+	Relations []map[string]interface{}
+
+	// Expected relations after the processing,
+	// nil to check their shape only
+	Expected []map[string]interface{}
+
+	// Whether the processing must fail
+	Error bool
+}
+
+/*
+ * Run all the checks of the suite
+ */
+func (s *ProcessorSuite) Run(t *testing.T) {
+	t.Helper()
+
+	for i, processor := range s.Invalid {
+		t.Run(fmt.Sprintf("invalid-%d", i), func(t *testing.T) {
+			plugin := s.New()
+			if err := plugin.Setup(processor); err == nil {
+				t.Errorf("Invalid definition was accepted: %+v", processor)
+				plugin.Stop()
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		relations, err := s.setup(t).Process([]map[string]interface{}{})
+		if err != nil {
+			t.Errorf("Can't process empty relations: %s", err.Error())
+		}
+
+		if len(relations) != 0 {
+			t.Errorf("Relations created from nothing: %v", relations)
+		}
+	})
+
+	for i, c := range s.Cases {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("case-%d", i)
+		}
+
+		t.Run(name, func(t *testing.T) {
+			s.runCase(t, c)
+		})
+	}
+}
+
+/*
+ * Process a single case's relations
+ */
+func (s *ProcessorSuite) runCase(t *testing.T, c *ProcessorCase) {
+	relations, err := s.setup(t).Process(c.Relations)
+	if c.Error {
+		if err == nil {
+			t.Errorf("Processing must fail, got %d relations", len(relations))
+		}
+
+		return
+	}
+
+	if err != nil {
+		t.Fatalf("Can't process relations: %s", err.Error())
+	}
+
+	checkRelations(t, nil, relations)
+
+	if c.Expected != nil && !reflect.DeepEqual(relations, c.Expected) {
+		t.Errorf("Invalid processing\ngot: %v\nexpected: %v", relations, c.Expected)
+	}
+}
+
+/*
+ * Create a new plugin's instance,
+ * it's stopped when the test finishes
+ */
+func (s *ProcessorSuite) setup(t *testing.T) pdk.ProcessorPlugin {
+	plugin := s.New()
+	processor := s.Processor()
+
+	if err := plugin.Setup(processor); err != nil {
+		t.Fatalf("Can't setup plugin: %s", err.Error())
+	}
+
+	t.Cleanup(func() {
+		if err := plugin.Stop(); err != nil {
+			t.Errorf("Can't stop plugin: %s", err.Error())
+		}
+	})
+
+	if plugin.Conf() != processor {
+		t.Errorf("Plugin's configuration differs from the given definition")
+	}
+
+	return plugin
+}
@@ -0,0 +1,172 @@
+package plugintest
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+/*
+ * Check relations are the way the core and the Web GUI expect them.
+ * Receives a data source definition to check variable types,
+ * nil to check the shape only
+ */
+func checkRelations(t *testing.T, source *pdk.Source, relations []map[string]interface{}) {
+	t.Helper()
+
+	unique := make(map[string]bool)
+
+	for _, relation := range relations {
+		if err := checkRelation(relation); err != nil {
+			t.Errorf("Invalid relation %v: %s", relation, err.Error())
+			continue
+		}
+
+		// Maps are printed sorted by the key
+		key := fmt.Sprint(relation)
+		if unique[key] {
+			t.Errorf("Duplicate relation: %v", relation)
+		}
+		unique[key] = true
+
+		if source == nil {
+			continue
+		}
+
+		if relation["source"] != source.Name {
+			t.Errorf("Invalid relation source: %v, expected: %s", relation["source"], source.Name)
+		}
+
+		for _, part := range []string{"from", "to"} {
+			if err := checkVarTypes(source, relation[part].(map[string]interface{})); err != nil {
+				t.Errorf("Invalid %s node of %v: %s", part, relation, err.Error())
+			}
+		}
+	}
+}
+
+/*
+ * Check a single relation's fields and their types
+ */
+func checkRelation(relation map[string]interface{}) error {
+	for _, part := range []string{"from", "to"} {
+		node, ok := relation[part].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("'%s' node is missing", part)
+		}
+
+		if node["id"] == nil || node["id"] == "" {
+			return fmt.Errorf("'%s' node has no ID", part)
+		}
+
+		for _, field := range []string{"group", "search"} {
+			if _, ok := node[field].(string); !ok {
+				return fmt.Errorf("'%s' node's '%s' is not a string", part, field)
+			}
+		}
+
+		if node["group"] == "" {
+			return fmt.Errorf("'%s' node has no group", part)
+		}
+
+		if err := checkAttributes(node); err != nil {
+			return fmt.Errorf("'%s' node %s", part, err.Error())
+		}
+	}
+
+	if source, ok := relation["source"].(string); !ok || source == "" {
+		return fmt.Errorf("Source is missing")
+	}
+
+	if relation["edge"] != nil {
+		edge, ok := relation["edge"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Edge is not an object")
+		}
+
+		if err := checkAttributes(edge); err != nil {
+			return fmt.Errorf("Edge %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Check the optional attributes of a node or an edge
+ */
+func checkAttributes(object map[string]interface{}) error {
+	if object["attributes"] == nil {
+		return nil
+	}
+
+	if _, ok := object["attributes"].(map[string]interface{}); !ok {
+		return fmt.Errorf("attributes are not an object")
+	}
+
+	return nil
+}
+
+/*
+ * Check node's group & search field are the ones its variable type defines.
+ *
+ * Node is checked against every defined node able to give it the same group,
+ * nodes of the relations created by the plugin itself are skipped
+ */
+func checkVarTypes(source *pdk.Source, node map[string]interface{}) error {
+	id := fmt.Sprintf("%v", node["id"])
+	checked := false
+
+	for _, relation := range source.Relations {
+		for _, def := range []*pdk.Node{relation.From, relation.To} {
+			if !hasGroup(def, node["group"]) {
+				continue
+			}
+
+			group, search := def.Group, def.Search
+
+			for _, t := range def.VarTypes {
+				re := t.RegexCompiled
+				if re == nil {
+					re = regexp.MustCompile(t.Regex)
+				}
+
+				if re.MatchString(id) {
+					group, search = t.Group, t.Search
+					break
+				}
+			}
+
+			if node["group"] == group && node["search"] == search {
+				return nil
+			}
+
+			checked = true
+		}
+	}
+
+	if checked {
+		return fmt.Errorf("group '%v' and search '%v' don't match the variable types of '%s'", node["group"], node["search"], id)
+	}
+
+	return nil
+}
+
+/*
+ * Check whether the defined node can have the given group
+ */
+func hasGroup(def *pdk.Node, group interface{}) bool {
+	if def.Group == group {
+		return true
+	}
+
+	for _, t := range def.VarTypes {
+		if t.Group == group {
+			return true
+		}
+	}
+
+	return false
+}
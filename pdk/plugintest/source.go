@@ -0,0 +1,341 @@
+/*
+ * Conformance test kit for the plugins.
+ *
+ * Runs a plugin against a table of queries and fixture entries
+ * and checks it behaves the way the core expects:
+ * relations shape, limit & statistics, variable types and error paths.
+ * Plugin authors can use it from their "_test.go" files
+ * to show compatibility before deploying the plugin
+ */
+
+package plugintest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+const (
+	// Default max amount of relations to return
+	defaultLimit = 100
+
+	// How long to wait for a canceled search to return
+	cancelTimeout = 10 * time.Second
+)
+
+/*
+ * Test suite of a data source plugin:
+ *
+ *     suite := &plugintest.SourceSuite{
+ *         New:    func() pdk.SourcePlugin { return &plugin{} },
+ *         Source: fixture,
+ *         Cases: []*plugintest.SourceCase{
+ *             {SQL: `SELECT * WHERE ip='10.10.10.10'`, Entries: entries, Matches: []int{0, 2}},
+ *             {SQL: `SELECT * WHERE cidr_match(ip, 'x')`, Error: true},
+ *         },
+ *     }
+ *
+ *     suite.Run(t)
+ */
+type SourceSuite struct {
+	// Create a new instance of the plugin
+	New func() pdk.SourcePlugin
+
+	// Prepare the data source containing the given entries:
+	// write a file, start a test server, etc.
+	// Returns a data source definition to setup the plugin with
+	Source func(t *testing.T, entries []map[string]interface{}) *pdk.Source
+
+	// Max amount of relations to return, 100 by default
+	Limit int
+
+	// Definitions the plugin must refuse on setup
+	Invalid []*pdk.Source
+
+	// Queries to run
+	Cases []*SourceCase
+}
+
+/*
+ * Single query to run
+ */
+type SourceCase struct {
+	// Name of the test, SQL query by default
+	Name string
+
+	// Query the way the plugin receives it,
+	// like "SELECT * WHERE ip='10.10.10.10'"
+	SQL string
+
+	// All the entries the data source contains
+	Entries []map[string]interface{}
+
+	// Indexes of the entries matching the query.
+	// Expected relations are created by the "pdk.RelationBuilder" from them,
+	// so relations are compared by the nodes group & ID
+	Matches []int
+
+	// Whether the query must fail
+	Error bool
+}
+
+/*
+ * Run all the checks of the suite
+ */
+func (s *SourceSuite) Run(t *testing.T) {
+	t.Helper()
+
+	limit := s.Limit
+	if limit == 0 {
+		limit = defaultLimit
+	}
+
+	for i, source := range s.Invalid {
+		t.Run(fmt.Sprintf("invalid-%d", i), func(t *testing.T) {
+			plugin := s.New()
+			if err := plugin.Setup(source, limit); err == nil {
+				t.Errorf("Invalid definition was accepted: %+v", source)
+				plugin.Stop()
+			}
+		})
+	}
+
+	for _, c := range s.Cases {
+		name := c.Name
+		if name == "" {
+			name = c.SQL
+		}
+
+		t.Run(name, func(t *testing.T) {
+			s.runCase(t, c, limit)
+		})
+	}
+}
+
+/*
+ * Run a single query with the given limit and with a limit of 1 relation
+ */
+func (s *SourceSuite) runCase(t *testing.T, c *SourceCase, limit int) {
+	source := s.Source(t, c.Entries)
+
+	stmt, err := parse(c.SQL, source)
+	if err != nil {
+		t.Fatalf("Can't parse '%s': %s", c.SQL, err.Error())
+	}
+
+	plugin := s.setup(t, source, limit)
+
+	results, stats, _, err := plugin.Search(stmt)
+	if c.Error {
+		if err == nil {
+			t.Errorf("Query '%s' must fail, got %d relations", c.SQL, len(results))
+		}
+
+		return
+	}
+
+	if err != nil {
+		t.Fatalf("Can't search '%s': %s", c.SQL, err.Error())
+	}
+
+	// Expected relations
+	builder := pdk.NewRelationBuilder(source, limit)
+	for _, i := range c.Matches {
+		builder.Add(c.Entries[i])
+	}
+
+	expected, _, err := builder.Results()
+	if err != nil {
+		t.Fatalf("Can't create expected relations: %s", err.Error())
+	}
+
+	checkRelations(t, source, results)
+	compareRelations(t, expected, results)
+
+	if stats != nil && !builder.Limited() {
+		t.Errorf("Statistics returned without reaching the limit: %v", stats)
+	}
+
+	s.checkLimit(t, c, source, stmt, len(expected))
+	s.checkCancel(t, source, stmt, limit)
+}
+
+/*
+ * Check the search stops at the limit and returns statistics instead.
+ * Skipped when the matching entries fit into 1 relation
+ */
+func (s *SourceSuite) checkLimit(t *testing.T, c *SourceCase, source *pdk.Source, stmt *sqlparser.Select, total int) {
+	builder := pdk.NewRelationBuilder(source, 1)
+	for _, i := range c.Matches {
+		builder.Add(c.Entries[i])
+	}
+
+	if !builder.Limited() {
+		return
+	}
+
+	plugin := s.setup(t, source, 1)
+
+	results, stats, _, err := plugin.Search(stmt)
+	if err != nil {
+		t.Fatalf("Can't search '%s' with a limit of 1: %s", c.SQL, err.Error())
+	}
+
+	if len(results) >= total {
+		t.Errorf("Limit of 1 is ignored: %d relations of %d returned", len(results), total)
+	}
+
+	if stats == nil {
+		t.Errorf("No statistics returned when the limit of 1 is reached")
+		return
+	}
+
+	if stats["source"] != source.Name {
+		t.Errorf("Invalid statistics source: %v, expected: %s", stats["source"], source.Name)
+	}
+
+	for field := range stats {
+		if field != "source" && !contains(source.StatsFields, field) {
+			t.Errorf("Statistics of the unknown field: %s", field)
+		}
+	}
+}
+
+/*
+ * Check a search with the canceled context returns in time.
+ * Skipped when the plugin is not able to cancel the search
+ */
+func (s *SourceSuite) checkCancel(t *testing.T, source *pdk.Source, stmt *sqlparser.Select, limit int) {
+	plugin, ok := s.setup(t, source, limit).(pdk.ContextSourcePlugin)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		plugin.SearchContext(ctx, stmt)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(cancelTimeout):
+		t.Errorf("Canceled search has not returned in %s", cancelTimeout)
+	}
+}
+
+/*
+ * Create a new plugin's instance,
+ * it's stopped when the test finishes
+ */
+func (s *SourceSuite) setup(t *testing.T, source *pdk.Source, limit int) pdk.SourcePlugin {
+	plugin := s.New()
+
+	if err := plugin.Setup(source, limit); err != nil {
+		t.Fatalf("Can't setup plugin: %s", err.Error())
+	}
+
+	t.Cleanup(func() {
+		if err := plugin.Stop(); err != nil {
+			t.Errorf("Can't stop plugin: %s", err.Error())
+		}
+	})
+
+	if plugin.Conf() != source {
+		t.Errorf("Plugin's configuration differs from the given definition")
+	}
+
+	if _, err := plugin.Fields(); err != nil {
+		t.Errorf("Can't get fields: %s", err.Error())
+	}
+
+	return plugin
+}
+
+/*
+ * Compare relations by the nodes group & ID, ignoring the order
+ */
+func compareRelations(t *testing.T, expected, results []map[string]interface{}) {
+	a := relationKeys(expected)
+	b := relationKeys(results)
+
+	if len(a) != len(b) {
+		t.Errorf("%d relations returned, expected: %d\ngot: %v\nexpected: %v", len(b), len(a), b, a)
+		return
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			t.Errorf("Invalid relations\ngot: %v\nexpected: %v", b, a)
+			return
+		}
+	}
+}
+
+/*
+ * Get sorted "group:id -> group:id" keys of the relations
+ */
+func relationKeys(relations []map[string]interface{}) []string {
+	keys := make([]string, 0, len(relations))
+
+	for _, relation := range relations {
+		from, _ := relation["from"].(map[string]interface{})
+		to, _ := relation["to"].(map[string]interface{})
+
+		keys = append(keys, fmt.Sprintf("%v:%v -> %v:%v", from["group"], from["id"], to["group"], to["id"]))
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+ * Parse the query the way the core does,
+ * selecting the "includeFields" only if defined
+ */
+func parse(query string, source *pdk.Source) (*sqlparser.Select, error) {
+	ast, err := sqlparser.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, ok := ast.(*sqlparser.Select)
+	if !ok {
+		return nil, fmt.Errorf("Only SELECT statement is allowed")
+	}
+
+	if len(source.IncludeFields) != 0 {
+		stmt.SelectExprs = sqlparser.SelectExprs{}
+
+		for _, field := range source.IncludeFields {
+			stmt.SelectExprs = append(stmt.SelectExprs, &sqlparser.AliasedExpr{
+				Expr: &sqlparser.ColName{Name: sqlparser.NewColIdent(field)},
+			})
+		}
+	}
+
+	return stmt, nil
+}
+
+/*
+ * Check whether the slice contains the given string
+ */
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
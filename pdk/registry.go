@@ -0,0 +1,80 @@
+package pdk
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+ * Function to create a new instance of the plugin,
+ * returns "SourcePlugin" or "ProcessorPlugin"
+ */
+type Factory func() interface{}
+
+/*
+ * Plugin registered by its "init()"
+ */
+type Registration struct {
+	Name    string
+	Version string
+	New     Factory
+}
+
+var (
+	// Registered plugins, is a map of plugin's name -> registration
+	registry   = make(map[string]*Registration)
+	registryMx sync.Mutex
+)
+
+/*
+ * Register the plugin to make it available to the core.
+ * Must be called from the plugin's "init()":
+ *
+ *     func init() {
+ *         pdk.Register(Name, Version, func() interface{} { return &plugin{} })
+ *     }
+ *
+ * Plugins compiled into the core binary are registered on start,
+ * ".so" plugins when they are opened.
+ * Plugin registered later replaces the previous one with the same name
+ */
+func Register(name, version string, factory Factory) {
+	registryMx.Lock()
+	defer registryMx.Unlock()
+
+	registry[name] = &Registration{
+		Name:    name,
+		Version: version,
+		New:     factory,
+	}
+}
+
+/*
+ * Get the registered plugin by its name
+ */
+func Registered(name string) (*Registration, bool) {
+	registryMx.Lock()
+	defer registryMx.Unlock()
+
+	registration, ok := registry[name]
+	return registration, ok
+}
+
+/*
+ * Get all the registered plugins sorted by name
+ */
+func Registrations() []*Registration {
+	registryMx.Lock()
+	defer registryMx.Unlock()
+
+	list := make([]*Registration, 0, len(registry))
+	for _, registration := range registry {
+		list = append(list, registration)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
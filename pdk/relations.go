@@ -0,0 +1,320 @@
+package pdk
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/umpc/go-sortedmap"
+	"github.com/umpc/go-sortedmap/desc"
+)
+
+/*
+ * Graph relations builder, shared by the data source plugins.
+ *
+ * Receives the raw data source entries one by one and turns them
+ * into the unique relations, as defined by the data source's "relations".
+ * Keeps statistics of the "statsFields" and stops accepting the entries
+ * when the limit of relations is reached. Safe for concurrent use.
+ *
+ * Usage:
+ *
+ *     builder := pdk.NewRelationBuilder(p.source, p.limit)
+ *
+ *     for _, entry := range entries {
+ *         if !builder.Add(entry) {
+ *             break
+ *         }
+ *     }
+ *
+ *     results, stats, err := builder.Results()
+ */
+type RelationBuilder struct {
+	// Optional function to adjust every created node,
+	// for example to shorten its ID or add the computed attributes
+	ModifyNode func(node *Node, entry, result map[string]interface{})
+
+	// Whether the nodes get a "label" of their matching variable type,
+	// which then labels the edge instead of the relation's own label.
+	// Behavior of the plugins which used "CreateRelations"
+	VarTypeLabels bool
+
+	source *Source
+	limit  int
+
+	// Relations to return
+	results []map[string]interface{}
+
+	// Already created relations, to skip the duplicates
+	unique map[string]bool
+
+	// Amount of the created relations
+	counter int
+
+	// Amount of the accepted entries
+	entries int
+
+	// Whether the entries were skipped because of the limit
+	limited bool
+
+	// Statistics data to return instead of the relations
+	// when the amount of entries is too large
+	stats *Stats
+
+	mx sync.Mutex
+}
+
+// Protects variable types regexes compiled by the builders
+var varTypesMx sync.Mutex
+
+/*
+ * Create a builder for a single search.
+ *
+ * Receives a data source definition and
+ * max amount of relations to create
+ */
+func NewRelationBuilder(source *Source, limit int) *RelationBuilder {
+	stats := NewStats()
+
+	for _, field := range source.StatsFields {
+		stats.Fields[field] = sortedmap.New(10, desc.Int)
+	}
+
+	// Plugins usually compile the regexes on setup,
+	// the rest are compiled once here
+	varTypesMx.Lock()
+	defer varTypesMx.Unlock()
+
+	for _, relation := range source.Relations {
+		for _, node := range []*Node{relation.From, relation.To} {
+			for _, t := range node.VarTypes {
+				if t.RegexCompiled == nil {
+					t.RegexCompiled = regexp.MustCompile(t.Regex)
+				}
+			}
+		}
+	}
+
+	return &RelationBuilder{
+		source:  source,
+		limit:   limit,
+		results: []map[string]interface{}{},
+		unique:  make(map[string]bool),
+		stats:   stats,
+	}
+}
+
+/*
+ * Turn a single data source entry into the relations.
+ * Returns false when the limit is already reached,
+ * so the rest of the entries are not needed anymore
+ */
+func (b *RelationBuilder) Add(entry map[string]interface{}) bool {
+	b.mx.Lock()
+	if b.counter >= b.limit {
+		b.limited = true
+		b.mx.Unlock()
+
+		return false
+	}
+	b.entries++
+	b.mx.Unlock()
+
+	// Update stats
+	for _, field := range b.source.StatsFields {
+		b.stats.Update(entry, field)
+	}
+
+	// Go through all the predefined relations and collect unique entries
+	for _, relation := range b.source.Relations {
+		if !hasValue(entry, relation.From.ID) || !hasValue(entry, relation.To.ID) {
+			continue
+		}
+
+		// Use "Sprintf(...%v..." instead of "entry[relation.From.ID].(string)"
+		// as the value can be not a string only
+		key := fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])
+
+		b.mx.Lock()
+
+		if b.unique[key] && ResultsContain(b.results, entry, relation) {
+			b.mx.Unlock()
+			continue
+		}
+
+		b.counter++
+		b.unique[key] = true
+		b.results = append(b.results, buildRelation(b.source, relation, entry, b.VarTypeLabels, b.ModifyNode))
+
+		b.mx.Unlock()
+	}
+
+	return true
+}
+
+/*
+ * Add a relation created by the plugin itself, not defined by the "relations".
+ * Relation with the same key is added only once, empty key skips the check.
+ * Returns false when the limit is already reached
+ */
+func (b *RelationBuilder) AddRelation(key string, relation map[string]interface{}) bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.counter >= b.limit {
+		b.limited = true
+		return false
+	}
+
+	if key != "" {
+		if b.unique[key] {
+			return true
+		}
+
+		b.unique[key] = true
+	}
+
+	if _, ok := relation["source"]; !ok {
+		relation["source"] = b.source.Name
+	}
+
+	b.counter++
+	b.results = append(b.results, relation)
+
+	return true
+}
+
+/*
+ * Check whether some entries were skipped because of the limit
+ */
+func (b *RelationBuilder) Limited() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return b.limited
+}
+
+/*
+ * Get the amount of the accepted entries,
+ * the rest were skipped because of the limit
+ */
+func (b *RelationBuilder) Entries() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return b.entries
+}
+
+/*
+ * Get the created relations.
+ * Statistics are returned too when the limit is reached,
+ * nil otherwise
+ */
+func (b *RelationBuilder) Results() ([]map[string]interface{}, map[string]interface{}, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if !b.limited {
+		return b.results, nil, nil
+	}
+
+	top, err := b.stats.ToJSON(b.source.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b.results, top, nil
+}
+
+/*
+ * Get the statistics of the entries received so far,
+ * for example when the search is canceled
+ */
+func (b *RelationBuilder) Stats() (map[string]interface{}, error) {
+	return b.stats.ToJSON(b.source.Name)
+}
+
+/*
+ * Check whether the entry contains a non empty value of the field.
+ * Empty IDs can't be displayed as nodes, so such entries are skipped
+ */
+func hasValue(entry map[string]interface{}, field string) bool {
+	return entry[field] != nil && entry[field] != ""
+}
+
+/*
+ * Create a single "FROM -> TO" relation of the entry.
+ * Receives whether to label the nodes and edge by the variable types
+ */
+func buildRelation(source *Source, relation *Relation, entry map[string]interface{}, labels bool, modify func(*Node, map[string]interface{}, map[string]interface{})) map[string]interface{} {
+	from := buildNode(relation.From, entry, labels)
+	to := buildNode(relation.To, entry, labels)
+
+	if modify != nil {
+		modify(relation.From, entry, from)
+		modify(relation.To, entry, to)
+	}
+
+	// Resulting graph entry to return
+	result := map[string]interface{}{
+		"from":   from,
+		"to":     to,
+		"source": source.Name,
+	}
+
+	/*
+	 * Edge between FROM and TO
+	 */
+	if relation.Edge != nil && (relation.Edge.Label != "" || len(relation.Edge.Attributes) > 0) {
+		edge := make(map[string]interface{})
+
+		if to["label"] != "" && to["label"] != nil {
+			edge["label"] = to["label"]
+		} else if from["label"] != "" && from["label"] != nil {
+			edge["label"] = from["label"]
+		} else if relation.Edge.Label != "" {
+			edge["label"] = relation.Edge.Label
+		}
+
+		if len(relation.Edge.Attributes) > 0 {
+			edge["attributes"] = make(map[string]interface{})
+			CopyPresentValues(entry, edge["attributes"].(map[string]interface{}), relation.Edge.Attributes)
+		}
+
+		result["edge"] = edge
+	}
+
+	return result
+}
+
+/*
+ * Create a node with its attributes.
+ * Group, search field and optional label depend on the first matching variable type
+ */
+func buildNode(node *Node, entry map[string]interface{}, labels bool) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":     entry[node.ID],
+		"group":  node.Group,
+		"search": node.Search,
+	}
+
+	for _, t := range node.VarTypes {
+		if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[node.ID])) {
+			result["group"] = t.Group
+			result["search"] = t.Search
+
+			if labels {
+				result["label"] = t.Label
+			}
+
+			break
+		}
+	}
+
+	if len(node.Attributes) > 0 {
+		result["attributes"] = make(map[string]interface{})
+		CopyPresentValues(entry, result["attributes"].(map[string]interface{}), node.Attributes)
+	}
+
+	return result
+}
@@ -0,0 +1,263 @@
+package pdk
+
+import (
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+/*
+ * Create a data source definition to build the relations by
+ */
+func builderSource(t *testing.T) *Source {
+	source := &Source{}
+
+	err := yaml.Unmarshal([]byte(`
+name: people
+statsFields: [ "country" ]
+
+relations:
+  -
+    from:
+        id: name
+        group: name
+        search: name
+        attributes: [ "age" ]
+
+    to:
+        id: country
+        group: country
+        search: country
+
+    edge:
+        label: lives in
+
+  -
+    from:
+        id: name
+        group: name
+        search: name
+
+    to:
+        id: contact
+        group: phone
+        search: phone
+
+        varTypes:
+          -
+            regex: ^.+@.+$
+            group: email
+            search: email
+            label: writes to
+
+    edge:
+        label: contacts
+`), source)
+	if err != nil {
+		t.Fatalf("Can't parse definition: %s", err.Error())
+	}
+
+	return source
+}
+
+// Data source entries, the first one is repeated
+var builderEntries = []map[string]interface{}{
+	{"name": "sarah", "country": "LV", "contact": "sarah@example.com", "age": "40"},
+	{"name": "john", "country": "LV", "contact": "+37120000000", "age": "25"},
+	{"name": "sarah", "country": "LV", "contact": "sarah@example.com", "age": "40"},
+	{"name": "sarah", "country": "LV", "contact": "sarah@example.com", "age": "41"},
+	{"name": "peter", "country": "", "contact": nil, "age": "25"},
+}
+
+/*
+ * Find the relation by the nodes IDs
+ */
+func findRelation(results []map[string]interface{}, from, to interface{}) map[string]interface{} {
+	for _, result := range results {
+		if result["from"].(map[string]interface{})["id"] == from &&
+			result["to"].(map[string]interface{})["id"] == to {
+			return result
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Test unique relations creation
+ */
+func TestRelationBuilder(t *testing.T) {
+	builder := NewRelationBuilder(builderSource(t), 100)
+
+	for _, entry := range builderEntries {
+		if !builder.Add(entry) {
+			t.Fatalf("Entry is not accepted below the limit: %v", entry)
+		}
+	}
+
+	results, stats, err := builder.Results()
+	if err != nil {
+		t.Fatalf("Can't get results: %s", err.Error())
+	}
+
+	// Repeated entry is skipped, different attributes create a new relation,
+	// empty and missing IDs create nothing
+	if len(results) != 5 {
+		t.Errorf("%d relations created, expected: 5\n%v", len(results), results)
+	}
+
+	if stats != nil || builder.Limited() {
+		t.Errorf("Statistics returned without reaching the limit: %v", stats)
+	}
+
+	if builder.Entries() != len(builderEntries) {
+		t.Errorf("%d entries accepted, expected: %d", builder.Entries(), len(builderEntries))
+	}
+
+	email := findRelation(results, "sarah", "sarah@example.com")
+	if email == nil {
+		t.Fatalf("Relation to the email is missing: %v", results)
+	}
+
+	expected := map[string]interface{}{
+		"from":   map[string]interface{}{"id": "sarah", "group": "name", "search": "name"},
+		"to":     map[string]interface{}{"id": "sarah@example.com", "group": "email", "search": "email"},
+		"edge":   map[string]interface{}{"label": "contacts"},
+		"source": "people",
+	}
+
+	if !reflect.DeepEqual(email, expected) {
+		t.Errorf("Invalid relation\ngot: %v\nexpected: %v", email, expected)
+	}
+
+	country := findRelation(results, "john", "LV")
+	if age := country["from"].(map[string]interface{})["attributes"].(map[string]interface{})["age"]; age != "25" {
+		t.Errorf("Invalid node attribute: %v, expected: 25", age)
+	}
+}
+
+/*
+ * Test the entries are not accepted after the limit is reached
+ */
+func TestRelationBuilderLimit(t *testing.T) {
+	builder := NewRelationBuilder(builderSource(t), 3)
+
+	accepted := 0
+	for _, entry := range builderEntries {
+		if !builder.Add(entry) {
+			break
+		}
+		accepted++
+	}
+
+	// Limit is checked before the entry,
+	// so the last accepted one may exceed it
+	if accepted != 2 || builder.Entries() != 2 {
+		t.Errorf("%d entries accepted, %d counted, expected: 2", accepted, builder.Entries())
+	}
+
+	if !builder.Limited() {
+		t.Errorf("Builder is not limited")
+	}
+
+	results, top, err := builder.Results()
+	if err != nil {
+		t.Fatalf("Can't get results: %s", err.Error())
+	}
+
+	if len(results) != 4 {
+		t.Errorf("%d relations created, expected: 4", len(results))
+	}
+
+	expected := map[string]interface{}{
+		"source":  "people",
+		"country": map[string]int{"LV": 2},
+	}
+
+	if !reflect.DeepEqual(top, expected) {
+		t.Errorf("Invalid statistics\ngot: %v\nexpected: %v", top, expected)
+	}
+
+	stats, err := builder.Stats()
+	if err != nil {
+		t.Fatalf("Can't get statistics: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Invalid intermediate statistics\ngot: %v\nexpected: %v", stats, expected)
+	}
+
+	if builder.AddRelation("", map[string]interface{}{}) {
+		t.Errorf("Relation is added after the limit is reached")
+	}
+}
+
+/*
+ * Test nodes and edges labeled by the variable types
+ */
+func TestRelationBuilderLabels(t *testing.T) {
+	builder := NewRelationBuilder(builderSource(t), 100)
+	builder.VarTypeLabels = true
+
+	builder.Add(builderEntries[0])
+	builder.Add(builderEntries[1])
+
+	results, _, err := builder.Results()
+	if err != nil {
+		t.Fatalf("Can't get results: %s", err.Error())
+	}
+
+	email := findRelation(results, "sarah", "sarah@example.com")
+	if label := email["edge"].(map[string]interface{})["label"]; label != "writes to" {
+		t.Errorf("Invalid edge label: %v, expected: writes to", label)
+	}
+
+	if label := email["to"].(map[string]interface{})["label"]; label != "writes to" {
+		t.Errorf("Invalid node label: %v, expected: writes to", label)
+	}
+
+	// Not matching variable type keeps the edge's own label
+	phone := findRelation(results, "john", "+37120000000")
+	if label := phone["edge"].(map[string]interface{})["label"]; label != "contacts" {
+		t.Errorf("Invalid edge label: %v, expected: contacts", label)
+	}
+
+	if _, ok := phone["to"].(map[string]interface{})["label"]; ok {
+		t.Errorf("Node without a matching variable type is labeled: %v", phone["to"])
+	}
+}
+
+/*
+ * Test relations created by the plugin itself
+ */
+func TestRelationBuilderAddRelation(t *testing.T) {
+	builder := NewRelationBuilder(builderSource(t), 100)
+
+	relation := map[string]interface{}{
+		"from": map[string]interface{}{"id": "sarah", "group": "name", "search": "name"},
+		"to":   map[string]interface{}{"id": "LV", "group": "country", "search": "country"},
+	}
+
+	builder.AddRelation("sarah-LV", relation)
+	builder.AddRelation("sarah-LV", relation)
+	builder.AddRelation("", map[string]interface{}{"source": "other"})
+	builder.AddRelation("", map[string]interface{}{"source": "other"})
+
+	results, _, err := builder.Results()
+	if err != nil {
+		t.Fatalf("Can't get results: %s", err.Error())
+	}
+
+	if len(results) != 3 {
+		t.Errorf("%d relations added, expected: 3", len(results))
+	}
+
+	if relation["source"] != "people" {
+		t.Errorf("Missing source is not set: %v", relation["source"])
+	}
+
+	if results[1]["source"] != "other" {
+		t.Errorf("Relation's own source is replaced: %v", results[1]["source"])
+	}
+}
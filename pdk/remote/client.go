@@ -0,0 +1,534 @@
+package remote
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+const (
+	// How long to wait for the plugin's handshake
+	handshakeTimeout = 10 * time.Second
+
+	// How long to wait for the plugin to stop gracefully
+	stopTimeout = 5 * time.Second
+
+	// Min interval between the restarts of a crashing plugin
+	restartDelay = time.Second
+
+	// How long to wait for the plugin's setup after the launch
+	setupTimeout = 30 * time.Second
+)
+
+/*
+ * Launch the plugin's executable once to get its name, version and type.
+ * Receives the max size of the messages in bytes, 0 for the default one.
+ * Returned "*Source" or "*Processor" is not running,
+ * it's cloned for every data source or processor definition
+ */
+func Open(path string, messageSize int) (string, string, interface{}, error) {
+	if messageSize <= 0 {
+		messageSize = DefaultMessageSize
+	}
+
+	p := &process{path: path, service: pluginService, messageSize: messageSize}
+
+	info := &InfoResponse{}
+	err := p.call(context.Background(), "Info", &Empty{}, info)
+	p.stop(context.Background())
+
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	switch info.Kind {
+	case KindSource:
+		return info.Name, info.Version, &Source{path: path, messageSize: messageSize}, nil
+	case KindProcessor:
+		return info.Name, info.Version, &Processor{path: path, messageSize: messageSize}, nil
+	}
+
+	return "", "", nil, fmt.Errorf("Unknown plugin type: " + info.Kind)
+}
+
+/*
+ * Data source plugin running in a separate process.
+ * Implements "pdk.SourcePlugin", the process is launched by the "Setup"
+ * and launched again with the same setup when it crashes
+ */
+type Source struct {
+	path        string
+	messageSize int
+	conf        *pdk.Source
+	caps        *pdk.Capabilities
+	process     *process
+}
+
+/*
+ * New instance of the same plugin, not running yet
+ */
+func (s *Source) Clone() pdk.SourcePlugin {
+	return &Source{path: s.path, messageSize: s.messageSize}
+}
+
+func (s *Source) Conf() *pdk.Source {
+	return s.conf
+}
+
+func (s *Source) Setup(conf *pdk.Source, limit int) error {
+	definition, err := yaml.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("Can't marshal definition: " + err.Error())
+	}
+
+	s.conf = conf
+	s.process = &process{
+		path:        s.path,
+		service:     sourceService,
+		setup:       &SetupRequest{Definition: definition, Limit: limit},
+		messageSize: s.messageSize,
+	}
+
+	if err := s.process.start(); err != nil {
+		return err
+	}
+
+	// Plugins built before the capabilities were introduced
+	// don't have such method and declare nothing
+	response := &CapabilitiesResponse{}
+	if err := s.process.call(context.Background(), "Capabilities", &Empty{}, response); err == nil {
+		s.caps = response.Capabilities
+	}
+
+	return nil
+}
+
+/*
+ * Query features declared by the plugin, received once on setup
+ */
+func (s *Source) Capabilities() *pdk.Capabilities {
+	return s.caps
+}
+
+func (s *Source) Fields() ([]string, error) {
+	response := &FieldsResponse{}
+	err := s.process.call(context.Background(), "Fields", &Empty{}, response)
+
+	return response.Fields, err
+}
+
+func (s *Source) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	return s.SearchContext(context.Background(), stmt)
+}
+
+/*
+ * Canceled context stops the plugin's search too
+ */
+func (s *Source) SearchContext(ctx context.Context, stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	response := &SearchResponse{}
+
+	err := s.process.call(ctx, "Search", &SearchRequest{SQL: sqlparser.String(stmt)}, response)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return response.Relations, response.Stats, response.Debug, nil
+}
+
+func (s *Source) Explain(stmt *sqlparser.Select) (interface{}, error) {
+	response := &ExplainResponse{}
+
+	err := s.process.call(context.Background(), "Explain", &SearchRequest{SQL: sqlparser.String(stmt)}, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Query, nil
+}
+
+/*
+ * Not set up instance has nothing to stop
+ */
+func (s *Source) Stop() error {
+	if s.process == nil {
+		return nil
+	}
+
+	return s.process.stop(context.Background())
+}
+
+/*
+ * Processor plugin running in a separate process.
+ * Implements "pdk.ProcessorPlugin" the same way as "Source"
+ */
+type Processor struct {
+	path        string
+	messageSize int
+	conf        *pdk.Processor
+	process     *process
+}
+
+/*
+ * New instance of the same plugin, not running yet
+ */
+func (p *Processor) Clone() pdk.ProcessorPlugin {
+	return &Processor{path: p.path, messageSize: p.messageSize}
+}
+
+func (p *Processor) Conf() *pdk.Processor {
+	return p.conf
+}
+
+func (p *Processor) Setup(conf *pdk.Processor) error {
+	definition, err := yaml.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("Can't marshal definition: " + err.Error())
+	}
+
+	p.conf = conf
+	p.process = &process{
+		path:        p.path,
+		service:     processorService,
+		setup:       &SetupRequest{Definition: definition},
+		messageSize: p.messageSize,
+	}
+
+	return p.process.start()
+}
+
+func (p *Processor) Process(relations []map[string]interface{}) ([]map[string]interface{}, error) {
+	ctx := context.Background()
+
+	if p.conf.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.conf.Timeout)
+		defer cancel()
+	}
+
+	response := &ProcessMessage{}
+
+	err := p.process.call(ctx, "Process", &ProcessMessage{Relations: relations}, response)
+	if err != nil {
+		return relations, err
+	}
+
+	return response.Relations, nil
+}
+
+func (p *Processor) Stop() error {
+	if p.process == nil {
+		return nil
+	}
+
+	return p.process.stop(context.Background())
+}
+
+/*
+ * Running plugin's executable
+ */
+type process struct {
+	// Executable to launch
+	path string
+
+	// gRPC service of the plugin's type,
+	// or a common one to get plugin's info only
+	service string
+
+	// Setup to repeat after the restart,
+	// nil while the plugin is not set up yet
+	setup *SetupRequest
+
+	// Max size of the messages in bytes, both ways
+	messageSize int
+
+	mx       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	conn     *grpc.ClientConn
+	secret   string
+	exited   chan struct{}
+	launched time.Time
+	stopped  bool
+}
+
+/*
+ * Launch the plugin and set it up
+ */
+func (p *process) start() error {
+	_, _, err := p.connect()
+	return err
+}
+
+/*
+ * Call plugin's method, launching the process when needed
+ */
+func (p *process) call(ctx context.Context, method string, request, response interface{}) error {
+	conn, secret, err := p.connect()
+	if err != nil {
+		return err
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, metadataSecret, secret)
+
+	err = conn.Invoke(ctx, "/"+p.service+"/"+method, request, response)
+	if err == nil {
+		return nil
+	}
+
+	// Connection is lost when the process exits
+	if status.Code(err) == codes.Unavailable || p.crashed() {
+		return fmt.Errorf("Plugin '%s' is not available, it will be restarted", p.path)
+	}
+
+	// Plugin's own errors keep their messages
+	return errors.New(status.Convert(err).Message())
+}
+
+/*
+ * Get a connection to the running plugin.
+ * Crashed plugin is launched again and set up the same way,
+ * independently of the caller's context, as the process is shared
+ */
+func (p *process) connect() (*grpc.ClientConn, string, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.stopped {
+		return nil, "", fmt.Errorf("Plugin '%s' is stopped", p.path)
+	}
+
+	if p.conn != nil {
+		select {
+		case <-p.exited:
+			p.conn.Close()
+			p.conn = nil
+		default:
+			return p.conn, p.secret, nil
+		}
+	}
+
+	// Do not restart a crashing plugin endlessly
+	if time.Since(p.launched) < restartDelay {
+		return nil, "", fmt.Errorf("Plugin '%s' is restarting", p.path)
+	}
+
+	if err := p.launch(); err != nil {
+		return nil, "", err
+	}
+
+	if p.setup != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+		defer cancel()
+
+		ctx = metadata.AppendToOutgoingContext(ctx, metadataSecret, p.secret)
+
+		err := p.conn.Invoke(ctx, "/"+p.service+"/Setup", p.setup, &Empty{})
+		if err != nil {
+			// Not set up plugin is useless
+			p.kill()
+			return nil, "", errors.New(status.Convert(err).Message())
+		}
+	}
+
+	return p.conn, p.secret, nil
+}
+
+/*
+ * Start the executable and connect to the address from its handshake
+ */
+func (p *process) launch() error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("Can't generate a secret: " + err.Error())
+	}
+
+	secret := hex.EncodeToString(b)
+
+	cmd := exec.Command(p.path)
+	cmd.Env = append(os.Environ(),
+		envSecret+"="+secret,
+		envMessageSize+"="+strconv.Itoa(p.messageSize))
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("Can't open plugin's stdin: " + err.Error())
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("Can't open plugin's stdout: " + err.Error())
+	}
+
+	p.launched = time.Now()
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Can't start plugin '%s': %s", p.path, err.Error())
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	addr, err := handshake(bufio.NewReader(stdout), exited)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		return fmt.Errorf("Plugin '%s' handshake failed: %s", p.path, err.Error())
+	}
+
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(
+			grpc.CallContentSubtype(codecName),
+			grpc.MaxCallRecvMsgSize(p.messageSize),
+			grpc.MaxCallSendMsgSize(p.messageSize)))
+	if err != nil {
+		_ = cmd.Process.Kill()
+		return fmt.Errorf("Can't connect to plugin '%s': %s", p.path, err.Error())
+	}
+
+	p.cmd = cmd
+	p.stdin = stdin
+	p.conn = conn
+	p.secret = secret
+	p.exited = exited
+
+	return nil
+}
+
+/*
+ * Read plugin's address from the first line of its output.
+ * The rest of the output is passed to the core's stdout
+ */
+func handshake(stdout *bufio.Reader, exited chan struct{}) (string, error) {
+	line := make(chan string, 1)
+
+	go func() {
+		s, _ := stdout.ReadString('\n')
+		line <- s
+
+		_, _ = io.Copy(os.Stdout, stdout)
+	}()
+
+	var s string
+
+	select {
+	case s = <-line:
+	case <-exited:
+		return "", fmt.Errorf("Plugin has exited")
+	case <-time.After(handshakeTimeout):
+		return "", fmt.Errorf("Timeout")
+	}
+
+	parts := strings.Split(strings.TrimSpace(s), "|")
+	if len(parts) != 3 || parts[0] != handshakePrefix {
+		return "", fmt.Errorf("Unexpected handshake: %q", s)
+	}
+
+	if parts[1] != strconv.Itoa(ProtocolVersion) {
+		return "", fmt.Errorf("Unsupported protocol version %s, %d expected", parts[1], ProtocolVersion)
+	}
+
+	return parts[2], nil
+}
+
+/*
+ * Kill the process and forget it, so the next call launches a new one.
+ * Caller must hold the lock
+ */
+func (p *process) kill() {
+	p.conn.Close()
+	p.stdin.Close()
+	_ = p.cmd.Process.Kill()
+	<-p.exited
+
+	p.cmd = nil
+	p.stdin = nil
+	p.conn = nil
+	p.secret = ""
+	p.exited = nil
+}
+
+/*
+ * Check whether the running process has exited
+ */
+func (p *process) crashed() bool {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.exited == nil {
+		return false
+	}
+
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+ * Stop the plugin gracefully, or kill it when it doesn't respond
+ */
+func (p *process) stop(ctx context.Context) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.stopped = true
+
+	if p.conn == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, stopTimeout)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, metadataSecret, p.secret)
+
+	err := p.conn.Invoke(ctx, "/"+pluginService+"/Stop", &Empty{}, &Empty{})
+	if status.Code(err) == codes.Unavailable {
+		// Process is gone already
+		err = nil
+	}
+
+	p.conn.Close()
+	p.conn = nil
+
+	// Plugin exits when its stdin is closed
+	p.stdin.Close()
+
+	select {
+	case <-p.exited:
+	case <-ctx.Done():
+		_ = p.cmd.Process.Kill()
+	}
+
+	if err != nil {
+		return errors.New(status.Convert(err).Message())
+	}
+
+	return nil
+}
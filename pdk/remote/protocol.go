@@ -0,0 +1,164 @@
+/*
+ * Out-of-process plugins.
+ *
+ * Plugin runs as a separate executable and serves "pdk.SourcePlugin" or
+ * "pdk.ProcessorPlugin" over gRPC, so it doesn't need to be built with the same
+ * toolchain and modules versions as the core, and its panic doesn't crash the service.
+ *
+ * Core launches the executable with a random secret in the environment.
+ * Plugin listens on a loopback address and prints the handshake line to stdout:
+ *
+ *     graphoscope-plugin|<protocol version>|<address>
+ *
+ * Every request carries the secret, so other local processes can't use the plugin.
+ * Plugin exits when its stdin is closed, for example when the core stops
+ */
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+const (
+	// Version of the protocol, core refuses plugins of a different one
+	ProtocolVersion = 1
+
+	// File extension of the plugins executables
+	Extension = ".grpc"
+
+	// First part of the handshake line
+	handshakePrefix = "graphoscope-plugin"
+
+	// Environment variable with a secret of the launched plugin
+	envSecret = "GRAPHOSCOPE_PLUGIN_SECRET"
+
+	// Environment variable with the max size of the messages in bytes
+	envMessageSize = "GRAPHOSCOPE_PLUGIN_MESSAGE_SIZE"
+
+	// Max size of the messages in bytes when it's not configured.
+	// gRPC's default of 4 MB is too small for the large search results
+	DefaultMessageSize = 64 << 20
+
+	// Request's metadata key with a secret
+	metadataSecret = "graphoscope-secret"
+
+	// Messages codec, no generated code is needed
+	codecName = "json"
+
+	// Service of the methods common for all the plugins,
+	// and services of the different plugin types
+	pluginService    = "graphoscope.pdk.Plugin"
+	sourceService    = "graphoscope.pdk.Source"
+	processorService = "graphoscope.pdk.Processor"
+
+	// Types of the plugins
+	KindSource    = "source"
+	KindProcessor = "processor"
+)
+
+/*
+ * Plugin's description
+ */
+type InfoResponse struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+/*
+ * Instance's definition, YAML encoded
+ * the same way as the definition files
+ */
+type SetupRequest struct {
+	Definition []byte `json:"definition"`
+
+	// Max amount of entries to return, for the data sources only
+	Limit int `json:"limit,omitempty"`
+}
+
+type FieldsResponse struct {
+	Fields []string `json:"fields"`
+}
+
+/*
+ * Query to execute, data source's plugin parses it again
+ */
+type SearchRequest struct {
+	SQL string `json:"sql"`
+}
+
+type SearchResponse struct {
+	Relations []map[string]interface{} `json:"relations"`
+	Stats     map[string]interface{}   `json:"stats,omitempty"`
+	Debug     map[string]interface{}   `json:"debug,omitempty"`
+}
+
+/*
+ * Query features declared by the plugin, nil if not declared
+ */
+type CapabilitiesResponse struct {
+	Capabilities *pdk.Capabilities `json:"capabilities,omitempty"`
+}
+
+type ExplainResponse struct {
+	Query interface{} `json:"query"`
+}
+
+/*
+ * Data to process and its result
+ */
+type ProcessMessage struct {
+	Relations []map[string]interface{} `json:"relations"`
+}
+
+type Empty struct{}
+
+/*
+ * JSON codec of the gRPC messages
+ */
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+/*
+ * Describe a unary gRPC method without a generated code
+ */
+func method(service, name string, request func() interface{}, call func(context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := request()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+
+			if interceptor == nil {
+				return call(ctx, req)
+			}
+
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + service + "/" + name}
+			return interceptor(ctx, req, info, call)
+		},
+	}
+}
@@ -0,0 +1,329 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+// Environment variable telling the test binary to run as a plugin
+const envHelper = "GRAPHOSCOPE_TEST_PLUGIN"
+
+/*
+ * Test binary launched by the tests is a plugin itself,
+ * its behavior depends on the "envHelper" value
+ */
+func TestMain(m *testing.M) {
+	switch os.Getenv(envHelper) {
+	case "":
+		os.Exit(m.Run())
+
+	case "source":
+		if err := ServeSource("test", "1.0", &testSource{}); err != nil {
+			os.Exit(1)
+		}
+
+	case "processor":
+		if err := ServeProcessor("test-processor", "1.0", &testProcessor{}); err != nil {
+			os.Exit(1)
+		}
+
+	case "version":
+		fmt.Printf("%s|%d|127.0.0.1:1\n", handshakePrefix, ProtocolVersion+1)
+		_, _ = io.Copy(io.Discard, os.Stdin)
+
+	case "hanging":
+		plugin := &testSource{hang: true}
+		_ = ServeSource("test", "1.0", plugin)
+
+		// Set up plugin ignores the closed stdin too
+		if plugin.conf != nil {
+			select {}
+		}
+	}
+
+	os.Exit(0)
+}
+
+/*
+ * Data source plugin returning the name of its definition,
+ * so a lost setup is noticed
+ */
+type testSource struct {
+	conf *pdk.Source
+
+	// Whether "Stop" of the set up plugin never returns.
+	// Not set up plugin is stopped right after its info is received
+	hang bool
+}
+
+func (s *testSource) Conf() *pdk.Source { return s.conf }
+
+func (s *testSource) Setup(conf *pdk.Source, limit int) error {
+	s.conf = conf
+	return nil
+}
+
+func (s *testSource) Fields() ([]string, error) { return []string{"ip"}, nil }
+
+func (s *testSource) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	if s.conf == nil {
+		return nil, nil, nil, fmt.Errorf("Not set up")
+	}
+
+	switch sqlparser.String(stmt.Where.Expr) {
+	case "ip = 'crash'":
+		os.Exit(1)
+
+	case "ip = 'large'":
+		return largeRelations(), nil, nil, nil
+	}
+
+	return []map[string]interface{}{
+		{"from": map[string]interface{}{"id": s.conf.Name}},
+	}, nil, nil, nil
+}
+
+func (s *testSource) Stop() error {
+	if s.hang && s.conf != nil {
+		select {}
+	}
+
+	return nil
+}
+
+/*
+ * Processor plugin returning the received relations
+ */
+type testProcessor struct {
+	conf *pdk.Processor
+}
+
+func (p *testProcessor) Conf() *pdk.Processor { return p.conf }
+
+func (p *testProcessor) Setup(conf *pdk.Processor) error {
+	p.conf = conf
+	return nil
+}
+
+func (p *testProcessor) Process(relations []map[string]interface{}) ([]map[string]interface{}, error) {
+	return relations, nil
+}
+
+func (p *testProcessor) Stop() error { return nil }
+
+/*
+ * Relations larger than gRPC's default message size of 4 MB
+ */
+func largeRelations() []map[string]interface{} {
+	relations := []map[string]interface{}{}
+	attribute := strings.Repeat("x", 1000)
+
+	for i := 0; i < 6000; i++ {
+		relations = append(relations, map[string]interface{}{
+			"from": map[string]interface{}{"id": fmt.Sprintf("10.0.0.%d", i), "attributes": attribute},
+		})
+	}
+
+	return relations
+}
+
+/*
+ * Open the test binary as a plugin of the given behavior
+ */
+func open(t *testing.T, helper string) (string, string, interface{}, error) {
+	t.Helper()
+	t.Setenv(envHelper, helper)
+
+	return Open(os.Args[0], 0)
+}
+
+/*
+ * Launch and set up a data source plugin
+ */
+func setupSource(t *testing.T, helper string) *Source {
+	t.Helper()
+
+	_, _, plugin, err := open(t, helper)
+	if err != nil {
+		t.Fatalf("Can't open plugin: %s", err.Error())
+	}
+
+	source, ok := plugin.(*Source)
+	if !ok {
+		t.Fatalf("Invalid plugin type: %T", plugin)
+	}
+
+	source = source.Clone().(*Source)
+	if err := source.Setup(&pdk.Source{Name: "dns"}, 100); err != nil {
+		t.Fatalf("Can't set up plugin: %s", err.Error())
+	}
+
+	return source
+}
+
+/*
+ * Search by a single "ip" filter
+ */
+func search(source *Source, ip string) ([]map[string]interface{}, error) {
+	stmt, err := sqlparser.Parse("SELECT * FROM t WHERE ip='" + ip + "'")
+	if err != nil {
+		return nil, err
+	}
+
+	relations, _, _, err := source.Search(stmt.(*sqlparser.Select))
+	return relations, err
+}
+
+/*
+ * Test plugin's handshake and info
+ */
+func TestOpen(t *testing.T) {
+	name, version, plugin, err := open(t, "source")
+	if err != nil {
+		t.Fatalf("Can't open plugin: %s", err.Error())
+	}
+
+	if name != "test" || version != "1.0" {
+		t.Errorf("Invalid plugin info: %s %s, expected: test 1.0", name, version)
+	}
+
+	if _, ok := plugin.(*Source); !ok {
+		t.Errorf("Invalid plugin type: %T, expected: *Source", plugin)
+	}
+
+	_, _, plugin, err = open(t, "processor")
+	if err != nil {
+		t.Fatalf("Can't open processor: %s", err.Error())
+	}
+
+	if _, ok := plugin.(*Processor); !ok {
+		t.Errorf("Invalid plugin type: %T, expected: *Processor", plugin)
+	}
+}
+
+/*
+ * Test plugin of another protocol version is refused
+ */
+func TestOpenVersion(t *testing.T) {
+	_, _, _, err := open(t, "version")
+	if err == nil {
+		t.Fatalf("Plugin of another protocol version is accepted")
+	}
+
+	if !strings.Contains(err.Error(), "Unsupported protocol version") {
+		t.Errorf("Invalid error: %s", err.Error())
+	}
+}
+
+/*
+ * Test messages larger than gRPC's default limit are accepted both ways
+ */
+func TestLargeMessages(t *testing.T) {
+	source := setupSource(t, "source")
+	defer source.Stop()
+
+	relations, err := search(source, "large")
+	if err != nil {
+		t.Fatalf("Can't receive large search results: %s", err.Error())
+	}
+
+	if len(relations) != len(largeRelations()) {
+		t.Errorf("%d relations received, expected: %d", len(relations), len(largeRelations()))
+	}
+
+	_, _, plugin, err := open(t, "processor")
+	if err != nil {
+		t.Fatalf("Can't open processor: %s", err.Error())
+	}
+
+	processor := plugin.(*Processor).Clone()
+	if err := processor.Setup(&pdk.Processor{Name: "echo"}); err != nil {
+		t.Fatalf("Can't set up processor: %s", err.Error())
+	}
+	defer processor.Stop()
+
+	relations, err = processor.Process(largeRelations())
+	if err != nil {
+		t.Fatalf("Can't process large batch: %s", err.Error())
+	}
+
+	if len(relations) != len(largeRelations()) {
+		t.Errorf("%d relations processed, expected: %d", len(relations), len(largeRelations()))
+	}
+}
+
+/*
+ * Test crashed plugin is launched again after a delay
+ * and set up the same way
+ */
+func TestRestart(t *testing.T) {
+	source := setupSource(t, "source")
+	defer source.Stop()
+
+	if _, err := search(source, "crash"); err == nil {
+		t.Fatalf("Crashed plugin returns no error")
+	}
+
+	// Wait for the process to exit
+	deadline := time.Now().Add(handshakeTimeout)
+	for !source.process.crashed() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Plugin hasn't exited")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Crashing plugin is not restarted immediately
+	_, err := search(source, "10.10.10.10")
+	if err == nil || !strings.Contains(err.Error(), "is restarting") {
+		t.Errorf("Plugin is restarted without a delay, error: %v", err)
+	}
+
+	time.Sleep(restartDelay)
+
+	relations, err := search(source, "10.10.10.10")
+	if err != nil {
+		t.Fatalf("Plugin is not restarted: %s", err.Error())
+	}
+
+	if len(relations) != 1 || relations[0]["from"].(map[string]interface{})["id"] != "dns" {
+		t.Errorf("Plugin is not set up after the restart: %v", relations)
+	}
+}
+
+/*
+ * Test plugin which doesn't respond is killed on stop
+ */
+func TestStopKill(t *testing.T) {
+	source := setupSource(t, "hanging")
+	exited := source.process.exited
+
+	start := time.Now()
+
+	if err := source.Stop(); err == nil {
+		t.Errorf("Not responding plugin is stopped without an error")
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatalf("Plugin is not killed")
+	}
+
+	if elapsed := time.Since(start); elapsed > stopTimeout+time.Second {
+		t.Errorf("Stop took %s, expected about %s", elapsed, stopTimeout)
+	}
+
+	if _, err := search(source, "10.10.10.10"); err == nil {
+		t.Errorf("Stopped plugin is used")
+	}
+}
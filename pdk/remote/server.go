@@ -0,0 +1,240 @@
+package remote
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+/*
+ * Serve the data source plugin to the core service.
+ * Must be called from the plugin's "main()", blocks until the core stops it
+ */
+func ServeSource(name, version string, plugin pdk.SourcePlugin) error {
+	info := &InfoResponse{Name: name, Version: version, Kind: KindSource}
+
+	desc := &grpc.ServiceDesc{
+		ServiceName: sourceService,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			method(sourceService, "Setup", newSetup, func(ctx context.Context, req interface{}) (interface{}, error) {
+				r := req.(*SetupRequest)
+
+				conf := &pdk.Source{}
+				if err := yaml.Unmarshal(r.Definition, conf); err != nil {
+					return nil, fmt.Errorf("Can't unmarshal definition: " + err.Error())
+				}
+
+				return &Empty{}, plugin.Setup(conf, r.Limit)
+			}),
+
+			method(sourceService, "Fields", newEmpty, func(ctx context.Context, req interface{}) (interface{}, error) {
+				fields, err := plugin.Fields()
+				return &FieldsResponse{Fields: fields}, err
+			}),
+
+			method(sourceService, "Capabilities", newEmpty, func(ctx context.Context, req interface{}) (interface{}, error) {
+				if p, ok := plugin.(pdk.CapableSourcePlugin); ok {
+					return &CapabilitiesResponse{Capabilities: p.Capabilities()}, nil
+				}
+
+				return &CapabilitiesResponse{}, nil
+			}),
+
+			method(sourceService, "Search", newSearch, func(ctx context.Context, req interface{}) (interface{}, error) {
+				stmt, err := parseSelect(req.(*SearchRequest).SQL)
+				if err != nil {
+					return nil, err
+				}
+
+				// Search runs in the request's goroutine,
+				// so the plugin's panic can be recovered
+				var relations []map[string]interface{}
+				var stats, debug map[string]interface{}
+
+				if p, ok := plugin.(pdk.ContextSourcePlugin); ok {
+					relations, stats, debug, err = p.SearchContext(ctx, stmt)
+				} else {
+					relations, stats, debug, err = plugin.Search(stmt)
+				}
+
+				if err != nil {
+					return nil, err
+				}
+
+				return &SearchResponse{Relations: relations, Stats: stats, Debug: debug}, nil
+			}),
+
+			method(sourceService, "Explain", newSearch, func(ctx context.Context, req interface{}) (interface{}, error) {
+				explainer, ok := plugin.(pdk.ExplainSourcePlugin)
+				if !ok {
+					return nil, status.Error(codes.Unimplemented, "Plugin can't explain queries")
+				}
+
+				stmt, err := parseSelect(req.(*SearchRequest).SQL)
+				if err != nil {
+					return nil, err
+				}
+
+				query, err := explainer.Explain(stmt)
+				return &ExplainResponse{Query: query}, err
+			}),
+		},
+	}
+
+	return serve(info, desc, plugin.Stop)
+}
+
+/*
+ * Serve the processor plugin to the core service.
+ * Must be called from the plugin's "main()", blocks until the core stops it
+ */
+func ServeProcessor(name, version string, plugin pdk.ProcessorPlugin) error {
+	info := &InfoResponse{Name: name, Version: version, Kind: KindProcessor}
+
+	desc := &grpc.ServiceDesc{
+		ServiceName: processorService,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			method(processorService, "Setup", newSetup, func(ctx context.Context, req interface{}) (interface{}, error) {
+				conf := &pdk.Processor{}
+				if err := yaml.Unmarshal(req.(*SetupRequest).Definition, conf); err != nil {
+					return nil, fmt.Errorf("Can't unmarshal definition: " + err.Error())
+				}
+
+				return &Empty{}, plugin.Setup(conf)
+			}),
+
+			method(processorService, "Process", newProcess, func(ctx context.Context, req interface{}) (interface{}, error) {
+				relations, err := plugin.Process(req.(*ProcessMessage).Relations)
+				return &ProcessMessage{Relations: relations}, err
+			}),
+		},
+	}
+
+	return serve(info, desc, plugin.Stop)
+}
+
+/*
+ * Listen on a loopback address and tell it to the core
+ */
+func serve(info *InfoResponse, desc *grpc.ServiceDesc, stop func() error) error {
+	secret := os.Getenv(envSecret)
+	if secret == "" {
+		return fmt.Errorf("Graphoscope plugin must be launched by the core service")
+	}
+
+	// Core sends its own limit, so both sides accept the same messages
+	messageSize := DefaultMessageSize
+
+	if size := os.Getenv(envMessageSize); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("Invalid max message size: %s", size)
+		}
+
+		messageSize = n
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("Can't listen: " + err.Error())
+	}
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor(secret)),
+		grpc.MaxRecvMsgSize(messageSize),
+		grpc.MaxSendMsgSize(messageSize))
+
+	// Methods common for all the plugin types.
+	// Plugin's "Stop" stops the whole process
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: pluginService,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			method(pluginService, "Info", newEmpty, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return info, nil
+			}),
+
+			method(pluginService, "Stop", newEmpty, func(ctx context.Context, req interface{}) (interface{}, error) {
+				err := stop()
+				go server.GracefulStop()
+
+				return &Empty{}, err
+			}),
+		},
+	}, struct{}{})
+
+	server.RegisterService(desc, struct{}{})
+
+	// Core doesn't need the plugin anymore
+	go func() {
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		server.Stop()
+	}()
+
+	_, err = fmt.Printf("%s|%d|%s\n", handshakePrefix, ProtocolVersion, listener.Addr().String())
+	if err != nil {
+		return fmt.Errorf("Can't send handshake: " + err.Error())
+	}
+
+	return server.Serve(listener)
+}
+
+/*
+ * Check request's secret and turn plugin's panics into errors,
+ * so a single failed request doesn't stop the plugin
+ */
+func interceptor(secret string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		given := md.Get(metadataSecret)
+
+		if len(given) != 1 || subtle.ConstantTimeCompare([]byte(given[0]), []byte(secret)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "Invalid plugin secret")
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				err = status.Errorf(codes.Internal, "Plugin panic: %v", r)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+/*
+ * Parse the query given by the core
+ */
+func parseSelect(sql string) (*sqlparser.Select, error) {
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse query: " + err.Error())
+	}
+
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected query type: %T", stmt)
+	}
+
+	return sel, nil
+}
+
+func newEmpty() interface{}   { return &Empty{} }
+func newSetup() interface{}   { return &SetupRequest{} }
+func newSearch() interface{}  { return &SearchRequest{} }
+func newProcess() interface{} { return &ProcessMessage{} }
@@ -0,0 +1,147 @@
+package pdk
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+// Debug info field with the amount of the data source's rows
+// turned into the relations, to know where the next page starts
+const DebugRows = "rows"
+
+/*
+ * Single search results to pass between goroutines
+ */
+type searchResult struct {
+	results []map[string]interface{}
+	stats   map[string]interface{}
+	debug   map[string]interface{}
+	err     error
+}
+
+/*
+ * Execute the given query by any data source plugin
+ * until the context is done.
+ *
+ * Plugins implementing "ContextSourcePlugin" receive the context directly.
+ * For the older plugins the search runs in a background and
+ * its results are abandoned as soon as the context is done,
+ * so the caller doesn't need to wait for them
+ */
+func Search(ctx context.Context, plugin SourcePlugin, stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+
+	// Do not start a search which is canceled already
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if p, ok := plugin.(ContextSourcePlugin); ok {
+		return p.SearchContext(ctx, stmt)
+	}
+
+	// Buffered to let the abandoned search finish
+	// without blocking forever
+	done := make(chan *searchResult, 1)
+
+	go func() {
+		results, stats, debug, err := plugin.Search(stmt)
+		done <- &searchResult{results, stats, debug, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.results, r.stats, r.debug, r.err
+	case <-ctx.Done():
+		return nil, nil, nil, ctx.Err()
+	}
+}
+
+/*
+ * Execute the given query by any data source plugin
+ * starting from the given position of the paginated results,
+ * empty for the first page.
+ * Returns also the position of the next page, empty when there is no more data.
+ *
+ * Plugins implementing "PagedSourcePlugin" track their positions themselves.
+ * For the other plugins position is the LIMIT's offset,
+ * increased by the amount of rows the plugin has turned into the relations.
+ * Plugins report it by the "DebugRows" debug info field,
+ * otherwise every relation is counted as a single row
+ */
+func SearchPage(ctx context.Context, plugin SourcePlugin, stmt *sqlparser.Select, position string) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, string, error) {
+
+	// Do not start a search which is canceled already
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, "", err
+	}
+
+	if p, ok := plugin.(PagedSourcePlugin); ok {
+		return p.SearchPage(ctx, stmt, position)
+	}
+
+	// Nothing to paginate by
+	if stmt.Limit == nil || stmt.Limit.Rowcount == nil {
+		results, stats, debug, err := Search(ctx, plugin, stmt)
+		return results, stats, debug, "", err
+	}
+
+	rowcount, err := strconv.Atoi(sqlparser.String(stmt.Limit.Rowcount))
+	if err != nil {
+		return nil, nil, nil, "", fmt.Errorf("Invalid LIMIT rowcount: %s", sqlparser.String(stmt.Limit.Rowcount))
+	}
+
+	offset := 0
+
+	if position != "" {
+		offset, err = strconv.Atoi(position)
+		if err != nil || offset < 0 {
+			return nil, nil, nil, "", fmt.Errorf("Invalid page position: %s", position)
+		}
+
+		stmt.Limit.Offset = sqlparser.NewIntVal([]byte(position))
+
+	} else if stmt.Limit.Offset != nil {
+		offset, _ = strconv.Atoi(sqlparser.String(stmt.Limit.Offset))
+	}
+
+	results, stats, debug, err := Search(ctx, plugin, stmt)
+	if err != nil {
+		return nil, nil, debug, "", err
+	}
+
+	rows := consumedRows(debug, len(results), rowcount)
+
+	// Page is not full, so there is no more data.
+	// Limited page continues right after the last used row
+	if (stats == nil && rows < rowcount) || rows == 0 {
+		return results, stats, debug, "", nil
+	}
+
+	return results, stats, debug, strconv.Itoa(offset + rows), nil
+}
+
+/*
+ * Get the amount of rows the plugin has turned into the relations.
+ * Receives the amount of relations to use when the plugin doesn't report it
+ * and the LIMIT's rowcount, as more rows can't be received
+ */
+func consumedRows(debug map[string]interface{}, relations, rowcount int) int {
+	rows := relations
+
+	// Remote plugins' numbers come from JSON
+	switch v := debug[DebugRows].(type) {
+	case int:
+		rows = v
+	case float64:
+		rows = int(v)
+	}
+
+	if rows > rowcount {
+		return rowcount
+	}
+
+	return rows
+}
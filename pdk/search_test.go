@@ -0,0 +1,214 @@
+package pdk
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+/*
+ * SQL data source returning 2 relations per row,
+ * which takes the LIMIT's offset and rowcount into account
+ */
+type rowsPlugin struct {
+	source *Source
+	limit  int
+	rows   []map[string]interface{}
+
+	// Whether to report the amount of used rows
+	report bool
+}
+
+func (p *rowsPlugin) Conf() *Source { return p.source }
+
+func (p *rowsPlugin) Setup(source *Source, limit int) error {
+	p.source = source
+	p.limit = limit
+	return nil
+}
+
+func (p *rowsPlugin) Fields() ([]string, error) { return []string{"ip", "domain", "country"}, nil }
+
+func (p *rowsPlugin) Stop() error { return nil }
+
+func (p *rowsPlugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	offset := 0
+	if stmt.Limit.Offset != nil {
+		offset, _ = strconv.Atoi(sqlparser.String(stmt.Limit.Offset))
+	}
+
+	rowcount, _ := strconv.Atoi(sqlparser.String(stmt.Limit.Rowcount))
+
+	builder := NewRelationBuilder(p.source, p.limit)
+
+	for i := offset; i < len(p.rows) && i < offset+rowcount; i++ {
+		if !builder.Add(p.rows[i]) {
+			break
+		}
+	}
+
+	results, stats, err := builder.Results()
+
+	debug := make(map[string]interface{})
+	if p.report {
+		debug[DebugRows] = builder.Entries()
+	}
+
+	return results, stats, debug, err
+}
+
+/*
+ * Create a plugin with the given amount of rows
+ */
+func newRowsPlugin(amount, limit int, report bool) *rowsPlugin {
+	source := &Source{
+		Name: "sql",
+		Relations: []*Relation{
+			{From: &Node{ID: "ip", Group: "ip", Search: "ip"}, To: &Node{ID: "domain", Group: "domain", Search: "domain"}},
+			{From: &Node{ID: "ip", Group: "ip", Search: "ip"}, To: &Node{ID: "country", Group: "country", Search: "country"}},
+		},
+	}
+
+	p := &rowsPlugin{report: report}
+	p.Setup(source, limit)
+
+	for i := 0; i < amount; i++ {
+		p.rows = append(p.rows, map[string]interface{}{
+			"ip":      fmt.Sprintf("10.0.0.%d", i),
+			"domain":  fmt.Sprintf("%d.example.com", i),
+			"country": "LV",
+		})
+	}
+
+	return p
+}
+
+/*
+ * Request all the pages and collect the unique IPs
+ */
+func searchAllPages(t *testing.T, plugin SourcePlugin, query string) (map[interface{}]bool, int) {
+	t.Helper()
+
+	ips := make(map[interface{}]bool)
+	position := ""
+	requests := 0
+
+	for {
+		ast, err := sqlparser.Parse(query)
+		if err != nil {
+			t.Fatalf("Can't parse '%s': %s", query, err.Error())
+		}
+
+		results, _, _, next, err := SearchPage(context.Background(), plugin, ast.(*sqlparser.Select), position)
+		if err != nil {
+			t.Fatalf("Can't search page '%s': %s", position, err.Error())
+		}
+
+		requests++
+		if requests > 100 {
+			t.Fatalf("Pagination doesn't stop")
+		}
+
+		for _, relation := range results {
+			ips[relation["from"].(map[string]interface{})["id"]] = true
+		}
+
+		if next == "" {
+			return ips, requests
+		}
+
+		position = next
+	}
+}
+
+/*
+ * Test pagination by the LIMIT's offset
+ */
+func TestSearchPageOffset(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     int
+		limit    int
+		report   bool
+		requests int
+	}{
+		// 10 rows by 4: 4, 4, 2 - the last page is not full
+		{"full pages", 10, 100, true, 3},
+		// 8 rows by 4: 4, 4, 0 - the end is not known before the empty page
+		{"exact pages", 8, 100, true, 3},
+		// Limit of 5 relations allows 3 rows of the 4 per page
+		{"limited pages", 10, 5, true, 4},
+		// Every relation counts as a row, so the last page of 2 rows looks full
+		{"not reported rows", 10, 100, false, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plugin := newRowsPlugin(test.rows, test.limit, test.report)
+
+			ips, requests := searchAllPages(t, plugin, "SELECT * FROM t WHERE ip='x' LIMIT 0,4")
+
+			if len(ips) != test.rows {
+				t.Errorf("%d rows received, expected: %d", len(ips), test.rows)
+			}
+
+			if requests != test.requests {
+				t.Errorf("%d pages requested, expected: %d", requests, test.requests)
+			}
+		})
+	}
+}
+
+/*
+ * Test rows amount reported by the plugins
+ */
+func TestConsumedRows(t *testing.T) {
+	tests := []struct {
+		debug     map[string]interface{}
+		relations int
+		expected  int
+	}{
+		{map[string]interface{}{DebugRows: 3}, 10, 3},
+		{map[string]interface{}{DebugRows: float64(2)}, 10, 2},
+		{map[string]interface{}{DebugRows: 50}, 10, 4},
+		{nil, 3, 3},
+		{nil, 10, 4},
+	}
+
+	for _, test := range tests {
+		if rows := consumedRows(test.debug, test.relations, 4); rows != test.expected {
+			t.Errorf("Invalid rows of %v with %d relations: %d, expected: %d", test.debug, test.relations, rows, test.expected)
+		}
+	}
+}
+
+/*
+ * Test pagination by the LIMIT's offset when the rows repeat,
+ * so a page gives fewer unique relations than rows
+ */
+func TestSearchPageDuplicates(t *testing.T) {
+	plugin := newRowsPlugin(5, 100, true)
+
+	// Every row is returned 3 times in a row
+	rows := []map[string]interface{}{}
+	for _, row := range plugin.rows {
+		rows = append(rows, row, row, row)
+	}
+	plugin.rows = rows
+
+	// Page of 6 rows gives only 4 relations,
+	// which must not be taken as the end of data
+	ips, requests := searchAllPages(t, plugin, "SELECT * FROM t WHERE ip='x' LIMIT 0,6")
+
+	if len(ips) != 5 {
+		t.Errorf("%d rows received, expected: 5", len(ips))
+	}
+
+	// 15 rows by 6: 6, 6, 3
+	if requests != 3 {
+		t.Errorf("%d pages requested, expected: 3", requests)
+	}
+}
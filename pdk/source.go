@@ -21,6 +21,8 @@ type Source struct {
 	IncludeDatetime bool              `yaml:"includeDatetime"`
 	SupportsSQL     bool              `yaml:"supportsSQL"`
 	Timeout         time.Duration     `yaml:"timeout"`
+	MaxConcurrency  int               `yaml:"maxConcurrency"`
+	RateLimit       float64           `yaml:"rateLimit"`
 	Access          map[string]string `yaml:"access"`
 	QueryFields     []string          `yaml:"queryFields"`
 	IncludeFields   []string          `yaml:"includeFields"`
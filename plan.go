@@ -0,0 +1,953 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+/*
+ * Structure of a single query to send to the data source
+ */
+type Query struct {
+	// Parsed SQL query to give to the data source
+	Select *sqlparser.Select
+
+	// Filters the data source can't handle itself,
+	// applied to the received relations in memory. Nil if not needed
+	Filter sqlparser.Expr
+
+	// User defined fields replacements,
+	// to find filtered fields in the received relations
+	replace map[string]string
+
+	// Compiled LIKE and REGEXP patterns of the filter
+	patterns map[*sqlparser.ComparisonExpr]*regexp.Regexp
+}
+
+/*
+ * Negated versions of the comparison operators
+ */
+var negations = map[string]string{
+	sqlparser.EqualStr:        sqlparser.NotEqualStr,
+	sqlparser.NotEqualStr:     sqlparser.EqualStr,
+	sqlparser.LessThanStr:     sqlparser.GreaterEqualStr,
+	sqlparser.GreaterEqualStr: sqlparser.LessThanStr,
+	sqlparser.GreaterThanStr:  sqlparser.LessEqualStr,
+	sqlparser.LessEqualStr:    sqlparser.GreaterThanStr,
+	sqlparser.InStr:           sqlparser.NotInStr,
+	sqlparser.NotInStr:        sqlparser.InStr,
+	sqlparser.LikeStr:         sqlparser.NotLikeStr,
+	sqlparser.NotLikeStr:      sqlparser.LikeStr,
+	sqlparser.RegexpStr:       sqlparser.NotRegexpStr,
+	sqlparser.NotRegexpStr:    sqlparser.RegexpStr,
+	sqlparser.BetweenStr:      sqlparser.NotBetweenStr,
+	sqlparser.NotBetweenStr:   sqlparser.BetweenStr,
+}
+
+/*
+ * Split a query into a list of independent queries
+ * for the data sources that don't support SQL directly.
+ *
+ * WHERE filters are rewritten into a disjunctive normal form -
+ * OR of AND groups. Every AND group gives one "field='value'" filter,
+ * and a "datetime BETWEEN ..." range if present, to the data source.
+ * The rest of the filters, like "!=", "LIKE", "NOT IN" or ranges,
+ * are applied to the received relations later as a post-filter,
+ * unless plugin declares their operators as supported.
+ * "datetime" comparisons narrow the pushed down time range,
+ * other "datetime" filters can't be post-filtered and are refused.
+ *
+ * Supported query examples:
+ *     - ip='8.8.8.8'
+ *     - ip='8.8.8.8' OR ip='1.2.3.4'
+ *     - domain IN ('example.com','google.com') AND datetime BETWEEN ...
+ *     - ip='8.8.8.8' AND NOT (port IN (80,443) OR name LIKE '%test%')
+ *     - (ip='8.8.8.8' OR domain='example.com') AND size>=1000
+ */
+func splitQuery(expr *sqlparser.Select, caps *pdk.Capabilities) ([]*Query, error) {
+
+	groups, err := normalize(expr.Where.Expr, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Queries to send, grouped by the pushed down filters,
+	// so the same data source request is done only once
+	queries := []*Query{}
+	residuals := map[string][][]sqlparser.Expr{}
+
+	for _, group := range groups {
+		pushed, rest := pushDown(group, caps)
+		if pushed == nil {
+			return nil, fmt.Errorf("Every OR part of the query must contain a \"field='value'\" filter: %s", sqlparser.String(conjunction(group)))
+		}
+
+		// Relations carry no "datetime" field to post-filter by
+		for _, e := range rest {
+			if contains(e, timeFiltered) {
+				return nil, fmt.Errorf("%s filter of the \"datetime\" field is not supported by this data source", firstFilter(e, timeFiltered))
+			}
+		}
+
+		// Replace WHERE of a shallow copy,
+		// then SQL -> string -> SQL as it is hard to deep copy 'expr'
+		// to prevent rewriting it
+		clone := *expr
+		clone.Where = &sqlparser.Where{
+			Type: sqlparser.WhereStr,
+			Expr: pushed,
+		}
+
+		sql := sqlparser.String(&clone)
+
+		if _, ok := residuals[sql]; !ok {
+			ast, err := sqlparser.Parse(sql)
+			if err != nil {
+				return nil, fmt.Errorf("Can't parse splitted SQL query: %s", err.Error())
+			}
+
+			queries = append(queries, &Query{Select: ast.(*sqlparser.Select)})
+			residuals[sql] = [][]sqlparser.Expr{}
+		}
+
+		residuals[sql] = append(residuals[sql], rest)
+	}
+
+	// Combine post-filters of the AND groups with the same pushed filters.
+	// No post-filter is needed when at least one group has nothing left
+	for _, query := range queries {
+		list := residuals[sqlparser.String(query.Select)]
+		parts := []sqlparser.Expr{}
+
+		for _, rest := range list {
+			if len(rest) == 0 {
+				parts = nil
+				break
+			}
+
+			parts = append(parts, conjunction(rest))
+		}
+
+		if len(parts) != 0 {
+			query.Filter = disjunction(parts)
+		}
+	}
+
+	return queries, nil
+}
+
+/*
+ * Rewrite filters into a disjunctive normal form:
+ * a list of AND groups, any of which must match.
+ *
+ * NOT is pushed down to the single filters by inverting their operators,
+ * positive IN lists are expanded into the "field='value'" filters
+ */
+func normalize(expr sqlparser.Expr, negate bool) ([][]sqlparser.Expr, error) {
+	switch e := expr.(type) {
+	case *sqlparser.ParenExpr:
+		return normalize(e.Expr, negate)
+
+	case *sqlparser.NotExpr:
+		return normalize(e.Expr, !negate)
+
+	case *sqlparser.AndExpr:
+		if negate {
+			return normalizeOr(e.Left, e.Right, true)
+		}
+		return normalizeAnd(e.Left, e.Right, false)
+
+	case *sqlparser.OrExpr:
+		if negate {
+			return normalizeAnd(e.Left, e.Right, true)
+		}
+		return normalizeOr(e.Left, e.Right, false)
+
+	case *sqlparser.ComparisonExpr:
+		if _, ok := e.Left.(*sqlparser.ColName); !ok {
+			return nil, fmt.Errorf("Left side of the filter must be a field: %s", sqlparser.String(e))
+		}
+
+		operator := e.Operator
+		if operator == "<>" {
+			operator = sqlparser.NotEqualStr
+		}
+
+		if negate {
+			negated, ok := negations[operator]
+			if !ok {
+				return nil, fmt.Errorf("Operator can't be negated: %s", e.Operator)
+			}
+			operator = negated
+		}
+
+		// Expand "field IN (...)" into separate "field='value'" filters
+		if operator == sqlparser.InStr {
+			values, ok := e.Right.(sqlparser.ValTuple)
+			if !ok {
+				return nil, fmt.Errorf("IN requires a list of values: %s", sqlparser.String(e))
+			}
+
+			groups := [][]sqlparser.Expr{}
+
+			for _, value := range values {
+				groups = append(groups, []sqlparser.Expr{&sqlparser.ComparisonExpr{
+					Operator: sqlparser.EqualStr,
+					Left:     e.Left,
+					Right:    value,
+				}})
+			}
+
+			return groups, nil
+		}
+
+		if _, ok := negations[operator]; !ok {
+			return nil, fmt.Errorf("Unsupported operator: %s", e.Operator)
+		}
+
+		return [][]sqlparser.Expr{{&sqlparser.ComparisonExpr{
+			Operator: operator,
+			Left:     e.Left,
+			Right:    e.Right,
+			Escape:   e.Escape,
+		}}}, nil
+
+	case *sqlparser.RangeCond:
+		if _, ok := e.Left.(*sqlparser.ColName); !ok {
+			return nil, fmt.Errorf("Left side of the filter must be a field: %s", sqlparser.String(e))
+		}
+
+		operator := e.Operator
+		if negate {
+			operator = negations[operator]
+		}
+
+		return [][]sqlparser.Expr{{&sqlparser.RangeCond{
+			Operator: operator,
+			Left:     e.Left,
+			From:     e.From,
+			To:       e.To,
+		}}}, nil
+
+	case *sqlparser.FuncExpr:
+		if !pdk.IsCIDR(e) {
+			return nil, fmt.Errorf("Unsupported function: %s", sqlparser.String(e))
+		}
+
+		if negate {
+			return [][]sqlparser.Expr{{&sqlparser.NotExpr{Expr: e}}}, nil
+		}
+		return [][]sqlparser.Expr{{e}}, nil
+
+	default:
+		return nil, fmt.Errorf("Unsupported filter: %s", sqlparser.String(expr))
+	}
+}
+
+/*
+ * Normalize both sides of OR and join their AND groups
+ */
+func normalizeOr(left, right sqlparser.Expr, negate bool) ([][]sqlparser.Expr, error) {
+	l, err := normalize(left, negate)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := normalize(right, negate)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(l, r...), nil
+}
+
+/*
+ * Normalize both sides of AND and distribute it over their AND groups:
+ * (a OR b) AND (c OR d) -> (a AND c) OR (a AND d) OR (b AND c) OR (b AND d)
+ */
+func normalizeAnd(left, right sqlparser.Expr, negate bool) ([][]sqlparser.Expr, error) {
+	l, err := normalize(left, negate)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := normalize(right, negate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every combination of the groups becomes a separate query
+	if config.MaxSplitQueries != 0 && len(l) > 1 && len(r) > 1 && len(l)*len(r) > config.MaxSplitQueries {
+		return nil, fmt.Errorf("Query is too complex, max %d independent queries are allowed", config.MaxSplitQueries)
+	}
+
+	groups := make([][]sqlparser.Expr, 0, len(l)*len(r))
+
+	for _, lg := range l {
+		for _, rg := range r {
+			group := make([]sqlparser.Expr, 0, len(lg)+len(rg))
+			group = append(group, lg...)
+			group = append(group, rg...)
+			groups = append(groups, group)
+		}
+	}
+
+	return groups, nil
+}
+
+/*
+ * Pick the filters of the AND group the data source can handle itself:
+ * first "field='value'" and "datetime BETWEEN ..." range,
+ * and all the other filters with the operators declared by the plugin.
+ * "datetime" comparisons like "datetime > '...'" narrow the range,
+ * open ends are limited by the Unix epoch and the current time.
+ * Returns the filters to push down and the rest of the filters
+ */
+func pushDown(group []sqlparser.Expr, caps *pdk.Capabilities) (sqlparser.Expr, []sqlparser.Expr) {
+	var equal, datetime sqlparser.Expr
+	supported := []sqlparser.Expr{}
+	bounds := []*sqlparser.ComparisonExpr{}
+	rest := []sqlparser.Expr{}
+
+	// Whether the time range can be sent to the data source
+	ranged := caps == nil || caps.Supports(sqlparser.BetweenStr)
+
+	for _, expr := range group {
+		switch e := expr.(type) {
+		case *sqlparser.ComparisonExpr:
+			if equal == nil && e.Operator == sqlparser.EqualStr && (caps == nil || caps.Supports(e.Operator)) {
+				if _, ok := e.Right.(*sqlparser.SQLVal); ok {
+					equal = e
+					continue
+				}
+			}
+
+			if caps != nil && caps.Supports(e.Operator) {
+				supported = append(supported, e)
+				continue
+			}
+
+			if ranged && timeBound(e) {
+				bounds = append(bounds, e)
+				continue
+			}
+
+		case *sqlparser.RangeCond:
+			if datetime == nil && e.Operator == sqlparser.BetweenStr &&
+				sqlparser.String(e.Left) == "datetime" && ranged {
+
+				datetime = e
+				continue
+			}
+
+			if caps != nil && caps.Supports(e.Operator) {
+				supported = append(supported, e)
+				continue
+			}
+		}
+
+		rest = append(rest, expr)
+	}
+
+	if len(bounds) != 0 {
+		datetime = timeRange(datetime, bounds)
+	}
+
+	// Data source needs at least one filter besides the time range
+	if equal == nil && len(supported) == 0 {
+		if datetime != nil {
+			rest = append(rest, datetime)
+		}
+
+		return nil, rest
+	}
+
+	pushed := []sqlparser.Expr{}
+	if equal != nil {
+		pushed = append(pushed, equal)
+	}
+
+	pushed = append(pushed, supported...)
+	if datetime != nil {
+		pushed = append(pushed, datetime)
+	}
+
+	return conjunction(pushed), rest
+}
+
+/*
+ * Check whether the filter is a "datetime" comparison
+ * with a single value, which can be turned into a time range
+ */
+func timeBound(expr *sqlparser.ComparisonExpr) bool {
+	if sqlparser.String(expr.Left) != "datetime" {
+		return false
+	}
+
+	if _, ok := expr.Right.(*sqlparser.SQLVal); !ok {
+		return false
+	}
+
+	switch expr.Operator {
+	case sqlparser.GreaterThanStr, sqlparser.GreaterEqualStr,
+		sqlparser.LessThanStr, sqlparser.LessEqualStr:
+		return true
+	}
+
+	return false
+}
+
+/*
+ * Narrow the "datetime BETWEEN ..." range, nil if missing,
+ * by the "datetime" comparisons. The range includes its ends,
+ * so "datetime > '...'" includes the given time too
+ */
+func timeRange(between sqlparser.Expr, bounds []*sqlparser.ComparisonExpr) sqlparser.Expr {
+	from := time.Unix(0, 0).UTC().Format(timeFormat)
+	to := time.Now().UTC().Format(timeFormat)
+
+	if r, ok := between.(*sqlparser.RangeCond); ok {
+		from, to = literal(r.From), literal(r.To)
+	}
+
+	for _, bound := range bounds {
+		value := literal(bound.Right)
+
+		switch bound.Operator {
+		case sqlparser.GreaterThanStr, sqlparser.GreaterEqualStr:
+			if order(value, from) > 0 {
+				from = value
+			}
+		default:
+			if order(value, to) < 0 {
+				to = value
+			}
+		}
+	}
+
+	return &sqlparser.RangeCond{
+		Operator: sqlparser.BetweenStr,
+		Left:     &sqlparser.ColName{Name: sqlparser.NewColIdent("datetime")},
+		From:     sqlparser.NewStrVal([]byte(from)),
+		To:       sqlparser.NewStrVal([]byte(to)),
+	}
+}
+
+/*
+ * Check whether the single filter is a filter of the "datetime" field
+ */
+func timeFiltered(expr sqlparser.Expr) bool {
+	switch e := expr.(type) {
+	case *sqlparser.ComparisonExpr:
+		return sqlparser.String(e.Left) == "datetime"
+	case *sqlparser.RangeCond:
+		return sqlparser.String(e.Left) == "datetime"
+	}
+
+	return false
+}
+
+/*
+ * Join filters with AND
+ */
+func conjunction(list []sqlparser.Expr) sqlparser.Expr {
+	expr := list[0]
+	for _, e := range list[1:] {
+		expr = &sqlparser.AndExpr{Left: expr, Right: e}
+	}
+
+	return expr
+}
+
+/*
+ * Join AND groups with OR
+ */
+func disjunction(list []sqlparser.Expr) sqlparser.Expr {
+	expr := list[0]
+	for _, e := range list[1:] {
+		expr = &sqlparser.OrExpr{Left: expr, Right: e}
+	}
+
+	return expr
+}
+
+/*
+ * Apply the post-filter to the received relations.
+ * Relations without the filtered fields at all are kept,
+ * as the data source could split one entry into several relations.
+ * Relations usually contain no "datetime" field, so time filters
+ * are pushed down as a range or refused by "splitQuery()"
+ */
+func (q *Query) filter(relations []map[string]interface{}) []map[string]interface{} {
+	if q.Filter == nil {
+		return relations
+	}
+
+	filtered := make([]map[string]interface{}, 0, len(relations))
+
+	for _, relation := range relations {
+		if q.match(q.Filter, relation) {
+			filtered = append(filtered, relation)
+		}
+	}
+
+	return filtered
+}
+
+/*
+ * Check whether the relation matches the given normalized filter.
+ * Field can have several values in one relation: positive filters
+ * need any of them to match, negated ones need all of them
+ */
+func (q *Query) match(expr sqlparser.Expr, relation map[string]interface{}) bool {
+	switch e := expr.(type) {
+	case *sqlparser.AndExpr:
+		return q.match(e.Left, relation) && q.match(e.Right, relation)
+
+	case *sqlparser.OrExpr:
+		return q.match(e.Left, relation) || q.match(e.Right, relation)
+
+	case *sqlparser.ParenExpr:
+		return q.match(e.Expr, relation)
+
+	case *sqlparser.ComparisonExpr:
+		return matchValues(q.values(sqlparser.String(e.Left), relation), negated(e.Operator), func(value string) bool {
+			return q.compare(e, value)
+		})
+
+	case *sqlparser.FuncExpr:
+		return q.matchCIDR(e, relation, false)
+
+	case *sqlparser.NotExpr:
+		if f, ok := e.Expr.(*sqlparser.FuncExpr); ok {
+			return q.matchCIDR(f, relation, true)
+		}
+
+	case *sqlparser.RangeCond:
+		from, to := literal(e.From), literal(e.To)
+
+		return matchValues(q.values(sqlparser.String(e.Left), relation), negated(e.Operator), func(value string) bool {
+			between := order(value, from) >= 0 && order(value, to) <= 0
+			return between == (e.Operator == sqlparser.BetweenStr)
+		})
+	}
+
+	return false
+}
+
+/*
+ * Check whether the relation's IP addresses belong to the "cidr_match" network,
+ * or don't belong when negated
+ */
+func (q *Query) matchCIDR(expr *sqlparser.FuncExpr, relation map[string]interface{}, negate bool) bool {
+	field, network, err := pdk.ParseCIDR(expr)
+	if err != nil {
+		return false
+	}
+
+	return matchValues(q.values(field, relation), negate, func(value string) bool {
+		ip := net.ParseIP(value)
+		return (ip != nil && network.Contains(ip)) != negate
+	})
+}
+
+/*
+ * Check the field's values by the filter's condition:
+ * all of them for the negated filter, any of them otherwise.
+ * Relation without the field matches in both cases
+ */
+func matchValues(values []string, all bool, condition func(string) bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, value := range values {
+		if condition(value) != all {
+			return !all
+		}
+	}
+
+	return all
+}
+
+/*
+ * Check whether the operator is a negated one: "!=", "NOT IN", etc.
+ */
+func negated(operator string) bool {
+	switch operator {
+	case sqlparser.NotEqualStr, sqlparser.NotInStr, sqlparser.NotLikeStr, sqlparser.NotRegexpStr, sqlparser.NotBetweenStr:
+		return true
+	}
+
+	return false
+}
+
+/*
+ * Find all values of the field in the relation:
+ * IDs of the nodes with the same search field, and the attributes
+ * of the nodes and edge, by user's and data source's field name
+ */
+func (q *Query) values(field string, relation map[string]interface{}) []string {
+	names := []string{field}
+	if replaced, ok := q.replace[field]; ok {
+		names = append(names, replaced)
+	}
+
+	values := []string{}
+
+	for _, part := range []string{"from", "to", "edge"} {
+		object, ok := relation[part].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		attributes, _ := object["attributes"].(map[string]interface{})
+
+		for _, name := range names {
+			if object["search"] == name && object["id"] != nil {
+				values = append(values, fmt.Sprint(object["id"]))
+			}
+
+			if value, ok := attributes[name]; ok && value != nil {
+				values = append(values, fmt.Sprint(value))
+			}
+		}
+	}
+
+	return values
+}
+
+/*
+ * Compile LIKE and REGEXP patterns of the filter once,
+ * instead of doing it for every received relation
+ */
+func (q *Query) compile() error {
+	if q.Filter == nil {
+		return nil
+	}
+
+	q.patterns = make(map[*sqlparser.ComparisonExpr]*regexp.Regexp)
+
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		expr, ok := node.(*sqlparser.ComparisonExpr)
+		if !ok {
+			return true, nil
+		}
+
+		switch expr.Operator {
+		case sqlparser.LikeStr, sqlparser.NotLikeStr:
+			q.patterns[expr] = likeToRegex(literal(expr.Right))
+
+		case sqlparser.RegexpStr, sqlparser.NotRegexpStr:
+			re, err := regexp.Compile("(?i)" + literal(expr.Right))
+			if err != nil {
+				return false, fmt.Errorf("Invalid REGEXP pattern '%s': %s", literal(expr.Right), err.Error())
+			}
+
+			q.patterns[expr] = re
+		}
+
+		return false, nil
+	}, q.Filter)
+}
+
+/*
+ * Compare the field value with the filter
+ */
+func (q *Query) compare(expr *sqlparser.ComparisonExpr, value string) bool {
+	switch expr.Operator {
+	case sqlparser.EqualStr:
+		return order(value, literal(expr.Right)) == 0
+	case sqlparser.NotEqualStr:
+		return order(value, literal(expr.Right)) != 0
+	case sqlparser.LessThanStr:
+		return order(value, literal(expr.Right)) < 0
+	case sqlparser.LessEqualStr:
+		return order(value, literal(expr.Right)) <= 0
+	case sqlparser.GreaterThanStr:
+		return order(value, literal(expr.Right)) > 0
+	case sqlparser.GreaterEqualStr:
+		return order(value, literal(expr.Right)) >= 0
+
+	case sqlparser.InStr:
+		values, _ := expr.Right.(sqlparser.ValTuple)
+		for _, v := range values {
+			if order(value, literal(v)) == 0 {
+				return true
+			}
+		}
+		return false
+
+	case sqlparser.NotInStr:
+		values, _ := expr.Right.(sqlparser.ValTuple)
+		for _, v := range values {
+			if order(value, literal(v)) == 0 {
+				return false
+			}
+		}
+		return true
+
+	case sqlparser.LikeStr, sqlparser.NotLikeStr:
+		return q.patterns[expr].MatchString(value) == (expr.Operator == sqlparser.LikeStr)
+
+	case sqlparser.RegexpStr, sqlparser.NotRegexpStr:
+		return q.patterns[expr].MatchString(value) == (expr.Operator == sqlparser.RegexpStr)
+	}
+
+	return false
+}
+
+/*
+ * Get a textual value of the SQL literal
+ */
+func literal(expr sqlparser.Expr) string {
+	if val, ok := expr.(*sqlparser.SQLVal); ok {
+		return string(val.Val)
+	}
+
+	return sqlparser.String(expr)
+}
+
+/*
+ * Compare two values as numbers when both are numeric,
+ * otherwise as strings
+ */
+func order(a, b string) int {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+
+	if errX == nil && errY == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(a, b)
+}
+
+/*
+ * Convert SQL LIKE pattern into a case insensitive regex
+ */
+func likeToRegex(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	b.WriteString("$")
+
+	return regexp.MustCompile(b.String())
+}
+
+/*
+ * Check whether the data source converts "cidr_match" filters itself
+ */
+func matchesCIDR(collector pdk.SourcePlugin) bool {
+	if c, ok := collector.(pdk.CIDRSourcePlugin); ok {
+		return c.MatchesCIDR()
+	}
+
+	return false
+}
+
+/*
+ * Get query features declared by the data source plugin,
+ * nil when the plugin doesn't declare them
+ */
+func capabilities(collector pdk.SourcePlugin) *pdk.Capabilities {
+	if c, ok := collector.(pdk.CapableSourcePlugin); ok {
+		return c.Capabilities()
+	}
+
+	return nil
+}
+
+/*
+ * Validate "cidr_match(field, 'network')" filters
+ * and store their networks in a canonical form: '10.1.2.3/8' -> '10.0.0.0/8'
+ */
+func validateCIDR(expr sqlparser.Expr) error {
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		f, ok := node.(*sqlparser.FuncExpr)
+		if !ok || !pdk.IsCIDR(f) {
+			return true, nil
+		}
+
+		_, network, err := pdk.ParseCIDR(f)
+		if err != nil {
+			return false, err
+		}
+
+		f.Exprs[1].(*sqlparser.AliasedExpr).Expr = sqlparser.NewStrVal([]byte(network.String()))
+		return false, nil
+	}, expr)
+}
+
+/*
+ * Check whether the single filter must be applied to the received relations,
+ * as the data source can't handle it itself
+ */
+func postFiltered(expr sqlparser.Expr, supportsCIDR bool, caps *pdk.Capabilities) bool {
+	switch e := expr.(type) {
+	case *sqlparser.FuncExpr:
+		return pdk.IsCIDR(e) && !supportsCIDR
+	case *sqlparser.ComparisonExpr:
+		return caps != nil && !caps.Supports(e.Operator)
+	case *sqlparser.RangeCond:
+		return caps != nil && !caps.Supports(e.Operator)
+	}
+
+	return false
+}
+
+/*
+ * Check whether the expression contains any filter matching the condition
+ */
+func contains(expr sqlparser.Expr, condition func(sqlparser.Expr) bool) bool {
+	found := false
+
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if e, ok := node.(sqlparser.Expr); ok && condition(e) {
+			found = true
+		}
+		return !found, nil
+	}, expr)
+
+	return found
+}
+
+/*
+ * Split top level AND filters into the filters the data source
+ * can't handle, like "cidr_match" or not supported operators,
+ * and the rest of the query, for the SQL data sources.
+ * Returns the rest of the query, which is nil when nothing is left,
+ * and a list of filters to apply to the received relations
+ */
+func splitFilters(expr sqlparser.Expr, unsupported func(sqlparser.Expr) bool) (sqlparser.Expr, []sqlparser.Expr, error) {
+	if !contains(expr, unsupported) {
+		return expr, nil, nil
+	}
+
+	switch e := expr.(type) {
+	case *sqlparser.FuncExpr, *sqlparser.ComparisonExpr, *sqlparser.RangeCond:
+		filter, err := postFilter(e)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, []sqlparser.Expr{filter}, nil
+
+	case *sqlparser.NotExpr:
+		if inner, ok := e.Expr.(*sqlparser.ParenExpr); ok {
+			return splitFilters(&sqlparser.NotExpr{Expr: inner.Expr}, unsupported)
+		}
+
+		if unsupported(e.Expr) {
+			filter, err := postFilter(e)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			return nil, []sqlparser.Expr{filter}, nil
+		}
+
+	case *sqlparser.ParenExpr:
+		return splitFilters(e.Expr, unsupported)
+
+	case *sqlparser.AndExpr:
+		left, lf, err := splitFilters(e.Left, unsupported)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		right, rf, err := splitFilters(e.Right, unsupported)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		filters := append(lf, rf...)
+
+		switch {
+		case left == nil:
+			return right, filters, nil
+		case right == nil:
+			return left, filters, nil
+		}
+
+		return &sqlparser.AndExpr{Left: left, Right: right}, filters, nil
+	}
+
+	return nil, nil, fmt.Errorf("%s can be used only as a top level AND filter for this data source", firstFilter(expr, unsupported))
+}
+
+/*
+ * Rewrite a single, possibly negated, filter into a form
+ * the post-filter understands: NOT is replaced by the inverted operator,
+ * IN list by the "field='value'" filters joined with OR
+ */
+func postFilter(expr sqlparser.Expr) (sqlparser.Expr, error) {
+	groups, err := normalize(expr, false)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]sqlparser.Expr, 0, len(groups))
+	for _, group := range groups {
+		parts = append(parts, conjunction(group))
+	}
+
+	return disjunction(parts), nil
+}
+
+/*
+ * Human readable name of the first filter matching the condition,
+ * like "cidr_match()" or "LIKE", for the error messages
+ */
+func firstFilter(expr sqlparser.Expr, condition func(sqlparser.Expr) bool) string {
+	name := ""
+
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if e, ok := node.(sqlparser.Expr); ok && condition(e) {
+			name = filterName(e)
+		}
+		return name == "", nil
+	}, expr)
+
+	return name
+}
+
+/*
+ * Human readable name of the single filter
+ */
+func filterName(expr sqlparser.Expr) string {
+	switch e := expr.(type) {
+	case *sqlparser.FuncExpr:
+		return e.Name.Lowered() + "()"
+	case *sqlparser.ComparisonExpr:
+		return strings.ToUpper(e.Operator)
+	case *sqlparser.RangeCond:
+		return strings.ToUpper(e.Operator)
+	}
+
+	return sqlparser.String(expr)
+}
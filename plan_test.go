@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+/*
+ * Test splitting queries for the data sources without SQL support
+ */
+func TestSplitQuery(t *testing.T) {
+	config = &Config{Limit: 100, MaxSplitQueries: 10}
+
+	// Long IN list, split without any limit
+	values := []string{}
+	expected := [][2]string{}
+
+	for i := 0; i < 150; i++ {
+		values = append(values, fmt.Sprintf("'10.0.0.%d'", i))
+		expected = append(expected, [2]string{fmt.Sprintf("ip = '10.0.0.%d'", i), ""})
+	}
+
+	// SQL queries and the expected "pushed down filters | post-filter" pairs,
+	// nil when the query must fail
+	tables := []struct {
+		sql     string
+		caps    *pdk.Capabilities
+		queries [][2]string
+	}{
+		{`ip='1.1.1.1'`, nil, [][2]string{
+			{"ip = '1.1.1.1'", ""},
+		}},
+		{`ip='1.1.1.1' OR ip='2.2.2.2'`, nil, [][2]string{
+			{"ip = '1.1.1.1'", ""},
+			{"ip = '2.2.2.2'", ""},
+		}},
+		{`ip IN ('1.1.1.1','2.2.2.2') AND datetime BETWEEN '2023-01-01T00:00:00.000Z' AND '2023-02-01T00:00:00.000Z'`, nil, [][2]string{
+			{"ip = '1.1.1.1' and datetime between '2023-01-01T00:00:00.000Z' and '2023-02-01T00:00:00.000Z'", ""},
+			{"ip = '2.2.2.2' and datetime between '2023-01-01T00:00:00.000Z' and '2023-02-01T00:00:00.000Z'", ""},
+		}},
+		{`ip='1.1.1.1' AND NOT (port IN (80,443) OR name LIKE '%test%')`, nil, [][2]string{
+			{"ip = '1.1.1.1'", "port not in (80, 443) and name not like '%test%'"},
+		}},
+		{`(ip='1.1.1.1' OR domain='example.com') AND size>=1000`, nil, [][2]string{
+			{"ip = '1.1.1.1'", "size >= 1000"},
+			{"domain = 'example.com'", "size >= 1000"},
+		}},
+		// The same data source request is done once, without a post-filter
+		{`ip='1.1.1.1' AND size>10 OR ip='1.1.1.1'`, nil, [][2]string{
+			{"ip = '1.1.1.1'", ""},
+		}},
+		// Declared operators are pushed down
+		{`ip='1.1.1.1' AND size>=1000 AND name LIKE 'a%'`, &pdk.Capabilities{Operators: []string{"=", ">="}}, [][2]string{
+			{"ip = '1.1.1.1' and size >= 1000", "name like 'a%'"},
+		}},
+		{`ip IN (` + strings.Join(values, ",") + `)`, nil, expected},
+		// Time comparisons are pushed down as a range
+		{`ip='1.1.1.1' AND datetime >= '2023-01-01T00:00:00.000Z' AND datetime < '2023-02-01T00:00:00.000Z'`, nil, [][2]string{
+			{"ip = '1.1.1.1' and datetime between '2023-01-01T00:00:00.000Z' and '2023-02-01T00:00:00.000Z'", ""},
+		}},
+		{`ip='1.1.1.1' AND datetime BETWEEN '2023-01-01T00:00:00.000Z' AND '2023-03-01T00:00:00.000Z' AND datetime > '2023-02-01T00:00:00.000Z'`, nil, [][2]string{
+			{"ip = '1.1.1.1' and datetime between '2023-02-01T00:00:00.000Z' and '2023-03-01T00:00:00.000Z'", ""},
+		}},
+		{`ip='1.1.1.1' AND datetime <= '2023-01-01T00:00:00.000Z'`, nil, [][2]string{
+			{"ip = '1.1.1.1' and datetime between '1970-01-01T00:00:00.000Z' and '2023-01-01T00:00:00.000Z'", ""},
+		}},
+		{`port!=80`, nil, nil},
+		{`ip IN (1,2,3,4) AND port IN (1,2,3)`, nil, nil},
+		// Time filters can't be post-filtered
+		{`ip='1.1.1.1' AND datetime != '2023-01-01T00:00:00.000Z'`, nil, nil},
+		{`ip='1.1.1.1' AND datetime NOT BETWEEN '2023-01-01T00:00:00.000Z' AND '2023-02-01T00:00:00.000Z'`, nil, nil},
+		{`ip='1.1.1.1' AND datetime > '2023-01-01T00:00:00.000Z'`, &pdk.Capabilities{Operators: []string{"="}}, nil},
+	}
+
+	for _, table := range tables {
+		ast, err := sqlparser.Parse("SELECT * WHERE " + table.sql)
+		if err != nil {
+			t.Errorf("Can't parse '%s': %s", table.sql, err.Error())
+			continue
+		}
+
+		queries, err := splitQuery(ast.(*sqlparser.Select), table.caps)
+		if table.queries == nil {
+			if err == nil {
+				t.Errorf("Query '%s' must fail", table.sql)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Can't split '%s': %s", table.sql, err.Error())
+			continue
+		}
+
+		result := [][2]string{}
+		for _, query := range queries {
+			filter := ""
+			if query.Filter != nil {
+				filter = sqlparser.String(query.Filter)
+			}
+
+			result = append(result, [2]string{sqlparser.String(query.Select.Where.Expr), filter})
+		}
+
+		if fmt.Sprint(result) != fmt.Sprint(table.queries) {
+			t.Errorf("Invalid split of '%s': %v, expected: %v", table.sql, result, table.queries)
+		}
+	}
+
+	// Open range ends at the current time
+	ast, _ := sqlparser.Parse("SELECT * WHERE ip='1.1.1.1' AND datetime > '2023-01-01T00:00:00.000Z'")
+	before := time.Now().UTC().Format(timeFormat)
+
+	queries, err := splitQuery(ast.(*sqlparser.Select), nil)
+	if err != nil {
+		t.Fatalf("Can't split open time range: %s", err.Error())
+	}
+
+	to := literal(queries[0].Select.Where.Expr.(*sqlparser.AndExpr).Right.(*sqlparser.RangeCond).To)
+	if to < before || to > time.Now().UTC().Format(timeFormat) {
+		t.Errorf("Invalid end of the open time range: %s", to)
+	}
+}
+
+/*
+ * Test rewriting filters into a disjunctive normal form
+ */
+func TestNormalize(t *testing.T) {
+	config = &Config{Limit: 100}
+
+	// SQL filters and the expected AND groups
+	tables := []struct {
+		sql    string
+		groups []string
+	}{
+		{`a=1`, []string{"a = 1"}},
+		{`a IN (1,2)`, []string{"a = 1", "a = 2"}},
+		{`NOT a IN (1,2)`, []string{"a not in (1, 2)"}},
+		{`NOT (a=1 OR b<2)`, []string{"a != 1 and b >= 2"}},
+		{`NOT (a=1 AND b LIKE 'x%')`, []string{"a != 1", "b not like 'x%'"}},
+		{`(a=1 OR a=2) AND (b=3 OR b=4)`, []string{"a = 1 and b = 3", "a = 1 and b = 4", "a = 2 and b = 3", "a = 2 and b = 4"}},
+		{`a=1 AND NOT b BETWEEN 1 AND 5`, []string{"a = 1 and b not between 1 and 5"}},
+		{`a<>1`, []string{"a != 1"}},
+	}
+
+	for _, table := range tables {
+		ast, err := sqlparser.Parse("SELECT * WHERE " + table.sql)
+		if err != nil {
+			t.Errorf("Can't parse '%s': %s", table.sql, err.Error())
+			continue
+		}
+
+		groups, err := normalize(ast.(*sqlparser.Select).Where.Expr, false)
+		if err != nil {
+			t.Errorf("Can't normalize '%s': %s", table.sql, err.Error())
+			continue
+		}
+
+		result := []string{}
+		for _, group := range groups {
+			result = append(result, sqlparser.String(conjunction(group)))
+		}
+
+		if fmt.Sprint(result) != fmt.Sprint(table.groups) {
+			t.Errorf("Invalid normalization of '%s': %v, expected: %v", table.sql, result, table.groups)
+		}
+	}
+
+	// Not supported filters
+	for _, sql := range []string{`upper(a)='X'`, `a IS NULL`} {
+		ast, err := sqlparser.Parse("SELECT * WHERE " + sql)
+		if err != nil {
+			t.Errorf("Can't parse '%s': %s", sql, err.Error())
+			continue
+		}
+
+		if _, err := normalize(ast.(*sqlparser.Select).Where.Expr, false); err == nil {
+			t.Errorf("Filter '%s' must fail", sql)
+		}
+	}
+}
+
+/*
+ * Test applying post-filters to the received relations
+ */
+func TestPostFilter(t *testing.T) {
+	config = &Config{Limit: 100, MaxSplitQueries: 10}
+
+	relation := map[string]interface{}{
+		"from": map[string]interface{}{
+			"id":     "10.10.10.10",
+			"group":  "ip",
+			"search": "ip",
+			"attributes": map[string]interface{}{
+				"port": 443,
+			},
+		},
+		"to": map[string]interface{}{
+			"id":     "test.example.com",
+			"group":  "domain",
+			"search": "domain",
+		},
+		"edge": map[string]interface{}{
+			"attributes": map[string]interface{}{
+				"size": "1500",
+				"ip":   "8.8.8.8",
+			},
+		},
+	}
+
+	// Post-filters and whether the relation matches them
+	tables := []struct {
+		sql   string
+		match bool
+	}{
+		{`port=443`, true},
+		{`port!=443`, false},
+		{`NOT port IN (80,443)`, false},
+		{`port IN (80,443)`, true},
+		{`size>=1000`, true},
+		{`size<1000`, false},
+		{`size BETWEEN 1000 AND 2000`, true},
+		{`NOT size BETWEEN 1000 AND 2000`, false},
+		{`domain LIKE '%.EXAMPLE.com'`, true},
+		{`domain NOT LIKE 'test.%'`, false},
+		{`domain REGEXP '^test'`, true},
+		{`cidr_match(ip, '10.10.0.0/16')`, true},
+		{`NOT cidr_match(ip, '10.10.0.0/16')`, false},
+		{`port=80 OR size>1000`, true},
+		{`port=443 AND size<1000`, false},
+		// Any of the field's values matches a positive filter,
+		// all of them must match a negated one
+		{`ip='8.8.8.8'`, true},
+		{`ip!='8.8.8.8'`, false},
+		{`ip!='1.1.1.1'`, true},
+		{`ip NOT IN ('8.8.8.8','1.1.1.1')`, false},
+		{`ip NOT LIKE '10.%'`, false},
+		{`ip NOT REGEXP '^8\\.'`, false},
+		{`ip NOT BETWEEN '8' AND '9'`, false},
+		{`NOT cidr_match(ip, '8.8.0.0/16')`, false},
+		{`NOT cidr_match(ip, '1.1.0.0/16')`, true},
+		// Relations without the field are kept
+		{`country='LV'`, true},
+		{`country!='LV'`, true},
+		{`country NOT IN ('LV','EE')`, true},
+		{`NOT cidr_match(country, '10.0.0.0/8')`, true},
+		{`datetime>'2023-01-01T00:00:00.000Z'`, true},
+	}
+
+	for _, table := range tables {
+		ast, err := sqlparser.Parse("SELECT * WHERE " + table.sql)
+		if err != nil {
+			t.Errorf("Can't parse '%s': %s", table.sql, err.Error())
+			continue
+		}
+
+		filter, err := postFilter(ast.(*sqlparser.Select).Where.Expr)
+		if err != nil {
+			t.Errorf("Can't create post-filter '%s': %s", table.sql, err.Error())
+			continue
+		}
+
+		query := &Query{Filter: filter}
+		if err := query.compile(); err != nil {
+			t.Errorf("Can't compile post-filter '%s': %s", table.sql, err.Error())
+			continue
+		}
+
+		result := query.filter([]map[string]interface{}{relation})
+
+		if (len(result) == 1) != table.match {
+			t.Errorf("Invalid filtering by '%s': %v, expected: %v", table.sql, len(result) == 1, table.match)
+		}
+	}
+
+	// Renamed fields are found by both names
+	for value, match := range map[string]bool{"test.example.com": true, "other.example.com": false} {
+		ast, _ := sqlparser.Parse("SELECT * WHERE destination='" + value + "'")
+		filter, _ := postFilter(ast.(*sqlparser.Select).Where.Expr)
+
+		query := &Query{Filter: filter, replace: map[string]string{"destination": "domain"}}
+		query.compile()
+
+		if (len(query.filter([]map[string]interface{}{relation})) == 1) != match {
+			t.Errorf("Invalid filtering by the replaced field: %s", value)
+		}
+	}
+
+	// Invalid pattern is refused before the search
+	_, err := parseSQL("FROM test WHERE ip='10.10.10.10' AND domain REGEXP '(['", true, nil, nil, false, true, nil)
+	if err == nil || !strings.Contains(err.Error(), "Invalid REGEXP pattern") {
+		t.Errorf("Invalid REGEXP pattern is accepted, error: %v", err)
+	}
+}
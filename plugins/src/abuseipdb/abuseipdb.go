@@ -9,12 +9,9 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/cert-lv/graphoscope/pdk"
-	"github.com/umpc/go-sortedmap"
-	"github.com/umpc/go-sortedmap/desc"
 )
 
 /*
@@ -97,9 +94,6 @@ func (p *plugin) Fields() ([]string, error) {
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
-
 	// Convert SQL statement
 	searchFields, err := p.convert(stmt)
 	if err != nil {
@@ -121,14 +115,6 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 		return nil, nil, debug, err
 	}
 
-	// Struct to store statistics data
-	// when the amount of returned entries is too large
-	stats := pdk.NewStats()
-
-	for _, field := range p.source.StatsFields {
-		stats.Fields[field] = sortedmap.New(10, desc.Int)
-	}
-
 	/*
 	 * Receive hits and deserialize them
 	 */
@@ -139,155 +125,21 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 	}
 
 	entries := data["data"].(map[string]interface{})
-
-	mx := sync.Mutex{}
-	umx := sync.Mutex{}
-	unique := make(map[string]bool)
-	counter := 0
-
 	reports := entries["reports"].([]interface{})
 
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
+
 	// Process results
+process:
 	for _, entry := range reports {
 		for _, categoryID := range entry.(map[string]interface{})["categories"].([]interface{}) {
 
 			// Stop when results count is too big
-			if counter >= p.limit {
-				top, err := stats.ToJSON(p.source.Name)
-				if err != nil {
-					return nil, nil, debug, err
-				}
-
-				return results, top, debug, nil
-			}
-
-			// Update stats
-			for _, field := range p.source.StatsFields {
-				stats.Update(entries, field)
-			}
-
-			// Go through all the predefined relations and collect unique entries
-			for _, relation := range p.source.Relations {
-				if entries[relation.From.ID] != nil && entries[relation.To.ID] != nil {
-					umx.Lock()
-
-					// Use "Sprintf(...%v..." instead of "entries[relation.From.ID].(string)"
-					// as the value can be not a string only
-					if _, exists := unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entries[relation.From.ID], relation.To.ID, entries[relation.To.ID])]; exists {
-						if pdk.ResultsContain(results, entries, relation) {
-							umx.Unlock()
-							continue
-						}
-					}
-
-					counter++
-
-					unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entries[relation.From.ID], relation.To.ID, entries[relation.To.ID])] = true
-					umx.Unlock()
-
-					/*
-					 * Check if expected relation exists in received data.
-					 * This allows returned JSON objects to have dynamic schema
-					 */
-					if _, ok := entries[relation.From.ID]; !ok {
-						continue
-					}
-
-					if _, ok := entries[relation.To.ID]; !ok {
-						continue
-					}
-
-					/*
-					 * FROM node with attributes
-					 */
-					from := map[string]interface{}{
-						"id":     entries[relation.From.ID],
-						"group":  relation.From.Group,
-						"search": relation.From.Search,
-					}
-
-					// Check FROM type & searching fields
-					if len(relation.From.VarTypes) > 0 {
-						for _, t := range relation.From.VarTypes {
-							if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entries[relation.From.ID])) {
-								from["group"] = t.Group
-								from["search"] = t.Search
-
-								break
-							}
-						}
-					}
-
-					if len(relation.From.Attributes) > 0 {
-						from["attributes"] = make(map[string]interface{})
-						pdk.CopyPresentValues(entries, from["attributes"].(map[string]interface{}), relation.From.Attributes)
-					}
-
-					/*
-					 * TO node
-					 */
-					to := map[string]interface{}{
-						"id":     entries[relation.To.ID],
-						"group":  relation.To.Group,
-						"search": relation.To.Search,
-					}
-
-					// Check FROM type & searching fields
-					if len(relation.To.VarTypes) > 0 {
-						for _, t := range relation.To.VarTypes {
-							if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entries[relation.To.ID])) {
-								to["group"] = t.Group
-								to["search"] = t.Search
-
-								break
-							}
-						}
-					}
-
-					if len(relation.To.Attributes) > 0 {
-						to["attributes"] = make(map[string]interface{})
-						pdk.CopyPresentValues(entries, to["attributes"].(map[string]interface{}), relation.To.Attributes)
-					}
-
-					// Resulting graph entry to return
-					result := make(map[string]interface{})
-
-					/*
-					 * Edge between FROM and TO
-					 */
-					if relation.Edge != nil && (relation.Edge.Label != "" || len(relation.Edge.Attributes) > 0) {
-						result["edge"] = make(map[string]interface{})
-
-						if relation.Edge.Label != "" {
-							result["edge"].(map[string]interface{})["label"] = relation.Edge.Label
-						}
-
-						if len(relation.Edge.Attributes) > 0 {
-							result["edge"].(map[string]interface{})["attributes"] = make(map[string]interface{})
-							pdk.CopyPresentValues(entries, result["edge"].(map[string]interface{})["attributes"].(map[string]interface{}), relation.Edge.Attributes)
-						}
-					}
-
-					/*
-					 * Put it together
-					 */
-					result["from"] = from
-					result["to"] = to
-					result["source"] = p.source.Name
-
-					//fmt.Println("Edge:", from, to, p.source.Name)
-
-					/*
-					 * Add current entry to the list to return
-					 */
-					mx.Lock()
-					results = append(results, result)
-					mx.Unlock()
-				}
+			if !builder.Add(entries) {
+				break process
 			}
 
-			// Insert custom hardcoded relations.
-			// TODO: rewrite this part when plugins development is simplified
+			// Insert custom hardcoded relations
 
 			// Comment -> IP
 			comment := strings.Trim(entry.(map[string]interface{})["comment"].(string), "\"'")
@@ -320,9 +172,9 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 				"source": p.source.Name,
 			}
 
-			mx.Lock()
-			results = append(results, result)
-			mx.Unlock()
+			if !builder.AddRelation("", result) {
+				break process
+			}
 
 			// Category -> Abusive activity
 			category, ok := mapping[categoryID.(float64)]
@@ -330,18 +182,6 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 				category = fmt.Sprintf("%.f", categoryID.(float64))
 			}
 
-			umx.Lock()
-
-			if _, exists := unique[fmt.Sprintf("%v-%v-%v-%v", "category", category, "comment", comment)]; exists {
-				umx.Unlock()
-				continue
-			}
-
-			counter++
-
-			unique[fmt.Sprintf("%v-%v-%v-%v", "category", category, "comment", comment)] = true
-			umx.Unlock()
-
 			from = map[string]interface{}{
 				"id":     category,
 				"group":  "taxonomy",
@@ -360,13 +200,18 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 				"source": p.source.Name,
 			}
 
-			mx.Lock()
-			results = append(results, result)
-			mx.Unlock()
+			if !builder.AddRelation(fmt.Sprintf("%v-%v-%v-%v", "category", category, "comment", comment), result) {
+				break process
+			}
 		}
 	}
 
-	return results, nil, debug, nil
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, err
+	}
+
+	return results, stats, debug, nil
 }
 
 // request connects to the HTTP access point and returns the response
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+const (
+	// How long Elasticsearch keeps the point in time
+	// between the requests of the next pages
+	pitKeepAlive = "10m"
+)
+
+/*
+ * Elasticsearch search response fields in use
+ */
+type searchResponse struct {
+	PIT  string `json:"pit_id"`
+	Hits struct {
+		Hits []*searchHit `json:"hits"`
+	} `json:"hits"`
+}
+
+/*
+ * Single Elasticsearch hit
+ */
+type searchHit struct {
+	Source map[string]interface{} `json:"_source"`
+	Sort   []json.RawMessage      `json:"sort"`
+}
+
+/*
+ * Position of the paginated search: point in time ID
+ * and "sort" values of the last processed hit
+ */
+type page struct {
+	PIT   string            `json:"pit"`
+	After []json.RawMessage `json:"after,omitempty"`
+
+	// Amount of the processed hits, not a part of the position
+	hits int
+}
+
+func (p *plugin) SearchPage(ctx context.Context, stmt *sqlparser.Select, position string) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, string, error) {
+
+	// Convert SQL statement
+	searchJSON, err := p.convert(stmt, p.source.IncludeFields)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+
+	// The first page opens a new point in time,
+	// so all the next pages see the same data
+	current := &page{}
+
+	if position == "" {
+		current.PIT, err = p.openPIT(ctx)
+		if err != nil {
+			return nil, nil, nil, "", err
+		}
+
+	} else if err := json.Unmarshal([]byte(position), current); err != nil || current.PIT == "" {
+		return nil, nil, nil, "", fmt.Errorf("Invalid page position")
+	}
+
+	searchJSON, err = paginate(searchJSON, current)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+
+	// Index is not set when point in time is used
+	results, stats, debug, last, err := p.search(ctx, searchJSON)
+	if err != nil {
+		return nil, nil, debug, "", err
+	}
+
+	size := 0
+	if stmt.Limit != nil {
+		size, _ = strconv.Atoi(sqlparser.String(stmt.Limit.Rowcount))
+	}
+
+	// No more data when less hits than requested were received
+	// and all of them were processed
+	if last == nil || last.hits == 0 || (stats == nil && last.hits < size) {
+		p.closePIT(current.PIT)
+		return results, stats, debug, "", nil
+	}
+
+	// Point in time ID can change between the requests
+	if last.PIT == "" {
+		last.PIT = current.PIT
+	}
+
+	next, err := json.Marshal(last)
+	if err != nil {
+		return nil, nil, debug, "", err
+	}
+
+	return results, stats, debug, string(next), nil
+}
+
+/*
+ * Add point in time and "search_after" to the Elasticsearch JSON query.
+ * Hits are sorted by the "_shard_doc" tiebreaker after the user's sorting
+ */
+func paginate(searchJSON string, position *page) (string, error) {
+	dsl := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(searchJSON), &dsl); err != nil {
+		return "", err
+	}
+
+	sort := []interface{}{}
+	if raw, ok := dsl["sort"]; ok {
+		if err := json.Unmarshal(raw, &sort); err != nil {
+			return "", err
+		}
+	}
+
+	sort = append(sort, map[string]string{"_shard_doc": "asc"})
+
+	fields := map[string]interface{}{
+		"sort": sort,
+		"pit": map[string]string{
+			"id":         position.PIT,
+			"keep_alive": pitKeepAlive,
+		},
+	}
+
+	// "from" can't be used together with "search_after"
+	if len(position.After) != 0 {
+		fields["search_after"] = position.After
+		delete(dsl, "from")
+	}
+
+	for key, value := range fields {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		dsl[key] = raw
+	}
+
+	b, err := json.Marshal(dsl)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+/*
+ * Open a new point in time for the configured indices.
+ * Returns its ID
+ */
+func (p *plugin) openPIT(ctx context.Context) (string, error) {
+	res, err := p.client.OpenPointInTime(
+		strings.Split(p.index, ","),
+		pitKeepAlive,
+		p.client.OpenPointInTime.WithContext(ctx),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", fmt.Errorf("Can't open point in time: %s", res.String())
+	}
+
+	pit := struct {
+		ID string `json:"id"`
+	}{}
+
+	if err := json.NewDecoder(res.Body).Decode(&pit); err != nil {
+		return "", fmt.Errorf("Can't decode point in time: %s", err.Error())
+	}
+
+	return pit.ID, nil
+}
+
+/*
+ * Close the point in time which is not needed anymore.
+ * Errors are ignored, as Elasticsearch closes it after "pitKeepAlive" anyway
+ */
+func (p *plugin) closePIT(id string) {
+	body, err := json.Marshal(map[string]string{"id": id})
+	if err != nil {
+		return
+	}
+
+	res, err := p.client.ClosePointInTime(p.client.ClosePointInTime.WithBody(bytes.NewReader(body)))
+	if err == nil {
+		res.Body.Close()
+	}
+}
@@ -10,10 +10,17 @@ import (
  */
 var (
 	Name    = "elasticsearch.v7"
-	Version = "1.0.9"
+	Version = "1.0.16"
 	Plugin  plugin
 )
 
+/*
+ * Register the plugin to be available to the core
+ */
+func init() {
+	pdk.Register(Name, Version, func() interface{} { return &plugin{} })
+}
+
 /*
  * Structure to be imported by the core as a plugin
  */
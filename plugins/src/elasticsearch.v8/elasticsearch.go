@@ -11,13 +11,11 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
-	"sync"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/cert-lv/graphoscope/pdk"
 	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/umpc/go-sortedmap"
-	"github.com/umpc/go-sortedmap/desc"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
 )
 
 /*
@@ -150,9 +148,10 @@ func (p *plugin) getFields(data map[string]interface{}, field string, fields map
 }
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	return p.SearchContext(context.Background(), stmt)
+}
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
+func (p *plugin) SearchContext(ctx context.Context, stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
 	// Convert SQL statement
 	searchJSON, err := p.convert(stmt, p.source.IncludeFields)
@@ -160,6 +159,17 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 		return nil, nil, nil, err
 	}
 
+	results, stats, debug, _, err := p.search(ctx, searchJSON, p.client.Search.WithIndex(p.index))
+	return results, stats, debug, err
+}
+
+/*
+ * Execute the given Elasticsearch JSON query with the additional search options
+ * and convert the received hits into relations.
+ * Returns also the position after the last processed hit
+ */
+func (p *plugin) search(ctx context.Context, searchJSON string, options ...func(*esapi.SearchRequest)) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, *page, error) {
+
 	// Debug info
 	debug := make(map[string]interface{})
 	debug["query"] = searchJSON
@@ -167,7 +177,7 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 	// Context to be able to cancel goroutines
 	// when some DB wants to return > limit amount of entries
 	// or time expires
-	ctx, cancel := context.WithTimeout(context.Background(), p.source.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, p.source.Timeout)
 	defer cancel()
 
 	// Search in Elasticsearch using a raw JSON string.
@@ -178,189 +188,96 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 	//
 	// So use a single request to make the plugin consistent with
 	// the other plugins
-	found, err := p.client.Search(
-		p.client.Search.WithIndex(p.index),
+	found, err := p.client.Search(append(options,
 		p.client.Search.WithBody(strings.NewReader(searchJSON)),
 		p.client.Search.WithContext(ctx),
-	)
+	)...)
 	if err != nil {
-		return nil, nil, debug, err
+		return nil, nil, debug, nil, err
+	}
+	defer found.Body.Close()
+
+	if found.IsError() {
+		return nil, nil, debug, nil, fmt.Errorf("Search failed: %s", found.String())
 	}
 
 	b, err := io.ReadAll(found.Body)
 	if err != nil {
-		return nil, nil, debug, err
+		return nil, nil, debug, nil, err
 	}
 
-	response := make(map[string]map[string][]map[string]interface{})
-	json.Unmarshal(b, &response)
+	response := &searchResponse{}
+	json.Unmarshal(b, response)
 
-	/*
-	 * Concurrent goroutines receive hits and deserialize them.
-	 * Number is set by "runtime.NumCPU()" - host's CPU cores count
-	 */
+	// Position after the last processed hit
+	position := &page{PIT: response.PIT}
 
-	mx := sync.Mutex{}
-	umx := sync.Mutex{}
-	unique := make(map[string]bool)
-	counter := 0
-
-	// Struct to store statistics data
-	// when the amount of returned entries is too large
-	stats := pdk.NewStats()
-
-	for _, field := range p.source.StatsFields {
-		stats.Fields[field] = sortedmap.New(10, desc.Int)
-	}
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
 
 	// Iterate through the results
-	for _, hit := range response["hits"]["hits"] {
+	for _, hit := range response.Hits.Hits {
+		entry := hit.Source
+		if entry == nil {
+			return nil, nil, debug, nil, fmt.Errorf("Can't decode '_source' response field")
+		}
 
 		// Stop when results count is too big
-		if counter >= p.limit {
+		if !builder.Add(entry) {
 			cancel()
-
-			top, err := stats.ToJSON(p.source.Name)
-			if err != nil {
-				return nil, nil, debug, err
-			}
-
-			return results, top, debug, nil
+			break
 		}
 
-		entry, ok := hit["_source"].(map[string]interface{})
-		if !ok {
-			return nil, nil, debug, fmt.Errorf("Can't decode '_source' response field")
-		}
-
-		// Update stats
-		for _, field := range p.source.StatsFields {
-			stats.Update(entry, field)
-		}
-
-		// Go through all the predefined relations and collect unique entries
-		for _, relation := range p.source.Relations {
-			if entry[relation.From.ID] != nil && entry[relation.To.ID] != nil {
-				umx.Lock()
-
-				// Use "Printf(...%v..." instead of "entry[relation.From.ID].(string)"
-				// as the value can be not a string only
-				if _, exists := unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])]; exists {
-					if pdk.ResultsContain(results, entry, relation) {
-						umx.Unlock()
-						continue
-					}
-				}
-
-				counter++
-
-				unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])] = true
-				umx.Unlock()
-
-				/*
-				 * FROM node with attributes
-				 */
-				from := map[string]interface{}{
-					"id":     entry[relation.From.ID],
-					"group":  relation.From.Group,
-					"search": relation.From.Search,
-				}
-
-				// Check FROM type & searching fields
-				if len(relation.From.VarTypes) > 0 {
-					for _, t := range relation.From.VarTypes {
-						if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[relation.From.ID])) {
-							from["group"] = t.Group
-							from["search"] = t.Search
-
-							break
-						}
-					}
-				}
-
-				if len(relation.From.Attributes) > 0 {
-					from["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, from["attributes"].(map[string]interface{}), relation.From.Attributes)
-				}
-
-				/*
-				 * TO node
-				 */
-				to := map[string]interface{}{
-					"id":     entry[relation.To.ID],
-					"group":  relation.To.Group,
-					"search": relation.To.Search,
-				}
-
-				// Check FROM type & searching fields
-				if len(relation.To.VarTypes) > 0 {
-					for _, t := range relation.To.VarTypes {
-						if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[relation.To.ID])) {
-							to["group"] = t.Group
-							to["search"] = t.Search
-
-							break
-						}
-					}
-				}
-
-				if len(relation.To.Attributes) > 0 {
-					to["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, to["attributes"].(map[string]interface{}), relation.To.Attributes)
-				}
-
-				// Resulting graph entry to return
-				result := make(map[string]interface{})
-
-				/*
-				 * Edge between FROM and TO
-				 */
-				if relation.Edge != nil && (relation.Edge.Label != "" || len(relation.Edge.Attributes) > 0) {
-					result["edge"] = make(map[string]interface{})
-
-					if relation.Edge.Label != "" {
-						result["edge"].(map[string]interface{})["label"] = relation.Edge.Label
-					}
-
-					if len(relation.Edge.Attributes) > 0 {
-						result["edge"].(map[string]interface{})["attributes"] = make(map[string]interface{})
-						pdk.CopyPresentValues(entry, result["edge"].(map[string]interface{})["attributes"].(map[string]interface{}), relation.Edge.Attributes)
-					}
-				}
-
-				/*
-				 * Put it together
-				 */
-				result["from"] = from
-				result["to"] = to
-				result["source"] = p.source.Name
-
-				//fmt.Println("Edge:", from, to)
-
-				/*
-				 * Add current entry to the list to return
-				 */
-				mx.Lock()
-				results = append(results, result)
-				mx.Unlock()
-			}
-		}
+		position.After = hit.Sort
+		position.hits++
 
 		// Terminate early?
 		select {
 		case <-ctx.Done():
 			// Parsing ES search results canceled
-			top, err := stats.ToJSON(p.source.Name)
+			top, err := builder.Stats()
 			if err != nil {
-				return nil, nil, debug, err
+				return nil, nil, debug, nil, err
 			}
 
-			return nil, top, debug, nil
+			return nil, top, debug, nil, nil
 		default:
 		}
 	}
 
-	return results, nil, debug, nil
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, nil, err
+	}
+
+	return results, stats, debug, position, nil
+}
+
+func (p *plugin) Explain(stmt *sqlparser.Select) (interface{}, error) {
+
+	// Convert SQL statement
+	searchJSON, err := p.convert(stmt, p.source.IncludeFields)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.RawMessage(searchJSON), nil
+}
+
+func (p *plugin) MatchesCIDR() bool {
+	return true
+}
+
+/*
+ * Filters are converted into the native query, except REGEXP.
+ * OR is supported, NOT is expected as a negated operator, like "!="
+ */
+func (p *plugin) Capabilities() *pdk.Capabilities {
+	return &pdk.Capabilities{
+		Operators:  []string{"=", "!=", "<", ">", "<=", ">=", "in", "not in", "like", "not like", "between", "not between"},
+		Or:         true,
+		GroupBy:    true,
+		Projection: true,
+	}
 }
 
 func (p *plugin) Stop() error {
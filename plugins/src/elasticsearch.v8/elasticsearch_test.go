@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
@@ -30,6 +31,8 @@ func TestConvert(t *testing.T) {
 		{`SELECT * WHERE size NOT BETWEEN 1 AND 10`, `{"query" : {"bool" : {"must" : [{"bool" : {"must_not" : {"range" : {"size" : {"from" : 1, "to" : 10}}}}}]}}}`},
 		{`SELECT * WHERE size IN (100,300)`, `{"query" : {"bool" : {"must" : [{"terms" : {"size" : [100, 300]}}]}}}`},
 		{`SELECT * WHERE size NOT IN (100,300)`, `{"query" : {"bool" : {"must" : [{"bool" : {"must_not" : {"terms" : {"size" : [100, 300]}}}}]}}}`},
+		{`SELECT * WHERE cidr_match(ip, '10.10.10.0/24')`, `{"query" : {"bool" : {"must" : [{"term" : {"ip" : "10.10.10.0/24"}}]}}}`},
+		{`SELECT * WHERE name='sarah' AND cidr_match(ip, '10.0.0.0/8')`, `{"query" : {"bool" : {"must" : [{"match_phrase" : {"name" : "sarah"}}, {"term" : {"ip" : "10.0.0.0/8"}}]}}}`},
 		{`select * where name='sarah' and age!=40 and (country='LV' or country='AU') limit 0,1`, `{"query" : {"bool" : {"must" : [{"match_phrase" : {"name" : "sarah"}}, {"bool" : {"must_not" : [{"match_phrase" : {"age" : 40}}]}}, {"bool" : {"should" : [{"match_phrase" : {"country" : "LV"}}, {"match_phrase" : {"country" : "AU"}}]}}]}}, "from" : 0, "size" : 1}`},
 	}
 
@@ -59,3 +62,31 @@ func TestConvert(t *testing.T) {
 		}
 	}
 }
+
+/*
+ * Test point in time and "search_after" addition to the converted query
+ */
+func TestPaginate(t *testing.T) {
+
+	// Pairs of queries, positions and the expected results
+	tables := []struct {
+		query     string
+		position  *page
+		paginated string
+	}{
+		{`{"query" : {"match_all" : {}}, "from" : 5, "size" : 10}`, &page{PIT: "abc"}, `{"from":5,"pit":{"id":"abc","keep_alive":"10m"},"query":{"match_all":{}},"size":10,"sort":[{"_shard_doc":"asc"}]}`},
+		{`{"query" : {"match_all" : {}}, "from" : 5, "size" : 10, "sort" : [{"name": "desc"}]}`, &page{PIT: "abc", After: []json.RawMessage{json.RawMessage(`"sarah"`), json.RawMessage(`9007199254740993`)}}, `{"pit":{"id":"abc","keep_alive":"10m"},"query":{"match_all":{}},"search_after":["sarah",9007199254740993],"size":10,"sort":[{"name":"desc"},{"_shard_doc":"asc"}]}`},
+	}
+
+	for _, table := range tables {
+		result, err := paginate(table.query, table.position)
+		if err != nil {
+			t.Errorf("Can't paginate '%s': %s", table.query, err.Error())
+			continue
+		}
+
+		if result != table.paginated {
+			t.Errorf("Invalid pagination of '%s': %s, expected: %s", table.query, result, table.paginated)
+		}
+	}
+}
@@ -10,10 +10,17 @@ import (
  */
 var (
 	Name    = "elasticsearch.v8"
-	Version = "1.0.2"
+	Version = "1.0.9"
 	Plugin  plugin
 )
 
+/*
+ * Register the plugin to be available to the core
+ */
+func init() {
+	pdk.Register(Name, Version, func() interface{} { return &plugin{} })
+}
+
 /*
  * Structure to be imported by the core as a plugin
  */
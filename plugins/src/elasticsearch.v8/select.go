@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/cert-lv/graphoscope/pdk"
 )
 
 /*
@@ -276,6 +277,35 @@ func handleSelectWhereBetweenExpr(expr *sqlparser.Expr, topLevel bool) (string,
 	return resultStr, nil
 }
 
+/*
+ * Handle "cidr_match(field, 'network')" filter.
+ * Elasticsearch "ip" fields accept networks in a "term" query
+ *
+ * Receives:
+ *     expr     - SQL expression to process
+ *     topLevel - whether it's a top level expression
+ */
+func handleSelectWhereFuncExpr(expr *sqlparser.Expr, topLevel bool) (string, error) {
+	funcExpr := (*expr).(*sqlparser.FuncExpr)
+
+	if !pdk.IsCIDR(funcExpr) {
+		return "", errors.New("Unsupported function: " + funcExpr.Name.String())
+	}
+
+	colNameStr, network, err := pdk.ParseCIDR(funcExpr)
+	if err != nil {
+		return "", err
+	}
+
+	resultStr := fmt.Sprintf(`{"term" : {"%v" : %#v}}`, colNameStr, network.String())
+
+	if topLevel {
+		resultStr = fmt.Sprintf(`{"bool" : {"must" : [%v]}}`, resultStr)
+	}
+
+	return resultStr, nil
+}
+
 /*
  * Handle top level or groups of expressions.
  *
@@ -404,6 +434,9 @@ func handleSelectWhere(expr *sqlparser.Expr, topLevel bool, parent *sqlparser.Ex
 
 	case *sqlparser.ParenExpr:
 		return handleSelectWhereParenExpr(expr, topLevel, parent)
+
+	case *sqlparser.FuncExpr:
+		return handleSelectWhereFuncExpr(expr, topLevel)
 	}
 
 	return "", fmt.Errorf("Unexpected SQL expression type received: %T", *expr)
@@ -6,13 +6,10 @@ import (
 	"fmt"
 	"path/filepath"
 	"regexp"
-	"sync"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/cert-lv/graphoscope/pdk"
 	_ "github.com/mithrandie/csvq-driver"
-	"github.com/umpc/go-sortedmap"
-	"github.com/umpc/go-sortedmap/desc"
 )
 
 /*
@@ -90,9 +87,10 @@ func (p *plugin) Fields() ([]string, error) {
 }
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	return p.SearchContext(context.Background(), stmt)
+}
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
+func (p *plugin) SearchContext(ctx context.Context, stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
 	// Convert SQL statement
 	filter, err := p.convert(stmt)
@@ -112,7 +110,7 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 
 	// Context to be able to cancel goroutines
 	// when DB wants to return > limit amount of entries or time expires
-	ctx, cancel := context.WithTimeout(context.Background(), p.source.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, p.source.Timeout)
 	defer cancel()
 
 	rows, err := p.db.QueryContext(ctx, query)
@@ -133,35 +131,13 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 		row = append(row, new(sql.NullString))
 	}
 
-	mx := sync.Mutex{}
-	umx := sync.Mutex{}
-	unique := make(map[string]bool)
-	counter := 0
-
-	// Struct to store statistics data
-	// when the amount of returned entries is too large
-	stats := pdk.NewStats()
-
-	for _, field := range p.source.StatsFields {
-		stats.Fields[field] = sortedmap.New(10, desc.Int)
-	}
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
 
 	/*
 	 * Iterate through the results
 	 */
 
 	for rows.Next() {
-
-		// Stop when results count is too big
-		if counter >= p.limit {
-			top, err := stats.ToJSON(p.source.Name)
-			if err != nil {
-				return nil, nil, debug, err
-			}
-
-			return results, top, debug, nil
-		}
-
 		if err := rows.Scan(row...); err != nil {
 			return nil, nil, debug, err
 		}
@@ -176,117 +152,9 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 			}
 		}
 
-		// Update stats
-		for _, field := range p.source.StatsFields {
-			stats.Update(entry, field)
-		}
-
-		// Go through all the predefined relations and collect unique entries
-		for _, relation := range p.source.Relations {
-			if entry[relation.From.ID] != nil && entry[relation.To.ID] != nil {
-				umx.Lock()
-
-				// Use "Printf(...%v..." instead of "entry[relation.From.ID].(string)"
-				// as the value can be not a string only
-				if _, exists := unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])]; exists {
-					if pdk.ResultsContain(results, entry, relation) {
-						umx.Unlock()
-						continue
-					}
-				}
-
-				counter++
-
-				unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])] = true
-				umx.Unlock()
-
-				/*
-				 * FROM node with attributes
-				 */
-				from := map[string]interface{}{
-					"id":     entry[relation.From.ID],
-					"group":  relation.From.Group,
-					"search": relation.From.Search,
-				}
-
-				// Check FROM type & searching fields
-				if len(relation.From.VarTypes) > 0 {
-					for _, t := range relation.From.VarTypes {
-						if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[relation.From.ID])) {
-							from["group"] = t.Group
-							from["search"] = t.Search
-
-							break
-						}
-					}
-				}
-
-				if len(relation.From.Attributes) > 0 {
-					from["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, from["attributes"].(map[string]interface{}), relation.From.Attributes)
-				}
-
-				/*
-				 * TO node
-				 */
-				to := map[string]interface{}{
-					"id":     entry[relation.To.ID],
-					"group":  relation.To.Group,
-					"search": relation.To.Search,
-				}
-
-				// Check FROM type & searching fields
-				if len(relation.To.VarTypes) > 0 {
-					for _, t := range relation.To.VarTypes {
-						if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[relation.To.ID])) {
-							to["group"] = t.Group
-							to["search"] = t.Search
-
-							break
-						}
-					}
-				}
-
-				if len(relation.To.Attributes) > 0 {
-					to["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, to["attributes"].(map[string]interface{}), relation.To.Attributes)
-				}
-
-				// Resulting graph entry to return
-				result := make(map[string]interface{})
-
-				/*
-				 * Edge between FROM and TO
-				 */
-				if relation.Edge != nil && (relation.Edge.Label != "" || len(relation.Edge.Attributes) > 0) {
-					result["edge"] = make(map[string]interface{})
-
-					if relation.Edge.Label != "" {
-						result["edge"].(map[string]interface{})["label"] = relation.Edge.Label
-					}
-
-					if len(relation.Edge.Attributes) > 0 {
-						result["edge"].(map[string]interface{})["attributes"] = make(map[string]interface{})
-						pdk.CopyPresentValues(entry, result["edge"].(map[string]interface{})["attributes"].(map[string]interface{}), relation.Edge.Attributes)
-					}
-				}
-
-				/*
-				 * Put it together
-				 */
-				result["from"] = from
-				result["to"] = to
-				result["source"] = p.source.Name
-
-				// fmt.Println("Edge:", from, to, p.source.Name)
-
-				/*
-				 * Add current entry to the list to return
-				 */
-				mx.Lock()
-				results = append(results, result)
-				mx.Unlock()
-			}
+		// Stop when results count is too big
+		if !builder.Add(entry) {
+			break
 		}
 	}
 
@@ -295,7 +163,28 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 		return nil, nil, debug, err
 	}
 
-	return results, nil, debug, nil
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, err
+	}
+
+	// Next page starts after the used rows
+	debug[pdk.DebugRows] = builder.Entries()
+
+	return results, stats, debug, nil
+}
+
+/*
+ * WHERE filters are given to the CSV query engine as they are,
+ * it has no REGEXP operator
+ */
+func (p *plugin) Capabilities() *pdk.Capabilities {
+	return &pdk.Capabilities{
+		Operators:  []string{"=", "!=", "<", ">", "<=", ">=", "in", "not in", "like", "not like", "between", "not between"},
+		Or:         true,
+		GroupBy:    true,
+		Projection: true,
+	}
 }
 
 func (p *plugin) Stop() error {
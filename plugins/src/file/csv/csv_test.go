@@ -1,9 +1,18 @@
 package main
 
 import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/cert-lv/graphoscope/pdk"
+	"github.com/cert-lv/graphoscope/pdk/plugintest"
+	yaml "gopkg.in/yaml.v3"
 )
 
 /*
@@ -59,3 +68,142 @@ func TestConvert(t *testing.T) {
 		}
 	}
 }
+
+/*
+ * Test plugin's compatibility with the core
+ */
+func TestConformance(t *testing.T) {
+
+	// Data file content
+	entries := []map[string]interface{}{
+		{"name": "sarah", "country": "LV", "contact": "sarah@example.com", "age": "40"},
+		{"name": "john", "country": "LV", "contact": "+37120000000", "age": "25"},
+		{"name": "anna", "country": "AU", "contact": "anna@example.com", "age": "31"},
+		{"name": "peter", "country": "", "contact": "", "age": "25"},
+	}
+
+	suite := &plugintest.SourceSuite{
+		New:    func() pdk.SourcePlugin { return &plugin{} },
+		Source: fixture,
+
+		Invalid: []*pdk.Source{
+			{Name: "no-path", Timeout: 5 * time.Second},
+		},
+
+		Cases: []*plugintest.SourceCase{
+			{SQL: `SELECT * WHERE name='sarah'`, Entries: entries, Matches: []int{0}},
+			{SQL: `SELECT * WHERE country='LV'`, Entries: entries, Matches: []int{0, 1}},
+			{SQL: `SELECT * WHERE age BETWEEN 20 AND 35`, Entries: entries, Matches: []int{1, 2, 3}},
+			{SQL: `SELECT * WHERE name='nobody'`, Entries: entries},
+			{SQL: `SELECT * WHERE unknown(name)='sarah'`, Entries: entries, Error: true},
+		},
+	}
+
+	suite.Run(t)
+}
+
+/*
+ * Write the entries into a temporary CSV file
+ * and create a data source definition for it
+ */
+func fixture(t *testing.T, entries []map[string]interface{}) *pdk.Source {
+	source := &pdk.Source{}
+
+	err := yaml.Unmarshal([]byte(`
+name: people
+plugin: file-csv
+timeout: 5s
+statsFields: [ "country" ]
+
+relations:
+  -
+    from:
+        id: name
+        group: name
+        search: name
+        attributes: [ "age" ]
+
+    to:
+        id: country
+        group: country
+        search: country
+
+  -
+    from:
+        id: name
+        group: name
+        search: name
+
+    to:
+        id: contact
+        group: phone
+        search: phone
+
+        varTypes:
+          -
+            regex: ^.+@.+$
+            group: email
+            search: email
+`), source)
+	if err != nil {
+		t.Fatalf("Can't parse definition: %s", err.Error())
+	}
+
+	source.Access = map[string]string{
+		"path": filepath.Join(t.TempDir(), "people.csv"),
+	}
+
+	// Columns of all the entries
+	columns := []string{}
+	for _, entry := range entries {
+		for column := range entry {
+			if !contains(columns, column) {
+				columns = append(columns, column)
+			}
+		}
+	}
+
+	sort.Strings(columns)
+
+	file, err := os.Create(source.Access["path"])
+	if err != nil {
+		t.Fatalf("Can't create data file: %s", err.Error())
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write(columns)
+
+	for _, entry := range entries {
+		row := make([]string, 0, len(columns))
+		for _, column := range columns {
+			if entry[column] == nil {
+				row = append(row, "")
+			} else {
+				row = append(row, fmt.Sprint(entry[column]))
+			}
+		}
+
+		w.Write(row)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		t.Fatalf("Can't write data file: %s", err.Error())
+	}
+
+	return source
+}
+
+/*
+ * Check whether the slice contains the given string
+ */
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
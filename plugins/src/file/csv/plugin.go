@@ -11,10 +11,17 @@ import (
  */
 var (
 	Name    = "file-csv"
-	Version = "1.0.7"
+	Version = "1.0.11"
 	Plugin  plugin
 )
 
+/*
+ * Register the plugin to be available to the core
+ */
+func init() {
+	pdk.Register(Name, Version, func() interface{} { return &plugin{} })
+}
+
 /*
  * Structure to be imported by the core as a plugin
  */
@@ -9,13 +9,10 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
-	"sync"
 
 	"github.com/Jeffail/gabs/v2"
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/cert-lv/graphoscope/pdk"
-	"github.com/umpc/go-sortedmap"
-	"github.com/umpc/go-sortedmap/desc"
 )
 
 /*
@@ -61,9 +58,6 @@ func (p *plugin) Fields() ([]string, error) {
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
-
 	// Debug info
 	debug := make(map[string]interface{})
 
@@ -115,14 +109,6 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 	var entries []map[string]interface{}
 
 	for _, body := range unpacked {
-		// Struct to store statistics data
-		// when the amount of returned entries is too large
-		stats := pdk.NewStats()
-
-		for _, field := range p.source.StatsFields {
-			stats.Fields[field] = sortedmap.New(10, desc.Int)
-		}
-
 		jsonParsed, err := gabs.ParseJSON([]byte(body))
 		if err != nil {
 			return nil, nil, debug, err
@@ -204,142 +190,21 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 		return nil, nil, debug, err
 	}
 
-	mx := sync.Mutex{}
-	umx := sync.Mutex{}
-	unique := make(map[string]bool)
-	counter := 0
-
-	// Struct to store statistics data
-	// when the amount of returned entries is too large
-	stats := pdk.NewStats()
-
-	for _, field := range p.source.StatsFields {
-		stats.Fields[field] = sortedmap.New(10, desc.Int)
-	}
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
 
 	for _, entry := range entries {
-
 		// Stop when results count is over the limit
-		if counter >= p.limit {
-			top, err := stats.ToJSON(p.source.Name)
-			if err != nil {
-				return nil, nil, debug, err
-			}
-
-			return results, top, debug, nil
-		}
-
-		// Update stats
-		for _, field := range p.source.StatsFields {
-			stats.Update(entry, field)
+		if !builder.Add(entry) {
+			break
 		}
+	}
 
-		// Go through all the predefined relations and collect unique entries
-		for _, relation := range p.source.Relations {
-			if entry[relation.From.ID] != nil && entry[relation.To.ID] != nil {
-				umx.Lock()
-				if _, exists := unique[entry[relation.From.ID].(string)+entry[relation.To.ID].(string)]; exists {
-					if pdk.ResultsContain(results, entry, relation) {
-						umx.Unlock()
-						continue
-					}
-				}
-
-				counter++
-
-				unique[entry[relation.From.ID].(string)+entry[relation.To.ID].(string)] = true
-				umx.Unlock()
-
-				/*
-				 * FROM node with attributes
-				 */
-				from := map[string]interface{}{
-					"id":     entry[relation.From.ID],
-					"group":  relation.From.Group,
-					"search": relation.From.Search,
-				}
-
-				// Check FROM type & searching fields
-				if len(relation.From.VarTypes) > 0 {
-					for _, t := range relation.From.VarTypes {
-						if t.RegexCompiled.MatchString(entry[relation.From.ID].(string)) {
-							from["group"] = t.Group
-							from["search"] = t.Search
-
-							break
-						}
-					}
-				}
-
-				if len(relation.From.Attributes) > 0 {
-					from["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, from["attributes"].(map[string]interface{}), relation.From.Attributes)
-				}
-
-				/*
-				 * TO node
-				 */
-				to := map[string]interface{}{
-					"id":     entry[relation.To.ID],
-					"group":  relation.To.Group,
-					"search": relation.To.Search,
-				}
-
-				// Check FROM type & searching fields
-				if len(relation.To.VarTypes) > 0 {
-					for _, t := range relation.To.VarTypes {
-						if t.RegexCompiled.MatchString(entry[relation.To.ID].(string)) {
-							to["group"] = t.Group
-							to["search"] = t.Search
-
-							break
-						}
-					}
-				}
-
-				if len(relation.To.Attributes) > 0 {
-					to["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, to["attributes"].(map[string]interface{}), relation.To.Attributes)
-				}
-
-				// Resulting graph entry to return
-				result := make(map[string]interface{})
-
-				/*
-				 * Edge between FROM and TO
-				 */
-				if relation.Edge != nil && (relation.Edge.Label != "" || len(relation.Edge.Attributes) > 0) {
-					result["edge"] = make(map[string]interface{})
-
-					if relation.Edge.Label != "" {
-						result["edge"].(map[string]interface{})["label"] = relation.Edge.Label
-					}
-
-					if len(relation.Edge.Attributes) > 0 {
-						pdk.CopyPresentValues(entry, result["edge"].(map[string]interface{}), relation.Edge.Attributes)
-					}
-				}
-
-				/*
-				 * Put it together
-				 */
-				result["from"] = from
-				result["to"] = to
-				result["source"] = p.source.Name
-
-				//fmt.Println("Edge:", from, to)
-
-				/*
-				 * Add current entry to the list to return
-				 */
-				mx.Lock()
-				results = append(results, result)
-				mx.Unlock()
-			}
-		}
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, err
 	}
 
-	return results, nil, debug, nil
+	return results, stats, debug, nil
 }
 
 // request connects to the HTTP access point and returns the response
@@ -2,18 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
-	"sync"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/cert-lv/graphoscope/pdk"
-	"github.com/umpc/go-sortedmap"
-	"github.com/umpc/go-sortedmap/desc"
 )
 
 /*
@@ -64,9 +62,10 @@ func (p *plugin) Fields() ([]string, error) {
 }
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	return p.SearchContext(context.Background(), stmt)
+}
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
+func (p *plugin) SearchContext(ctx context.Context, stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
 	// Convert SQL statement
 	searchFields, err := p.convert(stmt)
@@ -80,19 +79,11 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 	/*
 	 * Send indicators to get results back
 	 */
-	body, debug, err = p.request(searchFields)
+	body, debug, err = p.request(ctx, searchFields)
 	if err != nil {
 		return nil, nil, debug, err
 	}
 
-	// Struct to store statistics data
-	// when the amount of returned entries is too large
-	stats := pdk.NewStats()
-
-	for _, field := range p.source.StatsFields {
-		stats.Fields[field] = sortedmap.New(10, desc.Int)
-	}
-
 	/*
 	 * Receive hits and deserialize them
 	 */
@@ -102,155 +93,29 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 		return nil, nil, debug, err
 	}
 
-	mx := sync.Mutex{}
-	umx := sync.Mutex{}
-	unique := make(map[string]bool)
-	counter := 0
-
 	// Process results
-	for _, entry := range entries {
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
 
+	for _, entry := range entries {
 		// Stop when results count is too big
-		if counter >= p.limit {
-			top, err := stats.ToJSON(p.source.Name)
-			if err != nil {
-				return nil, nil, debug, err
-			}
-
-			return results, top, debug, nil
-		}
-
-		// Update stats
-		for _, field := range p.source.StatsFields {
-			stats.Update(entry, field)
+		if !builder.Add(entry) {
+			break
 		}
+	}
 
-		// Go through all the predefined relations and collect unique entries
-		for _, relation := range p.source.Relations {
-			if entry[relation.From.ID] != nil && entry[relation.To.ID] != nil {
-				umx.Lock()
-
-				// Use "Printf(...%v..." instead of "entry[relation.From.ID].(string)"
-				// as the value can be not a string only
-				if _, exists := unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])]; exists {
-					if pdk.ResultsContain(results, entry, relation) {
-						umx.Unlock()
-						continue
-					}
-				}
-
-				counter++
-
-				unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])] = true
-				umx.Unlock()
-
-				/*
-				 * Check if expected relation exists in received data.
-				 * This allows returned JSON objects to have dynamic schema
-				 */
-				if _, ok := entry[relation.From.ID]; !ok {
-					continue
-				}
-
-				if _, ok := entry[relation.To.ID]; !ok {
-					continue
-				}
-
-				/*
-				 * FROM node with attributes
-				 */
-				from := map[string]interface{}{
-					"id":     entry[relation.From.ID],
-					"group":  relation.From.Group,
-					"search": relation.From.Search,
-				}
-
-				// Check FROM type & searching fields
-				if len(relation.From.VarTypes) > 0 {
-					for _, t := range relation.From.VarTypes {
-						if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[relation.From.ID])) {
-							from["group"] = t.Group
-							from["search"] = t.Search
-
-							break
-						}
-					}
-				}
-
-				if len(relation.From.Attributes) > 0 {
-					from["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, from["attributes"].(map[string]interface{}), relation.From.Attributes)
-				}
-
-				/*
-				 * TO node
-				 */
-				to := map[string]interface{}{
-					"id":     entry[relation.To.ID],
-					"group":  relation.To.Group,
-					"search": relation.To.Search,
-				}
-
-				// Check FROM type & searching fields
-				if len(relation.To.VarTypes) > 0 {
-					for _, t := range relation.To.VarTypes {
-						if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[relation.To.ID])) {
-							to["group"] = t.Group
-							to["search"] = t.Search
-
-							break
-						}
-					}
-				}
-
-				if len(relation.To.Attributes) > 0 {
-					to["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, to["attributes"].(map[string]interface{}), relation.To.Attributes)
-				}
-
-				// Resulting graph entry to return
-				result := make(map[string]interface{})
-
-				/*
-				 * Edge between FROM and TO
-				 */
-				if relation.Edge != nil && (relation.Edge.Label != "" || len(relation.Edge.Attributes) > 0) {
-					result["edge"] = make(map[string]interface{})
-
-					if relation.Edge.Label != "" {
-						result["edge"].(map[string]interface{})["label"] = relation.Edge.Label
-					}
-
-					if len(relation.Edge.Attributes) > 0 {
-						result["edge"].(map[string]interface{})["attributes"] = make(map[string]interface{})
-						pdk.CopyPresentValues(entry, result["edge"].(map[string]interface{})["attributes"].(map[string]interface{}), relation.Edge.Attributes)
-					}
-				}
-
-				/*
-				 * Put it together
-				 */
-				result["from"] = from
-				result["to"] = to
-				result["source"] = p.source.Name
-
-				//fmt.Println("Edge:", from, to, p.source.Name)
-
-				/*
-				 * Add current entry to the list to return
-				 */
-				mx.Lock()
-				results = append(results, result)
-				mx.Unlock()
-			}
-		}
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, err
 	}
 
-	return results, nil, debug, nil
+	// Next page starts after the used rows
+	debug[pdk.DebugRows] = builder.Entries()
+
+	return results, stats, debug, nil
 }
 
 // request connects to the HTTP access point and returns the response
-func (p *plugin) request(searchFields [][2]string) (*bytes.Buffer, map[string]interface{}, error) {
+func (p *plugin) request(ctx context.Context, searchFields [][2]string) (*bytes.Buffer, map[string]interface{}, error) {
 
 	// Create a request body
 	data := url.Values{}
@@ -276,7 +141,7 @@ func (p *plugin) request(searchFields [][2]string) (*bytes.Buffer, map[string]in
 		debug["query"] = p.url
 		debug["POST_payload"] = payload.String()
 
-		req, err = http.NewRequest("POST", p.url, payload)
+		req, err = http.NewRequestWithContext(ctx, "POST", p.url, payload)
 		if err != nil {
 			return nil, debug, fmt.Errorf("Can't create a POST request: %s", err.Error())
 		}
@@ -284,7 +149,7 @@ func (p *plugin) request(searchFields [][2]string) (*bytes.Buffer, map[string]in
 		req.Header.Add("Content-Type", "application/json; charset=UTF-8")
 
 	} else {
-		req, err = http.NewRequest("GET", p.url, nil)
+		req, err = http.NewRequestWithContext(ctx, "GET", p.url, nil)
 		if err != nil {
 			return nil, debug, fmt.Errorf("Can't create a GET request: %s", err.Error())
 		}
@@ -325,6 +190,16 @@ func (p *plugin) request(searchFields [][2]string) (*bytes.Buffer, map[string]in
 	return body, debug, nil
 }
 
+/*
+ * Requested fields are sent as a flat list of "field=value" parameters,
+ * so the rest of the filters are applied by the core
+ */
+func (p *plugin) Capabilities() *pdk.Capabilities {
+	return &pdk.Capabilities{
+		Operators: []string{"=", "between"},
+	}
+}
+
 func (p *plugin) Stop() error {
 
 	// No error to check, so return nil
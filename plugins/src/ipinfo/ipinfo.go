@@ -8,12 +8,9 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
-	"sync"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/cert-lv/graphoscope/pdk"
-	"github.com/umpc/go-sortedmap"
-	"github.com/umpc/go-sortedmap/desc"
 )
 
 // type Response struct {
@@ -76,9 +73,6 @@ func (p *plugin) Fields() ([]string, error) {
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
-
 	// Convert SQL statement
 	searchField, err := p.convert(stmt)
 	if err != nil {
@@ -87,7 +81,7 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 
 	// With a free access level only IP can be queried
 	if searchField[0] != "ip" {
-		return results, nil, nil, nil
+		return []map[string]interface{}{}, nil, nil, nil
 	}
 
 	/*
@@ -98,18 +92,6 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 		return nil, nil, debug, err
 	}
 
-	// Struct to store statistics data
-	// when the amount of returned entries is too large
-	stats := pdk.NewStats()
-
-	for _, field := range p.source.StatsFields {
-		stats.Fields[field] = sortedmap.New(10, desc.Int)
-	}
-
-	mx := &sync.Mutex{}
-	unique := make(map[string]bool)
-	counter := 0
-
 	// API response struct
 	var entry map[string]interface{}
 	err = json.Unmarshal(response, &entry)
@@ -131,24 +113,16 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 		}
 	}
 
-	// Stop when results count is too big
-	if counter >= p.limit {
-		top, err := stats.ToJSON(p.source.Name)
-		if err != nil {
-			return nil, nil, debug, err
-		}
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
+	builder.VarTypeLabels = true
+	builder.Add(entry)
 
-		return results, top, debug, nil
-	}
-
-	// Update stats
-	for _, field := range p.source.StatsFields {
-		stats.Update(entry, field)
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, err
 	}
 
-	pdk.CreateRelations(p.source, entry, unique, &counter, mx, &results)
-
-	return results, nil, debug, nil
+	return results, stats, debug, nil
 }
 
 // request connects to the API access point and returns the response
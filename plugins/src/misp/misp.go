@@ -9,13 +9,10 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/cert-lv/graphoscope/pdk"
-	"github.com/umpc/go-sortedmap"
-	"github.com/umpc/go-sortedmap/desc"
 )
 
 /*
@@ -74,9 +71,6 @@ func (p *plugin) Fields() ([]string, error) {
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
-
 	// Convert SQL statement
 	searchField, err := p.convert(stmt)
 	if err != nil {
@@ -93,44 +87,28 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 
 	//fmt.Printf("MISP response:\n%v\n", body)
 
-	// Struct to store statistics data
-	// when the amount of returned entries is too large
-	stats := pdk.NewStats()
-
-	for _, field := range p.source.StatsFields {
-		stats.Fields[field] = sortedmap.New(10, desc.Int)
-	}
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
+	builder.VarTypeLabels = true
 
 	/*
 	 * Receive hits and deserialize them
 	 */
 
-	mx := &sync.Mutex{}
-	unique := make(map[string]bool)
-	counter := 0
-
 	// Process results
 	for _, entry := range entries {
 
 		// Stop when results count is too big
-		if counter >= p.limit {
-			top, err := stats.ToJSON(p.source.Name)
-			if err != nil {
-				return nil, nil, debug, err
-			}
-
-			return results, top, debug, nil
-		}
-
-		// Update stats
-		for _, field := range p.source.StatsFields {
-			stats.Update(entry, field)
+		if !builder.Add(entry) {
+			break
 		}
+	}
 
-		pdk.CreateRelations(p.source, entry, unique, &counter, mx, &results)
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, err
 	}
 
-	return results, nil, debug, nil
+	return results, stats, debug, nil
 }
 
 // request connects to the MISP instance and returns the response
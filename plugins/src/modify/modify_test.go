@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/cert-lv/graphoscope/pdk"
+	"github.com/cert-lv/graphoscope/pdk/plugintest"
 )
 
 /*
@@ -106,3 +107,50 @@ func TestProcess(t *testing.T) {
 		}
 	}
 }
+
+/*
+ * Test plugin's compatibility with the core
+ */
+func TestConformance(t *testing.T) {
+	suite := &plugintest.ProcessorSuite{
+		New: func() pdk.ProcessorPlugin { return &plugin{} },
+
+		Processor: func() *pdk.Processor {
+			return &pdk.Processor{
+				Name: "anonymize",
+				Data: map[string]interface{}{
+					"group": "name",
+
+					"modify": []interface{}{
+						map[string]interface{}{
+							"field":       "id",
+							"regex":       "^.*@",
+							"replacement": "***@",
+						},
+					},
+				},
+			}
+		},
+
+		Cases: []*plugintest.ProcessorCase{
+			{
+				Relations: []map[string]interface{}{
+					{
+						"from":   map[string]interface{}{"id": "john@example.com", "group": "name", "search": "name"},
+						"to":     map[string]interface{}{"id": "LV", "group": "country", "search": "country"},
+						"source": "people",
+					},
+				},
+				Expected: []map[string]interface{}{
+					{
+						"from":   map[string]interface{}{"id": "***@example.com", "group": "name", "search": "name"},
+						"to":     map[string]interface{}{"id": "LV", "group": "country", "search": "country"},
+						"source": "people",
+					},
+				},
+			},
+		},
+	}
+
+	suite.Run(t)
+}
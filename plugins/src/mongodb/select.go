@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/cert-lv/graphoscope/pdk"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -289,6 +290,39 @@ func handleSelectWhereBetweenExpr(expr *sqlparser.Expr, topLevel bool) (bson.M,
 	return resultStr, nil
 }
 
+/*
+ * Handle "cidr_match(field, 'network')" filter.
+ * IP addresses are expected to be stored as integers
+ *
+ * Receives SQL expression to process
+ */
+func handleSelectWhereFuncExpr(expr *sqlparser.Expr) (bson.M, error) {
+	funcExpr := (*expr).(*sqlparser.FuncExpr)
+
+	if !pdk.IsCIDR(funcExpr) {
+		return nil, errors.New("Unsupported function: " + funcExpr.Name.String())
+	}
+
+	colNameStr, network, err := pdk.ParseCIDR(funcExpr)
+	if err != nil {
+		return nil, err
+	}
+
+	first, last := pdk.IPRange(network)
+
+	fromInt, err := pdk.IPv4ToInt(first)
+	if err != nil {
+		return nil, errors.New("Only IPv4 networks are supported: " + err.Error())
+	}
+
+	toInt, err := pdk.IPv4ToInt(last)
+	if err != nil {
+		return nil, errors.New("Only IPv4 networks are supported: " + err.Error())
+	}
+
+	return bson.M{colNameStr: bson.M{"$gte": fromInt, "$lte": toInt}}, nil
+}
+
 /*
  * Handle top level or groups of expressions.
  *
@@ -417,6 +451,9 @@ func handleSelectWhere(expr *sqlparser.Expr, topLevel bool, parent *sqlparser.Ex
 
 	case *sqlparser.ParenExpr:
 		return handleSelectWhereParenExpr(expr, topLevel, parent)
+
+	case *sqlparser.FuncExpr:
+		return handleSelectWhereFuncExpr(expr)
 	}
 
 	return nil, fmt.Errorf("Unexpected SQL expression type received: %T", *expr)
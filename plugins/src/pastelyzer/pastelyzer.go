@@ -8,12 +8,9 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
-	"sync"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/cert-lv/graphoscope/pdk"
-	"github.com/umpc/go-sortedmap"
-	"github.com/umpc/go-sortedmap/desc"
 )
 
 /*
@@ -59,9 +56,6 @@ func (p *plugin) Fields() ([]string, error) {
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
-
 	// Convert SQL statement
 	searchFields, err := p.convert(stmt)
 	if err != nil {
@@ -94,181 +88,47 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 	 * Receive hits and deserialize them
 	 */
 
-	mx := sync.Mutex{}
-	umx := sync.Mutex{}
-	unique := make(map[string]bool)
-	counter := 0
-
-	// Struct to store statistics data
-	// when the amount of returned entries is too large
-	stats := pdk.NewStats()
-
-	for _, field := range p.source.StatsFields {
-		stats.Fields[field] = sortedmap.New(10, desc.Int)
-	}
-
 	var entries []map[string]interface{}
 	err = json.NewDecoder(body).Decode(&entries)
 	if err != nil {
 		return nil, nil, debug, err
 	}
 
-	// Process results
-	for _, entry := range entries {
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
 
-		// Stop when results count is too big
-		if counter >= p.limit {
-			top, err := stats.ToJSON(p.source.Name)
-			if err != nil {
-				return nil, nil, debug, err
-			}
-
-			return results, top, debug, nil
+	// Manually add link to the paste's content
+	builder.ModifyNode = func(node *pdk.Node, entry, result map[string]interface{}) {
+		if node.ID != "source" {
+			return
 		}
 
-		// Update stats
-		for _, field := range p.source.StatsFields {
-			stats.Update(entry, field)
+		// Show ID only, not full URL
+		result["id"] = strings.Split(result["id"].(string), "/")[4]
+
+		// Append additional param,
+		// which is no available by default
+		if _, ok := result["attributes"]; !ok {
+			result["attributes"] = make(map[string]interface{})
 		}
 
-		// Go through all the predefined relations and collect unique entries
-		for _, relation := range p.source.Relations {
-			if entry[relation.From.ID] != nil && entry[relation.To.ID] != nil {
-				umx.Lock()
-
-				// Use "Printf(...%v..." instead of "entry[relation.From.ID].(string)"
-				// as the value can be not a string only
-				if _, exists := unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])]; exists {
-					if pdk.ResultsContain(results, entry, relation) {
-						umx.Unlock()
-						continue
-					}
-				}
-
-				counter++
-
-				unique[fmt.Sprintf("%v-%v-%v-%v", relation.From.ID, entry[relation.From.ID], relation.To.ID, entry[relation.To.ID])] = true
-				umx.Unlock()
-
-				/*
-				 * FROM node with attributes
-				 */
-				from := map[string]interface{}{
-					"id":     entry[relation.From.ID],
-					"group":  relation.From.Group,
-					"search": relation.From.Search,
-				}
-
-				// Check FROM type & searching fields
-				if len(relation.From.VarTypes) > 0 {
-					for _, t := range relation.From.VarTypes {
-						if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[relation.From.ID])) {
-							from["group"] = t.Group
-							from["search"] = t.Search
-
-							break
-						}
-					}
-				}
-
-				if len(relation.From.Attributes) > 0 {
-					from["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, from["attributes"].(map[string]interface{}), relation.From.Attributes)
-				}
-
-				// Manually add link to the paste's content
-				if relation.From.ID == "source" {
-					// Show ID only, not full URL
-					from["id"] = strings.Split(from["id"].(string), "/")[4]
-
-					// Append additional param,
-					// which is no available by default
-					if _, ok := from["attributes"]; !ok {
-						from["attributes"] = make(map[string]interface{})
-					}
-
-					from["attributes"].(map[string]interface{})["content"] = entry["source"].(string) + "body"
-				}
-
-				/*
-				 * TO node
-				 */
-				to := map[string]interface{}{
-					"id":     entry[relation.To.ID],
-					"group":  relation.To.Group,
-					"search": relation.To.Search,
-				}
-
-				// Check FROM type & searching fields
-				if len(relation.To.VarTypes) > 0 {
-					for _, t := range relation.To.VarTypes {
-						if t.RegexCompiled.MatchString(fmt.Sprintf("%v", entry[relation.To.ID])) {
-							to["group"] = t.Group
-							to["search"] = t.Search
-
-							break
-						}
-					}
-				}
-
-				if len(relation.To.Attributes) > 0 {
-					to["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, to["attributes"].(map[string]interface{}), relation.To.Attributes)
-				}
-
-				// Manually add link to the paste's content
-				if relation.To.ID == "source" {
-					// Show ID only, not full URL
-					to["id"] = strings.Split(to["id"].(string), "/")[4]
-
-					// Append additional param,
-					// which is no available by default
-					if _, ok := to["attributes"]; !ok {
-						to["attributes"] = make(map[string]interface{})
-					}
-
-					to["attributes"].(map[string]interface{})["content"] = entry["source"].(string) + "body"
-				}
-
-				// Resulting graph entry to return
-				result := make(map[string]interface{})
-
-				/*
-				 * Edge between FROM and TO
-				 */
-				if relation.Edge != nil && (relation.Edge.Label != "" || len(relation.Edge.Attributes) > 0) {
-					result["edge"] = make(map[string]interface{})
-
-					if relation.Edge.Label != "" {
-						result["edge"].(map[string]interface{})["label"] = relation.Edge.Label
-					}
-
-					if len(relation.Edge.Attributes) > 0 {
-						result["edge"].(map[string]interface{})["attributes"] = make(map[string]interface{})
-						pdk.CopyPresentValues(entry, result["edge"].(map[string]interface{})["attributes"].(map[string]interface{}), relation.Edge.Attributes)
-					}
-				}
-
-				/*
-				 * Put it together
-				 */
-				result["from"] = from
-				result["to"] = to
-				result["source"] = p.source.Name
-
-				//fmt.Println("Edge:", from, to, p.name)
-
-				/*
-				 * Add current entry to the list to return
-				 */
-				mx.Lock()
-				results = append(results, result)
-				mx.Unlock()
-			}
+		result["attributes"].(map[string]interface{})["content"] = entry["source"].(string) + "body"
+	}
+
+	// Process results
+	for _, entry := range entries {
+
+		// Stop when results count is too big
+		if !builder.Add(entry) {
+			break
 		}
 	}
 
-	return results, nil, debug, nil
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, err
+	}
+
+	return results, stats, debug, nil
 }
 
 /*
@@ -9,10 +9,17 @@ import (
  */
 var (
 	Name    = "pastelyzer"
-	Version = "1.0.4"
+	Version = "1.0.6"
 	Plugin  plugin
 )
 
+/*
+ * Register the plugin to be available to the core
+ */
+func init() {
+	pdk.Register(Name, Version, func() interface{} { return &plugin{} })
+}
+
 /*
  * Structure to be imported by the core as a plugin
  */
@@ -8,12 +8,9 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
-	"sync"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/cert-lv/graphoscope/pdk"
-	"github.com/umpc/go-sortedmap"
-	"github.com/umpc/go-sortedmap/desc"
 )
 
 type Response struct {
@@ -69,9 +66,6 @@ func (p *plugin) Fields() ([]string, error) {
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
-
 	// Convert SQL statement
 	searchFields, err := p.convert(stmt)
 	if err != nil {
@@ -87,20 +81,11 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 	}
 
 	if response == nil {
-		return results, nil, debug, nil
-	}
-
-	// Struct to store statistics data
-	// when the amount of returned entries is too large
-	stats := pdk.NewStats()
-
-	for _, field := range p.source.StatsFields {
-		stats.Fields[field] = sortedmap.New(10, desc.Int)
+		return []map[string]interface{}{}, nil, debug, nil
 	}
 
-	mx := &sync.Mutex{}
-	unique := make(map[string]bool)
-	counter := 0
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
+	builder.VarTypeLabels = true
 
 	// Process results
 	for _, result := range response.Results {
@@ -116,24 +101,17 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 		entry["domain"] = urlParsed.Hostname()
 
 		// Stop when results count is too big
-		if counter >= p.limit {
-			top, err := stats.ToJSON(p.source.Name)
-			if err != nil {
-				return nil, nil, debug, err
-			}
-
-			return results, top, debug, nil
-		}
-
-		// Update stats
-		for _, field := range p.source.StatsFields {
-			stats.Update(entry, field)
+		if !builder.Add(entry) {
+			break
 		}
+	}
 
-		pdk.CreateRelations(p.source, entry, unique, &counter, mx, &results)
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, err
 	}
 
-	return results, nil, debug, nil
+	return results, stats, debug, nil
 }
 
 // request connects to the API access point and returns the response
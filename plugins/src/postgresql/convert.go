@@ -6,6 +6,7 @@ package main
 
 import (
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/cert-lv/graphoscope/pdk"
 )
 
 /*
@@ -14,7 +15,9 @@ import (
 func (p *plugin) convert(sel *sqlparser.Select) (string, error) {
 
 	// Handle WHERE
-	query := sqlparser.String(sel.Where.Expr)
+	buf := sqlparser.NewTrackedBuffer(formatNode)
+	buf.Myprintf("%v", sel.Where.Expr)
+	query := buf.String()
 
 	// Handle GROUP BY
 	if len(sel.GroupBy) > 0 {
@@ -49,3 +52,19 @@ func (p *plugin) convert(sel *sqlparser.Select) (string, error) {
 
 	return query, nil
 }
+
+/*
+ * Format SQL nodes the PostgreSQL way where it differs from MySQL:
+ * "cidr_match(field, 'network')" becomes "field::inet <<= 'network'"
+ */
+func formatNode(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+	if f, ok := node.(*sqlparser.FuncExpr); ok && pdk.IsCIDR(f) {
+		field, network, err := pdk.ParseCIDR(f)
+		if err == nil {
+			buf.Myprintf("%s::inet <<= '%s'", field, network.String())
+			return
+		}
+	}
+
+	node.Format(buf)
+}
@@ -29,6 +29,8 @@ func TestConvert(t *testing.T) {
 		{`SELECT * WHERE size BETWEEN 100 AND 300`, `size between 100 and 300`},
 		{`SELECT * WHERE size IN (100,300)`, `size in (100, 300)`},
 		{`SELECT * WHERE size NOT IN (100,300)`, `size not in (100, 300)`},
+		{`SELECT * WHERE cidr_match(ip, '10.10.10.0/24')`, `ip::inet <<= '10.10.10.0/24'`},
+		{`SELECT * WHERE name='sarah' AND cidr_match(ip, '10.0.0.0/8')`, `name = 'sarah' and ip::inet <<= '10.0.0.0/8'`},
 		{`SELECT * WHERE name='sarah' and age!=40 AND (country='LV' OR country='AU') ORDER BY age DESC limit 1`,
 			`name = 'sarah' and age != 40 and (country = 'LV' or country = 'AU') order by age desc OFFSET 0 LIMIT 1`},
 	}
@@ -84,9 +84,10 @@ func (p *plugin) Fields() ([]string, error) {
 }
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	return p.SearchContext(context.Background(), stmt)
+}
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
+func (p *plugin) SearchContext(ctx context.Context, stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
 	// Convert SQL statement
 	filter, err := p.convert(stmt)
@@ -103,7 +104,7 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 	 */
 
 	// Context to be able to cancel goroutines when time expires
-	ctx, cancel := context.WithTimeout(context.Background(), p.source.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, p.source.Timeout)
 	defer cancel()
 
 	record := p.client.HGetAll(ctx, filter).Val()
@@ -116,100 +117,15 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 	}
 
 	// Go through all the predefined relations and create them
-	for _, relation := range p.source.Relations {
-		fromID, fromExists := entry[relation.From.ID]
-		toID, toExists := entry[relation.To.ID]
-
-		if fromExists && toExists {
-
-			/*
-			 * FROM node with attributes
-			 */
-			from := map[string]interface{}{
-				"id":     fromID,
-				"group":  relation.From.Group,
-				"search": relation.From.Search,
-			}
-
-			// Check FROM type & searching fields
-			if len(relation.From.VarTypes) > 0 {
-				for _, t := range relation.From.VarTypes {
-					if t.RegexCompiled.MatchString(fmt.Sprintf("%v", fromID)) {
-						from["group"] = t.Group
-						from["search"] = t.Search
-
-						break
-					}
-				}
-			}
-
-			if len(relation.From.Attributes) > 0 {
-				from["attributes"] = make(map[string]interface{})
-				pdk.CopyPresentValues(entry, from["attributes"].(map[string]interface{}), relation.From.Attributes)
-			}
-
-			/*
-			 * TO node
-			 */
-			to := map[string]interface{}{
-				"id":     toID,
-				"group":  relation.To.Group,
-				"search": relation.To.Search,
-			}
-
-			// Check FROM type & searching fields
-			if len(relation.To.VarTypes) > 0 {
-				for _, t := range relation.To.VarTypes {
-					if t.RegexCompiled.MatchString(fmt.Sprintf("%v", toID)) {
-						to["group"] = t.Group
-						to["search"] = t.Search
-
-						break
-					}
-				}
-			}
-
-			if len(relation.To.Attributes) > 0 {
-				to["attributes"] = make(map[string]interface{})
-				pdk.CopyPresentValues(entry, to["attributes"].(map[string]interface{}), relation.To.Attributes)
-			}
-
-			// Resulting graph entry to return
-			result := make(map[string]interface{})
-
-			/*
-			 * Edge between FROM and TO
-			 */
-			if relation.Edge != nil && (relation.Edge.Label != "" || len(relation.Edge.Attributes) > 0) {
-				result["edge"] = make(map[string]interface{})
-
-				if relation.Edge.Label != "" {
-					result["edge"].(map[string]interface{})["label"] = relation.Edge.Label
-				}
-
-				if len(relation.Edge.Attributes) > 0 {
-					result["edge"].(map[string]interface{})["attributes"] = make(map[string]interface{})
-					pdk.CopyPresentValues(entry, result["edge"].(map[string]interface{})["attributes"].(map[string]interface{}), relation.Edge.Attributes)
-				}
-			}
-
-			/*
-			 * Put it together
-			 */
-			result["from"] = from
-			result["to"] = to
-			result["source"] = p.source.Name
-
-			//fmt.Println("Edge:", from, to)
-
-			/*
-			 * Add current entry to the list to return
-			 */
-			results = append(results, result)
-		}
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
+	builder.Add(entry)
+
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, err
 	}
 
-	return results, nil, debug, nil
+	return results, stats, debug, nil
 }
 
 func (p *plugin) Stop() error {
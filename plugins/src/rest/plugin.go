@@ -9,10 +9,17 @@ import (
  */
 var (
 	Name    = "rest"
-	Version = "1.0.1"
+	Version = "1.0.5"
 	Plugin  plugin
 )
 
+/*
+ * Register the plugin to be available to the core
+ */
+func init() {
+	pdk.Register(Name, Version, func() interface{} { return &plugin{} })
+}
+
 /*
  * Structure to be imported by the core as a plugin
  */
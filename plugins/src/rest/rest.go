@@ -2,17 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strings"
-	"sync"
 
 	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/cert-lv/graphoscope/pdk"
-	"github.com/umpc/go-sortedmap"
-	"github.com/umpc/go-sortedmap/desc"
 )
 
 /*
@@ -59,9 +57,10 @@ func (p *plugin) Fields() ([]string, error) {
 }
 
 func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	return p.SearchContext(context.Background(), stmt)
+}
 
-	// Storage for the results to return
-	results := []map[string]interface{}{}
+func (p *plugin) SearchContext(ctx context.Context, stmt *sqlparser.Select) ([]map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
 
 	// Convert SQL statement
 	searchField, err := p.convert(stmt)
@@ -75,20 +74,15 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 	/*
 	 * Send indicators to get results back
 	 */
-	body, debug, err = p.request(searchField)
+	body, debug, err = p.request(ctx, searchField)
 	if err != nil {
 		return nil, nil, debug, err
 	}
 
 	//fmt.Printf("REST API response:\n%v\n", body)
 
-	// Struct to store statistics data
-	// when the amount of returned entries is too large
-	stats := pdk.NewStats()
-
-	for _, field := range p.source.StatsFields {
-		stats.Fields[field] = sortedmap.New(10, desc.Int)
-	}
+	builder := pdk.NewRelationBuilder(p.source, p.limit)
+	builder.VarTypeLabels = true
 
 	/*
 	 * Receive hits and deserialize them
@@ -118,36 +112,25 @@ func (p *plugin) Search(stmt *sqlparser.Select) ([]map[string]interface{}, map[s
 		return nil, nil, debug, err
 	}
 
-	mx := &sync.Mutex{}
-	unique := make(map[string]bool)
-	counter := 0
-
 	// Process results
 	for _, entry := range entries {
 
 		// Stop when results count is too big
-		if counter >= p.limit {
-			top, err := stats.ToJSON(p.source.Name)
-			if err != nil {
-				return nil, nil, debug, err
-			}
-
-			return results, top, debug, nil
-		}
-
-		// Update stats
-		for _, field := range p.source.StatsFields {
-			stats.Update(entry, field)
+		if !builder.Add(entry) {
+			break
 		}
+	}
 
-		pdk.CreateRelations(p.source, entry, unique, &counter, mx, &results)
+	results, stats, err := builder.Results()
+	if err != nil {
+		return nil, nil, debug, err
 	}
 
-	return results, nil, debug, nil
+	return results, stats, debug, nil
 }
 
 // request connects to the HTTP access point and returns the response
-func (p *plugin) request(searchField [2]string) (*bytes.Buffer, map[string]interface{}, error) {
+func (p *plugin) request(ctx context.Context, searchField [2]string) (*bytes.Buffer, map[string]interface{}, error) {
 
 	// Debug info
 	debug := make(map[string]interface{})
@@ -157,7 +140,7 @@ func (p *plugin) request(searchField [2]string) (*bytes.Buffer, map[string]inter
 
 	debug["query"] = p.url + "/" + searchField[0] + "/" + searchField[1]
 
-	req, err := http.NewRequest(http.MethodGet, p.url+"/"+searchField[0]+"/"+searchField[1], nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url+"/"+searchField[0]+"/"+searchField[1], nil)
 	if err != nil {
 		return nil, debug, fmt.Errorf("Can't create a GET request: %s", err.Error())
 	}
@@ -190,6 +173,16 @@ func (p *plugin) request(searchField [2]string) (*bytes.Buffer, map[string]inter
 	return body, debug, nil
 }
 
+/*
+ * A single "field=value" filter is sent to the service,
+ * so the rest of the filters are applied by the core
+ */
+func (p *plugin) Capabilities() *pdk.Capabilities {
+	return &pdk.Capabilities{
+		Operators: []string{"="},
+	}
+}
+
 func (p *plugin) Stop() error {
 
 	// No error to check, so return nil
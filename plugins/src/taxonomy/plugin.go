@@ -9,10 +9,17 @@ import (
  */
 var (
 	Name    = "taxonomy"
-	Version = "1.0.0"
+	Version = "1.0.1"
 	Plugin  plugin
 )
 
+/*
+ * Register the plugin to be available to the core
+ */
+func init() {
+	pdk.Register(Name, Version, func() interface{} { return &plugin{} })
+}
+
 /*
  * Structure to be imported by the core as a plugin
  */
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/cert-lv/graphoscope/pdk"
+	"github.com/cert-lv/graphoscope/pdk/plugintest"
 )
 
 /*
@@ -107,3 +108,52 @@ func TestProcess(t *testing.T) {
 		}
 	}
 }
+
+/*
+ * Test plugin's compatibility with the core
+ */
+func TestConformance(t *testing.T) {
+	suite := &plugintest.ProcessorSuite{
+		New: func() pdk.ProcessorPlugin { return &plugin{} },
+
+		Processor: func() *pdk.Processor {
+			return &pdk.Processor{
+				Name: "taxonomy",
+				Data: map[string]interface{}{
+					"field": "id",
+					"group": "type",
+
+					"taxonomy": map[string]interface{}{
+						"brute-force": "intrusion-attempts",
+					},
+				},
+			}
+		},
+
+		Cases: []*plugintest.ProcessorCase{
+			{
+				Relations: []map[string]interface{}{
+					{
+						"from":   map[string]interface{}{"id": "10.10.10.10", "group": "ip", "search": "ip"},
+						"to":     map[string]interface{}{"id": "brute-force", "group": "type", "search": "type"},
+						"source": "incidents",
+					},
+				},
+				Expected: []map[string]interface{}{
+					{
+						"from":   map[string]interface{}{"id": "10.10.10.10", "group": "ip", "search": "ip"},
+						"to":     map[string]interface{}{"id": "brute-force", "group": "type", "search": "type"},
+						"source": "incidents",
+					},
+					{
+						"from":   map[string]interface{}{"id": "brute-force", "group": "type", "search": "type"},
+						"to":     map[string]interface{}{"id": "intrusion-attempts", "group": "taxonomy", "search": "taxonomy"},
+						"source": "taxonomy",
+					},
+				},
+			},
+		},
+	}
+
+	suite.Run(t)
+}
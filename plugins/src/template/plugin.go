@@ -19,6 +19,14 @@ var (
 	Plugin  plugin
 )
 
+/*
+ * Register the plugin, so it can be built into the core
+ * by the "static" build tag or loaded from a .so file
+ */
+func init() {
+	pdk.Register(Name, Version, func() interface{} { return &plugin{} })
+}
+
 /*
  * Structure to be imported by the core as a plugin
  */
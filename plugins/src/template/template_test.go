@@ -9,7 +9,9 @@ import (
 /*
  * STEP 17.
  *
- * Test plugin's functionality
+ * Test plugin's functionality.
+ * Use "pdk/plugintest" suites to check the plugin behaves the way the core expects,
+ * see "file/csv" and "modify" plugins tests as the examples
  */
 
 func TestConvert(t *testing.T) {
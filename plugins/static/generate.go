@@ -0,0 +1,170 @@
+//go:build ignore
+
+/*
+ * Prepare the chosen plugins to be built into the core binary.
+ *
+ * Plugins are "main" packages to be built as .so files,
+ * so their sources are copied into the importable packages
+ * and imported by the "static" build tag:
+ *
+ *     go run plugins/static/generate.go http rest file/csv
+ *     go build -tags static -o graphoscope .
+ *
+ * Without arguments all the plugins are prepared
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// Plugins sources
+	srcDir = "plugins/src"
+
+	// Generated packages
+	staticDir = "plugins/static"
+
+	// Generated imports of the core
+	importsFile = "plugins_static.go"
+
+	// Module path of the core
+	module = "github.com/cert-lv/graphoscope"
+)
+
+var (
+	// Package clause of the plugins sources
+	rePackage = regexp.MustCompile(`(?m)^package main$`)
+
+	// Characters not allowed in a package name
+	reName = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+)
+
+func main() {
+	names := os.Args[1:]
+
+	if len(names) == 0 {
+		var err error
+		names, err = allPlugins()
+		if err != nil {
+			fail("Can't list plugins: " + err.Error())
+		}
+	}
+
+	imports := []string{}
+
+	for _, name := range names {
+		pkg, err := generate(name)
+		if err != nil {
+			fail(fmt.Sprintf("Can't prepare plugin '%s': %s", name, err.Error()))
+		}
+
+		imports = append(imports, module+"/"+staticDir+"/"+pkg)
+		fmt.Println("Prepared plugin " + name)
+	}
+
+	sort.Strings(imports)
+
+	code := "//go:build static\n\n" +
+		"// Code generated by " + staticDir + "/generate.go. DO NOT EDIT.\n\n" +
+		"package main\n\n" +
+		"/*\n * Plugins built into the core, they register themselves\n */\n" +
+		"import (\n"
+
+	for _, path := range imports {
+		code += "\t_ \"" + path + "\"\n"
+	}
+
+	code += ")\n"
+
+	if err := os.WriteFile(importsFile, []byte(code), 0644); err != nil {
+		fail("Can't write imports: " + err.Error())
+	}
+}
+
+/*
+ * Find all the plugins directories, including the nested ones like "file/csv"
+ */
+func allPlugins() ([]string, error) {
+	names := []string{}
+
+	err := filepath.WalkDir(srcDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || d.Name() != "plugin.go" {
+			return nil
+		}
+
+		name := filepath.ToSlash(strings.TrimPrefix(filepath.Dir(path), srcDir+string(filepath.Separator)))
+
+		// Template is not a real plugin
+		if name != "template" {
+			names = append(names, name)
+		}
+
+		return nil
+	})
+
+	return names, err
+}
+
+/*
+ * Copy plugin's sources into an importable package.
+ * Returns the package name
+ */
+func generate(name string) (string, error) {
+	pkg := reName.ReplaceAllString(name, "_")
+	src := filepath.Join(srcDir, filepath.FromSlash(name))
+	dst := filepath.Join(staticDir, pkg)
+
+	files, err := filepath.Glob(filepath.Join(src, "*.go"))
+	if err != nil {
+		return "", err
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("No Go files in '%s'", src)
+	}
+
+	// Remove files of the previous generation
+	if err := os.RemoveAll(dst); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+
+		code := "// Code generated by " + staticDir + "/generate.go from " + filepath.ToSlash(file) + ". DO NOT EDIT.\n\n" +
+			rePackage.ReplaceAllString(string(b), "package "+pkg)
+
+		if err := os.WriteFile(filepath.Join(dst, filepath.Base(file)), []byte(code), 0644); err != nil {
+			return "", err
+		}
+	}
+
+	return pkg, nil
+}
+
+func fail(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(1)
+}
@@ -50,11 +50,23 @@ func profileHandler(w http.ResponseWriter, r *http.Request) {
 	// Merge some settings
 	account.Uploads.MaxSize = config.Upload.MaxSize
 
+	tokens, err := db.getTokens(username)
+	if err != nil {
+		log.Error().
+			Str("ip", ip).
+			Str("username", username).
+			Msg("Can't get API tokens: " + err.Error())
+	}
+
+	sourcesMx.RLock()
 	templateData := &TemplateData{
 		Account:        account,
+		Tokens:         tokens,
 		Collectors:     collectors,
+		SourceGroups:   sourceGroups,
 		NonGlobalExist: nonGlobalExist,
 	}
+	sourcesMx.RUnlock()
 
 	renderTemplate(w, "profile", templateData, nil)
 
@@ -71,7 +83,7 @@ func profileHandler(w http.ResponseWriter, r *http.Request) {
  *   1. New nodes stabilization time in milliseconds
  *   2. Limit value as a part of each query to the data sources
  */
-func (a *Account) optionsHandler(data string) {
+func (a *Account) optionsHandler(reqID, data string) {
 
 	parts := strings.Split(data, ",")
 
@@ -82,7 +94,7 @@ func (a *Account) optionsHandler(data string) {
 			Str("username", a.Username).
 			Msg("Can't parse stabilization time value: " + err.Error())
 
-		a.send("error", "Invalid <strong>stabilization time</strong> value given, integer expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>stabilization time</strong> value given, integer expected.", "Can't save!")
 		return
 	}
 
@@ -93,7 +105,7 @@ func (a *Account) optionsHandler(data string) {
 			Str("username", a.Username).
 			Msg("Can't parse limit value: " + err.Error())
 
-		a.send("error", "Invalid <strong>limit</strong> value given, integer expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>limit</strong> value given, integer expected.", "Can't save!")
 		return
 	}
 
@@ -104,7 +116,7 @@ func (a *Account) optionsHandler(data string) {
 			Str("username", a.Username).
 			Msg("Can't parse showLimited value: " + err.Error())
 
-		a.send("error", "Invalid <strong>showLimited</strong> value given, boolean expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>showLimited</strong> value given, boolean expected.", "Can't save!")
 		return
 	}
 
@@ -115,7 +127,7 @@ func (a *Account) optionsHandler(data string) {
 			Str("username", a.Username).
 			Msg("Can't parse debug value: " + err.Error())
 
-		a.send("error", "Invalid <strong>debug</strong> value given, boolean expected.", "Can't save!")
+		a.reply(reqID, "error", "Invalid <strong>debug</strong> value given, boolean expected.", "Can't save!")
 		return
 	}
 
@@ -134,11 +146,16 @@ func (a *Account) optionsHandler(data string) {
 			Str("username", a.Username).
 			Msg("Can't update profile options: " + err.Error())
 
-		a.send("error", err.Error(), "Can't update profile options!")
+		a.reply(reqID, "error", err.Error(), "Can't update profile options!")
 		return
 	}
 
-	a.send("ok", "", "")
+	// New searches use the new options
+	a.OptionsMutex.Lock()
+	a.Options = options
+	a.OptionsMutex.Unlock()
+
+	a.reply(reqID, "ok", "", "")
 
 	log.Info().
 		Str("ip", a.Session.IP).
@@ -482,9 +482,16 @@ func (c *Cursor) apply(parent, node sqlparser.SQLNode, replacer func(newNode, pa
 		c.apply(node, n.Name, func(newNode, parent sqlparser.SQLNode) {
 			parent.(*sqlparser.FuncExpr).Name = newNode.(sqlparser.ColIdent)
 		})
-		c.apply(node, n.Exprs, func(newNode, parent sqlparser.SQLNode) {
-			parent.(*sqlparser.FuncExpr).Exprs = newNode.(sqlparser.SelectExprs)
-		})
+		// Function arguments only, so fields like in
+		// "cidr_match(ip, '...')" are replaced too,
+		// while the list of selected fields stays untouched
+		for _, el := range n.Exprs {
+			if aliased, ok := el.(*sqlparser.AliasedExpr); ok {
+				c.apply(aliased, aliased.Expr, func(newNode, parent sqlparser.SQLNode) {
+					parent.(*sqlparser.AliasedExpr).Expr = newNode.(sqlparser.Expr)
+				})
+			}
+		}
 	case sqlparser.GroupBy:
 		for x, el := range n {
 			c.apply(node, el, func(idx int) func(sqlparser.SQLNode, sqlparser.SQLNode) {
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 
@@ -31,16 +32,196 @@ type APIresponse struct {
 	// Graph data or statistics will be returned as well
 	Error string `json:"error,omitempty"`
 
+	// Status of every queried data source
+	Sources map[string]*SourceStatus `json:"sources,omitempty"`
+
+	// Marks the last message of a streamed response
+	Done bool `json:"done,omitempty"`
+
+	// Rewritten queries of every data source
+	// when EXPLAIN is requested instead of the search
+	Explain map[string][]*Explanation `json:"explain,omitempty"`
+
+	// Opaque token to request the next page of the results,
+	// empty when there is no more data
+	Cursor string `json:"cursor,omitempty"`
+
+	// Next page positions of the paginated data sources
+	positions map[string]string
+
 	// Allow safe writing to the slice
 	sync.RWMutex
 }
 
+/*
+ * Structure of a single data source status in the API response
+ */
+type SourceStatus struct {
+	// One of: ok, error, timeout, limited.
+	// Background jobs use "running" until the results are received
+	Status string `json:"status"`
+
+	// Amount of the returned relations
+	Relations int `json:"relations"`
+
+	// Error message in case of a failed or timed out search
+	Error string `json:"error,omitempty"`
+
+	// Statistics (like Top 10)
+	// when the amount of returned results exceeds the limit
+	Stats map[string]interface{} `json:"stats,omitempty"`
+}
+
+const (
+	statusOK      = "ok"
+	statusError   = "error"
+	statusTimeout = "timeout"
+	statusLimited = "limited"
+)
+
+var (
+	// The more important status overrides the others
+	// when a data source runs several queries
+	statusPriority = map[string]int{
+		statusOK:      0,
+		statusLimited: 1,
+		statusTimeout: 2,
+		statusError:   3,
+	}
+)
+
+/*
+ * Add a single query result status of the given data source.
+ * Statuses of the same source are merged
+ */
+func (a *APIresponse) setStatus(source string, status *SourceStatus) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.Sources == nil {
+		a.Sources = make(map[string]*SourceStatus)
+	}
+
+	mergeStatus(a.Sources, source, status)
+}
+
+/*
+ * Merge the query's status into the data source's one,
+ * which may already contain the statuses of the other independent queries.
+ * The most important status wins, errors are combined
+ */
+func mergeStatus(statuses map[string]*SourceStatus, source string, status *SourceStatus) {
+	current, ok := statuses[source]
+	if !ok {
+		statuses[source] = &SourceStatus{
+			Status:    status.Status,
+			Relations: status.Relations,
+			Error:     status.Error,
+			Stats:     status.Stats,
+		}
+		return
+	}
+
+	current.Relations += status.Relations
+
+	if status.Stats != nil {
+		current.Stats = status.Stats
+	}
+
+	// Skip identical errors
+	if status.Error != "" && !strings.Contains(current.Error, status.Error) {
+		if current.Error != "" {
+			current.Error += ". "
+		}
+		current.Error += status.Error
+	}
+
+	if statusPriority[status.Status] > statusPriority[current.Status] {
+		current.Status = status.Status
+	}
+}
+
+/*
+ * Build a streamed response part
+ * with a single data source's results
+ */
+func partialResponse(source string, relations []map[string]interface{}, status *SourceStatus) *APIresponse {
+	return &APIresponse{
+		Relations: relations,
+		Sources: map[string]*SourceStatus{
+			source: status,
+		},
+	}
+}
+
+/*
+ * Fill the common "error" and "stats" fields
+ * from the data sources statuses, so the old clients keep working
+ */
+func (a *APIresponse) summarize() {
+	names := make([]string, 0, len(a.Sources))
+	for name := range a.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		status := a.Sources[name]
+
+		if status.Error != "" {
+			if a.Error != "" {
+				a.Error += "\n"
+			}
+			a.Error += fmt.Sprintf("\"%s\" %s: %s", name, status.Status, status.Error)
+		}
+
+		if len(a.Stats) == 0 && len(status.Stats) != 0 {
+			a.Stats = status.Stats
+		}
+	}
+}
+
+/*
+ * Get one set of stats per limited data source.
+ * Responses without the statuses contain a single one
+ */
+func (a *APIresponse) limitedStats() []map[string]interface{} {
+	stats := []map[string]interface{}{}
+
+	if a.Sources == nil {
+		if len(a.Stats) != 0 {
+			stats = append(stats, a.Stats)
+		}
+		return stats
+	}
+
+	names := []string{}
+	for name, status := range a.Sources {
+		if status.Status == statusLimited && len(status.Stats) != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		stats = append(stats, a.Sources[name].Stats)
+	}
+
+	return stats
+}
+
 /*
  * Send search results to the API user.
  * Receives user's IP, name, output format and SQL query
  */
 func (a *APIresponse) send(w http.ResponseWriter, ip, username, format, sql string) {
-	_, err := fmt.Fprint(w, a.format(format))
+	output := a.format(format)
+
+	if contentType, ok := contentTypes[format]; ok {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	_, err := fmt.Fprint(w, output)
 	if err != nil {
 		log.Error().
 			Str("ip", ip).
@@ -57,27 +238,54 @@ func (a *APIresponse) send(w http.ResponseWriter, ip, username, format, sql stri
 func (a *APIresponse) format(f string) string {
 
 	// Validate the format value
-	if f != "" && f != "json" && f != "table" {
+	if !validFormat(f) {
 		log.Error().Msg("Unexpected API response format requested: '" + f + "', JSON used instead")
 		a.Error = "Unexpected API response format: '" + f + "', JSON used instead. " + a.Error
+		f = "json"
+	}
+
+	switch f {
+	case "csv":
+		return a.formatCSV()
+
+	case "ndjson":
+		return a.formatNDJSON()
+
+	case "graphml", "gexf":
+		output, err := formatGraph(a.Relations, f, a.Error)
+		if err != nil {
+			return "Error: " + err.Error() + "\n"
+		}
+
+		return output
 	}
 
 	// Format the content if necessary
 	if f == "table" {
 		output := ""
 
+		// One error per line
 		if a.Error != "" {
-			output += "Error: " + a.Error + "\n"
+			for _, e := range strings.Split(a.Error, "\n") {
+				output += "Error: " + e + "\n"
+			}
 
 			if len(a.Stats) != 0 || len(a.Relations) != 0 {
 				output += "\n"
 			}
 		}
 
-		if len(a.Stats) != 0 {
-			output += "\"" + a.Stats["source"].(string) + "\" has too many results. "
+		// One set of stats per data source
+		stats := a.limitedStats()
+
+		for i, stat := range stats {
+			if i != 0 {
+				output += "\n"
+			}
+
+			output += "\"" + stat["source"].(string) + "\" has too many results. "
 
-			csv, err := formatTo(a.Stats, "table")
+			csv, err := formatTo(stat, "table")
 			if err != nil {
 				output += "Error: " + err.Error()
 			} else {
@@ -90,13 +298,22 @@ func (a *APIresponse) format(f string) string {
 			if err != nil {
 				output += "Error: " + err.Error()
 			} else {
-				if len(a.Stats) != 0 {
+				if len(stats) != 0 {
 					output += "\n\n"
 				}
 				output += csv
 			}
 		}
 
+		if len(a.Explain) != 0 {
+			csv, err := formatTo(a.explainRows(), "table")
+			if err != nil {
+				output += "Error: " + err.Error()
+			} else {
+				output += csv
+			}
+		}
+
 		if a.Debug != nil {
 			if len(a.Relations) != 0 {
 				output += "\n\nDebug info:\n"
@@ -132,73 +349,48 @@ func (a *APIresponse) format(f string) string {
  * Format the given single object
  */
 func formatTo(data interface{}, format string) (string, error) {
-	if format == "table" {
-		// JSON to CSV
-		// to get all the existing headers
-		csvSTR, err := json2csv.JSON2CSV(data)
-		if err != nil {
-			return "", fmt.Errorf("Can't convert API response to CSV: " + err.Error())
-		}
-
-		output := ""
-		buf := bytes.NewBufferString(output)
-		wr := json2csv.NewCSVWriter(buf)
-		wr.HeaderStyle = json2csv.DotNotationStyle
-
-		err = wr.WriteCSV(csvSTR)
+	switch format {
+	case "table", "csv":
+		rows, err := flatten(data)
 		if err != nil {
-			return "", fmt.Errorf("Can't format API response to CSV: " + err.Error())
+			return "", err
 		}
 
-		// Read csv values using csv.Reader.
-		// Strings splitting by \n and "," is not enough as some fields
-		// may contain them
-		csvReader := csv.NewReader(strings.NewReader(buf.String()))
-		rows, err := csvReader.ReadAll()
-		if err != nil {
-			return "", fmt.Errorf("Can't parse CSV: " + err.Error())
-		}
+		buf := &bytes.Buffer{}
 
-		// Find system internal fields to be removed.
-		// rows[0] is a headers row
-		fromSearch := -1
-		toSearch := -1
+		if format == "csv" {
+			wr := csv.NewWriter(buf)
 
-		for i, header := range rows[0] {
-			if header == "from.search" {
-				fromSearch = i
-			}
-			if header == "to.search" {
-				toSearch = i
+			err = wr.WriteAll(rows)
+			if err != nil {
+				return "", fmt.Errorf("Can't format API response to CSV: " + err.Error())
 			}
-		}
 
-		// Switch indexes in case 'To' element
-		// comes before the 'From' element,
-		// so the fields removing function works in the expected way
-		if toSearch < fromSearch {
-			i := toSearch
-			toSearch = fromSearch
-			fromSearch = i
+			return buf.String(), nil
 		}
 
-		rows[0] = removeFields(rows[0], fromSearch, toSearch)
-
-		// Clear CSV data from buffer to render a table
-		buf.Reset()
 		table := tablewriter.NewWriter(buf)
 		table.SetHeader(rows[0])
+		table.AppendBulk(rows[1:])
+		table.Render()
 
-		for i := 1; i < len(rows); i++ {
-			row := rows[i]
+		return buf.String(), nil
 
-			row = removeFields(row, fromSearch, toSearch)
-			table.Append(row)
-		}
+	case "ndjson":
+		// One element of the list per line
+		if list, ok := data.([]map[string]interface{}); ok {
+			output := ""
 
-		table.Render()
+			for _, element := range list {
+				line, err := formatTo(element, "json")
+				if err != nil {
+					return "", err
+				}
+				output += line
+			}
 
-		return buf.String(), nil
+			return output, nil
+		}
 	}
 
 	// Return JSON by default
@@ -211,13 +403,138 @@ func formatTo(data interface{}, format string) (string, error) {
 }
 
 /*
- * Remove system internal fields from an output formatted as table
+ * Flatten the given object into CSV rows, nested fields
+ * use a dot notation. The first row contains headers
+ */
+func flatten(data interface{}) ([][]string, error) {
+	// JSON to CSV
+	// to get all the existing headers
+	csvSTR, err := json2csv.JSON2CSV(data)
+	if err != nil {
+		return nil, fmt.Errorf("Can't convert API response to CSV: " + err.Error())
+	}
+
+	buf := &bytes.Buffer{}
+	wr := json2csv.NewCSVWriter(buf)
+	wr.HeaderStyle = json2csv.DotNotationStyle
+
+	err = wr.WriteCSV(csvSTR)
+	if err != nil {
+		return nil, fmt.Errorf("Can't format API response to CSV: " + err.Error())
+	}
+
+	// Read csv values using csv.Reader.
+	// Strings splitting by \n and "," is not enough as some fields
+	// may contain them
+	csvReader := csv.NewReader(strings.NewReader(buf.String()))
+	rows, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse CSV: " + err.Error())
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("Can't format an empty API response")
+	}
+
+	return removeFields(rows), nil
+}
+
+/*
+ * Format search output data as CSV. Relations are returned when exist,
+ * otherwise queries explanation, statistics of the limited
+ * data sources or errors
+ */
+func (a *APIresponse) formatCSV() string {
+	var data interface{}
+
+	switch {
+	case len(a.Relations) != 0:
+		data = a.Relations
+	case len(a.Explain) != 0:
+		data = a.explainRows()
+	case len(a.limitedStats()) != 0:
+		data = a.limitedStats()
+	}
+
+	output := ""
+
+	if data != nil {
+		csv, err := formatTo(data, "csv")
+		if err != nil {
+			a.Error = strings.TrimSpace(a.Error + "\n" + err.Error())
+		} else {
+			output = csv
+		}
+	}
+
+	// Errors can't be mixed with the data rows
+	if output == "" && a.Error != "" {
+		rows := []map[string]interface{}{}
+
+		for _, e := range strings.Split(a.Error, "\n") {
+			rows = append(rows, map[string]interface{}{"error": e})
+		}
+
+		output, _ = formatTo(rows, "csv")
+	}
+
+	return output
+}
+
+/*
+ * Format search output data as NDJSON, one relation per line.
+ * The last line contains everything else and is marked as "done",
+ * the same way as the last line of the streamed response
  */
-func removeFields(slice []string, f, t int) []string {
-	if f == -1 && t == -1 {
-		return slice
+func (a *APIresponse) formatNDJSON() string {
+	output, err := formatTo(a.Relations, "ndjson")
+	if err != nil {
+		output = ""
+		a.Error = strings.TrimSpace(a.Error + "\n" + err.Error())
+	}
+
+	summary, err := formatTo(&APIresponse{
+		Stats:   a.Stats,
+		Debug:   a.Debug,
+		Error:   a.Error,
+		Sources: a.Sources,
+		Done:    true,
+		Explain: a.Explain,
+		Cursor:  a.Cursor,
+	}, "json")
+	if err != nil {
+		return output + `{"error":"` + err.Error() + `","done":true}` + "\n"
+	}
+
+	return output + summary
+}
+
+/*
+ * Remove system internal fields from the flattened rows,
+ * the first row contains headers
+ */
+func removeFields(rows [][]string) [][]string {
+	keep := []int{}
+
+	for i, header := range rows[0] {
+		if header != "from.search" && header != "to.search" {
+			keep = append(keep, i)
+		}
+	}
+
+	if len(keep) == len(rows[0]) {
+		return rows
+	}
+
+	for n, row := range rows {
+		filtered := make([]string, 0, len(keep))
+		for _, i := range keep {
+			if i < len(row) {
+				filtered = append(filtered, row[i])
+			}
+		}
+		rows[n] = filtered
 	}
 
-	slice = append(slice[:f], slice[f+1:]...)
-	return append(slice[:t-1], slice[t:]...)
+	return rows
 }
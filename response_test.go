@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+/*
+ * Test statuses of the independent queries merging
+ */
+func TestMergeStatus(t *testing.T) {
+	statuses := make(map[string]*SourceStatus)
+
+	mergeStatus(statuses, "elastic", &SourceStatus{Status: statusError, Error: "timeout"})
+	mergeStatus(statuses, "elastic", &SourceStatus{Status: statusOK, Relations: 5})
+	mergeStatus(statuses, "elastic", &SourceStatus{Status: statusLimited, Relations: 2, Stats: map[string]interface{}{"source": "elastic"}})
+	mergeStatus(statuses, "elastic", &SourceStatus{Status: statusError, Error: "timeout"})
+
+	status := statuses["elastic"]
+
+	// Error of one query is not hidden by the later successful ones
+	if status.Status != statusError {
+		t.Errorf("Invalid merged status: %s, expected: %s", status.Status, statusError)
+	}
+
+	if status.Error != "timeout" {
+		t.Errorf("Invalid merged error: %s, expected: timeout", status.Error)
+	}
+
+	if status.Relations != 7 {
+		t.Errorf("Invalid merged relations amount: %d, expected: 7", status.Relations)
+	}
+
+	if status.Stats == nil {
+		t.Errorf("Statistics are lost")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+ * Queue of the requests to a single data source.
+ *
+ * Limits the amount of concurrent requests and their rate,
+ * so rate limited APIs don't ban the service. Waiting requests
+ * are grouped by user and served in turns, so one user's large
+ * upload can't starve the other users' interactive queries
+ */
+type scheduler struct {
+	sync.Mutex
+
+	// Max amount of concurrent requests, 0 means unlimited
+	limit int
+
+	// Min time between the requests, 0 means unlimited
+	interval time.Duration
+
+	// Amount of currently running requests
+	running int
+
+	// Earliest time the next request can start
+	next time.Time
+
+	// Whether a delayed dispatching is already planned
+	delayed bool
+
+	// Users with the waiting requests, in order of their turn
+	users []string
+
+	// Waiting requests of each user,
+	// closed channel means request can start
+	queues map[string][]chan struct{}
+}
+
+/*
+ * Create a new scheduler.
+ *
+ * Receives max amount of concurrent requests
+ * and max amount of requests per second, 0 for no limit
+ */
+func newScheduler(limit int, rate float64) *scheduler {
+	s := &scheduler{
+		limit:  limit,
+		queues: make(map[string][]chan struct{}),
+	}
+
+	if rate > 0 {
+		s.interval = time.Duration(float64(time.Second) / rate)
+	}
+
+	return s
+}
+
+/*
+ * Wait for the user's turn to query the data source.
+ * Returned function must be called when the request is finished.
+ *
+ * Returns an error if the context is done before the request can start
+ */
+func (s *scheduler) acquire(ctx context.Context, username string) (func(), error) {
+	// Nothing to limit
+	if s == nil || (s.limit <= 0 && s.interval <= 0) {
+		return func() {}, nil
+	}
+
+	ready := make(chan struct{})
+
+	s.Lock()
+	if _, ok := s.queues[username]; !ok {
+		s.users = append(s.users, username)
+	}
+	s.queues[username] = append(s.queues[username], ready)
+	s.dispatch()
+	s.Unlock()
+
+	select {
+	case <-ready:
+		return s.release, nil
+
+	case <-ctx.Done():
+		s.Lock()
+		waiting := s.remove(username, ready)
+		s.Unlock()
+
+		// Request was allowed to start at the same time
+		if !waiting {
+			s.release()
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+/*
+ * Free the finished request's place for the next one
+ */
+func (s *scheduler) release() {
+	s.Lock()
+	s.running--
+	s.dispatch()
+	s.Unlock()
+}
+
+/*
+ * Start as many waiting requests as limits allow,
+ * taking one request of each user in turn.
+ * Must be called with the lock held
+ */
+func (s *scheduler) dispatch() {
+	for len(s.users) != 0 {
+		if s.limit > 0 && s.running >= s.limit {
+			return
+		}
+
+		if s.interval > 0 {
+			now := time.Now()
+
+			// Try again when the rate limit allows
+			if now.Before(s.next) {
+				if !s.delayed {
+					s.delayed = true
+
+					time.AfterFunc(s.next.Sub(now), func() {
+						s.Lock()
+						s.delayed = false
+						s.dispatch()
+						s.Unlock()
+					})
+				}
+				return
+			}
+
+			s.next = now.Add(s.interval)
+		}
+
+		// Take the first request of the next user,
+		// and move the user to the end of the line
+		username := s.users[0]
+		queue := s.queues[username]
+		s.users = s.users[1:]
+
+		if len(queue) > 1 {
+			s.queues[username] = queue[1:]
+			s.users = append(s.users, username)
+		} else {
+			delete(s.queues, username)
+		}
+
+		s.running++
+		close(queue[0])
+	}
+}
+
+/*
+ * Remove the waiting request from the queue.
+ * Returns false if it has been started already.
+ * Must be called with the lock held
+ */
+func (s *scheduler) remove(username string, ready chan struct{}) bool {
+	queue := s.queues[username]
+
+	for i, ch := range queue {
+		if ch != ready {
+			continue
+		}
+
+		queue = append(queue[:i], queue[i+1:]...)
+		if len(queue) != 0 {
+			s.queues[username] = queue
+			return true
+		}
+
+		// No more requests of this user
+		delete(s.queues, username)
+
+		for j, user := range s.users {
+			if user == username {
+				s.users = append(s.users[:j], s.users[j+1:]...)
+				break
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+/*
+ * Test requests without any limits
+ */
+func TestSchedulerUnlimited(t *testing.T) {
+	for _, s := range []*scheduler{nil, newScheduler(0, 0)} {
+		for i := 0; i < 10; i++ {
+			release, err := s.acquire(context.Background(), "user")
+			if err != nil {
+				t.Fatalf("Can't acquire unlimited scheduler: %s", err.Error())
+			}
+			defer release()
+		}
+	}
+}
+
+/*
+ * Test max amount of concurrent requests
+ */
+func TestSchedulerConcurrency(t *testing.T) {
+	s := newScheduler(2, 0)
+
+	first, _ := s.acquire(context.Background(), "user")
+	s.acquire(context.Background(), "user")
+
+	started := make(chan struct{})
+
+	go func() {
+		release, _ := s.acquire(context.Background(), "user")
+		close(started)
+		release()
+	}()
+
+	select {
+	case <-started:
+		t.Fatalf("Request started above the concurrency limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatalf("Request hasn't started after a place was freed")
+	}
+}
+
+/*
+ * Test users are served in turns
+ */
+func TestSchedulerFairness(t *testing.T) {
+	s := newScheduler(1, 0)
+
+	// Occupy the only place
+	release, _ := s.acquire(context.Background(), "holder")
+
+	order := make(chan string, 4)
+
+	// Large upload of one user, then an interactive query of another one
+	for i, username := range []string{"upload", "upload", "upload", "interactive"} {
+		go func(username string) {
+			release, err := s.acquire(context.Background(), username)
+			if err != nil {
+				t.Errorf("Can't acquire: %s", err.Error())
+				return
+			}
+
+			order <- username
+			release()
+		}(username)
+
+		waitQueued(t, s, i+1)
+	}
+
+	release()
+
+	expected := []string{"upload", "interactive", "upload", "upload"}
+	for i, username := range expected {
+		select {
+		case started := <-order:
+			if started != username {
+				t.Errorf("Invalid request #%d started: %s, expected: %s", i, started, username)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Request #%d hasn't started", i)
+		}
+	}
+}
+
+/*
+ * Test canceling the waiting request
+ */
+func TestSchedulerCancel(t *testing.T) {
+	s := newScheduler(1, 0)
+
+	release, _ := s.acquire(context.Background(), "holder")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		_, err := s.acquire(ctx, "user")
+		done <- err
+	}()
+
+	waitQueued(t, s, 1)
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Invalid error of the canceled request: %v", err)
+	}
+
+	s.Lock()
+	if len(s.users) != 0 || len(s.queues) != 0 {
+		t.Errorf("Canceled request is still queued: %v", s.users)
+	}
+	s.Unlock()
+
+	// Place is not lost
+	release()
+
+	next, err := s.acquire(context.Background(), "user")
+	if err != nil {
+		t.Fatalf("Can't acquire after the cancellation: %s", err.Error())
+	}
+	next()
+
+	s.Lock()
+	if s.running != 0 {
+		t.Errorf("Invalid amount of running requests: %d", s.running)
+	}
+	s.Unlock()
+}
+
+/*
+ * Test max rate of the requests
+ */
+func TestSchedulerRate(t *testing.T) {
+	// One request per 50ms
+	s := newScheduler(0, 20)
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		release, err := s.acquire(context.Background(), "user")
+		if err != nil {
+			t.Fatalf("Can't acquire: %s", err.Error())
+		}
+		release()
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Rate limit is ignored: 3 requests in %s", elapsed)
+	}
+}
+
+/*
+ * Wait until the given amount of requests is queued
+ */
+func waitQueued(t *testing.T, s *scheduler, amount int) {
+	deadline := time.Now().Add(time.Second)
+
+	for time.Now().Before(deadline) {
+		queued := 0
+
+		s.Lock()
+		for _, queue := range s.queues {
+			queued += len(queue)
+		}
+		s.Unlock()
+
+		if queued == amount {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("%d requests are not queued", amount)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -53,6 +54,13 @@ type Session struct {
 	// A channel to use accross the code
 	// to indicate about a closed websocket connection
 	Done chan bool
+
+	// Context of the running searches. Canceled when
+	// the connection is closed or user stops the searches
+	SearchContext context.Context
+	SearchCancel  context.CancelFunc
+	// Mutex, as searches run concurrently with the "cancel" command
+	SearchMutex sync.Mutex
 }
 
 /*
@@ -6,16 +6,21 @@ import (
 	"os"
 	"plugin"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
 	yaml "gopkg.in/yaml.v3"
 
 	"github.com/cert-lv/graphoscope/pdk"
+	"github.com/cert-lv/graphoscope/pdk/remote"
 )
 
 var (
-	// Loaded plugins
-	plugins map[string]interface{}
+	// Loaded plugins, is a map of plugin's name -> factory
+	// of the new instances
+	plugins map[string]pdk.Factory
 
 	// Collectors for the preconfigured data sources.
 	// Is a map of data source's name -> related plugin
@@ -32,39 +37,105 @@ var (
 	// A list of all known data sources fields
 	// for the Web GUI autocomplete
 	fields map[string][]string
+
+	// Named groups of the data sources to query at once,
+	// is a map of group's name -> definition
+	sourceGroups map[string]*SourceGroup
+
+	// Queues of the requests to the data sources,
+	// is a map of data source's name -> scheduler
+	schedulers map[string]*scheduler
+
+	// Protects the loaded data sources, groups and processors,
+	// as they are replaced on reload while the searches read them.
+	// New maps are built aside and swapped, published ones are never modified
+	sourcesMx sync.RWMutex
 )
 
 /*
- * Load plugins from a configured directory
+ * Structure of a named data sources group definition
+ */
+type SourceGroup struct {
+	// Name of the group.
+	// Will be used in SQL queries instead of a data source name
+	Name string `yaml:"name"`
+
+	// Label to display in Web GUI dropdowns
+	Label string `yaml:"label"`
+
+	// GUI dropdowns icon
+	Icon string `yaml:"icon"`
+
+	// Names of the data sources to query
+	Sources []string `yaml:"sources"`
+}
+
+/*
+ * Load plugins built into the binary
+ * and the ones from a configured directory
  */
 func loadPlugins() error {
-	plugins = make(map[string]interface{})
+	plugins = make(map[string]pdk.Factory)
+
+	// Plugins compiled in by the "static" build tag
+	for _, registration := range pdk.Registrations() {
+		plugins[registration.Name] = registration.New
+
+		log.Info().
+			Str("plugin", registration.Name).
+			Msg("Built-in plugin loaded, version " + registration.Version)
+	}
 
 	// Load several types of plugins
 	for _, group := range []string{"sources", "processors", "outputs"} {
 
+		// Directories are optional when all the plugins are built in
 		files, err := ioutil.ReadDir(config.Plugins + "/" + group)
-		if err != nil {
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
 			return fmt.Errorf("Can't read from '%s' directory: %s", config.Plugins+"/"+group, err.Error())
 		}
 
 		for _, f := range files {
-			// Skip non-plugin files
 			name := f.Name()
-			if name[len(name)-3:] != ".so" {
+
+			// Out-of-process plugin is launched to get its name and version
+			if strings.HasSuffix(name, remote.Extension) {
+				pName, pVersion, symPlugin, err := remote.Open(config.Plugins+"/"+group+"/"+name, config.PluginMessageSize<<20)
+				if err != nil {
+					return fmt.Errorf("Can't open '%s': %s", name, err.Error())
+				}
+
+				switch p := symPlugin.(type) {
+				case *remote.Source:
+					plugins[pName] = func() interface{} { return p.Clone() }
+				case *remote.Processor:
+					plugins[pName] = func() interface{} { return p.Clone() }
+				}
+
+				log.Info().
+					Str("plugin", pName).
+					Msg("Out-of-process plugin loaded, version " + pVersion)
 				continue
 			}
 
-			// Open a .so file to load the symbols
-			plug, err := plugin.Open(config.Plugins + "/" + group + "/" + name)
-			if err != nil {
-				return fmt.Errorf("Can't open '%s': %s", name, err.Error())
+			// Skip non-plugin files
+			if !strings.HasSuffix(name, ".so") {
+				continue
 			}
 
-			// Look up the main plugin's symbol
-			symPlugin, err := plug.Lookup("Plugin")
+			// Plugins registered before the file is opened
+			registered := make(map[string]*pdk.Registration)
+			for _, registration := range pdk.Registrations() {
+				registered[registration.Name] = registration
+			}
+
+			// Open a .so file to load the symbols,
+			// plugin's "init()" registers it
+			plug, err := plugin.Open(config.Plugins + "/" + group + "/" + name)
 			if err != nil {
-				return fmt.Errorf("Can't lookup symbol 'Plugin' in '%s': %s", name, err.Error())
+				return fmt.Errorf("Can't open '%s': %s", name, err.Error())
 			}
 
 			// Get plugin name
@@ -87,25 +158,21 @@ func loadPlugins() error {
 				return fmt.Errorf("Unexpected plugin version type in '%s': %T, '*string' expected", name, pVersion)
 			}
 
-			// Assert that loaded symbol is of a desired type
-			// and make plugin globally available
-			// switch group {
-			// case "source":
-			// 	plugin, ok := symPlugin.(pdk.SourcePlugin)
-			// 	plugins[*pName] = plugin
-			// case "process":
-			// 	plugin, ok := symPlugin.(pdk.ProcessPlugin)
-			// 	plugins[*pName] = plugin
-			// case "output":
-			// 	plugin, ok := symPlugin.(pdk.OutputPlugin)
-			// 	plugins[*pName] = plugin
-			// }
-
-			// if !ok {
-			// 	return fmt.Errorf("Invalid plugin's type of '%s': %T, all methods must be implemented", name, symPlugin)
-			// }
-
-			plugins[*pName] = symPlugin
+			// Plugin has registered itself and replaced the built-in one, if any
+			if registration, ok := pdk.Registered(*pName); ok && registration != registered[*pName] {
+				plugins[*pName] = registration.New
+
+			} else {
+				// Older plugins export the "Plugin" symbol only,
+				// new instances are created by its type
+				symPlugin, err := plug.Lookup("Plugin")
+				if err != nil {
+					return fmt.Errorf("Can't lookup symbol 'Plugin' in '%s': %s", name, err.Error())
+				}
+
+				t := reflect.TypeOf(symPlugin).Elem()
+				plugins[*pName] = func() interface{} { return reflect.New(t).Interface() }
+			}
 
 			log.Info().
 				Str("plugin", *pName).
@@ -120,20 +187,44 @@ func loadPlugins() error {
  * Setup collectors for the predefined data sources
  */
 func setupCollectors() error {
-	// Clear old content
-	collectors = make(map[string]pdk.SourcePlugin)
+	sourcesMx.RLock()
+	previous := collectors
+	sourcesMx.RUnlock()
+
+	// Out-of-process plugins keep running until stopped
+	for name, collector := range previous {
+		if r, ok := collector.(*remote.Source); ok {
+			if err := r.Stop(); err != nil {
+				log.Error().
+					Str("source", name).
+					Msg("Can't stop the collector: " + err.Error())
+			}
+		}
+	}
 
 	files, err := ioutil.ReadDir(config.Definitions + "/sources")
 	if err != nil {
 		return fmt.Errorf("Can't read directory '%s': %s", config.Definitions+"/sources", err.Error())
 	}
 
+	// New content replaces the old one at once when ready
+	loadedCollectors := make(map[string]pdk.SourcePlugin)
+	loadedSchedulers := make(map[string]*scheduler)
+	loadedDefinitions := make(map[string]*pdk.Source)
+	loadedHealth := make(map[string]*SourceHealth)
+
 	// A map of data sources fields,
 	// source name -> list
-	fields = make(map[string][]string)
+	loadedFields := make(map[string][]string)
 
 	// Reset flag in case collectors are reloaded without service restart
-	nonGlobalExist = false
+	loadedNonGlobal := false
+
+	// Remember the data source's definition and the result of its setup
+	setHealth := func(def *pdk.Source, err error) {
+		loadedDefinitions[def.Name] = def
+		loadedHealth[def.Name] = newHealth(err)
+	}
 
 	for _, f := range files {
 		// Skip not YAML files
@@ -148,19 +239,29 @@ func setupCollectors() error {
 			continue
 		}
 
-		// Use needed plugin
-		collector, ok := plugins[def.Plugin].(pdk.SourcePlugin)
+		// New instance of the needed plugin
+		// to avoid pointers in "collectors" to the same value
+		factory, ok := plugins[def.Plugin]
 		if !ok {
 			log.Error().
 				Str("source", def.Name).
 				Str("plugin", def.Plugin).
 				Msg("No such plugin required by a collector")
+
+			setHealth(def, fmt.Errorf("No such plugin: "+def.Plugin))
 			continue
 		}
 
-		// Clone interface to avoid pointers in "collectors" to the same value
-		collectorIntf := reflect.New(reflect.TypeOf(collector).Elem())
-		clone := collectorIntf.Interface().(pdk.SourcePlugin)
+		clone, ok := factory().(pdk.SourcePlugin)
+		if !ok {
+			log.Error().
+				Str("source", def.Name).
+				Str("plugin", def.Plugin).
+				Msg("Plugin is not a data source plugin")
+
+			setHealth(def, fmt.Errorf("Not a data source plugin: "+def.Plugin))
+			continue
+		}
 
 		// Close previous connection if exists
 		err = clone.Stop()
@@ -169,6 +270,8 @@ func setupCollectors() error {
 				Str("source", def.Name).
 				Str("plugin", def.Plugin).
 				Msg("Can't stop collector: " + err.Error())
+
+			setHealth(def, fmt.Errorf("Can't stop collector: "+err.Error()))
 			continue
 		}
 
@@ -179,9 +282,37 @@ func setupCollectors() error {
 				Str("source", def.Name).
 				Str("plugin", def.Plugin).
 				Msg("Can't setup: " + err.Error())
+
+			setHealth(def, fmt.Errorf("Can't setup: "+err.Error()))
+			continue
+		}
+
+		// Definition must not expect features the plugin doesn't have
+		warnings, err := checkCapabilities(def, capabilities(clone))
+		if err != nil {
+			log.Error().
+				Str("source", def.Name).
+				Str("plugin", def.Plugin).
+				Msg("Invalid definition: " + err.Error())
+
+			if err := clone.Stop(); err != nil {
+				log.Error().
+					Str("source", def.Name).
+					Str("plugin", def.Plugin).
+					Msg("Can't stop collector: " + err.Error())
+			}
+
+			setHealth(def, fmt.Errorf("Invalid definition: "+err.Error()))
 			continue
 		}
 
+		for _, warning := range warnings {
+			log.Warn().
+				Str("source", def.Name).
+				Str("plugin", def.Plugin).
+				Msg(warning)
+		}
+
 		// Get all the possible field names
 		list, err := clone.Fields()
 		if err != nil {
@@ -207,14 +338,16 @@ func setupCollectors() error {
 		}
 
 		// Merge field names with a global list
-		fields[def.Name] = list
+		loadedFields[def.Name] = list
 
 		if !clone.Conf().InGlobal {
-			nonGlobalExist = true
+			loadedNonGlobal = true
 		}
 
 		// Store collectors to be usable by the end-users
-		collectors[def.Name] = clone
+		loadedCollectors[def.Name] = clone
+		loadedSchedulers[def.Name] = newScheduler(def.MaxConcurrency, def.RateLimit)
+		setHealth(def, nil)
 
 		log.Info().
 			Str("source", def.Name).
@@ -222,6 +355,172 @@ func setupCollectors() error {
 			Msg("Collector initialized")
 	}
 
+	sourcesMx.Lock()
+	collectors = loadedCollectors
+	schedulers = loadedSchedulers
+	definitions = loadedDefinitions
+	health = loadedHealth
+	fields = loadedFields
+	nonGlobalExist = loadedNonGlobal
+	sourcesMx.Unlock()
+
+	return nil
+}
+
+/*
+ * Check data source's definition against the query features
+ * declared by the plugin. Returns the settings to reconsider
+ * and an error when the data source can't work as defined
+ */
+func checkCapabilities(def *pdk.Source, caps *pdk.Capabilities) ([]string, error) {
+	if caps == nil {
+		return nil, nil
+	}
+
+	if len(caps.Operators) == 0 {
+		return nil, fmt.Errorf("Plugin declares no supported operators")
+	}
+
+	warnings := []string{}
+
+	if def.SupportsSQL {
+		// Whole query is given to the plugin,
+		// only the top level AND filters can be post-filtered
+		if !caps.Or {
+			return nil, fmt.Errorf("\"supportsSQL: true\" is set, but plugin doesn't support OR and NOT")
+		}
+
+	} else {
+		// Splitted queries need a single field value to search for
+		if !caps.Supports(sqlparser.EqualStr) {
+			return nil, fmt.Errorf("\"supportsSQL: false\" is set, but plugin doesn't support \"=\" to search by")
+		}
+
+		if caps.Or {
+			warnings = append(warnings, "Plugin supports OR, \"supportsSQL: true\" would avoid splitting queries")
+		}
+	}
+
+	if len(def.IncludeFields) != 0 && !caps.Projection {
+		warnings = append(warnings, "Plugin returns all the fields, \"includeFields\" is ignored")
+	}
+
+	return warnings, nil
+}
+
+/*
+ * Setup named groups of the data sources.
+ * Must be called after the collectors are set up
+ */
+func setupSourceGroups() error {
+	sourcesMx.RLock()
+	loaded := collectors
+	sourceFields := fields
+	sourcesMx.RUnlock()
+
+	// New content replaces the old one at once when ready
+	loadedGroups := make(map[string]*SourceGroup)
+
+	// Groups fields are added to the data sources ones
+	loadedFields := make(map[string][]string, len(sourceFields))
+	for name, list := range sourceFields {
+		if _, ok := loaded[name]; ok {
+			loadedFields[name] = list
+		}
+	}
+
+	// Published even without any groups defined
+	defer func() {
+		sourcesMx.Lock()
+		sourceGroups = loadedGroups
+		fields = loadedFields
+		sourcesMx.Unlock()
+	}()
+
+	// Groups are optional
+	files, err := ioutil.ReadDir(config.Definitions + "/groups")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Can't read directory '%s': %s", config.Definitions+"/groups", err.Error())
+	}
+
+	for _, f := range files {
+		// Skip not YAML files
+		name := f.Name()
+		if len(name) <= 5 || name[len(name)-5:] != ".yaml" {
+			continue
+		}
+
+		def, err := loadSourceGroup(config.Definitions + "/groups/" + name)
+		if err != nil {
+			log.Error().Msgf("Can't load group file '%s': %s", name, err.Error())
+			continue
+		}
+
+		// Group name must not hide a data source
+		if _, ok := loaded[def.Name]; ok || def.Name == "global" {
+			log.Error().
+				Str("group", def.Name).
+				Str("file", name).
+				Msg("Group name is already reserved by a data source")
+			continue
+		}
+
+		// The first loaded group keeps the name
+		if _, ok := loadedGroups[def.Name]; ok {
+			log.Error().
+				Str("group", def.Name).
+				Str("file", name).
+				Msg("Group name is already used by another group")
+			continue
+		}
+
+		// Skip unknown data sources
+		known := []string{}
+		unique := make(map[string]bool)
+
+		for _, source := range def.Sources {
+			if _, ok := loaded[source]; !ok {
+				log.Error().
+					Str("group", def.Name).
+					Str("source", source).
+					Msg("No such data source required by a group")
+				continue
+			}
+
+			known = append(known, source)
+
+			// Merge data sources fields for the Web GUI autocomplete
+			for _, field := range sourceFields[source] {
+				unique[field] = true
+			}
+		}
+
+		if len(known) == 0 {
+			log.Error().
+				Str("group", def.Name).
+				Msg("Group has no known data sources")
+			continue
+		}
+
+		def.Sources = known
+
+		list := make([]string, 0, len(unique))
+		for field := range unique {
+			list = append(list, field)
+		}
+		loadedFields[def.Name] = list
+
+		// Store groups to be usable by the end-users
+		loadedGroups[def.Name] = def
+
+		log.Info().
+			Str("group", def.Name).
+			Strs("sources", def.Sources).
+			Msg("Sources group initialized")
+	}
+
 	return nil
 }
 
@@ -229,8 +528,23 @@ func setupCollectors() error {
  * Setup processors of the data sources received data
  */
 func setupProcessors() error {
-	// Clear old content
-	processors = []pdk.ProcessorPlugin{}
+	sourcesMx.RLock()
+	previous := processors
+	sourcesMx.RUnlock()
+
+	// Out-of-process plugins keep running until stopped
+	for _, processor := range previous {
+		if r, ok := processor.(*remote.Processor); ok {
+			if err := r.Stop(); err != nil {
+				log.Error().
+					Str("processor", processor.Conf().Name).
+					Msg("Can't stop the processor: " + err.Error())
+			}
+		}
+	}
+
+	// New content replaces the old one at once when ready
+	loaded := []pdk.ProcessorPlugin{}
 
 	files, err := ioutil.ReadDir(config.Definitions + "/processors")
 	if err != nil {
@@ -250,8 +564,9 @@ func setupProcessors() error {
 			continue
 		}
 
-		// Use needed plugin
-		processor, ok := plugins[def.Plugin].(pdk.ProcessorPlugin)
+		// New instance of the needed plugin
+		// to avoid pointers in "processors" to the same value
+		factory, ok := plugins[def.Plugin]
 		if !ok {
 			log.Error().
 				Str("process", def.Name).
@@ -260,9 +575,14 @@ func setupProcessors() error {
 			continue
 		}
 
-		// Clone interface to avoid pointers in "processors" to the same value
-		processorIntf := reflect.New(reflect.TypeOf(processor).Elem())
-		clone := processorIntf.Interface().(pdk.ProcessorPlugin)
+		clone, ok := factory().(pdk.ProcessorPlugin)
+		if !ok {
+			log.Error().
+				Str("process", def.Name).
+				Str("plugin", def.Plugin).
+				Msg("Plugin is not a processor plugin")
+			continue
+		}
 
 		// Close previous connection if exists
 		err = clone.Stop()
@@ -285,7 +605,7 @@ func setupProcessors() error {
 		}
 
 		// Store processors to be usable by the end-users
-		processors = append(processors, clone)
+		loaded = append(loaded, clone)
 
 		log.Info().
 			Str("processor", def.Name).
@@ -293,6 +613,10 @@ func setupProcessors() error {
 			Msg("Processor initialized")
 	}
 
+	sourcesMx.Lock()
+	processors = loaded
+	sourcesMx.Unlock()
+
 	return nil
 }
 
@@ -304,6 +628,7 @@ func loadSource(filename string) (*pdk.Source, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Can't open: " + err.Error())
 	}
+	defer confFile.Close()
 
 	fi, _ := confFile.Stat()
 	buffer := make([]byte, fi.Size())
@@ -326,6 +651,44 @@ func loadSource(filename string) (*pdk.Source, error) {
 	return source, nil
 }
 
+/*
+ * Load data sources group configuration file
+ */
+func loadSourceGroup(filename string) (*SourceGroup, error) {
+	confFile, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open: " + err.Error())
+	}
+	defer confFile.Close()
+
+	fi, _ := confFile.Stat()
+	buffer := make([]byte, fi.Size())
+	_, err = confFile.Read(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read: " + err.Error())
+	}
+
+	group := &SourceGroup{}
+	err = yaml.Unmarshal(buffer, &group)
+	if err != nil {
+		return nil, fmt.Errorf("Can't unmarshall: " + err.Error())
+	}
+
+	if group.Name == "" {
+		return nil, fmt.Errorf("Group has no name")
+	}
+
+	// Set default values if not specified
+	if group.Label == "" {
+		group.Label = group.Name
+	}
+	if group.Icon == "" {
+		group.Icon = "layer group"
+	}
+
+	return group, nil
+}
+
 /*
  * Load processor configuration file
  */
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cert-lv/graphoscope/pdk"
+)
+
+/*
+ * Test data source definitions are checked against the plugin's capabilities
+ */
+func TestCheckCapabilities(t *testing.T) {
+	// Capabilities as declared by the bundled plugins
+	rest := &pdk.Capabilities{Operators: []string{"="}}
+	elastic := &pdk.Capabilities{
+		Operators:  []string{"=", "!=", "<", ">", "<=", ">=", "in", "not in", "like", "not like", "between", "not between"},
+		Or:         true,
+		GroupBy:    true,
+		Projection: true,
+	}
+	ranges := &pdk.Capabilities{Operators: []string{"between"}}
+
+	tables := []struct {
+		yaml     string
+		caps     *pdk.Capabilities
+		err      string
+		warnings int
+	}{
+		{"name: api\nsupportsSQL: false\n", rest, "", 0},
+		{"name: api\nsupportsSQL: true\n", rest, "plugin doesn't support OR and NOT", 0},
+		{"name: api\nsupportsSQL: false\nincludeFields: [ip]\n", rest, "", 1},
+		{"name: es\nsupportsSQL: true\nincludeFields: [ip]\n", elastic, "", 0},
+		{"name: es\nsupportsSQL: false\n", elastic, "", 1},
+		{"name: ranges\nsupportsSQL: false\n", ranges, "plugin doesn't support \"=\"", 0},
+		{"name: none\nsupportsSQL: false\n", &pdk.Capabilities{}, "Plugin declares no supported operators", 0},
+		{"name: legacy\nsupportsSQL: true\n", nil, "", 0},
+	}
+
+	for _, table := range tables {
+		filename := t.TempDir() + "/source.yaml"
+		if err := os.WriteFile(filename, []byte(table.yaml), 0600); err != nil {
+			t.Fatalf("Can't write definition: %s", err.Error())
+		}
+
+		def, err := loadSource(filename)
+		if err != nil {
+			t.Fatalf("Can't load definition '%s': %s", table.yaml, err.Error())
+		}
+
+		warnings, err := checkCapabilities(def, table.caps)
+
+		if table.err == "" && err != nil {
+			t.Errorf("Invalid check of '%s': %s, expected no error", table.yaml, err.Error())
+		} else if table.err != "" && (err == nil || !strings.Contains(err.Error(), table.err)) {
+			t.Errorf("Invalid check of '%s': %v, expected: %s", table.yaml, err, table.err)
+		}
+
+		if err == nil && len(warnings) != table.warnings {
+			t.Errorf("Invalid warnings of '%s': %v, expected: %d", table.yaml, warnings, table.warnings)
+		}
+	}
+}
+
+/*
+ * Test filters with the operators the plugin doesn't support
+ * are applied to the received results
+ */
+func TestUnsupportedOperator(t *testing.T) {
+	config = &Config{Limit: 100}
+
+	caps := &pdk.Capabilities{
+		Operators: []string{"=", "!=", "in", "not in", "like", "not like", "between", "not between"},
+		Or:        true,
+	}
+
+	// Top level AND filter is post-filtered
+	queries, err := parseSQL("FROM es WHERE ip='10.10.10.10' AND domain REGEXP '^test'", true, nil, nil, true, true, caps)
+	if err != nil {
+		t.Fatalf("Can't parse query with an unsupported operator: %s", err.Error())
+	}
+
+	if len(queries) != 1 || queries[0].Filter == nil {
+		t.Fatalf("Unsupported operator is not post-filtered: %v", queries)
+	}
+
+	// Nested filter can't be separated from the query
+	_, err = parseSQL("FROM es WHERE ip='10.10.10.10' OR domain REGEXP '^test'", true, nil, nil, true, true, caps)
+	if err == nil {
+		t.Errorf("Nested unsupported operator is accepted")
+	}
+}
+
+/*
+ * Test group definitions without a name are rejected
+ */
+func TestLoadSourceGroup(t *testing.T) {
+	tables := []struct {
+		yaml  string
+		label string
+		err   bool
+	}{
+		{"name: internal\nsources: [es]\n", "internal", false},
+		{"name: internal\nlabel: Internal\nsources: [es]\n", "Internal", false},
+		{"label: Internal\nsources: [es]\n", "", true},
+	}
+
+	for _, table := range tables {
+		filename := t.TempDir() + "/group.yaml"
+		if err := os.WriteFile(filename, []byte(table.yaml), 0600); err != nil {
+			t.Fatalf("Can't write definition: %s", err.Error())
+		}
+
+		group, err := loadSourceGroup(filename)
+		if table.err {
+			if err == nil {
+				t.Errorf("Invalid group '%s' is accepted", table.yaml)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Can't load group '%s': %s", table.yaml, err.Error())
+		} else if group.Label != table.label {
+			t.Errorf("Invalid label of '%s': %s, expected: %s", table.yaml, group.Label, table.label)
+		}
+	}
+}
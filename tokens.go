@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Prefix of the API token's secret,
+	// helps to recognize leaked tokens
+	tokenPrefix = "gs_"
+)
+
+/*
+ * Named API token of the user.
+ * Only a hash of the secret is stored, the secret itself
+ * is shown once when the token is created
+ */
+type Token struct {
+	// Public ID to list and revoke the token
+	ID string `bson:"_id" json:"id"`
+
+	// SHA-256 hash of the secret
+	Hash string `bson:"hash" json:"-"`
+
+	// Owner of the token
+	Username string `bson:"username" json:"username"`
+
+	// Name to describe the token's purpose
+	Name string `bson:"name" json:"name"`
+
+	// Names of the data sources or groups the token can query.
+	// All are allowed when empty
+	Sources []string `bson:"sources,omitempty" json:"sources,omitempty"`
+
+	// Whether the token can't modify any data,
+	// like dashboards, notes or uploads
+	ReadOnly bool `bson:"readOnly" json:"readOnly"`
+
+	// Creation timestamp
+	Created time.Time `bson:"created" json:"created"`
+
+	// Expiration timestamp, the token never expires when not set.
+	// MongoDB's TTL index deletes expired tokens
+	Expires *time.Time `bson:"expires,omitempty" json:"expires,omitempty"`
+
+	// Timestamp when the token was used the last time
+	LastUsed *time.Time `bson:"lastUsed,omitempty" json:"lastUsed,omitempty"`
+}
+
+/*
+ * Web GUI request to create a new token
+ */
+type TokenRequest struct {
+	Name string `json:"name"`
+
+	// Days until the token expires, 0 for never
+	Days int `json:"days"`
+
+	Sources  []string `json:"sources"`
+	ReadOnly bool     `json:"readOnly"`
+}
+
+/*
+ * Create a new token of the user.
+ * Returns the token and its secret to give to the user
+ */
+func newToken(username string, request *TokenRequest) (*Token, string, error) {
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		return nil, "", fmt.Errorf("Token's name can't be empty")
+	}
+
+	if request.Days < 0 {
+		return nil, "", fmt.Errorf("Token's lifetime can't be negative")
+	}
+
+	sourcesMx.RLock()
+	defer sourcesMx.RUnlock()
+
+	for _, source := range request.Sources {
+		_, isCollector := collectors[source]
+		_, isGroup := sourceGroups[source]
+
+		if !isCollector && !isGroup && source != "global" {
+			return nil, "", fmt.Errorf("Unknown data source: " + source)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("Can't generate a secret: " + err.Error())
+	}
+
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	token := &Token{
+		ID:       uuid.NewString(),
+		Hash:     hashToken(secret),
+		Username: username,
+		Name:     request.Name,
+		Sources:  request.Sources,
+		ReadOnly: request.ReadOnly,
+		Created:  time.Now().UTC(),
+	}
+
+	if request.Days != 0 {
+		expires := token.Created.AddDate(0, 0, request.Days)
+		token.Expires = &expires
+	}
+
+	if err := db.addToken(token); err != nil {
+		return nil, "", err
+	}
+
+	return token, secret, nil
+}
+
+/*
+ * Hash the token's secret to store or to search for
+ */
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+ * Authenticate API request by the "Authorization: Bearer" token
+ * or by the legacy user's UUID.
+ * Returns the token when it was used
+ */
+func authenticate(r *http.Request, uuid string) (*Account, *Token, error) {
+	header := r.Header.Get("Authorization")
+
+	if header == "" {
+		if uuid == "" {
+			return nil, nil, fmt.Errorf("Neither token nor UUID is given")
+		}
+
+		account, err := db.getAccountByUUID(uuid)
+		return account, nil, err
+	}
+
+	secret, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, nil, fmt.Errorf("Unsupported authorization scheme")
+	}
+
+	token, err := db.getToken(hashToken(strings.TrimSpace(secret)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// TTL index removes expired tokens with a delay
+	if token.expired() {
+		return nil, nil, fmt.Errorf("Token '" + token.Name + "' has expired")
+	}
+
+	account, err := db.getAccount(token.Username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = db.touchToken(token.ID)
+	if err != nil {
+		log.Error().
+			Str("username", token.Username).
+			Str("token", token.Name).
+			Msg("Can't update token's last used time: " + err.Error())
+	}
+
+	return account, token, nil
+}
+
+/*
+ * Check whether the token has expired
+ */
+func (t *Token) expired() bool {
+	return t.Expires != nil && t.Expires.Before(time.Now())
+}
+
+/*
+ * Check whether the token is allowed to query the data source or group.
+ * Requests without a token have no restrictions
+ */
+func (t *Token) allows(source string) error {
+	if t == nil || len(t.Sources) == 0 {
+		return nil
+	}
+
+	for _, s := range t.Sources {
+		if s == source {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Token '" + t.Name + "' is not allowed to query '" + source + "'")
+}
+
+/*
+ * Check whether the token is allowed to modify the data
+ */
+func (t *Token) writable() error {
+	if t != nil && t.ReadOnly {
+		return fmt.Errorf("Token '" + t.Name + "' is read-only")
+	}
+
+	return nil
+}
+
+/*
+ * Handle 'token-create' websocket command to create a new API token
+ */
+func (a *Account) createTokenHandler(reqID, data string) {
+	request := &TokenRequest{}
+
+	err := json.Unmarshal([]byte(data), request)
+	if err != nil {
+		log.Error().
+			Str("ip", a.Session.IP).
+			Str("username", a.Username).
+			Msg("Can't unmarshal token request: " + err.Error())
+
+		a.reply(reqID, "error", err.Error(), "Can't create token!")
+		return
+	}
+
+	token, secret, err := newToken(a.Username, request)
+	if err != nil {
+		log.Error().
+			Str("ip", a.Session.IP).
+			Str("username", a.Username).
+			Msg("Can't create token: " + err.Error())
+
+		a.reply(reqID, "error", err.Error(), "Can't create token!")
+		return
+	}
+
+	b, err := json.Marshal(token)
+	if err != nil {
+		log.Error().
+			Str("ip", a.Session.IP).
+			Str("username", a.Username).
+			Msg("Can't marshal token: " + err.Error())
+
+		a.reply(reqID, "error", "Check server logs for more info!", "Can't create token!")
+		return
+	}
+
+	a.reply(reqID, "token-created", string(b), secret)
+
+	log.Info().
+		Str("ip", a.Session.IP).
+		Str("username", a.Username).
+		Str("token", token.Name).
+		Msg("API token created")
+}
+
+/*
+ * Handle 'token-revoke' websocket command to delete an API token.
+ * Administrators can revoke tokens of any user
+ */
+func (a *Account) revokeTokenHandler(reqID, id string) {
+	owner := a.Username
+	if a.Admin || config.Environment != "prod" {
+		owner = ""
+	}
+
+	err := db.deleteToken(id, owner)
+	if err != nil {
+		log.Error().
+			Str("ip", a.Session.IP).
+			Str("username", a.Username).
+			Str("token-id", id).
+			Msg("Can't revoke token: " + err.Error())
+
+		a.reply(reqID, "error", err.Error(), "Can't revoke token!")
+		return
+	}
+
+	a.reply(reqID, "token-revoked", id, "")
+
+	log.Info().
+		Str("ip", a.Session.IP).
+		Str("username", a.Username).
+		Str("token-id", id).
+		Msg("API token revoked")
+}
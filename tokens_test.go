@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+/*
+ * Test hashing of the tokens secrets
+ */
+func TestHashToken(t *testing.T) {
+	hash := hashToken("gs_secret")
+
+	if len(hash) != 64 || strings.Contains(hash, "secret") {
+		t.Errorf("Invalid SHA-256 hash: %s", hash)
+	}
+
+	if hashToken("gs_secret") != hash {
+		t.Errorf("Hash of the same secret differs")
+	}
+
+	if hashToken("gs_secreT") == hash {
+		t.Errorf("Hash of the different secrets is the same")
+	}
+}
+
+/*
+ * Test token's expiration and scopes
+ */
+func TestTokenScopes(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	// Requests without a token have no restrictions
+	var none *Token
+	if none.allows("people") != nil || none.writable() != nil {
+		t.Errorf("Request without a token is restricted")
+	}
+
+	tables := []struct {
+		token    *Token
+		expired  bool
+		allowed  []string
+		denied   []string
+		writable bool
+	}{
+		{&Token{Name: "all"}, false, []string{"people", "global"}, nil, true},
+		{&Token{Name: "expired", Expires: &past}, true, []string{"people"}, nil, true},
+		{&Token{Name: "valid", Expires: &future, ReadOnly: true}, false, []string{"people"}, nil, false},
+		{&Token{Name: "scoped", Sources: []string{"people", "network"}}, false, []string{"people", "network"}, []string{"global", "shodan", "peop"}, true},
+	}
+
+	for _, table := range tables {
+		if table.token.expired() != table.expired {
+			t.Errorf("Invalid expiration of '%s': %v, expected: %v", table.token.Name, table.token.expired(), table.expired)
+		}
+
+		for _, source := range table.allowed {
+			if err := table.token.allows(source); err != nil {
+				t.Errorf("Token '%s' must allow '%s': %s", table.token.Name, source, err.Error())
+			}
+		}
+
+		for _, source := range table.denied {
+			if table.token.allows(source) == nil {
+				t.Errorf("Token '%s' must deny '%s'", table.token.Name, source)
+			}
+		}
+
+		if (table.token.writable() == nil) != table.writable {
+			t.Errorf("Invalid writability of '%s', expected: %v", table.token.Name, table.writable)
+		}
+	}
+}
+
+/*
+ * Test API requests authentication by a token
+ */
+func TestAuthenticate(t *testing.T) {
+	config = &Config{Limit: 100}
+	config.Database.Timeout = 5
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	secret := "gs_secret"
+	past := time.Now().Add(-time.Minute)
+
+	// Token as stored in the database
+	token := func(expires *time.Time) bson.D {
+		doc := bson.D{
+			{Key: "_id", Value: "token-id"},
+			{Key: "hash", Value: hashToken(secret)},
+			{Key: "username", Value: "john"},
+			{Key: "name", Value: "script"},
+			{Key: "sources", Value: bson.A{"people"}},
+			{Key: "readOnly", Value: true},
+		}
+
+		if expires != nil {
+			doc = append(doc, bson.E{Key: "expires", Value: *expires})
+		}
+
+		return doc
+	}
+
+	account := bson.D{
+		{Key: "username", Value: "john"},
+		{Key: "options", Value: bson.D{{Key: "limit", Value: 100}}},
+		{Key: "uploads", Value: bson.D{{Key: "in", Value: bson.D{}}, {Key: "out", Value: bson.A{}}}},
+		{Key: "notifications", Value: bson.A{}},
+	}
+
+	updated := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+
+	request := func(header string) *http.Request {
+		r, _ := http.NewRequest("GET", "/api", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		return r
+	}
+
+	mt.Run("valid", func(mt *mtest.T) {
+		db = &Database{Users: mt.Coll, Tokens: mt.Coll}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.tokens", mtest.FirstBatch, token(nil)),
+			mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, account),
+			updated,
+			updated,
+		)
+
+		a, tk, err := authenticate(request("Bearer "+secret), "")
+		if err != nil {
+			mt.Fatalf("Can't authenticate: %s", err.Error())
+		}
+
+		if a.Username != "john" || tk.Name != "script" || !tk.ReadOnly || tk.allows("people") != nil {
+			mt.Errorf("Invalid account or token: %+v, %+v", a, tk)
+		}
+
+		// Token is searched by the hash, not by the secret
+		filter := mt.GetStartedEvent().Command.Lookup("filter").String()
+		if !strings.Contains(filter, hashToken(secret)) || strings.Contains(filter, secret) {
+			mt.Errorf("Token is not searched by the hash: %s", filter)
+		}
+
+		// Last used time is updated
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName == "update" && strings.Contains(event.Command.String(), "lastUsed") {
+				return
+			}
+		}
+
+		mt.Errorf("Token's last used time is not updated")
+	})
+
+	mt.Run("expired", func(mt *mtest.T) {
+		db = &Database{Users: mt.Coll, Tokens: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.tokens", mtest.FirstBatch, token(&past)))
+
+		if _, _, err := authenticate(request("Bearer "+secret), ""); err == nil {
+			mt.Errorf("Expired token is accepted")
+		}
+	})
+
+	mt.Run("unknown", func(mt *mtest.T) {
+		db = &Database{Users: mt.Coll, Tokens: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.tokens", mtest.FirstBatch))
+
+		if _, _, err := authenticate(request("Bearer gs_unknown"), ""); err == nil {
+			mt.Errorf("Unknown token is accepted")
+		}
+	})
+
+	mt.Run("invalid", func(mt *mtest.T) {
+		for _, header := range []string{"Basic am9objpwYXNz", "Token " + secret} {
+			if _, _, err := authenticate(request(header), ""); err == nil {
+				mt.Errorf("Authorization is accepted: %s", header)
+			}
+		}
+
+		if _, _, err := authenticate(request(""), ""); err == nil {
+			mt.Errorf("Request without a token and UUID is accepted")
+		}
+	})
+}
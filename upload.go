@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,11 +14,17 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
 )
 
 var (
 	// Regex to validate a datetime value
 	reDatetime = regexp.MustCompile(`^\d\d\d\d-\d\d-\d\dT\d\d:\d\d:\d\d\.\d\d\dZ$`)
+
+	// Regex to validate a field name of the indicators,
+	// nested fields are separated by dots
+	reField = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
 )
 
 /*
@@ -207,8 +214,7 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add file to the user's queue
-	account.Uploads.In[prefix+"-"+header.Filename] = upload
-	err = account.update("uploads.in", account.Uploads.In)
+	err = account.addUpload(prefix+"-"+header.Filename, upload)
 	if err != nil {
 		_, e := w.Write([]byte("Can't add uploaded file to the user's in-queue: " + err.Error()))
 		if e != nil {
@@ -223,9 +229,6 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 			Str("username", username).
 			Str("filename", header.Filename).
 			Msg("Can't add uploaded file to the user's in-queue: " + err.Error())
-
-		// Clean the environment in case of an error
-		account.cleanUploads(prefix + "-" + header.Filename)
 		return
 	}
 
@@ -247,24 +250,47 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Notify that the uploaded file is in a queue.
 	// TODO: Merge "ok" and "upload" responses in just one
-	account.send("uploaded", prefix+"-"+header.Filename, "")
+	account.startUpload(ip, prefix+"-"+header.Filename, upload)
+}
+
+/*
+ * Add the stored file to the user's queue.
+ * The environment is cleaned in case of an error
+ */
+func (a *Account) addUpload(filename string, upload *Upload) error {
+	a.Uploads.In[filename] = upload
+
+	err := a.update("uploads.in", a.Uploads.In)
+	if err != nil {
+		a.cleanUploads(filename)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Notify user that the file is in a queue
+ * and start processing it in a background
+ */
+func (a *Account) startUpload(ip, filename string, upload *Upload) {
+	a.send("uploaded", filename, "")
 
-	// Start processing the uploaded file
 	go func() {
-		err = account.processUploads(prefix+"-"+header.Filename, upload)
+		err := a.processUploads(filename, upload)
 		if err != nil {
 			log.Error().
 				Str("ip", ip).
-				Str("username", username).
-				Str("filename", header.Filename).
+				Str("username", a.Username).
+				Str("filename", filename[19:]).
 				Msg(err.Error())
 
 			// Notify user about the error
-			account.addNotification("error", err.Error())
+			a.addNotification("error", err.Error())
 		}
 
 		// Clean the environment whether the error was nil or not
-		account.cleanUploads(prefix + "-" + header.Filename)
+		a.cleanUploads(filename)
 	}()
 }
 
@@ -282,6 +308,9 @@ func (a *Account) processUploads(filename string, upload *Upload) error {
 	rDebug := ""
 	rError := ""
 
+	// Options can be changed while the upload is processed
+	options := a.options()
+
 	// Validate user input
 	if err := validUploads("source", upload.Source); err != nil {
 		rError += "\n  - " + "Invalid 'source' value: " + err.Error()
@@ -292,10 +321,14 @@ func (a *Account) processUploads(filename string, upload *Upload) error {
 	if err := validUploads("datetime", upload.EndTime); err != nil {
 		rError += "\n  - " + "Invalid 'end time' value: " + err.Error()
 	}
-	if upload.Format != "json" && upload.Format != "table" {
-		rError += "\n  - " + "Invalid 'output format' value: " + upload.Format + ", 'json' or 'table' extected"
+	if upload.Format == "" || !validFormat(upload.Format) {
+		rError += "\n  - " + "Invalid 'output format' value: " + upload.Format + ", 'json', 'table', 'csv', 'ndjson', 'graphml' or 'gexf' expected"
 	}
 
+	// Relations of all the indicators
+	// for the formats which can't contain a text report
+	relations := []map[string]interface{}{}
+
 	// Start processing
 	file, err := os.Open(config.Upload.Path + "/queue/" + filename)
 	if err != nil {
@@ -306,25 +339,38 @@ func (a *Account) processUploads(filename string, upload *Upload) error {
 	scanner := bufio.NewScanner(file)
 
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+		line, err := indicatorFilter(field, scanner.Text())
+		if err != nil {
+			rError += "\n  - Indicator: " + scanner.Text() + ", " + err.Error()
+			continue
+		}
 
-		// Some values shouldn't be quoted
-		if field != "" {
-			if valueIsNumeric(line) {
-				line = field + "=" + line
-			} else {
-				line = field + "='" + line + "'"
-			}
+		sql := indicatorSQL(upload.Source, line, upload.StartTime, upload.EndTime)
+
+		// The same validation as for the other queries
+		request, err := prepareQuery(sql)
+		if err != nil {
+			rError += "\n  - Indicator: " + line + ", " + err.Error()
+			continue
 		}
 
-		// Generate a resulting query
-		sql := fmt.Sprintf("FROM %s WHERE (%s) AND datetime BETWEEN '%s' AND '%s'",
-			upload.Source, strings.TrimSpace(line), upload.StartTime, upload.EndTime)
+		// Query data sources for a new relations data.
+		// Processing doesn't depend on the user's connection
+		response := querySources(context.Background(), request.Source, request.SQL, nil, options.ShowLimited, options.Debug, a.Username, nil)
 
-		// Query data sources for a new relations data
-		response := querySources(upload.Source, sql, a.Options.ShowLimited, a.Options.Debug, a.Username)
+		if rawFormat(upload.Format) {
+			// Remember which indicator has found the relation
+			for _, relation := range response.Relations {
+				copied := make(map[string]interface{}, len(relation)+1)
+				for k, v := range relation {
+					copied[k] = v
+				}
+				copied["indicator"] = line
 
-		if len(response.Relations) != 0 {
+				relations = append(relations, copied)
+			}
+
+		} else if len(response.Relations) != 0 {
 			rRelations += "\n\nIndicator: " + line + "\n\n"
 
 			// Format relations data
@@ -337,11 +383,12 @@ func (a *Account) processUploads(filename string, upload *Upload) error {
 			}
 		}
 
-		if len(response.Stats) != 0 {
-			rStats += "\n\nIndicator: " + line + "\n\n"
+		// One set of stats per limited data source
+		for _, stat := range response.limitedStats() {
+			rStats += "\n\nIndicator: " + line + ", source: " + fmt.Sprint(stat["source"]) + "\n\n"
 
 			// Format stats
-			str, err := formatTo(response.Stats, upload.Format)
+			str, err := formatTo(stat, upload.Format)
 			if err != nil {
 				rError += "\n  - " + err.Error()
 				break
@@ -350,7 +397,7 @@ func (a *Account) processUploads(filename string, upload *Upload) error {
 			}
 		}
 
-		if a.Options.Debug && response.Debug != nil {
+		if options.Debug && response.Debug != nil {
 			rDebug += "\n\nDebug info: " + line + "\n"
 
 			for source, section := range response.Debug {
@@ -384,6 +431,10 @@ func (a *Account) processUploads(filename string, upload *Upload) error {
 
 	file.Close()
 
+	if rawFormat(upload.Format) {
+		return a.saveRawReport(filename, upload.Format, relations, rStats, rError)
+	}
+
 	// Fill report by the relations data
 	if rRelations != "" {
 		report += "\n" + rRelations
@@ -415,6 +466,46 @@ func (a *Account) processUploads(filename string, upload *Upload) error {
 	return nil
 }
 
+/*
+ * Save the report of the processed file in a format for the other tools.
+ * Only relations are stored, user is notified about the errors separately
+ */
+func (a *Account) saveRawReport(filename, format string, relations []map[string]interface{}, rStats, rError string) error {
+	var (
+		report string
+		err    error
+	)
+
+	switch {
+	case graphFormat(format):
+		report, err = formatGraph(mergeRelations(relations), format, strings.TrimSpace(rError))
+	case len(relations) != 0:
+		report, err = formatTo(relations, format)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Can't format processed upload results: " + err.Error())
+	}
+
+	err = ioutil.WriteFile(config.Upload.Path+"/processed/"+filename, []byte(report), 0600)
+	if err != nil {
+		return fmt.Errorf("Can't save processed upload results: " + err.Error())
+	}
+
+	if rStats != "" {
+		a.addNotification("info", "File '"+filename[19:]+"': the amount of relations for some indicators exceeds the limit, they are not included")
+	}
+
+	if rError != "" {
+		a.addNotification("error", "File '"+filename[19:]+"': some errors have occurred during the process:"+rError)
+	}
+
+	// Notify user that processing is completed
+	a.send("upload-processed", filename, "")
+
+	return nil
+}
+
 /*
  * Process uploaded files again if any process was interrupted,
  * for example service was stopped
@@ -467,6 +558,13 @@ func validUploads(field, value string) error {
 		}
 	}
 
+	// Validate 'field' value, empty when indicators are filters themselves
+	if field == "field" {
+		if value != "" && !reField.MatchString(value) {
+			return fmt.Errorf("Format is incorrect")
+		}
+	}
+
 	// Validate 'datetime' value
 	if field == "datetime" {
 		if value == "" {
@@ -685,7 +783,7 @@ func deleteUploadResults() {
  * Send user's upload queue and download lists
  * to be displayed as Web GUI items
  */
-func (a *Account) getUploadLists() {
+func (a *Account) getUploadLists(reqID string) {
 	// Use a list instead of a concatenated string
 	// to avoid possible splitting errors on a JavaScript side
 	listIn := []string{}
@@ -700,7 +798,7 @@ func (a *Account) getUploadLists() {
 			Str("username", a.Username).
 			Msg("Can't marshal upload queue list: " + err.Error())
 
-		a.send("error", "Check server logs for more info!", "Can't get upload queue and download lists.")
+		a.reply(reqID, "error", "Check server logs for more info!", "Can't get upload queue and download lists.")
 		return
 	}
 
@@ -711,11 +809,43 @@ func (a *Account) getUploadLists() {
 			Str("username", a.Username).
 			Msg("Can't marshal download list: " + err.Error())
 
-		a.send("error", "Check server logs for more info!", "Can't get upload queue and download lists.")
+		a.reply(reqID, "error", "Check server logs for more info!", "Can't get upload queue and download lists.")
 		return
 	}
 
-	a.send("upload-lists", string(bIn), string(bOut))
+	a.reply(reqID, "upload-lists", string(bIn), string(bOut))
+}
+
+/*
+ * Convert indicator into a query filter.
+ * Without a field the indicator is a filter itself, like "field='value'".
+ * Numeric values are not quoted, quotes of the other values are escaped
+ */
+func indicatorFilter(field, indicator string) (string, error) {
+	indicator = strings.TrimSpace(indicator)
+
+	if field == "" {
+		return indicator, nil
+	}
+
+	if err := validUploads("field", field); err != nil {
+		return "", fmt.Errorf("Invalid field '%s': %s", field, err.Error())
+	}
+
+	if valueIsNumeric(indicator) {
+		return field + "=" + indicator, nil
+	}
+
+	return field + "=" + sqlparser.String(sqlparser.NewStrVal([]byte(indicator))), nil
+}
+
+/*
+ * Generate a resulting query of the single indicator's filter,
+ * data source name is quoted when needed
+ */
+func indicatorSQL(source, filter, startTime, endTime string) string {
+	return fmt.Sprintf("FROM %s WHERE (%s) AND datetime BETWEEN '%s' AND '%s'",
+		sqlparser.String(sqlparser.NewTableIdent(source)), filter, startTime, endTime)
 }
 
 /*
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
 	"runtime/debug"
@@ -19,6 +21,13 @@ var (
 	// Send pings to the client with this period
 	pingPeriod = 60 * time.Second
 
+	// Max amount of searches a single connection
+	// is allowed to run concurrently
+	searchesLimit = 10
+
+	// Max amount of other requests waiting to be processed
+	queueSize = 100
+
 	// Online users for the faster access
 	online = make(map[string]*Account)
 )
@@ -35,6 +44,10 @@ type Message struct {
 
 	// Possible additional data
 	Extra string `json:"extra,omitempty"`
+
+	// Request ID set by the client.
+	// Responses echo it back, so the client knows what they belong to
+	ID string `json:"id,omitempty"`
 }
 
 /*
@@ -88,6 +101,9 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		ResponseWriter: w,
 	}
 
+	// Searches can't outlive the connection
+	account.Session.SearchContext, account.Session.SearchCancel = context.WithCancel(context.Background())
+
 	online[username] = account
 
 	// Listen for the incoming Websocket messages in a loop
@@ -99,10 +115,54 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
  */
 func (a *Account) listen() {
 	a.Session.Done = make(chan bool)
+
+	// Searches run in a background, so the user is able to cancel them
+	// or to do something else meanwhile. Limit their amount per connection,
+	// the ones above the limit are rejected, so the reader is never blocked.
+	// Options are taken at the moment of the request,
+	// as they can be changed while the search is running
+	searches := make(chan struct{}, searchesLimit)
+	search := func(reqID string, handler func(options Options)) {
+		select {
+		case searches <- struct{}{}:
+		default:
+			a.reply(reqID, "error", fmt.Sprintf("Too many searches are running, %d at most are allowed.", searchesLimit), "Can't search!")
+			return
+		}
+
+		options := a.options()
+
+		go func() {
+			defer func() { <-searches }()
+
+			handler(options)
+		}()
+	}
+
+	// Other requests modify account's data, so process them one by one
+	// in the order they were received. They never wait for the searches
+	queue := make(chan func(), queueSize)
+	go func() {
+		for handler := range queue {
+			handler()
+		}
+	}()
+
 	defer func() {
 		close(a.Session.Done)
+		close(queue)
+
+		// Stop the searches nobody waits for anymore
+		a.Session.SearchMutex.Lock()
+		a.Session.SearchCancel()
+		a.Session.SearchMutex.Unlock()
+
+		// Searches may still try to send their results
+		a.Session.WebsocketMutex.Lock()
 		a.Session.Websocket.Close()
 		a.Session.Websocket = nil
+		a.Session.WebsocketMutex.Unlock()
+
 		delete(online, a.Username)
 	}()
 
@@ -154,39 +214,50 @@ func (a *Account) listen() {
 		 * Process all kind of message types
 		 */
 
+		// Message can be modified below, so keep the original values
+		id, data, extra := message.ID, message.Data, message.Extra
+
 		switch message.Type {
 		case "sql":
-			a.sqlHandler(message.Data)
+			search(id, func(options Options) { a.sqlHandler(id, data, options) })
+		case "page":
+			search(id, func(options Options) { a.pageHandler(id, data, extra, options) })
 		case "common":
-			a.commonHandler(message.Data, message.Extra)
+			search(id, func(options Options) { a.commonHandler(id, data, extra, options) })
+		case "cancel":
+			a.cancelHandler(id)
 		case "notes":
-			a.notesHandler(message.Data)
+			queue <- func() { a.notesHandler(id, data) }
 		case "notes-save":
-			a.notesSaveHandler(message.Data, message.Extra)
+			queue <- func() { a.notesSaveHandler(id, data, extra) }
 		case "uuid":
-			a.regenerateUUID()
+			queue <- func() { a.regenerateUUID(id) }
+		case "token-create":
+			queue <- func() { a.createTokenHandler(id, data) }
+		case "token-revoke":
+			queue <- func() { a.revokeTokenHandler(id, data) }
 		case "account-save":
-			a.saveHandler(message.Data)
+			queue <- func() { a.saveHandler(id, data) }
 		case "account-delete":
-			a.delete()
+			queue <- func() { a.delete(id) }
 		case "settings":
-			a.settingsHandler(message.Data)
+			queue <- func() { a.settingsHandler(id, data) }
 		case "users":
-			a.usersHandler(message.Data)
+			queue <- func() { a.usersHandler(id, data) }
 		case "reload":
-			a.reloadHandler()
+			queue <- func() { a.reloadHandler(id) }
 		case "notifications":
-			a.notificationsHandler()
+			queue <- func() { a.notificationsHandler(id) }
 		case "filters":
-			a.filtersHandler(message.Data)
+			queue <- func() { a.filtersHandler(id, data) }
 		case "dashboard-save":
-			a.saveDashboardHandler(message.Data)
+			queue <- func() { a.saveDashboardHandler(id, data) }
 		case "dashboard-delete":
-			a.delDashboardHandler(message.Data, message.Extra)
+			queue <- func() { a.delDashboardHandler(id, data, extra) }
 		case "options":
-			a.optionsHandler(message.Data)
+			queue <- func() { a.optionsHandler(id, data) }
 		case "upload-lists":
-			a.getUploadLists()
+			queue <- func() { a.getUploadLists(id) }
 		}
 
 		// select {
@@ -223,13 +294,15 @@ func (a *Account) listen() {
 		}
 
 		// Update user's last active time
-		err = a.update("lastActive", time.Now())
-		if err != nil {
-			log.Error().
-				Str("ip", a.Session.IP).
-				Str("username", a.Username).
-				Msg("Can't update account to set 'lastActive' time: " + err.Error())
-			continue
+		// together with the other account's modifications
+		queue <- func() {
+			err := a.update("lastActive", time.Now())
+			if err != nil {
+				log.Error().
+					Str("ip", a.Session.IP).
+					Str("username", a.Username).
+					Msg("Can't update account to set 'lastActive' time: " + err.Error())
+			}
 		}
 	}
 }
@@ -237,45 +310,131 @@ func (a *Account) listen() {
 /*
  * Process user's search query
  */
-func (a *Account) sqlHandler(sql string) {
+func (a *Account) sqlHandler(reqID, sql string, options Options) {
+
+	// Get users initial query
+	query := reTimeRange.ReplaceAllString(sql, "")
 
-	// Find requested data source
-	match := reSource.FindStringSubmatch(sql)
-	if len(match) != 2 {
-		a.send("error", "Requested data source missing", sql)
+	// Validate SQL query and find requested data source
+	request, err := prepareQuery(sql)
+	if err != nil {
+		a.reply(reqID, "error", err.Error(), sql)
 
 		log.Error().
 			Str("ip", a.Session.IP).
 			Str("username", a.Username).
 			Str("sql", sql).
-			Msg("Requested data source missing")
+			Msg("Invalid query: " + err.Error())
 		return
 	}
-	source := match[1]
 
-	// Query data sources for a new data
-	response := querySources(source, sql, a.Options.ShowLimited, a.Options.Debug, a.Username)
+	source := request.Source
+	sql = request.SQL
 
-	// Get users initial query
-	sql = reDatetimeLimit.ReplaceAllString(sql, "")
+	// Show how data sources would be queried
+	// instead of running the search
+	if request.Explain {
+		a.reply(reqID, "done", explainSources(source, sql, a.Username).format("json"), query)
+		return
+	}
 
-	// Send the formatted response back
-	a.send("results", response.format("json"), sql)
+	a.runSearch(reqID, source, sql, nil, query, options)
+}
+
+/*
+ * Process user's request of the next page of the paginated results.
+ * Receives continuation token and user's initial query
+ * to attach the results to
+ */
+func (a *Account) pageHandler(reqID, token, query string, options Options) {
+	cursor, err := decodeCursor(token)
+	if err != nil {
+		a.reply(reqID, "error", err.Error(), query)
+
+		log.Error().
+			Str("ip", a.Session.IP).
+			Str("username", a.Username).
+			Msg("Can't decode cursor: " + err.Error())
+		return
+	}
+
+	// Data source could be removed in the meantime
+	request, err := prepareQuery(cursor.SQL)
+	if err != nil {
+		a.reply(reqID, "error", err.Error(), query)
+
+		log.Error().
+			Str("ip", a.Session.IP).
+			Str("username", a.Username).
+			Str("sql", cursor.SQL).
+			Msg("Invalid query: " + err.Error())
+		return
+	}
+
+	a.runSearch(reqID, request.Source, request.SQL, cursor.Positions, query, options)
+}
+
+/*
+ * Query data sources and send the results back,
+ * starting from the given positions of the paginated results, if any
+ */
+func (a *Account) runSearch(reqID, source, sql string, positions map[string]string, query string, options Options) {
+
+	// Send each data source's results as soon as they arrive
+	stream := func(name string, relations []map[string]interface{}, status *SourceStatus, pending int) {
+		a.reply(reqID, "partial", partialResponse(name, relations, status).format("json"), query)
+	}
+
+	// Query data sources for a new data
+	response := querySources(a.searchContext(), source, sql, positions, options.ShowLimited, options.Debug, a.Username, stream)
+	response.Done = true
+
+	// Send the formatted summary back
+	a.reply(reqID, "done", response.format("json"), query)
 
 	// Allow OS to take memory back
 	debug.FreeOSMemory()
 }
 
+/*
+ * Return a context for the new searches
+ */
+func (a *Account) searchContext() context.Context {
+	a.Session.SearchMutex.Lock()
+	defer a.Session.SearchMutex.Unlock()
+
+	return a.Session.SearchContext
+}
+
+/*
+ * Handle 'cancel' websocket command to stop all the running searches.
+ * Searches started later get a fresh context.
+ * Each stopped search still sends its own response
+ */
+func (a *Account) cancelHandler(reqID string) {
+	a.Session.SearchMutex.Lock()
+	a.Session.SearchCancel()
+	a.Session.SearchContext, a.Session.SearchCancel = context.WithCancel(context.Background())
+	a.Session.SearchMutex.Unlock()
+
+	log.Info().
+		Str("ip", a.Session.IP).
+		Str("username", a.Username).
+		Msg("Running searches canceled")
+
+	a.reply(reqID, "canceled", "", "")
+}
+
 /*
  * Find selected nodes common neighbors.
  * Receives a list of "field='value'" of selected nodes
  * and a datetime range to search in
  */
-func (a *Account) commonHandler(data string, datetime string) {
+func (a *Account) commonHandler(reqID, data string, datetime string, options Options) {
 	var queries []string
 	err := json.Unmarshal([]byte(data), &queries)
 	if err != nil {
-		a.send("error", "Can't parse selected nodes.", "Error!")
+		a.reply(reqID, "error", "Can't parse selected nodes.", "Error!")
 
 		log.Error().
 			Str("ip", a.Session.IP).
@@ -294,12 +453,15 @@ func (a *Account) commonHandler(data string, datetime string) {
 		nodes := []string{}
 		results := [][]map[string]interface{}{}
 
+		// All the queries are canceled at once
+		ctx := a.searchContext()
+
 		for _, query := range queries {
 			field := strings.SplitN(query, "='", 2)
 			nodes = append(nodes, field[1][:len(field[1])-1])
 
 			// Query data sources for a new data
-			result := querySources("global", "FROM global WHERE ("+query+") AND datetime BETWEEN "+datetime, a.Options.ShowLimited, a.Options.Debug, a.Username)
+			result := querySources(ctx, "global", "FROM global WHERE ("+query+") AND datetime BETWEEN "+datetime, nil, options.ShowLimited, options.Debug, a.Username, nil)
 			results = append(results, result.Relations)
 
 			if result.Error != "" {
@@ -308,6 +470,9 @@ func (a *Account) commonHandler(data string, datetime string) {
 			if len(result.Stats) != 0 {
 				response.Stats = result.Stats
 			}
+			for name, status := range result.Sources {
+				response.setStatus(name, status)
+			}
 		}
 
 		// To find common neighbors of all the selected nodes
@@ -371,7 +536,7 @@ func (a *Account) commonHandler(data string, datetime string) {
 
 	// Send common nodes back
 	b, _ := json.Marshal(responseNeighbors)
-	a.send("common", response.format("json"), string(b))
+	a.reply(reqID, "common", response.format("json"), string(b))
 }
 
 /*
@@ -411,9 +576,9 @@ func (a *Account) commonNeighborsInclude(values [][2]interface{}, field interfac
  * Handle 'notes' websocket command
  * to get users notes for the graph element by its ID/value
  */
-func (a *Account) notesHandler(id string) {
+func (a *Account) notesHandler(reqID, id string) {
 	if id == "" {
-		a.send("notes-error", "Notes for an empty element requested.", "Error!")
+		a.reply(reqID, "notes-error", "Notes for an empty element requested.", "Error!")
 
 		log.Error().
 			Str("ip", a.Session.IP).
@@ -424,7 +589,7 @@ func (a *Account) notesHandler(id string) {
 
 	notes, err := db.getNotes(id)
 	if err != nil {
-		a.send("notes-error", err.Error(), "Can't get notes!")
+		a.reply(reqID, "notes-error", err.Error(), "Can't get notes!")
 
 		log.Error().
 			Str("ip", a.Session.IP).
@@ -433,16 +598,16 @@ func (a *Account) notesHandler(id string) {
 		return
 	}
 
-	a.send("notes", notes, "")
+	a.reply(reqID, "notes", notes, "")
 }
 
 /*
  * Handle 'notes-save' websocket command
  * to set users notes for the graph element by its ID/value
  */
-func (a *Account) notesSaveHandler(id, notes string) {
+func (a *Account) notesSaveHandler(reqID, id, notes string) {
 	if id == "" {
-		a.send("notes-error", "Can't save notes for an empty element.", "Error!")
+		a.reply(reqID, "notes-error", "Can't save notes for an empty element.", "Error!")
 
 		log.Error().
 			Str("ip", a.Session.IP).
@@ -453,7 +618,7 @@ func (a *Account) notesSaveHandler(id, notes string) {
 
 	err := db.setNotes(id, strings.TrimSpace(notes))
 	if err != nil {
-		a.send("notes-error", err.Error(), "Can't set notes!")
+		a.reply(reqID, "notes-error", err.Error(), "Can't set notes!")
 
 		log.Error().
 			Str("ip", a.Session.IP).
@@ -464,7 +629,7 @@ func (a *Account) notesSaveHandler(id, notes string) {
 		return
 	}
 
-	a.send("notes-set", "", "")
+	a.reply(reqID, "notes-set", "", "")
 
 	log.Info().
 		Str("ip", a.Session.IP).
@@ -475,14 +640,23 @@ func (a *Account) notesSaveHandler(id, notes string) {
 }
 
 /*
- * Send a Websocket message to the client.
- * Receives all the values for the Message structure
+ * Send a Websocket message to the client,
+ * not related to any of its requests
  */
 func (a *Account) send(tp, data, extra string) {
+	a.reply("", tp, data, extra)
+}
+
+/*
+ * Send a Websocket message to the client
+ * as a response to the request with the given ID
+ */
+func (a *Account) reply(reqID, tp, data, extra string) {
 	m := &Message{
 		Type:  tp,
 		Data:  data,
 		Extra: extra,
+		ID:    reqID,
 	}
 
 	bytes, err := json.Marshal(m)
@@ -499,6 +673,11 @@ func (a *Account) send(tp, data, extra string) {
 		a.Session.WebsocketMutex.Lock()
 		defer a.Session.WebsocketMutex.Unlock()
 
+		// Connection was closed while a search was running
+		if a.Session.Websocket == nil {
+			return
+		}
+
 		err = a.Session.Websocket.WriteMessage(websocket.TextMessage, bytes)
 		if err != nil {
 			log.Error().
@@ -548,12 +727,14 @@ func broadcast(tp, data, extra string) {
 			// Connections support one concurrent reader and one concurrent writer
 			account.Session.WebsocketMutex.Lock()
 
-			err = account.Session.Websocket.WriteMessage(websocket.TextMessage, bytes)
-			if err != nil {
-				log.Error().
-					Str("ip", account.Session.IP).
-					Str("username", account.Username).
-					Msg("Can't write to the Websocket: " + err.Error())
+			if account.Session.Websocket != nil {
+				err = account.Session.Websocket.WriteMessage(websocket.TextMessage, bytes)
+				if err != nil {
+					log.Error().
+						Str("ip", account.Session.IP).
+						Str("username", account.Username).
+						Msg("Can't write to the Websocket: " + err.Error())
+				}
 			}
 
 			account.Session.WebsocketMutex.Unlock()